@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveHold is how long a batch size that was cut stays put before it
+// may grow again.
+const adaptiveHold = 30 * time.Second
+
+// batchTuner adjusts an output's batch size from its bulk responses
+// (BULK_ADAPTIVE), like Beats' adaptive batching: it grows by 10% while
+// requests come back within half of BULK_ADAPTIVE_LATENCY, shrinks by a
+// quarter when they take longer than it, and halves on 429/503 rejections;
+// after a cut it holds for adaptiveHold. BULK_SIZE is only the starting
+// size, kept within BULK_ADAPTIVE_MIN..BULK_ADAPTIVE_MAX.
+type batchTuner struct {
+	min, max int
+	target   time.Duration
+
+	mu   sync.Mutex
+	size int
+	hold time.Time
+}
+
+func newBatchTuner(config Config) *batchTuner {
+	if !config.BulkAdaptive {
+		return nil
+	}
+	return &batchTuner{
+		min:    config.BulkAdaptiveMin,
+		max:    config.BulkAdaptiveMax,
+		target: config.BulkAdaptiveLatency,
+		size:   min(max(config.BulkSize, config.BulkAdaptiveMin), config.BulkAdaptiveMax),
+	}
+}
+
+// adaptiveOutput is an output whose batch size is tuned from its responses.
+type adaptiveOutput interface {
+	tuner() *batchTuner
+}
+
+// Size is the current batch size.
+func (t *batchTuner) Size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// observe feeds back one bulk request: its latency and whether the
+// cluster pushed back (429/503, whole request or items).
+func (t *batchTuner) observe(name string, latency time.Duration, throttled bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, now := t.size, time.Now()
+	switch {
+	case throttled:
+		t.size = max(t.min, t.size/2)
+		t.hold = now.Add(adaptiveHold)
+	case latency > t.target:
+		t.size = max(t.min, t.size*3/4)
+		t.hold = now.Add(adaptiveHold)
+	case latency < t.target/2 && now.After(t.hold):
+		t.size = min(t.max, t.size+max(t.size/10, 1))
+	}
+	if t.size != old {
+		metricBulkBatchSize.Set(float64(t.size), name)
+		logOutput.Debug("batch size adjusted", "output", name, "from", old, "to", t.size, "latency", latency.String(), "throttled", throttled)
+	}
+}
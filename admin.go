@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// adminAPI is the runtime control API under /admin/ on HTTP_ADDR, enabled
+// by ADMIN_TOKEN; every request needs "Authorization: Bearer <token>".
+//
+//	POST /admin/pause            finish the files in flight, start no new ones
+//	POST /admin/resume           lift the pause above (a disk watermark pause stays)
+//	GET  /admin/files            queued and in-flight files
+//	POST /admin/reprocess?path=  forget a file in the ledger and queue it again
+//	GET  /admin/log-level        level per component
+//	POST /admin/log-level?level=debug[&component=elasticsearch]
+//	GET  /admin/config           the settings in use, credentials masked
+//
+// The file endpoints answer 503 in modes without a worker pool (sender,
+// reflector).
+type adminAPI struct {
+	token string
+
+	mu     sync.Mutex
+	pool   *workerPool
+	state  *stateStore
+	roots  func() []string // directories reprocess may read from
+	config func() Config
+}
+
+func newAdminAPI(config Config) *adminAPI {
+	if config.AdminToken == "" {
+		return nil
+	}
+	return &adminAPI{token: config.AdminToken, config: func() Config { return config }}
+}
+
+// setPool hands over the worker pool once it's running.
+func (a *adminAPI) setPool(pool *workerPool, state *stateStore, roots func() []string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.pool, a.state, a.roots = pool, state, roots
+	a.mu.Unlock()
+}
+
+// setConfig makes /admin/config follow hot reloads.
+func (a *adminAPI) setConfig(config func() Config) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.config = config
+	a.mu.Unlock()
+}
+
+func (a *adminAPI) register(mux *http.ServeMux) {
+	if a == nil {
+		return
+	}
+	mux.HandleFunc("/admin/pause", a.post(a.pause))
+	mux.HandleFunc("/admin/resume", a.post(a.resume))
+	mux.HandleFunc("/admin/files", a.get(a.files))
+	mux.HandleFunc("/admin/reprocess", a.post(a.reprocess))
+	mux.HandleFunc("/admin/log-level", a.auth(a.logLevel))
+	mux.HandleFunc("/admin/config", a.get(a.dumpConfig))
+}
+
+// auth checks the bearer token and logs every call that passes.
+func (a *adminAPI) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			logHTTP.Warn("admin request denied", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		logHTTP.Info("admin request", "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery, "remote", r.RemoteAddr)
+		h(w, r)
+	}
+}
+
+func (a *adminAPI) get(h http.HandlerFunc) http.HandlerFunc {
+	return a.auth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET"})
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (a *adminAPI) post(h http.HandlerFunc) http.HandlerFunc {
+	return a.auth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		h(w, r)
+	})
+}
+
+// workers returns the pool, or answers 503 without one.
+func (a *adminAPI) workers(w http.ResponseWriter) (*workerPool, *stateStore, func() []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pool == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no file ingestion in this mode"})
+	}
+	return a.pool, a.state, a.roots
+}
+
+func (a *adminAPI) pause(w http.ResponseWriter, r *http.Request) {
+	pool, _, _ := a.workers(w)
+	if pool == nil {
+		return
+	}
+	pool.Pause()
+	logIngest.Warn("ingestion paused through the admin API")
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+func (a *adminAPI) resume(w http.ResponseWriter, r *http.Request) {
+	pool, _, _ := a.workers(w)
+	if pool == nil {
+		return
+	}
+	pool.Resume()
+	// 디스크 watermark 로 멈춘 것은 cluster 가 내려갈 때까지 그대로
+	if pool.Paused() {
+		logIngest.Warn("admin pause lifted, ingestion still paused", "paused_for", pool.PausedFor())
+	} else {
+		logIngest.Info("ingestion resumed through the admin API")
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": pool.Paused()})
+}
+
+func (a *adminAPI) files(w http.ResponseWriter, r *http.Request) {
+	pool, _, _ := a.workers(w)
+	if pool == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"paused":     pool.Paused(),
+		"paused_for": pool.PausedFor(),
+		"queued":     pool.Queued(),
+		"in_flight":  progress.status().Files,
+	})
+}
+
+func (a *adminAPI) reprocess(w http.ResponseWriter, r *http.Request) {
+	pool, state, roots := a.workers(w)
+	if pool == nil {
+		return
+	}
+	path, err := filepath.Abs(r.URL.Query().Get("path"))
+	if err != nil || r.URL.Query().Get("path") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "path is required"})
+		return
+	}
+	// 감시하는 디렉토리 밖의 파일은 읽지 않는다
+	allowed := false
+	for _, root := range roots() {
+		if root == "" {
+			continue
+		}
+		root, _ = filepath.Abs(root)
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "path is outside the ingested directories"})
+		return
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such file"})
+		return
+	}
+	if err := state.Forget(path); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	pool.Submit(path)
+	logIngest.Info("file queued for reprocessing", "file", path)
+	writeJSON(w, http.StatusAccepted, map[string]string{"queued": path})
+}
+
+func (a *adminAPI) logLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		component, level := r.URL.Query().Get("component"), r.URL.Query().Get("level")
+		if err := setLogLevel(component, level); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		logHTTP.Info("log level changed", "component", component, "level", level)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or POST"})
+		return
+	}
+	levels := make(map[string]string, len(logLevels))
+	for name, v := range logLevels {
+		levels[name] = v.Level().String()
+	}
+	writeJSON(w, http.StatusOK, levels)
+}
+
+// dumpConfig returns the Config fields, credentials masked and passwords
+// taken out of URLs.
+func (a *adminAPI) dumpConfig(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	config := a.config()
+	a.mu.Unlock()
+	out := map[string]interface{}{}
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		name, val := v.Type().Field(i).Name, v.Field(i).Interface()
+		if _, ok := val.([]tenantConfig); ok {
+			val = maskSecret(val)
+		} else if s, ok := val.(string); ok && s != "" {
+			if secretField(name) {
+				val = "***"
+			} else if u, err := url.Parse(s); err == nil && u.User != nil {
+				val = u.Redacted()
+			}
+		}
+		out[name] = val
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logHTTP.Debug("error writing response", "err", err)
+	}
+}
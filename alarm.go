@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alarmSink follows the alarm IDs the probes put on their rows
+// (ALARM_FIELD, alarmid in the twamp export) and lines them up with what
+// the measurements show. Per session:
+//
+//   - an alarm is raised on the first row carrying its ID and cleared on the
+//     first row without it (missing, empty, one of ALARM_CLEAR_VALUES or
+//     other IDs); a field with several IDs (comma, semicolon or space
+//     separated) holds as many alarms.
+//   - a degradation window runs from the first degraded round to the first
+//     good one; a round is degraded when the KPI stage marked it
+//     unavailable or its rtt_mean is over ALARM_DEGRADED_RTT (0 off).
+//
+// Every alarm is one state document in ALARMS_INDEX (kind=alarm), written
+// as raised and overwritten when it clears, with its duration and the
+// rounds, degraded rounds, worst loss and delay seen while it was up, and
+// lead_s, how long the measurements had been degraded before it was
+// raised. Every degradation window that ends is written as kind=degradation
+// with the alarm IDs that were up during it; alarmed=false ones are
+// degradations no alarm covered.
+type alarmSink struct {
+	next        recordSink
+	out         recordSink
+	field       string
+	clear       map[string]bool
+	degradedRTT float64 // µs
+
+	mu       sync.Mutex
+	sessions map[string]*alarmSession
+
+	closeOnce sync.Once
+}
+
+type alarmSession struct {
+	alarms   map[string]*alarmState
+	degraded *degradationWindow // nil while the session is fine
+}
+
+type alarmState struct {
+	id       string
+	raisedAt time.Time
+	lead     time.Duration // 측정이 먼저 나빠진 시간
+	dims     Record
+
+	rounds, degradedRounds int
+	lossMax, rttMax        float64
+	rttSum                 float64
+	rttRounds              int
+}
+
+type degradationWindow struct {
+	start  time.Time
+	rounds int
+	alarms map[string]bool
+	dims   Record
+}
+
+func newAlarmSink(next, out recordSink, config Config) *alarmSink {
+	a := &alarmSink{
+		next: next, out: out, field: config.AlarmField, clear: map[string]bool{"": true},
+		degradedRTT: float64(config.AlarmDegradedRTT) / float64(time.Microsecond),
+		sessions:    map[string]*alarmSession{},
+	}
+	for _, v := range splitList(config.AlarmClearValues) {
+		a.clear[strings.ToLower(v)] = true
+	}
+	return a
+}
+
+func (a *alarmSink) Add(rec Record) error {
+	for _, doc := range a.evaluate(rec) {
+		if err := a.out.Add(doc); err != nil {
+			logAlert.Error("error writing alarm state", "err", err)
+		}
+	}
+	return a.next.Add(rec)
+}
+
+func (a *alarmSink) Flush() error { return a.next.Flush() }
+
+// Close leaves open alarms and windows as they are; their raised
+// documents are already written.
+func (a *alarmSink) Close() error {
+	a.closeOnce.Do(func() {
+		if err := a.out.Close(); err != nil {
+			logAlert.Error("error flushing alarm states", "err", err)
+		}
+	})
+	return a.next.Close()
+}
+
+func (a *alarmSink) evaluate(rec Record) []Record {
+	ids := map[string]bool{}
+	raw, _ := recordField(rec, a.field)
+	if raw == nil {
+		raw = "" // 빈 칸은 레코드에 없다
+	}
+	for _, id := range strings.FieldsFunc(fmt.Sprint(raw), func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		if !a.clear[strings.ToLower(id)] {
+			ids[id] = true
+		}
+	}
+	t := recordTime(rec)
+	degraded := a.degraded(rec)
+	loss, hasLoss := number(rec, "loss_pct")
+	rtt, hasRTT := number(rec, "rtt_mean")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := fmt.Sprint(rec["session_id"])
+	s, ok := a.sessions[key]
+	if !ok {
+		s = &alarmSession{alarms: map[string]*alarmState{}}
+		a.sessions[key] = s
+	}
+	open := len(s.alarms)
+	var docs []Record
+	// 사라진 알람은 이 행의 시각에 해제
+	for id, st := range s.alarms {
+		if !ids[id] {
+			delete(s.alarms, id)
+			docs = append(docs, st.document("cleared", t))
+			metricAlarms.Inc("cleared")
+		}
+	}
+	if degraded && s.degraded == nil {
+		s.degraded = &degradationWindow{start: t, alarms: map[string]bool{}, dims: alarmDimensions(rec)}
+	}
+	for id := range ids {
+		st, ok := s.alarms[id]
+		if !ok {
+			st = &alarmState{id: id, raisedAt: t, dims: alarmDimensions(rec)}
+			if s.degraded != nil {
+				st.lead = t.Sub(s.degraded.start)
+			}
+			s.alarms[id] = st
+			docs = append(docs, st.document("raised", time.Time{}))
+			metricAlarms.Inc("raised")
+		}
+		st.rounds++
+		if degraded {
+			st.degradedRounds++
+		}
+		if hasLoss && loss > st.lossMax {
+			st.lossMax = loss
+		}
+		if hasRTT {
+			if rtt > st.rttMax {
+				st.rttMax = rtt
+			}
+			st.rttSum += rtt
+			st.rttRounds++
+		}
+	}
+	if w := s.degraded; w != nil {
+		if degraded {
+			w.rounds++
+			for id := range ids {
+				w.alarms[id] = true
+			}
+		} else {
+			s.degraded = nil
+			docs = append(docs, w.document(t))
+			metricDegradationWindows.Inc(fmt.Sprint(len(w.alarms) > 0))
+		}
+	}
+	metricAlarmsOpen.Add(float64(len(s.alarms) - open))
+	return docs
+}
+
+// degraded reports whether the round counts towards a degradation window.
+func (a *alarmSink) degraded(rec Record) bool {
+	if v, ok := number(rec, "available"); ok && v == 0 {
+		return true
+	}
+	if a.degradedRTT > 0 {
+		if rtt, ok := number(rec, "rtt_mean"); ok && rtt > a.degradedRTT {
+			return true
+		}
+	}
+	return false
+}
+
+func alarmDimensions(rec Record) Record {
+	dims := Record{}
+	for _, d := range rollupDimensions {
+		if v, ok := rec[d]; ok {
+			dims[d] = v
+		}
+	}
+	return dims
+}
+
+// document is the alarm's state document; cleared ones replace the raised
+// one (same alarm_key, the _id in ALARMS_INDEX).
+func (st *alarmState) document(state string, clearedAt time.Time) Record {
+	doc := Record{}
+	for k, v := range st.dims {
+		doc[k] = v
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v|%s|%s", st.dims["session_id"], st.id, st.raisedAt.Format(time.RFC3339Nano))))
+	doc["alarm_key"] = hex.EncodeToString(sum[:16])
+	doc["@timestamp"] = st.raisedAt.UTC().Format(time.RFC3339Nano)
+	doc["kind"] = "alarm"
+	doc["alarm_id"] = st.id
+	doc["state"] = state
+	doc["raised_at"] = doc["@timestamp"]
+	doc["lead_s"] = st.lead.Seconds()
+	if state == "cleared" {
+		doc["cleared_at"] = clearedAt.UTC().Format(time.RFC3339Nano)
+		doc["duration_s"] = clearedAt.Sub(st.raisedAt).Seconds()
+		doc["rounds"] = st.rounds
+		doc["degraded_rounds"] = st.degradedRounds
+		doc["loss_pct_max"] = st.lossMax
+		if st.rttRounds > 0 {
+			doc["rtt_mean_max"] = st.rttMax
+			doc["rtt_mean_avg"] = st.rttSum / float64(st.rttRounds)
+		}
+	}
+	doc["message"] = fmt.Sprintf("alarm %s %s on session %v", st.id, state, st.dims["session_id"])
+	return doc
+}
+
+// document is the window that ended at end, the first good round.
+func (w *degradationWindow) document(end time.Time) Record {
+	doc := Record{}
+	for k, v := range w.dims {
+		doc[k] = v
+	}
+	ids := make([]string, 0, len(w.alarms))
+	for id := range w.alarms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	doc["@timestamp"] = w.start.UTC().Format(time.RFC3339Nano)
+	doc["kind"] = "degradation"
+	doc["window_end"] = end.UTC().Format(time.RFC3339Nano)
+	doc["duration_s"] = end.Sub(w.start).Seconds()
+	doc["rounds"] = w.rounds
+	doc["alarm_ids"] = ids
+	doc["alarmed"] = len(ids) > 0
+	doc["message"] = fmt.Sprintf("degradation of %s on session %v, alarms %v", end.Sub(w.start), w.dims["session_id"], ids)
+	return doc
+}
+
+// alarmSchema describes alarm and degradation documents for the index
+// template.
+func alarmSchema() *Schema {
+	s := &Schema{fields: map[string]schemaField{}}
+	add := func(name string, typ fieldType) { s.fields[name] = schemaField{Name: name, Type: typ} }
+	for _, name := range []string{"@timestamp", "raised_at", "cleared_at", "window_end"} {
+		add(name, typeDate)
+	}
+	for _, name := range []string{"kind", "alarm_key", "alarm_id", "alarm_ids", "state", "session_name", "session_type", "source_ne", "source_ip", "destination_ip", "system_id"} {
+		add(name, typeString)
+	}
+	for _, name := range []string{"session_id", "rounds", "degraded_rounds"} {
+		add(name, typeInt)
+	}
+	for _, name := range []string{"duration_s", "lead_s", "loss_pct_max", "rtt_mean_max", "rtt_mean_avg"} {
+		add(name, typeFloat)
+	}
+	add("alarmed", typeBool)
+	return s
+}
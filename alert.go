@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ALERTS_FILE 예시:
+//
+//	rules:
+//	  - name: high-rtt
+//	    field: rtt_p95
+//	    op: ">"
+//	    value: 50ms      # durations are compared in microseconds, like the delay fields
+//	    for: 3           # consecutive stat rounds before firing
+//	    severity: warning
+//	  - name: loss
+//	    field: loss_pct
+//	    op: ">"
+//	    value: 1
+//	webhook:
+//	  url: https://hooks.example.com/twamp
+//	  timeout: 5s
+type alertConfig struct {
+	Rules   []*alertRule `json:"rules"`
+	Webhook alertWebhook `json:"webhook"`
+}
+
+type alertRule struct {
+	Name     string      `json:"name"`
+	Field    string      `json:"field"`
+	Op       string      `json:"op"`
+	Value    interface{} `json:"value"`
+	For      int         `json:"for"`
+	Severity string      `json:"severity"`
+
+	threshold float64
+}
+
+type alertWebhook struct {
+	URL     string            `json:"url"`
+	Timeout string            `json:"timeout"`
+	Headers map[string]string `json:"headers"`
+}
+
+func loadAlertConfig(path string) (*alertConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading alerts file: %w", err)
+	}
+	var cfg alertConfig
+	if err := decodeYAML(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing alerts file %s: %w", path, err)
+	}
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if r.Field == "" {
+			return nil, fmt.Errorf("alerts file %s: rule %s has no field", path, r.Name)
+		}
+		if r.Op == "" {
+			r.Op = ">"
+		}
+		switch r.Op {
+		case ">", ">=", "<", "<=", "==", "!=":
+		default:
+			return nil, fmt.Errorf("alerts file %s: rule %s: unknown op %q", path, r.Name, r.Op)
+		}
+		if r.For < 1 {
+			r.For = 1
+		}
+		if r.Severity == "" {
+			r.Severity = "warning"
+		}
+		switch v := r.Value.(type) {
+		case float64:
+			r.threshold = v
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("alerts file %s: rule %s: value %q is neither a number nor a duration", path, r.Name, v)
+			}
+			r.threshold = float64(d) / float64(time.Microsecond)
+		default:
+			return nil, fmt.Errorf("alerts file %s: rule %s has no value", path, r.Name)
+		}
+	}
+	return &cfg, nil
+}
+
+func (r *alertRule) breached(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	default:
+		return v != r.threshold
+	}
+}
+
+// alertSink evaluates the rules on every record per session, then passes
+// the record on. A rule fires after For consecutive breaching rounds and
+// resolves on the first round that doesn't breach; both transitions are
+// written to out (the alerts index) and posted to the webhook.
+type alertSink struct {
+	next recordSink
+	out  recordSink
+
+	mu    sync.Mutex
+	rules []*alertRule
+	hook  *webhookSender
+	state map[alertKey]*alertState
+
+	closeOnce sync.Once
+}
+
+type alertKey struct {
+	rule    string
+	session string
+}
+
+type alertState struct {
+	count  int
+	firing bool
+}
+
+func newAlertSink(next, out recordSink, cfg *alertConfig) (*alertSink, error) {
+	a := &alertSink{next: next, out: out, state: make(map[alertKey]*alertState)}
+	if err := a.SetRules(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetRules swaps in a new rule set (config reload). Sessions keep their
+// consecutive counts for rules that still exist by name.
+func (a *alertSink) SetRules(cfg *alertConfig) error {
+	var hook *webhookSender
+	if cfg.Webhook.URL != "" {
+		timeout := 5 * time.Second
+		if cfg.Webhook.Timeout != "" {
+			d, err := time.ParseDuration(cfg.Webhook.Timeout)
+			if err != nil {
+				return fmt.Errorf("invalid webhook timeout %q: %w", cfg.Webhook.Timeout, err)
+			}
+			timeout = d
+		}
+		hook = newWebhookSender(cfg.Webhook.URL, cfg.Webhook.Headers, timeout)
+	}
+	names := map[string]bool{}
+	for _, r := range cfg.Rules {
+		names[r.Name] = true
+	}
+	a.mu.Lock()
+	old := a.hook
+	a.rules, a.hook = cfg.Rules, hook
+	for k := range a.state {
+		if !names[k.rule] {
+			delete(a.state, k)
+		}
+	}
+	a.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (a *alertSink) Add(rec Record) error {
+	alerts, hook := a.evaluate(rec)
+	for _, alert := range alerts {
+		if err := a.out.Add(alert); err != nil {
+			logAlert.Error("error writing alert", "err", err)
+		}
+		if hook != nil {
+			hook.Send(alert)
+		}
+		notify(notification{
+			Event:    eventAlert,
+			Severity: fmt.Sprint(alert["severity"]),
+			Title:    fmt.Sprintf("%v %v", alert["alert"], alert["status"]),
+			Text:     fmt.Sprint(alert["message"]),
+		})
+	}
+	return a.next.Add(rec)
+}
+
+func (a *alertSink) Flush() error { return a.next.Flush() }
+
+func (a *alertSink) Close() error {
+	a.closeOnce.Do(func() {
+		a.mu.Lock()
+		hook := a.hook
+		a.mu.Unlock()
+		if hook != nil {
+			hook.Close()
+		}
+		if err := a.out.Close(); err != nil {
+			logAlert.Error("error flushing alerts", "err", err)
+		}
+	})
+	return a.next.Close()
+}
+
+func (a *alertSink) evaluate(rec Record) ([]Record, *webhookSender) {
+	session := fmt.Sprint(rec["session_id"])
+	var alerts []Record
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.rules {
+		v, ok := number(rec, r.Field)
+		if !ok {
+			continue
+		}
+		key := alertKey{rule: r.Name, session: session}
+		st, ok := a.state[key]
+		if !ok {
+			st = &alertState{}
+			a.state[key] = st
+		}
+		if r.breached(v) {
+			st.count++
+			if !st.firing && st.count >= r.For {
+				st.firing = true
+				alerts = append(alerts, alertDocument(r, rec, v, st.count, "firing"))
+			}
+			continue
+		}
+		if st.firing {
+			alerts = append(alerts, alertDocument(r, rec, v, st.count, "resolved"))
+		}
+		st.count, st.firing = 0, false
+	}
+	return alerts, a.hook
+}
+
+func alertDocument(r *alertRule, rec Record, v float64, count int, status string) Record {
+	doc := Record{
+		"@timestamp":  recordTime(rec).Format(time.RFC3339Nano),
+		"alert":       r.Name,
+		"severity":    r.Severity,
+		"status":      status,
+		"field":       r.Field,
+		"op":          r.Op,
+		"threshold":   r.threshold,
+		"value":       v,
+		"consecutive": count,
+	}
+	for _, d := range rollupDimensions {
+		if dv, ok := rec[d]; ok {
+			doc[d] = dv
+		}
+	}
+	doc["message"] = fmt.Sprintf("[%s] %s %s: %s=%g (threshold %s %g, %d rounds), session %v",
+		r.Severity, r.Name, status, r.Field, v, r.Op, r.threshold, count, rec["session_id"])
+	return doc
+}
+
+// webhookSender posts alerts as JSON from a background goroutine so a slow
+// endpoint doesn't hold up ingestion. When its queue is full alerts are
+// dropped (they are still in the alerts index).
+type webhookSender struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	queue   chan Record
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newWebhookSender(url string, headers map[string]string, timeout time.Duration) *webhookSender {
+	w := &webhookSender{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: timeout},
+		queue:   make(chan Record, 100),
+		done:    make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *webhookSender) Send(alert Record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- alert:
+	default:
+		logAlert.Warn("webhook queue full, dropping alert", "alert", alert["alert"])
+	}
+}
+
+func (w *webhookSender) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *webhookSender) loop() {
+	defer close(w.done)
+	for alert := range w.queue {
+		if err := w.post(alert); err != nil {
+			logAlert.Error("error posting alert to webhook", "err", err)
+		}
+	}
+}
+
+func (w *webhookSender) post(alert Record) error {
+	return postJSON(w.client, w.url, w.headers, alert)
+}
+
+// alertSchema describes alert documents for the index template.
+func alertSchema() *Schema {
+	s := &Schema{fields: map[string]schemaField{}}
+	add := func(name string, typ fieldType) { s.fields[name] = schemaField{Name: name, Type: typ} }
+	add("@timestamp", typeDate)
+	for _, name := range []string{"alert", "severity", "status", "field", "op", "session_name", "session_type", "source_ne", "source_ip", "destination_ip", "system_id"} {
+		add(name, typeString)
+	}
+	add("session_id", typeInt)
+	add("consecutive", typeInt)
+	add("threshold", typeFloat)
+	add("value", typeFloat)
+	return s
+}
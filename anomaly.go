@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anomalySink learns a baseline per session for every ANOMALY_FIELDS
+// field, an EWMA of the value and its variance (weight ANOMALY_ALPHA; a
+// plain running mean until 1/n drops below it), and flags stat rounds that
+// are more than ANOMALY_SIGMA standard deviations from it, and at least
+// the field's minimum change away, so a session with flat zero loss isn't
+// flagged for a single lost packet:
+//
+//	ANOMALY_FIELDS="rtt_mean=1ms,loss_pct=1"   # durations in microseconds, like the delay fields
+//
+// With ANOMALY_SEASONAL each hour of the day (TIMESTAMP_TIMEZONE) has its
+// own baseline, for links busy at the same hours every day; those take a
+// day per sample to learn. Nothing is flagged before ANOMALY_MIN_SAMPLES
+// rounds. Flagged rounds still update the baseline, so a lasting change
+// becomes the new normal.
+//
+// Every flagged round is written to out (ANOMALY_INDEX), counted in
+// twamp_anomalies_total and sent as an anomaly notification; the record
+// itself passes on unchanged.
+type anomalySink struct {
+	next       recordSink
+	out        recordSink
+	fields     []anomalyField
+	sigma      float64
+	alpha      float64
+	minSamples int
+	seasonal   *time.Location
+
+	mu        sync.Mutex
+	baselines map[anomalyKey]*anomalyBaseline
+
+	closeOnce sync.Once
+}
+
+type anomalyField struct {
+	name      string
+	minChange float64
+}
+
+type anomalyKey struct {
+	session string
+	field   string
+	hour    int // -1 without ANOMALY_SEASONAL
+}
+
+type anomalyBaseline struct {
+	n        int
+	mean     float64
+	variance float64
+}
+
+// parseAnomalyFields reads ANOMALY_FIELDS, field or field=min_change.
+func parseAnomalyFields(s string) ([]anomalyField, error) {
+	var fields []anomalyField
+	for _, item := range splitList(s) {
+		name, min, _ := strings.Cut(item, "=")
+		f := anomalyField{name: strings.TrimSpace(name)}
+		if min = strings.TrimSpace(min); min != "" {
+			if v, err := strconv.ParseFloat(min, 64); err == nil {
+				f.minChange = v
+			} else if d, err := time.ParseDuration(min); err == nil {
+				f.minChange = float64(d) / float64(time.Microsecond)
+			} else {
+				return nil, fmt.Errorf("ANOMALY_FIELDS: %q is neither a number nor a duration", min)
+			}
+		}
+		if f.name == "" || f.minChange < 0 {
+			return nil, fmt.Errorf("ANOMALY_FIELDS: %q is not field or field=min_change", item)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func newAnomalySink(next, out recordSink, config Config) (*anomalySink, error) {
+	fields, err := parseAnomalyFields(config.AnomalyFields)
+	if err != nil {
+		return nil, err
+	}
+	a := &anomalySink{
+		next: next, out: out, fields: fields, sigma: config.AnomalySigma, alpha: config.AnomalyAlpha,
+		minSamples: config.AnomalyMinSamples, baselines: map[anomalyKey]*anomalyBaseline{},
+	}
+	if config.AnomalySeasonal {
+		if a.seasonal, err = time.LoadLocation(config.TimestampTimezone); err != nil {
+			return nil, fmt.Errorf("invalid TIMESTAMP_TIMEZONE %q: %w", config.TimestampTimezone, err)
+		}
+	}
+	return a, nil
+}
+
+func (a *anomalySink) Add(rec Record) error {
+	for _, doc := range a.evaluate(rec) {
+		metricAnomalies.Inc(fmt.Sprint(doc["field"]), fmt.Sprint(doc["direction"]))
+		if err := a.out.Add(doc); err != nil {
+			logAlert.Error("error writing anomaly", "err", err)
+		}
+		notify(notification{
+			Event:    eventAnomaly,
+			Severity: "warning",
+			Title:    fmt.Sprintf("anomaly %v %v", doc["field"], doc["direction"]),
+			Text:     fmt.Sprint(doc["message"]),
+		})
+	}
+	return a.next.Add(rec)
+}
+
+func (a *anomalySink) Flush() error { return a.next.Flush() }
+
+func (a *anomalySink) Close() error {
+	a.closeOnce.Do(func() {
+		if err := a.out.Close(); err != nil {
+			logAlert.Error("error flushing anomalies", "err", err)
+		}
+	})
+	return a.next.Close()
+}
+
+func (a *anomalySink) evaluate(rec Record) []Record {
+	session := fmt.Sprint(rec["session_id"])
+	hour := -1
+	if a.seasonal != nil {
+		hour = recordTime(rec).In(a.seasonal).Hour()
+	}
+	var docs []Record
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, f := range a.fields {
+		v, ok := number(rec, f.name)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		key := anomalyKey{session: session, field: f.name, hour: hour}
+		b, ok := a.baselines[key]
+		if !ok {
+			b = &anomalyBaseline{}
+			a.baselines[key] = b
+		}
+		if b.n >= a.minSamples {
+			dev, std := v-b.mean, math.Sqrt(b.variance)
+			if math.Abs(dev) > a.sigma*std && math.Abs(dev) >= f.minChange && dev != 0 {
+				docs = append(docs, a.document(rec, f.name, v, b, hour))
+			}
+		}
+		b.update(v, a.alpha)
+	}
+	return docs
+}
+
+// update folds v into the EWMA mean and variance.
+func (b *anomalyBaseline) update(v, alpha float64) {
+	b.n++
+	if w := 1 / float64(b.n); w > alpha {
+		alpha = w
+	}
+	diff := v - b.mean
+	incr := alpha * diff
+	b.mean += incr
+	b.variance = (1 - alpha) * (b.variance + diff*incr)
+}
+
+func (a *anomalySink) document(rec Record, field string, v float64, b *anomalyBaseline, hour int) Record {
+	dev, std := v-b.mean, math.Sqrt(b.variance)
+	direction := "high"
+	if dev < 0 {
+		direction = "low"
+	}
+	doc := Record{
+		"@timestamp":      recordTime(rec).Format(time.RFC3339Nano),
+		"field":           field,
+		"direction":       direction,
+		"value":           v,
+		"baseline":        b.mean,
+		"stddev":          std,
+		"deviation":       dev,
+		"threshold_sigma": a.sigma,
+		"samples":         b.n,
+	}
+	// 분산이 0 이면 sigma 는 무한대라 쓰지 않는다
+	score := "flat baseline"
+	if std > 0 {
+		doc["sigma"] = dev / std
+		score = fmt.Sprintf("%.1f sigma", dev/std)
+	}
+	if hour >= 0 {
+		doc["hour_of_day"] = hour
+	}
+	for _, d := range rollupDimensions {
+		if dv, ok := rec[d]; ok {
+			doc[d] = dv
+		}
+	}
+	doc["message"] = fmt.Sprintf("%s %s: %.4g against a baseline of %.4g (%s), session %v",
+		field, direction, v, b.mean, score, rec["session_id"])
+	return doc
+}
+
+// anomalySchema describes anomaly documents for the index template.
+func anomalySchema() *Schema {
+	s := &Schema{fields: map[string]schemaField{}}
+	add := func(name string, typ fieldType) { s.fields[name] = schemaField{Name: name, Type: typ} }
+	add("@timestamp", typeDate)
+	for _, name := range []string{"field", "direction", "session_name", "session_type", "source_ne", "source_ip", "destination_ip", "system_id"} {
+		add(name, typeString)
+	}
+	for _, name := range []string{"session_id", "samples", "hour_of_day"} {
+		add(name, typeInt)
+	}
+	for _, name := range []string{"value", "baseline", "stddev", "deviation", "sigma", "threshold_sigma"} {
+		add(name, typeFloat)
+	}
+	return s
+}
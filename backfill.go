@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// archiveDay and archiveMonth find the date of a file in a dated archive
+// tree: /archive/2024/05/17/..., /archive/2024-05-17/... or a file name
+// like twamp_20240517.csv. The last match wins, so a file name beats its
+// directory; a tree of year/month directories only dates to the month.
+var (
+	archiveDay   = regexp.MustCompile(`((?:19|20)\d\d)[/_.-]?(0[1-9]|1[0-2])[/_.-]?(0[1-9]|[12]\d|3[01])(?:\D|$)`)
+	archiveMonth = regexp.MustCompile(`((?:19|20)\d\d)[/_-](0[1-9]|1[0-2])(?:/|$)`)
+)
+
+// archiveDate dates rel, a path relative to the archive root, falling back
+// to the file's modification time.
+func archiveDate(rel string, modTime time.Time) time.Time {
+	rel = filepath.ToSlash(rel)
+	if m := archiveDay.FindAllStringSubmatch(rel, -1); m != nil {
+		last := m[len(m)-1]
+		if t, err := time.Parse("2006-01-02", last[1]+"-"+last[2]+"-"+last[3]); err == nil {
+			return t
+		}
+	}
+	if m := archiveMonth.FindAllStringSubmatch(rel, -1); m != nil {
+		last := m[len(m)-1]
+		if t, err := time.Parse("2006-01", last[1]+"-"+last[2]); err == nil {
+			return t
+		}
+	}
+	return modTime.UTC()
+}
+
+type backfillFile struct {
+	path string
+	date time.Time
+	size int64
+}
+
+// planBackfill lists the files under dir dated within since..until (either
+// may be zero, until is inclusive), oldest first; files dated the same day
+// go by modification time, then name.
+func planBackfill(root, dir string, recursive bool, filter fileFilter, since, until time.Time) ([]backfillFile, error) {
+	paths, err := listInputFiles(root, dir, recursive, filter)
+	if err != nil {
+		return nil, err
+	}
+	type dated struct {
+		backfillFile
+		modTime time.Time
+	}
+	var files []dated
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		rel, _ := filepath.Rel(dir, path)
+		date := archiveDate(rel, info.ModTime())
+		day := date.Truncate(24 * time.Hour)
+		if !since.IsZero() && day.Before(since) || !until.IsZero() && day.After(until) {
+			continue
+		}
+		files = append(files, dated{backfillFile{path, date, info.Size()}, info.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if !a.date.Equal(b.date) {
+			return a.date.Before(b.date)
+		}
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime)
+		}
+		return a.path < b.path
+	})
+	out := make([]backfillFile, len(files))
+	for i, f := range files {
+		out[i] = f.backfillFile
+	}
+	return out, nil
+}
+
+// backfillRun tracks a backfill and keeps its checkpoint
+// (BACKFILL_CHECKPOINT): the arguments it was started with, so
+// `twamp backfill -resume` picks it up again, and how far it got. The
+// files themselves are resumed by the ledger: done ones are skipped and an
+// interrupted one continues from its last row checkpoint. A dry run keeps
+// no checkpoint (file is empty); nil outside of backfill.
+type backfillRun struct {
+	file string
+
+	Path        string    `json:"path"`
+	Since       string    `json:"since,omitempty"`
+	Until       string    `json:"until,omitempty"`
+	Recursive   bool      `json:"recursive"`
+	Concurrency int       `json:"concurrency"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Files       int       `json:"files"`
+	Done        int       `json:"done"`
+	Failed      int       `json:"failed"`
+	Bytes       int64     `json:"bytes"`
+	DoneBytes   int64     `json:"done_bytes"`
+	// 이 날짜까지의 파일은 모두 처리됐다
+	Through string `json:"through,omitempty"`
+
+	mu          sync.Mutex
+	files       []backfillFile
+	index       map[string]int
+	finished    []bool
+	next        int // 처음으로 끝나지 않은 파일
+	resumedAt   time.Time
+	startBytes  int64 // 이번 실행 전에 끝난 파일
+	failedBytes int64
+	rows        int64
+}
+
+// loadBackfillRun reads the checkpoint of an interrupted backfill.
+func loadBackfillRun(file string) (*backfillRun, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no backfill to resume, %s doesn't exist", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backfill checkpoint: %w", err)
+	}
+	r := &backfillRun{file: file}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("error parsing backfill checkpoint %s: %w", file, err)
+	}
+	return r, nil
+}
+
+// resume returns the run to start: with -resume the checkpointed one,
+// where flags given on the command line override its settings, else r
+// itself for path.
+func (r *backfillRun) resume(fs *flag.FlagSet, resume bool, args []string) (*backfillRun, error) {
+	run := r
+	if resume {
+		var err error
+		if run, err = loadBackfillRun(r.file); err != nil {
+			return nil, err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "since":
+				run.Since = r.Since
+			case "until":
+				run.Until = r.Until
+			case "recursive":
+				run.Recursive = r.Recursive
+			case "concurrency":
+				run.Concurrency = r.Concurrency
+			}
+		})
+		logIngest.Info("resuming backfill", "path", run.Path, "started_at", run.StartedAt, "through", run.Through)
+	} else {
+		run.Path, run.StartedAt = args[0], time.Now().UTC()
+	}
+	if run.Concurrency < 1 {
+		return nil, fmt.Errorf("-concurrency must be at least 1")
+	}
+	return run, nil
+}
+
+// plan sets the files of the run; the ones the ledger already has as done
+// count as done from the start.
+func (r *backfillRun) plan(files []backfillFile, state *stateStore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files, r.index, r.finished = files, make(map[string]int, len(files)), make([]bool, len(files))
+	r.Files, r.Done, r.Failed, r.Bytes, r.DoneBytes, r.next = len(files), 0, 0, 0, 0, 0
+	r.resumedAt = time.Now()
+	for i, f := range files {
+		r.index[f.path] = i
+		r.Bytes += f.size
+		if state.IsProcessed(f.path) {
+			r.finished[i] = true
+			r.Done++
+			r.DoneBytes += f.size
+		}
+	}
+	r.startBytes = r.DoneBytes
+	r.advanceLocked()
+}
+
+// pending is the files not done yet, in order.
+func (r *backfillRun) pending() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for i, f := range r.files {
+		if !r.finished[i] {
+			out = append(out, f.path)
+		}
+	}
+	return out
+}
+
+// finish records the outcome of one file; failed files are tried again by
+// the next run.
+func (r *backfillRun) finish(path string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.index[path]
+	if !ok || r.finished[i] {
+		return
+	}
+	if err != nil {
+		r.Failed++
+		r.failedBytes += r.files[i].size
+		return
+	}
+	r.finished[i] = true
+	r.Done++
+	r.DoneBytes += r.files[i].size
+	r.advanceLocked()
+}
+
+func (r *backfillRun) advanceLocked() {
+	for r.next < len(r.files) && r.finished[r.next] {
+		r.next++
+	}
+	if r.next > 0 {
+		r.Through = r.files[r.next-1].date.Format("2006-01-02")
+	}
+}
+
+func (r *backfillRun) addRows(n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.rows += n
+	r.mu.Unlock()
+}
+
+type backfillStatus struct {
+	Path       string  `json:"path"`
+	Files      int     `json:"files"`
+	Done       int     `json:"done"`
+	Failed     int     `json:"failed"`
+	Percent    float64 `json:"percent"`
+	Rows       int64   `json:"rows"`
+	RowsPerSec float64 `json:"rows_per_sec"`
+	Through    string  `json:"through,omitempty"`
+	Elapsed    string  `json:"elapsed"`
+	ETA        string  `json:"eta,omitempty"`
+}
+
+// status adds the files in flight (bytes read, rows) to the done ones.
+// Rates and the ETA go by this process; a resumed run starts them over.
+func (r *backfillRun) status(now time.Time, read, rows int64) *backfillStatus {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elapsed := now.Sub(r.resumedAt)
+	done := r.DoneBytes + r.failedBytes + read
+	return &backfillStatus{
+		Path:       r.Path,
+		Files:      r.Files,
+		Done:       r.Done,
+		Failed:     r.Failed,
+		Percent:    percentOf(r.DoneBytes+read, r.Bytes),
+		Rows:       r.rows + rows,
+		RowsPerSec: perSecond(r.rows+rows, elapsed),
+		Through:    r.Through,
+		Elapsed:    elapsed.Truncate(time.Second).String(),
+		ETA:        eta(elapsed, done-r.startBytes, r.Bytes-r.startBytes),
+	}
+}
+
+func (s *backfillStatus) log(msg string) {
+	logIngest.Info(msg, "files_done", s.Done, "files", s.Files, "failed", s.Failed, "percent", s.Percent,
+		"rows", s.Rows, "rows_per_sec", s.RowsPerSec, "through", s.Through, "elapsed", s.Elapsed, "eta", s.ETA)
+}
+
+// save writes the checkpoint, like the ledger through a temp file.
+func (r *backfillRun) save() error {
+	if r == nil || r.file == "" {
+		return nil
+	}
+	r.mu.Lock()
+	r.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := r.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing backfill checkpoint: %w", err)
+	}
+	return os.Rename(tmp, r.file)
+}
+
+// complete removes the checkpoint once every file is done, or saves it
+// for -resume.
+func (r *backfillRun) complete() error {
+	if r == nil || r.file == "" {
+		return nil
+	}
+	r.mu.Lock()
+	done := r.Done == r.Files
+	r.mu.Unlock()
+	if !done {
+		logIngest.Info("backfill incomplete, run twamp backfill -resume to continue", "checkpoint", r.file)
+		return r.save()
+	}
+	if err := os.Remove(r.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// parseBackfillDate parses a -since/-until date.
+func parseBackfillDate(flag, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s %q, want YYYY-MM-DD", flag, s)
+	}
+	return t, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the largest bulk body kept for reuse; a request
+// larger than BULK_FLUSH_BYTES (one big document) isn't worth holding on
+// to.
+const maxPooledBuffer = 16 << 20
+
+// bulkBuffers recycles the bodies of the bulk requests (and of their
+// dry-run and spool copies), so a busy indexer doesn't allocate and grow
+// a few megabytes for every request.
+var bulkBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// bulkBody returns items as one bulk request body, in a buffer sized for
+// them up front. Give it back with putBulkBuffer once it's sent.
+func bulkBody(items []bulkItem) *bytes.Buffer {
+	n := 0
+	for _, item := range items {
+		n += len(item.meta) + len(item.doc)
+	}
+	buf := bulkBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.Grow(n)
+	for _, item := range items {
+		buf.Write(item.meta)
+		buf.Write(item.doc)
+	}
+	return buf
+}
+
+func putBulkBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bulkBuffers.Put(buf)
+	}
+}
+
+// recordBatches recycles the batch slices of the buffered outputs. A
+// batch goes back once its output's Write returned; no output keeps the
+// slice.
+var recordBatches sync.Pool
+
+// getBatch returns an empty batch with room for n records.
+func getBatch(n int) []Record {
+	if p, ok := recordBatches.Get().(*[]Record); ok && cap(*p) >= n {
+		return (*p)[:0]
+	}
+	return make([]Record, 0, n)
+}
+
+// putBatch clears batch, so the pool doesn't keep its records alive, and
+// recycles it.
+func putBatch(batch []Record) {
+	if cap(batch) == 0 {
+		return
+	}
+	clear(batch[:cap(batch)])
+	batch = batch[:0]
+	recordBatches.Put(&batch)
+}
+
+// recordMaps recycles the Records that Schema.Convert fills. A record
+// goes back only from a fanOut with recycleRecords on: its single output
+// encoded it in Write and nothing before it still holds it. Everywhere
+// else records are shared (several outputs, tenant routing) and are left
+// to the GC.
+var recordMaps sync.Pool
+
+// getRecord returns an empty Record, with room for n fields if it's new.
+func getRecord(n int) Record {
+	if rec, ok := recordMaps.Get().(Record); ok {
+		return rec
+	}
+	return make(Record, n)
+}
+
+// putRecord clears rec and recycles it; rec must not be used afterwards.
+func putRecord(rec Record) {
+	clear(rec)
+	recordMaps.Put(rec)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchCSV is the sample CSVexport file with its rows repeated to about
+// rows data rows, in memory.
+func benchCSV(b *testing.B, rows int) []byte {
+	b.Helper()
+	files, _ := filepath.Glob("sample_data/*.csv.gz")
+	if len(files) == 0 {
+		b.Skip("no sample data")
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	header, body, _ := bytes.Cut(data, []byte("\n"))
+	n := bytes.Count(body, []byte("\n"))
+	out := append(append([]byte(nil), header...), '\n')
+	for i := 0; i < rows; i += n {
+		out = append(out, body...)
+	}
+	return out
+}
+
+func benchSchema(b *testing.B) *Schema {
+	b.Helper()
+	ts, err := newTimestampParser(loadConfig())
+	if err != nil {
+		b.Fatal(err)
+	}
+	schema, err := newSchema("", nil, ts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return schema
+}
+
+// parseConvertEncode reads every row of data, converts it and encodes it
+// with encode, like a file on its way to the Elasticsearch output.
+func parseConvertEncode(b *testing.B, parse parserFunc, schema *Schema, data []byte, buf []byte, encode func([]byte, Record) ([]byte, error)) ([]byte, int) {
+	reader, err := parse("bench.csv", bytes.NewReader(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	rows := 0
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return buf, rows
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		rec, err := schema.Convert(reader.Header(), row)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if buf, err = encode(buf[:0], rec); err != nil {
+			b.Fatal(err)
+		}
+		rows++
+	}
+}
+
+func reportRows(b *testing.B, rows int) {
+	b.ReportMetric(float64(rows)/b.Elapsed().Seconds(), "rows/s")
+}
+
+// BenchmarkParseConvertEncode compares appendRecordJSON with the
+// json.Marshal the outputs used before.
+func BenchmarkParseConvertEncode(b *testing.B) {
+	data := benchCSV(b, 1000)
+	schema := benchSchema(b)
+	parse, err := lookupParser("twamp", csvOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	encoders := []struct {
+		name   string
+		encode func([]byte, Record) ([]byte, error)
+	}{
+		{"appendRecordJSON", appendRecordJSON},
+		{"json.Marshal", func(dst []byte, rec Record) ([]byte, error) {
+			data, err := json.Marshal(rec)
+			return append(dst, data...), err
+		}},
+	}
+	for _, e := range encoders {
+		b.Run(e.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			var buf []byte
+			rows := 0
+			for i := 0; i < b.N; i++ {
+				var n int
+				buf, n = parseConvertEncode(b, parse, schema, data, buf, e.encode)
+				rows += n
+			}
+			reportRows(b, rows)
+		})
+	}
+}
+
+func benchItems(n int) []bulkItem {
+	items := make([]bulkItem, n)
+	for i := range items {
+		items[i] = bulkItem{
+			meta: []byte(`{ "create" : { "_index" : "twamp-data", "_id" : "b2d3ec08876b237e01941bab223b9236" } }` + "\n"),
+			doc:  bytes.Repeat([]byte("x"), 1500),
+		}
+	}
+	return items
+}
+
+// BenchmarkBulkBody compares the pooled bulk bodies with a new buffer
+// grown for every request.
+func BenchmarkBulkBody(b *testing.B) {
+	items := benchItems(1000)
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			putBulkBuffer(bulkBody(items))
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			for _, item := range items {
+				buf.Write(item.meta)
+				buf.Write(item.doc)
+			}
+		}
+	})
+}
+
+// BenchmarkBatch compares the pooled batch slices of the buffered outputs
+// with a new slice per batch.
+func BenchmarkBatch(b *testing.B) {
+	rec := Record{"session_id": int64(1)}
+	fill := func(batch []Record) []Record {
+		for j := 0; j < 1000; j++ {
+			batch = append(batch, rec)
+		}
+		return batch
+	}
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			putBatch(fill(getBatch(1000)))
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = fill(nil)
+		}
+	})
+}
+
+// BenchmarkRecords compares converting rows into recycled Records, as a
+// fanOut with recycleRecords does once they're written, with a new map
+// per row.
+func BenchmarkRecords(b *testing.B) {
+	data := benchCSV(b, 1000)
+	schema := benchSchema(b)
+	parse, err := lookupParser("twamp", csvOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, recycle := range []bool{true, false} {
+		name := "recycled"
+		if !recycle {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			encode := func(dst []byte, rec Record) ([]byte, error) {
+				out, err := appendRecordJSON(dst, rec)
+				if recycle {
+					putRecord(rec)
+				}
+				return out, err
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			var buf []byte
+			rows := 0
+			for i := 0; i < b.N; i++ {
+				var n int
+				buf, n = parseConvertEncode(b, parse, schema, data, buf, encode)
+				rows += n
+			}
+			reportRows(b, rows)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Files are parsed as UTF-8; CHARSET (or a pipeline's charset) decodes
+// others first, so Korean device names exported by older EMS in CP949
+// don't reach the outputs as U+FFFD. auto keeps valid UTF-8 and decodes
+// anything else as CP949; it decides at the first non-ASCII byte, the
+// text before being the same in both.
+//
+//	auto    UTF-8, or CP949 if the file isn't valid UTF-8 (default)
+//	utf-8   no decoding
+//	cp949   CP949 / UHC, Windows code page 949
+//	euc-kr  decoded as CP949, which extends it
+
+// withCharset returns parse decoding its input from charset.
+func withCharset(parse parserFunc, charset string) (parserFunc, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8":
+		return parse, nil
+	case "cp949", "euc-kr", "euckr", "uhc":
+		return func(name string, r io.Reader) (rowReader, error) {
+			return parse(name, newCP949Reader(r))
+		}, nil
+	case "auto":
+		return func(name string, r io.Reader) (rowReader, error) {
+			return parse(name, &charsetDetector{name: name, r: bufio.NewReaderSize(r, 64<<10)})
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown charset %q (auto, utf-8, cp949 or euc-kr)", charset)
+}
+
+// charsetDetector passes ASCII on until the first other byte and then
+// checks the buffered data from there: valid UTF-8 stays as it is, the
+// rest of the file is decoded as CP949 otherwise. Hangul in CP949 starts
+// with bytes UTF-8 only uses inside a character, so it's never mistaken
+// for UTF-8.
+type charsetDetector struct {
+	name    string
+	r       *bufio.Reader
+	decided io.Reader
+}
+
+func (d *charsetDetector) Read(p []byte) (int, error) {
+	if d.decided != nil {
+		return d.decided.Read(p)
+	}
+	data, err := d.r.Peek(d.r.Size())
+	if len(data) == 0 {
+		return 0, err
+	}
+	ascii := 0
+	for ascii < len(data) && data[ascii] < utf8.RuneSelf {
+		ascii++
+	}
+	if ascii > 0 {
+		return d.r.Read(p[:min(len(p), ascii)])
+	}
+	if validUTF8Prefix(data, err != nil) {
+		d.decided = d.r
+	} else {
+		logIngest.Debug("file isn't UTF-8, decoding it as CP949", "file", d.name)
+		d.decided = newCP949Reader(d.r)
+	}
+	return d.decided.Read(p)
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8; unless eof a
+// character cut off at the end doesn't count.
+func validUTF8Prefix(b []byte, eof bool) bool {
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return !eof && !utf8.FullRune(b[i:])
+		}
+		i += size
+	}
+	return true
+}
+
+// cp949Reader decodes CP949 into UTF-8. Undefined codes become U+FFFD; an
+// ASCII byte after a lead byte is kept, like Windows does.
+type cp949Reader struct {
+	r     io.Reader
+	in    []byte
+	carry int // a lead byte left at the end of the last read, in in[0]
+	out   []byte
+	buf   []byte
+	err   error
+}
+
+func newCP949Reader(r io.Reader) *cp949Reader {
+	return &cp949Reader{r: r, in: make([]byte, 32<<10)}
+}
+
+func (d *cp949Reader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.r.Read(d.in[d.carry:])
+		d.err = err
+		src := d.in[:d.carry+n]
+		var rest []byte
+		d.buf, rest = appendCP949(d.buf[:0], src, err != nil)
+		d.out = d.buf
+		d.carry = copy(d.in, rest)
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// appendCP949 appends src decoded to dst; rest is a lead byte at the end
+// of src waiting for its trail byte (none when eof).
+func appendCP949(dst, src []byte, eof bool) (out, rest []byte) {
+	for i := 0; i < len(src); {
+		b := src[i]
+		if b < utf8.RuneSelf {
+			j := i + 1
+			for j < len(src) && src[j] < utf8.RuneSelf {
+				j++
+			}
+			dst = append(dst, src[i:j]...)
+			i = j
+			continue
+		}
+		if b == 0x80 || b == 0xff {
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+			i++
+			continue
+		}
+		if i+1 == len(src) {
+			if !eof {
+				return dst, src[i:]
+			}
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+			break
+		}
+		t := src[i+1]
+		if idx := cp949Index(b, t); idx >= 0 && cp949Table[idx] != 0 {
+			dst = utf8.AppendRune(dst, rune(cp949Table[idx]))
+			i += 2
+			continue
+		}
+		dst = utf8.AppendRune(dst, utf8.RuneError)
+		if t < utf8.RuneSelf {
+			i++
+		} else {
+			i += 2
+		}
+	}
+	return dst, nil
+}
+
+// cp949Index is the position of a double-byte code in cp949Table: lead
+// bytes 0x81-0xfe, trail bytes 0x41-0x5a, 0x61-0x7a and 0x81-0xfe.
+func cp949Index(lead, trail byte) int {
+	var t int
+	switch {
+	case trail >= 0x41 && trail <= 0x5a:
+		t = int(trail - 0x41)
+	case trail >= 0x61 && trail <= 0x7a:
+		t = int(trail-0x61) + 26
+	case trail >= 0x81 && trail <= 0xfe:
+		t = int(trail-0x81) + 52
+	default:
+		return -1
+	}
+	return int(lead-0x81)*178 + t
+}
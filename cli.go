@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// command is one twamp subcommand. twamp without a command runs watch.
+type command struct {
+	name    string
+	args    string
+	summary string
+}
+
+var commands = []command{
+	{"watch", "", "watch FILE_PATH and ingest new files until stopped (default)"},
+	{"backfill", "<path>", "ingest a directory, oldest dated files first, or a single file once and exit"},
+	{"replay", "", "resend the dead letters in DEAD_LETTER_DIR, except today's, and exit"},
+	{"validate", "<file>", "parse a file and print the typed records without indexing"},
+	{"state", "[status]", "print the ingestion ledger"},
+	{"sender", "", "measure TWAMP_TARGETS directly and index the results"},
+	{"reflector", "", "run as a TWAMP Light reflector"},
+	{"provision-grafana", "", "create the Grafana datasource and import the TWAMP dashboards"},
+	{"provision-kibana", "", "create the Kibana data view, visualizations and TWAMP overview dashboard"},
+	{"provision-ingest-pipeline", "", "install the recommended Elasticsearch ingest pipeline (date parsing, fingerprint)"},
+	{"report", "", "write the daily or weekly SLA report from the indexed data and exit"},
+	{"reindex", "", "re-index the documents of an existing index through the current transforms into ES_INDEX and exit"},
+}
+
+const refreshHelp = `
+ES_REFRESH sets the refresh parameter of every bulk request:
+  false     (default) documents become searchable on the index's refresh_interval
+            (1s by default); the fastest, let Elasticsearch batch its refreshes
+  wait_for  each bulk response waits for the next scheduled refresh; no extra
+            load, but a request takes up to refresh_interval and so does a file
+  true      refresh after every request; creates a small segment each time and
+            can cut indexing throughput several times over on a busy cluster
+`
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "usage: twamp [--config file] <command> [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nflags:")
+	flag.PrintDefaults()
+	fmt.Fprint(w, refreshHelp)
+	fmt.Fprintln(w, "\nRun 'twamp <command> -h' for the command's flags.")
+}
+
+// parseCommand picks the command from the arguments left after the global
+// flags; no arguments means watch.
+func parseCommand(args []string) (command, []string, error) {
+	if len(args) == 0 {
+		return commands[0], nil, nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c, args[1:], nil
+		}
+	}
+	return command{}, nil, fmt.Errorf("unknown command %q", args[0])
+}
+
+// flags returns the command's flag set; Parse exits with the command's
+// usage on -h or a bad flag.
+func (c command) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "usage: twamp %s [flags] %s\n\n%s\n", c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// exactArgs exits with the command's usage unless fs has n positional args.
+func exactArgs(fs *flag.FlagSet, n int) {
+	if fs.NArg() != n {
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// printSink writes every record as one JSON line instead of indexing it;
+// used by validate.
+type printSink struct {
+	enc   *json.Encoder
+	limit int
+	n     int
+}
+
+func newPrintSink(w io.Writer, limit int) *printSink {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &printSink{enc: enc, limit: limit}
+}
+
+func (s *printSink) Add(rec Record) error {
+	s.n++
+	if s.limit > 0 && s.n > s.limit {
+		return nil
+	}
+	return s.enc.Encode(rec)
+}
+
+func (s *printSink) Flush() error { return nil }
+func (s *printSink) Close() error { return nil }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClickHouseOutput inserts records into CLICKHOUSE_TABLE (OUTPUT=clickhouse)
+// through the HTTP interface, one INSERT ... FORMAT JSONEachRow per batch.
+// @timestamp goes into the timestamp column; fields the table has no column
+// for are skipped, so inventory or GeoIP fields need an ALTER TABLE first.
+type ClickHouseOutput struct {
+	endpoint string
+	user     string
+	password string
+	table    string
+	client   *http.Client
+	retry    retryPolicy
+}
+
+func newClickHouseOutput(config Config) (*ClickHouseOutput, error) {
+	if config.ClickHouseURL == "" {
+		return nil, errors.New("CLICKHOUSE_URL is required for the clickhouse output")
+	}
+	if _, err := url.Parse(config.ClickHouseURL); err != nil {
+		return nil, fmt.Errorf("invalid CLICKHOUSE_URL: %w", err)
+	}
+	return &ClickHouseOutput{
+		endpoint: strings.TrimSuffix(config.ClickHouseURL, "/") + "/",
+		user:     config.ClickHouseUser,
+		password: config.ClickHousePassword,
+		table:    config.ClickHouseTable,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		retry:    config.retryPolicy(),
+	}, nil
+}
+
+func (o *ClickHouseOutput) Name() string { return "clickhouse" }
+
+func (o *ClickHouseOutput) Close() error { return nil }
+
+func (o *ClickHouseOutput) Write(ctx context.Context, records []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, rec := range records {
+		row := make(Record, len(rec))
+		for k, v := range rec {
+			if k == "@timestamp" {
+				k = "timestamp"
+			}
+			row[k] = v
+		}
+		if err := enc.Encode(row); err != nil {
+			return fmt.Errorf("error marshalling record: %w", err)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	query := "INSERT INTO " + o.table + " FORMAT JSONEachRow"
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := o.exec(ctx, query, body.Bytes())
+		metricBulkLatency.Observe(time.Since(start).Seconds(), o.Name())
+		if err == nil {
+			metricDocumentsIndexed.Add(float64(len(records)), o.Name())
+			logOutput.Debug("rows inserted", "output", o.Name(), "rows", len(records), "table", o.table)
+			return nil
+		}
+		metricBulkFailures.Inc(o.Name())
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= o.retry.MaxAttempts {
+			return fmt.Errorf("giving up on clickhouse insert after %d attempts: %w", attempt, err)
+		}
+		wait := o.retry.backoff(attempt)
+		metricBulkRetries.Inc(o.Name())
+		logOutput.Warn("clickhouse insert failed, retrying", "attempt", attempt, "max_attempts", o.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// exec runs query with body as its data (the INSERT rows), or as the
+// statement itself when query is empty.
+func (o *ClickHouseOutput) exec(ctx context.Context, query string, body []byte) error {
+	q := url.Values{
+		"input_format_skip_unknown_fields": {"1"},
+		"date_time_input_format":           {"best_effort"},
+	}
+	if query != "" {
+		q.Set("query", query)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint+"?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if o.user != "" {
+		req.Header.Set("X-ClickHouse-User", o.user)
+		req.Header.Set("X-ClickHouse-Key", o.password)
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	err = fmt.Errorf("clickhouse: %s: %s", res.Status, bytes.TrimSpace(msg))
+	if retryableStatus(res.StatusCode) {
+		return retryableError{err}
+	}
+	return err
+}
+
+// bootstrap creates the table if it doesn't exist, with a column per
+// schema field (after MAPPING_FILE) plus the KPIs: a MergeTree partitioned
+// by month and ordered by session and time, which suits per-session history
+// and capacity planning scans. An existing table is left alone.
+func (o *ClickHouseOutput) bootstrap(ctx context.Context, schema *Schema, mapping *fieldMapping, config Config) error {
+	names, types := tableColumns(schema, mapping, config)
+	cols := []string{"`timestamp` DateTime64(3, 'UTC')"}
+	for _, name := range names {
+		typ := clickHouseType(types[name])
+		if name == "session_id" {
+			typ = "Int64" // ORDER BY 키는 Nullable 이 될 수 없다
+		}
+		cols = append(cols, "`"+strings.ReplaceAll(name, "`", "\\`")+"` "+typ)
+	}
+	cols = append(cols, "`"+pipelineField+"` LowCardinality(String) DEFAULT ''")
+	ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n) ENGINE = MergeTree\nPARTITION BY toYYYYMM(timestamp)\nORDER BY (session_id, timestamp)",
+		o.table, strings.Join(cols, ",\n  "))
+	if config.ClickHouseTTL != "" {
+		ddl += "\nTTL toDateTime(timestamp) + INTERVAL " + config.ClickHouseTTL
+	}
+	if err := o.exec(ctx, "", []byte(ddl)); err != nil {
+		return fmt.Errorf("error creating table %s: %w", o.table, err)
+	}
+	logOutput.Info("clickhouse table ready", "table", o.table, "columns", len(cols))
+	return nil
+}
+
+func clickHouseType(esType string) string {
+	switch esType {
+	case "long":
+		return "Nullable(Int64)"
+	case "double":
+		return "Nullable(Float64)"
+	case "boolean":
+		return "Nullable(Bool)"
+	case "date":
+		return "Nullable(DateTime64(3, 'UTC'))"
+	}
+	return "Nullable(String)"
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	FilePath        string
+	WatchRecursive  bool
+	IncludePatterns string
+	ExcludePatterns string
+	Parser          string
+	Charset         string
+	CSVDelimiter    string
+	CSVQuotes       string
+	CSVComment      string
+	CSVComments     string
+	CSVSkipLines    int
+	CSVFooter       string
+	CSVHeader       string
+	CSVColumns      string
+	GzipBlocks      int
+	GzipBlockSize   int
+	Pipelines       []pipelineConfig // config file only, see pipeline.go
+	Tenants         []tenantConfig   // config file only, see tenant.go
+
+	WatchMode         string
+	WatchPollInterval time.Duration
+	WatchSettleTime   time.Duration
+	WatchReconcile    time.Duration
+	WatchTempSuffixes string
+
+	Output         string
+	DocumentFormat string
+
+	DryRun       bool
+	DryRunOutput string
+
+	ESServer   string
+	ESUser     string
+	ESPassword string
+	ESAPIKey   string
+	ESToken    string
+	ESCloudID  string
+	ESIndex    string
+	SchemaFile string
+
+	TimestampColumns  string
+	TimestampLayouts  string
+	TimestampTimezone string
+	TimestampOnError  string
+
+	KPIEnrich             bool
+	KPIUnavailableLossPct float64
+	OWDTimestampFields    string
+	OWDTimestampUnit      string
+	SyncStatusSynced      string
+	MappingFile           string
+	FieldsInclude         string
+	FieldsExclude         string
+	DelayUnit             string
+	DelayPrecision        int
+	ValidationFile        string
+	InventoryFile         string
+	NetBoxURL             string
+	NetBoxToken           string
+	InventoryRefresh      time.Duration
+	GeoIPCityDB           string
+	GeoIPASNDB            string
+
+	RollupEnabled  bool
+	RollupIndex    string
+	RollupInterval time.Duration
+
+	SampleEvery           int
+	SampleInterval        time.Duration
+	SampleKeepUnavailable bool
+
+	CorrelateDirections     bool
+	CorrelateDirectionField string
+	CorrelateForward        string
+	CorrelateReverse        string
+	CorrelateKey            string
+	CorrelateInterval       time.Duration
+
+	AlertsFile  string
+	AlertsIndex string
+	NotifyFile  string
+
+	AnomalyDetection  bool
+	AnomalyFields     string
+	AnomalySigma      float64
+	AnomalyAlpha      float64
+	AnomalyMinSamples int
+	AnomalySeasonal   bool
+	AnomalyIndex      string
+
+	AlarmCorrelation bool
+	AlarmField       string
+	AlarmClearValues string
+	AlarmDegradedRTT time.Duration
+	AlarmsIndex      string
+
+	GrafanaURL        string
+	GrafanaToken      string
+	GrafanaUser       string
+	GrafanaPassword   string
+	GrafanaFolder     string
+	GrafanaDatasource string
+	GrafanaESURL      string
+
+	KibanaURL   string
+	KibanaSpace string
+
+	ReportSource          string
+	ReportGroupBy         string
+	ReportFormat          string
+	ReportDir             string
+	ReportSchedule        string
+	ReportAt              string
+	ReportTimezone        string
+	ReportEmailChannel    string
+	ReportSLAAvailability float64
+	ReportSLALatency      time.Duration
+	ReportSLALossPct      float64
+
+	ESDataStream bool
+	ESWriteAlias string
+	ESDuplicates string
+	ESIDTemplate string
+	ESRouting    string
+	ESPipeline   string
+	ESProduct    string
+	ESRefresh    string
+
+	ESCACert             string
+	ESClientCert         string
+	ESClientKey          string
+	ESInsecureSkipVerify bool
+
+	ESCompress      bool
+	ESCompressLevel int
+
+	ESRateLimitDocs     float64
+	ESRateLimitRequests float64
+	ESWatermarkPause    bool
+	ESWatermarkCheck    time.Duration
+
+	ESSecondaryServer   string
+	ESSecondaryUser     string
+	ESSecondaryPassword string
+	ESSecondaryAPIKey   string
+	ESFailoverAfter     time.Duration
+	ESFailbackCheck     time.Duration
+	ESReconcileDir      string
+
+	ESBootstrap        bool
+	ESTemplateName     string
+	ILMPolicy          string
+	ILMRolloverMaxAge  string
+	ILMRolloverMaxSize string
+	ILMRolloverMaxDocs int
+	ILMRollover        string
+	ILMRolloverCheck   time.Duration
+	ILMWarmAfter       string
+	ILMDeleteAfter     string
+
+	DeadLetterDir  string
+	ErrorsDir      string
+	RejectDir      string
+	StateFile      string
+	CheckpointRows int
+	FileTimeout    time.Duration
+
+	BackfillCheckpoint string
+	ProgressInterval   time.Duration
+
+	RetentionMaxAge   time.Duration
+	RetentionAction   string
+	RetentionInterval time.Duration
+	RetentionDryRun   bool
+
+	Workers   int
+	QueueSize int
+
+	LogLevel     string
+	LogLevels    string
+	LogFormat    string
+	LogDocuments bool
+	LogFile      string
+
+	GRPCAddr            string
+	GRPCTLSCert         string
+	GRPCTLSKey          string
+	GRPCToken           string
+	GRPCMaxMessageBytes int
+
+	HTTPAddr            string
+	AdminToken          string
+	HealthCheckInterval time.Duration
+	ShutdownGrace       time.Duration
+
+	BulkSize            int
+	BulkFlushInterval   time.Duration
+	BulkFlushBytes      int
+	BulkWorkers         int
+	BulkTimeout         time.Duration
+	BulkAdaptive        bool
+	BulkAdaptiveMin     int
+	BulkAdaptiveMax     int
+	BulkAdaptiveLatency time.Duration
+	OutputQueueSize     int
+	OutputStallTimeout  time.Duration
+	FileOutputPath      string
+	FileOutputMaxBytes  int
+	FileOutputRotate    time.Duration
+	FileOutputGzip      bool
+	FileOutputKeep      int
+
+	RetryMaxAttempts    int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+
+	SpoolDir           string
+	SpoolMaxBytes      int
+	SpoolRetryInterval time.Duration
+
+	WALDir           string
+	WALMaxBytes      int
+	WALFsync         string
+	WALFsyncInterval time.Duration
+
+	S3Bucket          string
+	S3Prefix          string
+	S3Region          string
+	S3Endpoint        string
+	S3PathStyle       bool
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3SessionToken    string
+	S3PollInterval    time.Duration
+	S3SpoolDir        string
+
+	PullFile           string
+	PullSpoolDir       string
+	PullCheckpointFile string
+
+	KafkaBrokers  string
+	KafkaTopic    string
+	KafkaAcks     int
+	KafkaClientID string
+
+	InfluxURL         string
+	InfluxToken       string
+	InfluxOrg         string
+	InfluxBucket      string
+	InfluxDatabase    string
+	InfluxMeasurement string
+	InfluxTags        string
+	InfluxFields      string
+
+	ClickHouseURL       string
+	ClickHouseUser      string
+	ClickHousePassword  string
+	ClickHouseTable     string
+	ClickHouseBootstrap bool
+	ClickHouseTTL       string
+
+	PostgresURL       string
+	PostgresTable     string
+	PostgresBootstrap bool
+	PostgresTimescale bool
+
+	ParquetDir         string
+	ParquetPartition   string
+	ParquetCompression string
+	ParquetS3Bucket    string
+	ParquetS3Prefix    string
+
+	RemoteWriteURL      string
+	RemoteWriteUser     string
+	RemoteWritePassword string
+	RemoteWriteToken    string
+	RemoteWriteTenant   string
+	RemoteWriteLabels   string
+
+	OTLPEndpoint           string
+	OTLPHeaders            string
+	OTelServiceName        string
+	OTelResourceAttributes string
+	OTelMetricsAttributes  string
+	OTelTracesExporter     string
+
+	TwampTargets string
+	TwampSender  twampSenderConfig
+
+	TwampReflector twampReflectorConfig
+
+	invalid []error // 읽지 못한 환경 변수 값, validate 가 알린다
+}
+
+func loadConfig() Config {
+	env := &envParser{}
+	c := Config{
+		FilePath:        os.Getenv("FILE_PATH"),
+		WatchRecursive:  env.bool("WATCH_RECURSIVE", false),
+		IncludePatterns: envString("INCLUDE_PATTERNS", "*.gz,*.csv,*.zip,*.tar,*.tgz,*.zst"),
+		ExcludePatterns: os.Getenv("EXCLUDE_PATTERNS"),
+		Parser:          envString("PARSER", "twamp"),
+		Charset:         envString("CHARSET", "auto"),
+		CSVDelimiter:    os.Getenv("CSV_DELIMITER"),
+		CSVQuotes:       os.Getenv("CSV_QUOTES"),
+		CSVComment:      os.Getenv("CSV_COMMENT"),
+		CSVComments:     os.Getenv("CSV_COMMENTS"),
+		CSVSkipLines:    env.int("CSV_SKIP_LINES", 0),
+		CSVFooter:       os.Getenv("CSV_FOOTER"),
+		CSVHeader:       os.Getenv("CSV_HEADER"),
+		CSVColumns:      os.Getenv("CSV_COLUMNS"),
+		GzipBlocks:      env.int("GZIP_BLOCKS", 4),
+		GzipBlockSize:   env.int("GZIP_BLOCK_SIZE", 1<<20),
+
+		WatchMode:         envString("WATCH_MODE", "notify"),
+		WatchPollInterval: env.duration("WATCH_POLL_INTERVAL", 30*time.Second),
+		WatchSettleTime:   env.duration("WATCH_SETTLE_TIME", 5*time.Second),
+		WatchReconcile:    env.duration("WATCH_RECONCILE", 5*time.Minute),
+		WatchTempSuffixes: envString("WATCH_TEMP_SUFFIXES", ".tmp,.temp,.part,.partial,.filepart,.crdownload"),
+
+		Output:         envString("OUTPUT", "elasticsearch"),
+		DocumentFormat: envString("DOCUMENT_FORMAT", "raw"),
+
+		DryRun:       env.bool("DRY_RUN", false),
+		DryRunOutput: envString("DRY_RUN_OUTPUT", "-"),
+
+		ESServer:   os.Getenv("ES_SERVER"),
+		ESUser:     os.Getenv("ES_USER"),
+		ESPassword: os.Getenv("ES_PASSWORD"),
+		ESAPIKey:   os.Getenv("ES_API_KEY"),
+		ESToken:    os.Getenv("ES_SERVICE_TOKEN"),
+		ESCloudID:  os.Getenv("ES_CLOUD_ID"),
+		ESIndex:    envString("ES_INDEX", "twamp-data"),
+		SchemaFile: os.Getenv("SCHEMA_FILE"),
+
+		TimestampColumns:  envString("TIMESTAMP_COLUMNS", "statTime"),
+		TimestampLayouts:  envString("TIMESTAMP_LAYOUTS", defaultTimestampLayouts),
+		TimestampTimezone: envString("TIMESTAMP_TIMEZONE", "Local"),
+		TimestampOnError:  envString("TIMESTAMP_ON_ERROR", "reject"),
+
+		KPIEnrich:             env.bool("KPI_ENRICH", true),
+		KPIUnavailableLossPct: env.float("KPI_UNAVAILABLE_LOSS_PCT", 50),
+		OWDTimestampFields:    envString("OWD_TIMESTAMP_FIELDS", "t1,t2,t3,t4"),
+		OWDTimestampUnit:      envString("OWD_TIMESTAMP_UNIT", "us"),
+		SyncStatusSynced:      envString("SYNC_STATUS_SYNCED", "1"),
+		MappingFile:           os.Getenv("MAPPING_FILE"),
+		FieldsInclude:         os.Getenv("FIELDS_INCLUDE"),
+		FieldsExclude:         os.Getenv("FIELDS_EXCLUDE"),
+		DelayUnit:             envString("DELAY_UNIT", "us"),
+		DelayPrecision:        env.int("DELAY_PRECISION", 3),
+		ValidationFile:        os.Getenv("VALIDATION_FILE"),
+		InventoryFile:         os.Getenv("INVENTORY_FILE"),
+		NetBoxURL:             os.Getenv("INVENTORY_NETBOX_URL"),
+		NetBoxToken:           os.Getenv("INVENTORY_NETBOX_TOKEN"),
+		InventoryRefresh:      env.duration("INVENTORY_REFRESH", 15*time.Minute),
+		GeoIPCityDB:           os.Getenv("GEOIP_CITY_DB"),
+		GeoIPASNDB:            os.Getenv("GEOIP_ASN_DB"),
+
+		RollupEnabled:  env.bool("ROLLUP_ENABLED", false),
+		RollupIndex:    envString("ROLLUP_INDEX", "twamp-rollup"),
+		RollupInterval: env.duration("ROLLUP_INTERVAL", 15*time.Minute),
+
+		SampleEvery:           env.int("SAMPLE_EVERY", 1),
+		SampleInterval:        env.duration("SAMPLE_INTERVAL", 0),
+		SampleKeepUnavailable: env.bool("SAMPLE_KEEP_UNAVAILABLE", true),
+
+		CorrelateDirections:     env.bool("CORRELATE_DIRECTIONS", false),
+		CorrelateDirectionField: envString("CORRELATE_DIRECTION_FIELD", "direction"),
+		CorrelateForward:        envString("CORRELATE_FORWARD", "forward,near-end,near,tx,ul"),
+		CorrelateReverse:        envString("CORRELATE_REVERSE", "reverse,far-end,far,rx,dl"),
+		CorrelateKey:            envString("CORRELATE_KEY", "session_id"),
+		CorrelateInterval:       env.duration("CORRELATE_INTERVAL", time.Minute),
+
+		AlertsFile:  os.Getenv("ALERTS_FILE"),
+		AlertsIndex: envString("ALERTS_INDEX", "twamp-alerts"),
+		NotifyFile:  os.Getenv("NOTIFY_FILE"),
+
+		AnomalyDetection:  env.bool("ANOMALY_DETECTION", false),
+		AnomalyFields:     envString("ANOMALY_FIELDS", "rtt_mean=1ms,loss_pct=1"),
+		AnomalySigma:      env.float("ANOMALY_SIGMA", 3),
+		AnomalyAlpha:      env.float("ANOMALY_ALPHA", 0.05),
+		AnomalyMinSamples: env.int("ANOMALY_MIN_SAMPLES", 30),
+		AnomalySeasonal:   env.bool("ANOMALY_SEASONAL", false),
+		AnomalyIndex:      envString("ANOMALY_INDEX", "twamp-anomalies"),
+
+		AlarmCorrelation: env.bool("ALARM_CORRELATION", false),
+		AlarmField:       envString("ALARM_FIELD", "alarmid"),
+		AlarmClearValues: envString("ALARM_CLEAR_VALUES", "0,none,-"),
+		AlarmDegradedRTT: env.duration("ALARM_DEGRADED_RTT", 0),
+		AlarmsIndex:      envString("ALARMS_INDEX", "twamp-alarms"),
+
+		GrafanaURL:        os.Getenv("GRAFANA_URL"),
+		GrafanaToken:      os.Getenv("GRAFANA_TOKEN"),
+		GrafanaUser:       os.Getenv("GRAFANA_USER"),
+		GrafanaPassword:   os.Getenv("GRAFANA_PASSWORD"),
+		GrafanaFolder:     envString("GRAFANA_FOLDER", "TWAMP"),
+		GrafanaDatasource: envString("GRAFANA_DATASOURCE", "TWAMP Elasticsearch"),
+		GrafanaESURL:      os.Getenv("GRAFANA_ES_URL"),
+
+		KibanaURL:   os.Getenv("KIBANA_URL"),
+		KibanaSpace: os.Getenv("KIBANA_SPACE"),
+
+		ReportSource:          envString("REPORT_SOURCE", "raw"),
+		ReportGroupBy:         envString("REPORT_GROUP_BY", "session_name"),
+		ReportFormat:          envString("REPORT_FORMAT", "html"),
+		ReportDir:             envString("REPORT_DIR", "reports"),
+		ReportSchedule:        os.Getenv("REPORT_SCHEDULE"),
+		ReportAt:              envString("REPORT_AT", "06:00"),
+		ReportTimezone:        envString("REPORT_TIMEZONE", "Local"),
+		ReportEmailChannel:    os.Getenv("REPORT_EMAIL_CHANNEL"),
+		ReportSLAAvailability: env.float("REPORT_SLA_AVAILABILITY", 99.9),
+		ReportSLALatency:      env.duration("REPORT_SLA_LATENCY_P95", 0),
+		ReportSLALossPct:      env.float("REPORT_SLA_LOSS_PCT", 1),
+
+		ESDataStream: env.bool("ES_DATA_STREAM", false),
+		ESWriteAlias: os.Getenv("ES_WRITE_ALIAS"),
+		ESDuplicates: envString("ES_DUPLICATES", "skip"),
+		ESIDTemplate: os.Getenv("ES_ID_TEMPLATE"),
+		ESRouting:    os.Getenv("ES_ROUTING_TEMPLATE"),
+		ESPipeline:   os.Getenv("ES_PIPELINE"),
+		ESProduct:    envString("ES_PRODUCT", "elasticsearch"),
+		ESRefresh:    envString("ES_REFRESH", "false"),
+
+		ESCACert:             os.Getenv("ES_CA_CERT"),
+		ESClientCert:         os.Getenv("ES_CLIENT_CERT"),
+		ESClientKey:          os.Getenv("ES_CLIENT_KEY"),
+		ESInsecureSkipVerify: env.bool("ES_TLS_INSECURE_SKIP_VERIFY", false),
+
+		ESCompress:      env.bool("ES_COMPRESS", true),
+		ESCompressLevel: env.int("ES_COMPRESS_LEVEL", gzip.DefaultCompression),
+
+		ESRateLimitDocs:     env.float("ES_RATE_LIMIT_DOCS", 0),
+		ESRateLimitRequests: env.float("ES_RATE_LIMIT_REQUESTS", 0),
+		ESWatermarkPause:    env.bool("ES_WATERMARK_PAUSE", true),
+		ESWatermarkCheck:    env.duration("ES_WATERMARK_CHECK", time.Minute),
+
+		ESSecondaryServer:   os.Getenv("ES_SECONDARY_SERVER"),
+		ESSecondaryUser:     os.Getenv("ES_SECONDARY_USER"),
+		ESSecondaryPassword: os.Getenv("ES_SECONDARY_PASSWORD"),
+		ESSecondaryAPIKey:   os.Getenv("ES_SECONDARY_API_KEY"),
+		ESFailoverAfter:     env.duration("ES_FAILOVER_AFTER", time.Minute),
+		ESFailbackCheck:     env.duration("ES_FAILBACK_CHECK", 30*time.Second),
+		ESReconcileDir:      envString("ES_RECONCILE_DIR", "./es-reconcile"),
+
+		ESBootstrap:        env.bool("ES_BOOTSTRAP", true),
+		ESTemplateName:     envString("ES_TEMPLATE_NAME", "twamp-data"),
+		ILMPolicy:          envString("ILM_POLICY", "twamp-data"),
+		ILMRolloverMaxAge:  envString("ILM_ROLLOVER_MAX_AGE", "1d"),
+		ILMRolloverMaxSize: envString("ILM_ROLLOVER_MAX_SIZE", "50gb"),
+		ILMRolloverMaxDocs: env.int("ILM_ROLLOVER_MAX_DOCS", 0),
+		ILMRollover:        envString("ILM_ROLLOVER", "auto"),
+		ILMRolloverCheck:   env.duration("ILM_ROLLOVER_CHECK", 5*time.Minute),
+		ILMWarmAfter:       envString("ILM_WARM_AFTER", "7d"),
+		ILMDeleteAfter:     envString("ILM_DELETE_AFTER", "30d"),
+
+		DeadLetterDir:  envString("DEAD_LETTER_DIR", "./dead_letter"),
+		ErrorsDir:      envString("ERRORS_DIR", "./errors"),
+		RejectDir:      os.Getenv("REJECT_DIR"),
+		StateFile:      envString("STATE_FILE", "./twamp-state.json"),
+		CheckpointRows: env.int("CHECKPOINT_ROWS", 50000),
+		FileTimeout:    env.duration("FILE_TIMEOUT", 0),
+
+		BackfillCheckpoint: envString("BACKFILL_CHECKPOINT", "./twamp-backfill.json"),
+		ProgressInterval:   env.duration("PROGRESS_INTERVAL", 30*time.Second),
+
+		RetentionMaxAge:   env.duration("RETENTION_MAX_AGE", 0),
+		RetentionAction:   envString("RETENTION_ACTION", "delete"),
+		RetentionInterval: env.duration("RETENTION_INTERVAL", time.Hour),
+		RetentionDryRun:   env.bool("RETENTION_DRY_RUN", false),
+
+		Workers:   env.int("WORKERS", 4),
+		QueueSize: env.int("QUEUE_SIZE", 100),
+
+		LogLevel:     envString("LOG_LEVEL", "info"),
+		LogLevels:    os.Getenv("LOG_LEVELS"),
+		LogFormat:    envString("LOG_FORMAT", "text"),
+		LogDocuments: env.bool("LOG_DOCUMENTS", false),
+		LogFile:      os.Getenv("LOG_FILE"),
+
+		GRPCAddr:            os.Getenv("GRPC_ADDR"),
+		GRPCTLSCert:         os.Getenv("GRPC_TLS_CERT"),
+		GRPCTLSKey:          os.Getenv("GRPC_TLS_KEY"),
+		GRPCToken:           os.Getenv("GRPC_TOKEN"),
+		GRPCMaxMessageBytes: env.int("GRPC_MAX_MESSAGE_BYTES", 4<<20),
+
+		HTTPAddr:            envString("HTTP_ADDR", ":9108"),
+		AdminToken:          os.Getenv("ADMIN_TOKEN"),
+		HealthCheckInterval: env.duration("HEALTH_CHECK_INTERVAL", 15*time.Second),
+		ShutdownGrace:       env.duration("SHUTDOWN_GRACE", 30*time.Second),
+
+		BulkSize:            env.int("BULK_SIZE", 5000),
+		BulkFlushInterval:   env.duration("BULK_FLUSH_INTERVAL", 5*time.Second),
+		BulkFlushBytes:      env.int("BULK_FLUSH_BYTES", 5<<20),
+		BulkWorkers:         env.int("BULK_WORKERS", 2),
+		BulkTimeout:         env.duration("BULK_TIMEOUT", time.Minute),
+		BulkAdaptive:        env.bool("BULK_ADAPTIVE", false),
+		BulkAdaptiveMin:     env.int("BULK_ADAPTIVE_MIN", 500),
+		BulkAdaptiveMax:     env.int("BULK_ADAPTIVE_MAX", 20000),
+		BulkAdaptiveLatency: env.duration("BULK_ADAPTIVE_LATENCY", 2*time.Second),
+		OutputQueueSize:     env.int("OUTPUT_QUEUE_SIZE", 4),
+		OutputStallTimeout:  env.duration("OUTPUT_STALL_TIMEOUT", 15*time.Minute),
+		FileOutputPath:      envString("FILE_OUTPUT_PATH", "./twamp-output.ndjson"),
+		FileOutputMaxBytes:  env.int("FILE_OUTPUT_MAX_BYTES", 0),
+		FileOutputRotate:    env.duration("FILE_OUTPUT_ROTATE", 0),
+		FileOutputGzip:      env.bool("FILE_OUTPUT_GZIP", false),
+		FileOutputKeep:      env.int("FILE_OUTPUT_KEEP", 0),
+
+		RetryMaxAttempts:    env.int("RETRY_MAX_ATTEMPTS", 5),
+		RetryInitialBackoff: env.duration("RETRY_INITIAL_BACKOFF", 500*time.Millisecond),
+		RetryMaxBackoff:     env.duration("RETRY_MAX_BACKOFF", 30*time.Second),
+
+		SpoolDir:           os.Getenv("SPOOL_DIR"),
+		SpoolMaxBytes:      env.int("SPOOL_MAX_BYTES", 1<<30),
+		SpoolRetryInterval: env.duration("SPOOL_RETRY_INTERVAL", 10*time.Second),
+
+		WALDir:           os.Getenv("WAL_DIR"),
+		WALMaxBytes:      env.int("WAL_MAX_BYTES", 1<<30),
+		WALFsync:         envString("WAL_FSYNC", "interval"),
+		WALFsyncInterval: env.duration("WAL_FSYNC_INTERVAL", time.Second),
+
+		S3Bucket:          os.Getenv("S3_BUCKET"),
+		S3Prefix:          os.Getenv("S3_PREFIX"),
+		S3Region:          envString("S3_REGION", envString("AWS_REGION", "us-east-1")),
+		S3Endpoint:        os.Getenv("S3_ENDPOINT"),
+		S3PathStyle:       env.bool("S3_PATH_STYLE", false),
+		S3AccessKeyID:     envString("S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+		S3SecretAccessKey: envString("S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		S3SessionToken:    envString("S3_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN")),
+		S3PollInterval:    env.duration("S3_POLL_INTERVAL", time.Minute),
+		S3SpoolDir:        envString("S3_SPOOL_DIR", "./s3-spool"),
+
+		PullFile:           os.Getenv("PULL_FILE"),
+		PullSpoolDir:       envString("PULL_SPOOL_DIR", "./pull-spool"),
+		PullCheckpointFile: envString("PULL_CHECKPOINT_FILE", "./pull-checkpoint.json"),
+
+		KafkaBrokers:  os.Getenv("KAFKA_BROKERS"),
+		KafkaTopic:    envString("KAFKA_TOPIC", "twamp-data"),
+		KafkaAcks:     env.int("KAFKA_ACKS", -1),
+		KafkaClientID: envString("KAFKA_CLIENT_ID", "twamp"),
+
+		InfluxURL:         os.Getenv("INFLUX_URL"),
+		InfluxToken:       os.Getenv("INFLUX_TOKEN"),
+		InfluxOrg:         os.Getenv("INFLUX_ORG"),
+		InfluxBucket:      os.Getenv("INFLUX_BUCKET"),
+		InfluxDatabase:    os.Getenv("INFLUX_DATABASE"),
+		InfluxMeasurement: envString("INFLUX_MEASUREMENT", "twamp"),
+		InfluxTags:        envString("INFLUX_TAGS", "session_id,session_name,source_ne,source_ip,destination_ip,source_hostname,destination_hostname,pipeline"),
+		InfluxFields:      envString("INFLUX_FIELDS", "rtt_*,*_jitter,*loss_pct,available"),
+
+		ClickHouseURL:       os.Getenv("CLICKHOUSE_URL"),
+		ClickHouseUser:      os.Getenv("CLICKHOUSE_USER"),
+		ClickHousePassword:  os.Getenv("CLICKHOUSE_PASSWORD"),
+		ClickHouseTable:     envString("CLICKHOUSE_TABLE", "twamp"),
+		ClickHouseBootstrap: env.bool("CLICKHOUSE_BOOTSTRAP", true),
+		ClickHouseTTL:       os.Getenv("CLICKHOUSE_TTL"),
+
+		PostgresURL:       os.Getenv("POSTGRES_URL"),
+		PostgresTable:     envString("POSTGRES_TABLE", "twamp"),
+		PostgresBootstrap: env.bool("POSTGRES_BOOTSTRAP", true),
+		PostgresTimescale: env.bool("POSTGRES_TIMESCALE", false),
+
+		ParquetDir:         envString("PARQUET_DIR", "./parquet"),
+		ParquetPartition:   envString("PARQUET_PARTITION", "date=%{+yyyy-MM-dd}/device=%{source_ne}"),
+		ParquetCompression: envString("PARQUET_COMPRESSION", "gzip"),
+		ParquetS3Bucket:    os.Getenv("PARQUET_S3_BUCKET"),
+		ParquetS3Prefix:    os.Getenv("PARQUET_S3_PREFIX"),
+
+		RemoteWriteURL:      os.Getenv("REMOTE_WRITE_URL"),
+		RemoteWriteUser:     os.Getenv("REMOTE_WRITE_USER"),
+		RemoteWritePassword: os.Getenv("REMOTE_WRITE_PASSWORD"),
+		RemoteWriteToken:    os.Getenv("REMOTE_WRITE_TOKEN"),
+		RemoteWriteTenant:   os.Getenv("REMOTE_WRITE_TENANT"),
+		RemoteWriteLabels:   envString("REMOTE_WRITE_LABELS", "device=source_ne,session=session_id,session_name,source_ip,destination_ip,pipeline"),
+
+		OTLPEndpoint:           os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		OTLPHeaders:            os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"),
+		OTelServiceName:        envString("OTEL_SERVICE_NAME", "twamp"),
+		OTelResourceAttributes: os.Getenv("OTEL_RESOURCE_ATTRIBUTES"),
+		OTelMetricsAttributes:  envString("OTEL_METRICS_ATTRIBUTES", "device=source_ne,session=session_id,session_name,source_ip,destination_ip,pipeline"),
+		OTelTracesExporter:     envString("OTEL_TRACES_EXPORTER", "none"),
+
+		TwampTargets: os.Getenv("TWAMP_TARGETS"),
+		TwampSender: twampSenderConfig{
+			Packets:        env.int("TWAMP_PACKETS", 100),
+			PacketInterval: env.duration("TWAMP_PACKET_INTERVAL", 100*time.Millisecond),
+			Padding:        env.int("TWAMP_PADDING", twampReflectorHeaderSize-twampSenderHeaderSize),
+			Wait:           env.duration("TWAMP_WAIT", 2*time.Second),
+			ReceiverPort:   env.int("TWAMP_RECEIVER_PORT", twampTestPort),
+			DSCP:           env.int("TWAMP_DSCP", 0),
+			Light:          env.bool("TWAMP_LIGHT", false),
+			Interval:       env.duration("TWAMP_INTERVAL", 60*time.Second),
+			Timeout:        env.duration("TWAMP_TIMEOUT", 5*time.Second),
+		},
+
+		TwampReflector: twampReflectorConfig{
+			Addr:     envString("TWAMP_REFLECTOR_ADDR", ":862"),
+			Interval: env.duration("TWAMP_INTERVAL", 60*time.Second),
+		},
+	}
+	c.invalid = env.errs
+	return c
+}
+
+func (c Config) retryPolicy() retryPolicy {
+	return retryPolicy{
+		MaxAttempts:    c.RetryMaxAttempts,
+		InitialBackoff: c.RetryInitialBackoff,
+		MaxBackoff:     c.RetryMaxBackoff,
+	}
+}
+
+// dataIndex is where the data documents are written and searched:
+// ES_WRITE_ALIAS if set, ES_INDEX otherwise.
+func (c Config) dataIndex() string {
+	if c.ESWriteAlias != "" {
+		return c.ESWriteAlias
+	}
+	return c.ESIndex
+}
+
+func (c Config) csvOptions() csvOptions {
+	opts := csvOptions{Delimiter: c.CSVDelimiter, Quotes: c.CSVQuotes, Comment: c.CSVComment, Comments: splitList(c.CSVComments),
+		SkipLines: c.CSVSkipLines, Footer: c.CSVFooter, Columns: splitList(c.CSVColumns)}
+	if h, err := strconv.ParseBool(c.CSVHeader); err == nil {
+		opts.Header = &h
+	}
+	return opts
+}
+
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// envParser reads typed environment variables. A value that doesn't
+// parse is kept for validate, so startup and reloads fail with its key,
+// and the default stands in until then.
+type envParser struct {
+	errs []error
+}
+
+func (e *envParser) fail(key, v, want string) {
+	e.errs = append(e.errs, fmt.Errorf("%s=%q is not %s", key, v, want))
+}
+
+func (e *envParser) int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail(key, v, "an integer")
+		return def
+	}
+	return n
+}
+
+func (e *envParser) float(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		e.fail(key, v, "a number")
+		return def
+	}
+	return f
+}
+
+func (e *envParser) bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(key, v, "true or false")
+		return def
+	}
+	return b
+}
+
+func (e *envParser) duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.fail(key, v, "a duration like 30s or 5m")
+		return def
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigInvalidValues(t *testing.T) {
+	tests := []struct {
+		key, value string
+		invalid    bool
+	}{
+		{"BULK_WORKERS", "abc", true},
+		{"BULK_WORKERS", "3", false},
+		{"BULK_FLUSH_INTERVAL", "5", true},
+		{"BULK_FLUSH_INTERVAL", "5s", false},
+		{"KPI_UNAVAILABLE_LOSS_PCT", "half", true},
+		{"KPI_UNAVAILABLE_LOSS_PCT", "12.5", false},
+		{"KPI_UNAVAILABLE_LOSS_PCT", "NaN", true},
+		{"KPI_UNAVAILABLE_LOSS_PCT", "+Inf", true},
+		{"ES_COMPRESS", "maybe", true},
+		{"ES_COMPRESS", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			config := loadConfig()
+			err := config.validate(false)
+			mentioned := err != nil && strings.Contains(err.Error(), tt.key+"=")
+			if mentioned != tt.invalid {
+				t.Errorf("validate() = %v; invalid %s expected: %v", err, tt.key, tt.invalid)
+			}
+		})
+	}
+	t.Setenv("BULK_FLUSH_INTERVAL", "5")
+	if got := loadConfig().BulkFlushInterval; got != 5*time.Second {
+		t.Errorf("BulkFlushInterval = %s, want the default until validate fails", got)
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configKeys maps config file paths to the environment variables that
+// loadConfig reads. The file only fills in variables that aren't already
+// set, so the environment (and .env) always wins.
+var configKeys = map[string]string{
+	"watch.path":      "FILE_PATH",
+	"watch.recursive": "WATCH_RECURSIVE",
+	"watch.include":   "INCLUDE_PATTERNS",
+	"watch.exclude":   "EXCLUDE_PATTERNS",
+	"watch.parser":    "PARSER",
+	"watch.charset":   "CHARSET",
+	"csv.delimiter":   "CSV_DELIMITER",
+	"csv.quotes":      "CSV_QUOTES",
+	"csv.comment":     "CSV_COMMENT",
+	"csv.comments":    "CSV_COMMENTS",
+	"csv.skip_lines":  "CSV_SKIP_LINES",
+	"csv.footer":      "CSV_FOOTER",
+	"csv.header":      "CSV_HEADER",
+	"csv.columns":     "CSV_COLUMNS",
+	"gzip.blocks":     "GZIP_BLOCKS",
+	"gzip.block_size": "GZIP_BLOCK_SIZE",
+
+	"watch.mode":          "WATCH_MODE",
+	"watch.poll_interval": "WATCH_POLL_INTERVAL",
+	"watch.settle_time":   "WATCH_SETTLE_TIME",
+	"watch.reconcile":     "WATCH_RECONCILE",
+	"watch.temp_suffixes": "WATCH_TEMP_SUFFIXES",
+
+	"outputs":                "OUTPUT",
+	"output_queue_size":      "OUTPUT_QUEUE_SIZE",
+	"output_stall_timeout":   "OUTPUT_STALL_TIMEOUT",
+	"document_format":        "DOCUMENT_FORMAT",
+	"file_output.path":       "FILE_OUTPUT_PATH",
+	"file_output.max_bytes":  "FILE_OUTPUT_MAX_BYTES",
+	"file_output.rotate":     "FILE_OUTPUT_ROTATE",
+	"file_output.gzip":       "FILE_OUTPUT_GZIP",
+	"file_output.keep":       "FILE_OUTPUT_KEEP",
+	"dry_run.enabled":        "DRY_RUN",
+	"dry_run.output":         "DRY_RUN_OUTPUT",
+	"dead_letter_dir":        "DEAD_LETTER_DIR",
+	"errors_dir":             "ERRORS_DIR",
+	"reject_dir":             "REJECT_DIR",
+	"state_file":             "STATE_FILE",
+	"checkpoint_rows":        "CHECKPOINT_ROWS",
+	"file_timeout":           "FILE_TIMEOUT",
+	"progress_interval":      "PROGRESS_INTERVAL",
+	"shutdown_grace":         "SHUTDOWN_GRACE",
+	"concurrency.workers":    "WORKERS",
+	"concurrency.queue_size": "QUEUE_SIZE",
+
+	"backfill.checkpoint": "BACKFILL_CHECKPOINT",
+
+	"retention.max_age":  "RETENTION_MAX_AGE",
+	"retention.action":   "RETENTION_ACTION",
+	"retention.interval": "RETENTION_INTERVAL",
+	"retention.dry_run":  "RETENTION_DRY_RUN",
+
+	"log.level":     "LOG_LEVEL",
+	"log.levels":    "LOG_LEVELS",
+	"log.format":    "LOG_FORMAT",
+	"log.documents": "LOG_DOCUMENTS",
+	"log.file":      "LOG_FILE",
+
+	"grpc.addr":              "GRPC_ADDR",
+	"grpc.tls_cert":          "GRPC_TLS_CERT",
+	"grpc.tls_key":           "GRPC_TLS_KEY",
+	"grpc.token":             "GRPC_TOKEN",
+	"grpc.max_message_bytes": "GRPC_MAX_MESSAGE_BYTES",
+
+	"http.addr":                  "HTTP_ADDR",
+	"http.health_check_interval": "HEALTH_CHECK_INTERVAL",
+	"http.admin_token":           "ADMIN_TOKEN",
+
+	"elasticsearch.server":        "ES_SERVER",
+	"elasticsearch.user":          "ES_USER",
+	"elasticsearch.password":      "ES_PASSWORD",
+	"elasticsearch.api_key":       "ES_API_KEY",
+	"elasticsearch.service_token": "ES_SERVICE_TOKEN",
+	"elasticsearch.cloud_id":      "ES_CLOUD_ID",
+	"elasticsearch.index":         "ES_INDEX",
+	"elasticsearch.data_stream":   "ES_DATA_STREAM",
+	"elasticsearch.write_alias":   "ES_WRITE_ALIAS",
+	"elasticsearch.duplicates":    "ES_DUPLICATES",
+	"elasticsearch.id":            "ES_ID_TEMPLATE",
+	"elasticsearch.routing":       "ES_ROUTING_TEMPLATE",
+	"elasticsearch.pipeline":      "ES_PIPELINE",
+	"elasticsearch.product":       "ES_PRODUCT",
+	"elasticsearch.refresh":       "ES_REFRESH",
+	"elasticsearch.bootstrap":     "ES_BOOTSTRAP",
+	"elasticsearch.template_name": "ES_TEMPLATE_NAME",
+
+	"elasticsearch.tls.ca_cert":              "ES_CA_CERT",
+	"elasticsearch.tls.client_cert":          "ES_CLIENT_CERT",
+	"elasticsearch.tls.client_key":           "ES_CLIENT_KEY",
+	"elasticsearch.tls.insecure_skip_verify": "ES_TLS_INSECURE_SKIP_VERIFY",
+
+	"elasticsearch.compress":            "ES_COMPRESS",
+	"elasticsearch.compress_level":      "ES_COMPRESS_LEVEL",
+	"elasticsearch.rate_limit.docs":     "ES_RATE_LIMIT_DOCS",
+	"elasticsearch.rate_limit.requests": "ES_RATE_LIMIT_REQUESTS",
+	"elasticsearch.watermark.pause":     "ES_WATERMARK_PAUSE",
+	"elasticsearch.watermark.check":     "ES_WATERMARK_CHECK",
+
+	"elasticsearch.secondary.server":        "ES_SECONDARY_SERVER",
+	"elasticsearch.secondary.user":          "ES_SECONDARY_USER",
+	"elasticsearch.secondary.password":      "ES_SECONDARY_PASSWORD",
+	"elasticsearch.secondary.api_key":       "ES_SECONDARY_API_KEY",
+	"elasticsearch.failover.after":          "ES_FAILOVER_AFTER",
+	"elasticsearch.failover.failback_check": "ES_FAILBACK_CHECK",
+	"elasticsearch.failover.reconcile_dir":  "ES_RECONCILE_DIR",
+
+	"ilm.policy":            "ILM_POLICY",
+	"ilm.rollover_max_age":  "ILM_ROLLOVER_MAX_AGE",
+	"ilm.rollover_max_size": "ILM_ROLLOVER_MAX_SIZE",
+	"ilm.rollover_max_docs": "ILM_ROLLOVER_MAX_DOCS",
+	"ilm.rollover":          "ILM_ROLLOVER",
+	"ilm.rollover_check":    "ILM_ROLLOVER_CHECK",
+	"ilm.warm_after":        "ILM_WARM_AFTER",
+	"ilm.delete_after":      "ILM_DELETE_AFTER",
+
+	"bulk.size":             "BULK_SIZE",
+	"bulk.flush_interval":   "BULK_FLUSH_INTERVAL",
+	"bulk.flush_bytes":      "BULK_FLUSH_BYTES",
+	"bulk.workers":          "BULK_WORKERS",
+	"bulk.timeout":          "BULK_TIMEOUT",
+	"bulk.adaptive.enabled": "BULK_ADAPTIVE",
+	"bulk.adaptive.min":     "BULK_ADAPTIVE_MIN",
+	"bulk.adaptive.max":     "BULK_ADAPTIVE_MAX",
+	"bulk.adaptive.latency": "BULK_ADAPTIVE_LATENCY",
+
+	"retry.max_attempts":    "RETRY_MAX_ATTEMPTS",
+	"retry.initial_backoff": "RETRY_INITIAL_BACKOFF",
+	"retry.max_backoff":     "RETRY_MAX_BACKOFF",
+
+	"spool.dir":            "SPOOL_DIR",
+	"spool.max_bytes":      "SPOOL_MAX_BYTES",
+	"spool.retry_interval": "SPOOL_RETRY_INTERVAL",
+
+	"wal.dir":            "WAL_DIR",
+	"wal.max_bytes":      "WAL_MAX_BYTES",
+	"wal.fsync":          "WAL_FSYNC",
+	"wal.fsync_interval": "WAL_FSYNC_INTERVAL",
+
+	"s3.bucket":            "S3_BUCKET",
+	"s3.prefix":            "S3_PREFIX",
+	"s3.region":            "S3_REGION",
+	"s3.endpoint":          "S3_ENDPOINT",
+	"s3.path_style":        "S3_PATH_STYLE",
+	"s3.access_key_id":     "S3_ACCESS_KEY_ID",
+	"s3.secret_access_key": "S3_SECRET_ACCESS_KEY",
+	"s3.session_token":     "S3_SESSION_TOKEN",
+	"s3.poll_interval":     "S3_POLL_INTERVAL",
+	"s3.spool_dir":         "S3_SPOOL_DIR",
+
+	"pull.file":            "PULL_FILE",
+	"pull.spool_dir":       "PULL_SPOOL_DIR",
+	"pull.checkpoint_file": "PULL_CHECKPOINT_FILE",
+
+	"kafka.brokers":   "KAFKA_BROKERS",
+	"kafka.topic":     "KAFKA_TOPIC",
+	"kafka.acks":      "KAFKA_ACKS",
+	"kafka.client_id": "KAFKA_CLIENT_ID",
+
+	"influxdb.url":         "INFLUX_URL",
+	"influxdb.token":       "INFLUX_TOKEN",
+	"influxdb.org":         "INFLUX_ORG",
+	"influxdb.bucket":      "INFLUX_BUCKET",
+	"influxdb.database":    "INFLUX_DATABASE",
+	"influxdb.measurement": "INFLUX_MEASUREMENT",
+	"influxdb.tags":        "INFLUX_TAGS",
+	"influxdb.fields":      "INFLUX_FIELDS",
+
+	"clickhouse.url":       "CLICKHOUSE_URL",
+	"clickhouse.user":      "CLICKHOUSE_USER",
+	"clickhouse.password":  "CLICKHOUSE_PASSWORD",
+	"clickhouse.table":     "CLICKHOUSE_TABLE",
+	"clickhouse.bootstrap": "CLICKHOUSE_BOOTSTRAP",
+	"clickhouse.ttl":       "CLICKHOUSE_TTL",
+
+	"postgres.url":       "POSTGRES_URL",
+	"postgres.table":     "POSTGRES_TABLE",
+	"postgres.bootstrap": "POSTGRES_BOOTSTRAP",
+	"postgres.timescale": "POSTGRES_TIMESCALE",
+
+	"parquet.dir":         "PARQUET_DIR",
+	"parquet.partition":   "PARQUET_PARTITION",
+	"parquet.compression": "PARQUET_COMPRESSION",
+	"parquet.s3_bucket":   "PARQUET_S3_BUCKET",
+	"parquet.s3_prefix":   "PARQUET_S3_PREFIX",
+
+	"remote_write.url":      "REMOTE_WRITE_URL",
+	"remote_write.user":     "REMOTE_WRITE_USER",
+	"remote_write.password": "REMOTE_WRITE_PASSWORD",
+	"remote_write.token":    "REMOTE_WRITE_TOKEN",
+	"remote_write.tenant":   "REMOTE_WRITE_TENANT",
+	"remote_write.labels":   "REMOTE_WRITE_LABELS",
+
+	"otel.endpoint":            "OTEL_EXPORTER_OTLP_ENDPOINT",
+	"otel.headers":             "OTEL_EXPORTER_OTLP_HEADERS",
+	"otel.service_name":        "OTEL_SERVICE_NAME",
+	"otel.resource_attributes": "OTEL_RESOURCE_ATTRIBUTES",
+	"otel.metrics.attributes":  "OTEL_METRICS_ATTRIBUTES",
+	"otel.traces.exporter":     "OTEL_TRACES_EXPORTER",
+
+	"schema.file":        "SCHEMA_FILE",
+	"timestamp.columns":  "TIMESTAMP_COLUMNS",
+	"timestamp.layouts":  "TIMESTAMP_LAYOUTS",
+	"timestamp.timezone": "TIMESTAMP_TIMEZONE",
+	"timestamp.on_error": "TIMESTAMP_ON_ERROR",
+
+	"kpi.enabled":              "KPI_ENRICH",
+	"kpi.unavailable_loss_pct": "KPI_UNAVAILABLE_LOSS_PCT",
+	"owd.timestamp_fields":     "OWD_TIMESTAMP_FIELDS",
+	"owd.timestamp_unit":       "OWD_TIMESTAMP_UNIT",
+	"owd.synced_values":        "SYNC_STATUS_SYNCED",
+	"mapping.file":             "MAPPING_FILE",
+	"mapping.include":          "FIELDS_INCLUDE",
+	"mapping.exclude":          "FIELDS_EXCLUDE",
+	"units.delay":              "DELAY_UNIT",
+	"units.delay_precision":    "DELAY_PRECISION",
+	"validation.file":          "VALIDATION_FILE",
+	"inventory.file":           "INVENTORY_FILE",
+	"inventory.netbox_url":     "INVENTORY_NETBOX_URL",
+	"inventory.netbox_token":   "INVENTORY_NETBOX_TOKEN",
+	"inventory.refresh":        "INVENTORY_REFRESH",
+	"geoip.city_db":            "GEOIP_CITY_DB",
+	"geoip.asn_db":             "GEOIP_ASN_DB",
+	"rollup.enabled":           "ROLLUP_ENABLED",
+	"rollup.index":             "ROLLUP_INDEX",
+	"rollup.interval":          "ROLLUP_INTERVAL",
+	"sampling.every":           "SAMPLE_EVERY",
+	"sampling.interval":        "SAMPLE_INTERVAL",
+	"sampling.unavailable":     "SAMPLE_KEEP_UNAVAILABLE",
+	"correlate.enabled":        "CORRELATE_DIRECTIONS",
+	"correlate.field":          "CORRELATE_DIRECTION_FIELD",
+	"correlate.forward":        "CORRELATE_FORWARD",
+	"correlate.reverse":        "CORRELATE_REVERSE",
+	"correlate.key":            "CORRELATE_KEY",
+	"correlate.interval":       "CORRELATE_INTERVAL",
+	"alerts.file":              "ALERTS_FILE",
+	"alerts.index":             "ALERTS_INDEX",
+	"notify.file":              "NOTIFY_FILE",
+	"anomaly.enabled":          "ANOMALY_DETECTION",
+	"anomaly.fields":           "ANOMALY_FIELDS",
+	"anomaly.sigma":            "ANOMALY_SIGMA",
+	"anomaly.alpha":            "ANOMALY_ALPHA",
+	"anomaly.min_samples":      "ANOMALY_MIN_SAMPLES",
+	"anomaly.seasonal":         "ANOMALY_SEASONAL",
+	"anomaly.index":            "ANOMALY_INDEX",
+	"alarms.enabled":           "ALARM_CORRELATION",
+	"alarms.field":             "ALARM_FIELD",
+	"alarms.clear_values":      "ALARM_CLEAR_VALUES",
+	"alarms.degraded_rtt":      "ALARM_DEGRADED_RTT",
+	"alarms.index":             "ALARMS_INDEX",
+	"grafana.url":              "GRAFANA_URL",
+	"grafana.token":            "GRAFANA_TOKEN",
+	"grafana.user":             "GRAFANA_USER",
+	"grafana.password":         "GRAFANA_PASSWORD",
+	"grafana.folder":           "GRAFANA_FOLDER",
+	"grafana.datasource":       "GRAFANA_DATASOURCE",
+	"grafana.es_url":           "GRAFANA_ES_URL",
+	"kibana.url":               "KIBANA_URL",
+	"kibana.space":             "KIBANA_SPACE",
+	"report.source":            "REPORT_SOURCE",
+	"report.group_by":          "REPORT_GROUP_BY",
+	"report.format":            "REPORT_FORMAT",
+	"report.dir":               "REPORT_DIR",
+	"report.schedule":          "REPORT_SCHEDULE",
+	"report.at":                "REPORT_AT",
+	"report.timezone":          "REPORT_TIMEZONE",
+	"report.email_channel":     "REPORT_EMAIL_CHANNEL",
+	"report.sla.availability":  "REPORT_SLA_AVAILABILITY",
+	"report.sla.latency_p95":   "REPORT_SLA_LATENCY_P95",
+	"report.sla.loss_pct":      "REPORT_SLA_LOSS_PCT",
+
+	"twamp.targets":         "TWAMP_TARGETS",
+	"twamp.packets":         "TWAMP_PACKETS",
+	"twamp.packet_interval": "TWAMP_PACKET_INTERVAL",
+	"twamp.padding":         "TWAMP_PADDING",
+	"twamp.wait":            "TWAMP_WAIT",
+	"twamp.receiver_port":   "TWAMP_RECEIVER_PORT",
+	"twamp.dscp":            "TWAMP_DSCP",
+	"twamp.light":           "TWAMP_LIGHT",
+	"twamp.interval":        "TWAMP_INTERVAL",
+	"twamp.timeout":         "TWAMP_TIMEOUT",
+	"twamp.reflector_addr":  "TWAMP_REFLECTOR_ADDR",
+}
+
+// 목록 값을 env 로 옮길 때 쓰는 구분자 (기본은 ",")
+var configListSeparators = map[string]string{
+	"timestamp.layouts": "|",
+	"csv.footer":        "|",
+}
+
+// fileEnv records the variables the config file set, so a reload can
+// change or unset them without touching ones from the real environment.
+var fileEnv = map[string]bool{}
+
+// configFile holds what the file can express but env vars can't.
+type configFile struct {
+	SchemaFields map[string]schemaField
+	Pipelines    []pipelineConfig
+	Tenants      []tenantConfig
+}
+
+// loadConfigFile reads a YAML config file and exports its settings as
+// environment variables for loadConfig. schema.fields (per-column
+// overrides, same shape as SCHEMA_FILE), pipelines and tenants are
+// returned separately.
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	node, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	root, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config file %s: top level must be a mapping", path)
+	}
+
+	cf := &configFile{}
+	if schema, ok := root["schema"].(map[string]interface{}); ok {
+		if fields, ok := schema["fields"]; ok {
+			if err := remarshal(fields, &cf.SchemaFields); err != nil {
+				return nil, fmt.Errorf("config file %s: schema.fields: %w", path, err)
+			}
+			delete(schema, "fields")
+		}
+	}
+	if pipelines, ok := root["pipelines"]; ok {
+		if err := remarshal(pipelines, &cf.Pipelines); err != nil {
+			return nil, fmt.Errorf("config file %s: pipelines: %w", path, err)
+		}
+		delete(root, "pipelines")
+	}
+	if tenants, ok := root["tenants"]; ok {
+		if err := remarshal(tenants, &cf.Tenants); err != nil {
+			return nil, fmt.Errorf("config file %s: tenants: %w", path, err)
+		}
+		delete(root, "tenants")
+	}
+
+	values := map[string]string{}
+	if err := flattenConfig("", root, values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for key, v := range values {
+		env := configKeys[key]
+		seen[env] = true
+		if _, set := os.LookupEnv(env); !set || fileEnv[env] {
+			os.Setenv(env, v)
+			fileEnv[env] = true
+		}
+	}
+	// 파일에서 지워진 키는 기본값으로 돌아간다
+	for env := range fileEnv {
+		if !seen[env] {
+			os.Unsetenv(env)
+			delete(fileEnv, env)
+		}
+	}
+	return cf, nil
+}
+
+func flattenConfig(prefix string, node map[string]interface{}, out map[string]string) error {
+	var unknown []string
+	for k, v := range node {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if _, known := configKeys[key]; !known {
+			if m, ok := v.(map[string]interface{}); ok {
+				if err := flattenConfig(key, m, out); err != nil {
+					return err
+				}
+				continue
+			}
+			unknown = append(unknown, key)
+			continue
+		}
+		switch v := v.(type) {
+		case nil:
+		case []interface{}:
+			sep := configListSeparators[key]
+			if sep == "" {
+				sep = ","
+			}
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, sep)
+		case map[string]interface{}:
+			return fmt.Errorf("%s must be a value, not a mapping", key)
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// validate reports settings that would only fail later at runtime. watch
+// is false for the sender/reflector/replay subcommands.
+func (c Config) validate(watch bool) error {
+	errs := append([]error(nil), c.invalid...)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	outputs := map[string]bool{}
+	for _, o := range splitList(c.Output) {
+		outputs[strings.ToLower(o)] = true
+	}
+	check(!watch || c.FilePath != "" || len(c.Pipelines) > 0, "FILE_PATH (watch.path) or pipelines is required")
+	check(c.DryRun || !(outputs["elasticsearch"] || outputs["es"]) || c.ESServer != "" || c.ESCloudID != "", "ES_SERVER (elasticsearch.server) or ES_CLOUD_ID is required for the elasticsearch output")
+	check(c.ESServer == "" || c.ESCloudID == "", "ES_SERVER and ES_CLOUD_ID can't both be set")
+	check(c.ESCompressLevel >= gzip.HuffmanOnly && c.ESCompressLevel <= gzip.BestCompression, "ES_COMPRESS_LEVEL must be between -2 and 9")
+	check(c.ESRateLimitDocs >= 0 && c.ESRateLimitRequests >= 0, "ES_RATE_LIMIT_DOCS and ES_RATE_LIMIT_REQUESTS can't be negative")
+	check(!c.ESWatermarkPause || c.ESWatermarkCheck > 0, "ES_WATERMARK_CHECK must be positive")
+	auth := 0
+	for _, set := range []bool{c.ESUser != "" || c.ESPassword != "", c.ESAPIKey != "", c.ESToken != ""} {
+		if set {
+			auth++
+		}
+	}
+	check(auth <= 1, "use only one of ES_USER/ES_PASSWORD, ES_API_KEY and ES_SERVICE_TOKEN")
+	if c.ESSecondaryServer != "" {
+		check(outputs["elasticsearch"] || outputs["es"], "ES_SECONDARY_SERVER needs the elasticsearch output")
+		check(c.ESSecondaryUser == "" && c.ESSecondaryPassword == "" || c.ESSecondaryAPIKey == "", "use only one of ES_SECONDARY_USER/ES_SECONDARY_PASSWORD and ES_SECONDARY_API_KEY")
+		check(c.ESFailoverAfter > 0, "ES_FAILOVER_AFTER must be positive")
+		check(c.ESFailbackCheck > 0, "ES_FAILBACK_CHECK must be positive")
+		check(c.ESReconcileDir != "", "ES_RECONCILE_DIR is required with ES_SECONDARY_SERVER")
+	}
+	if outputs["file"] {
+		check(c.FileOutputMaxBytes >= 0, "FILE_OUTPUT_MAX_BYTES must not be negative")
+		check(c.FileOutputRotate >= 0, "FILE_OUTPUT_ROTATE must not be negative")
+		check(c.FileOutputKeep >= 0, "FILE_OUTPUT_KEEP must not be negative")
+	}
+	check(!outputs["kafka"] || c.KafkaBrokers != "", "KAFKA_BROKERS (kafka.brokers) is required for the kafka output")
+	check(!(outputs["influxdb"] || outputs["influx"]) || c.InfluxURL != "", "INFLUX_URL (influxdb.url) is required for the influxdb output")
+	check(!outputs["clickhouse"] || c.ClickHouseURL != "", "CLICKHOUSE_URL (clickhouse.url) is required for the clickhouse output")
+	check(!outputs["clickhouse"] || c.DocumentFormat == "raw", "the clickhouse output needs DOCUMENT_FORMAT=raw")
+	check(!(outputs["postgres"] || outputs["postgresql"]) || c.PostgresURL != "", "POSTGRES_URL (postgres.url) is required for the postgres output")
+	check(!(outputs["postgres"] || outputs["postgresql"]) || c.DocumentFormat == "raw", "the postgres output needs DOCUMENT_FORMAT=raw")
+	if outputs["parquet"] {
+		check(c.DocumentFormat == "raw", "the parquet output needs DOCUMENT_FORMAT=raw")
+		check(c.ParquetS3Bucket != "" || c.ParquetDir != "", "PARQUET_DIR (parquet.dir) or PARQUET_S3_BUCKET is required for the parquet output")
+		_, err := parseParquetPartition(c.ParquetPartition)
+		check(err == nil, "%v", err)
+		_, err = parquetCodec(c.ParquetCompression)
+		check(err == nil, "%v", err)
+	}
+	if outputs["remote_write"] || outputs["prometheus"] {
+		check(c.RemoteWriteURL != "", "REMOTE_WRITE_URL (remote_write.url) is required for the remote_write output")
+		check(c.KPIEnrich, "the remote_write output exports the KPI_ENRICH fields; enable KPI_ENRICH")
+		_, err := parseLabelList("REMOTE_WRITE_LABELS", c.RemoteWriteLabels, validLabelName)
+		check(err == nil, "%v", err)
+	}
+	if outputs["otlp"] {
+		check(c.OTLPEndpoint != "", "OTEL_EXPORTER_OTLP_ENDPOINT (otel.endpoint) is required for the otlp output")
+		check(c.KPIEnrich, "the otlp output exports the KPI_ENRICH fields; enable KPI_ENRICH")
+		_, err := parseLabelList("OTEL_METRICS_ATTRIBUTES", c.OTelMetricsAttributes, validOTelAttribute)
+		check(err == nil, "%v", err)
+	}
+	check(c.OTelTracesExporter == "none" || c.OTelTracesExporter == "otlp", "OTEL_TRACES_EXPORTER must be otlp or none")
+	check(c.OTelTracesExporter != "otlp" || c.OTLPEndpoint != "", "OTEL_EXPORTER_OTLP_ENDPOINT (otel.endpoint) is required for OTEL_TRACES_EXPORTER=otlp")
+	for setting, v := range map[string]string{"OTEL_EXPORTER_OTLP_HEADERS": c.OTLPHeaders, "OTEL_RESOURCE_ATTRIBUTES": c.OTelResourceAttributes} {
+		_, err := parseOTelPairs(setting, v)
+		check(err == nil, "%v", err)
+	}
+	check((c.ESClientCert == "") == (c.ESClientKey == ""), "ES_CLIENT_CERT and ES_CLIENT_KEY (elasticsearch.tls.client_cert/client_key) must be set together")
+	check(c.DocumentFormat == "raw" || c.DocumentFormat == "ecs", "DOCUMENT_FORMAT must be raw or ecs")
+	check(c.InventoryFile == "" || c.NetBoxURL == "", "INVENTORY_FILE and INVENTORY_NETBOX_URL can't both be set")
+	check(c.InventoryRefresh > 0, "INVENTORY_REFRESH must be positive")
+	check(c.ESDuplicates == "skip" || c.ESDuplicates == "overwrite" || c.ESDuplicates == "allow", "ES_DUPLICATES must be skip, overwrite or allow")
+	check(c.ESRefresh == "false" || c.ESRefresh == "wait_for" || c.ESRefresh == "true", "ES_REFRESH must be false, wait_for or true")
+	check(!c.ESDataStream || c.ESDuplicates != "overwrite", "ES_DUPLICATES=overwrite can't be used with data streams, they only accept create")
+	check(c.ESWriteAlias == "" || !c.ESDataStream, "ES_WRITE_ALIAS can't be used with ES_DATA_STREAM, data streams roll over by themselves")
+	check(!strings.Contains(c.ESWriteAlias, "%{"), "ES_WRITE_ALIAS can't have placeholders, the alias moves to a new index on rollover instead")
+	check(c.ILMRollover == "auto" || c.ILMRollover == "ilm" || c.ILMRollover == "self" || c.ILMRollover == "off", "ILM_ROLLOVER must be auto, ilm, self or off")
+	check(c.ILMRollover != "ilm" || c.ILMPolicy != "" && !c.openSearch(), "ILM_ROLLOVER=ilm needs ILM_POLICY on Elasticsearch (OpenSearch has no ILM)")
+	check(c.ILMRolloverCheck > 0, "ILM_ROLLOVER_CHECK must be positive")
+	check(c.ILMRolloverMaxDocs >= 0, "ILM_ROLLOVER_MAX_DOCS can't be negative")
+	check(c.ESWriteAlias == "" || c.ILMRollover == "off" || len(rolloverConditions(c)) > 0, "ES_WRITE_ALIAS rolls over on ILM_ROLLOVER_MAX_AGE, _SIZE or _DOCS; set one or ILM_ROLLOVER=off")
+	check(c.ESIDTemplate == "" || c.ESDuplicates != "allow", "ES_ID_TEMPLATE can't be used with ES_DUPLICATES=allow, which sends no _id")
+	_, idErr := parseRecordTemplate("ES_ID_TEMPLATE", c.ESIDTemplate)
+	check(idErr == nil, "ES_ID_TEMPLATE: %v", idErr)
+	_, routingErr := parseRecordTemplate("ES_ROUTING_TEMPLATE", c.ESRouting)
+	check(routingErr == nil, "ES_ROUTING_TEMPLATE: %v", routingErr)
+	_, err := strconv.ParseBool(c.CSVHeader)
+	check(c.CSVHeader == "" || err == nil, "CSV_HEADER must be true or false")
+	check(c.GzipBlocks >= 1, "GZIP_BLOCKS must be at least 1")
+	check(c.GzipBlockSize >= 4096, "GZIP_BLOCK_SIZE must be at least 4096")
+	_, zstdErr := exec.LookPath("zstd")
+	check(!watch || zstdErr == nil || !c.zstdInput(), "INCLUDE_PATTERNS (watch.include) lets .zst files through but the zstd binary isn't in PATH; install zstd or drop *.zst")
+	_, err = lookupParser(c.Parser, c.csvOptions())
+	check(err == nil, "PARSER (watch.parser): %v", err)
+	_, err = withCharset(nil, c.Charset)
+	check(err == nil, "CHARSET (watch.charset): %v", err)
+	check(c.ESProduct == "elasticsearch" || c.ESProduct == "opensearch", "ES_PRODUCT must be elasticsearch or opensearch")
+	check(!c.openSearch() || c.ESCloudID == "", "ES_CLOUD_ID can't be used with ES_PRODUCT=opensearch")
+	check(!c.openSearch() || c.ESAPIKey == "" && c.ESToken == "", "OpenSearch takes ES_USER/ES_PASSWORD, not ES_API_KEY or ES_SERVICE_TOKEN")
+	check(c.Workers > 0, "WORKERS must be at least 1")
+	check(c.QueueSize >= 0, "QUEUE_SIZE can't be negative")
+	check(c.CheckpointRows >= 0, "CHECKPOINT_ROWS can't be negative")
+	check(c.FileTimeout >= 0, "FILE_TIMEOUT can't be negative")
+	check(c.ProgressInterval > 0, "PROGRESS_INTERVAL must be positive")
+	check(c.BulkSize > 0, "BULK_SIZE must be at least 1")
+	check(!c.BulkAdaptive || (c.BulkAdaptiveMin > 0 && c.BulkAdaptiveMax >= c.BulkAdaptiveMin), "BULK_ADAPTIVE_MIN must be at least 1 and BULK_ADAPTIVE_MAX at least BULK_ADAPTIVE_MIN")
+	check(!c.BulkAdaptive || c.BulkAdaptiveLatency > 0, "BULK_ADAPTIVE_LATENCY must be positive")
+	check(c.BulkFlushInterval > 0, "BULK_FLUSH_INTERVAL must be positive")
+	check(c.BulkFlushBytes > 0, "BULK_FLUSH_BYTES must be positive")
+	check(c.BulkWorkers > 0, "BULK_WORKERS must be at least 1")
+	check(c.BulkTimeout >= 0, "BULK_TIMEOUT can't be negative")
+	check(c.OutputQueueSize > 0, "OUTPUT_QUEUE_SIZE must be at least 1")
+	check(c.OutputStallTimeout >= 0, "OUTPUT_STALL_TIMEOUT can't be negative")
+	check(c.SpoolDir == "" || c.SpoolMaxBytes > 0, "SPOOL_MAX_BYTES must be positive")
+	check(c.SpoolDir == "" || c.SpoolRetryInterval > 0, "SPOOL_RETRY_INTERVAL must be positive")
+	check(c.WALDir == "" || c.WALMaxBytes > 0, "WAL_MAX_BYTES must be positive")
+	check(c.WALFsync == "always" || c.WALFsync == "interval" || c.WALFsync == "never", "WAL_FSYNC must be always, interval or never")
+	check(c.WALFsync != "interval" || c.WALFsyncInterval > 0, "WAL_FSYNC_INTERVAL must be positive")
+	check(c.RetryMaxAttempts > 0, "RETRY_MAX_ATTEMPTS must be at least 1")
+	check(!c.RollupEnabled || c.RollupInterval > 0, "ROLLUP_INTERVAL must be positive")
+	check(c.SampleEvery >= 1, "SAMPLE_EVERY must be at least 1")
+	fieldsErr := checkFieldPatterns(splitList(c.FieldsInclude), splitList(c.FieldsExclude))
+	check(fieldsErr == nil, "FIELDS_INCLUDE/FIELDS_EXCLUDE: %v", fieldsErr)
+	_, delayUnit := timeUnits[c.DelayUnit]
+	check(delayUnit, "DELAY_UNIT must be ns, us, ms or s, got %q", c.DelayUnit)
+	check(c.DelayPrecision <= 15, "DELAY_PRECISION can be at most 15")
+	check(c.SampleInterval >= 0, "SAMPLE_INTERVAL can't be negative")
+	_, owdUnit := owdUnits[c.OWDTimestampUnit]
+	check(c.OWDTimestampFields == "" || len(splitList(c.OWDTimestampFields)) == 4, "OWD_TIMESTAMP_FIELDS must be the four T1,T2,T3,T4 fields, got %q", c.OWDTimestampFields)
+	check(c.OWDTimestampFields == "" || owdUnit, "OWD_TIMESTAMP_UNIT must be s, ms, us, ns or ntp, got %q", c.OWDTimestampUnit)
+	check(!c.CorrelateDirections || c.CorrelateInterval > 0, "CORRELATE_INTERVAL must be positive")
+	check(!c.CorrelateDirections || c.CorrelateDirectionField != "" && len(splitList(c.CorrelateKey)) > 0, "CORRELATE_DIRECTION_FIELD and CORRELATE_KEY are required with CORRELATE_DIRECTIONS")
+	check(c.RetentionMaxAge >= 0, "RETENTION_MAX_AGE can't be negative")
+	check(c.RetentionMaxAge == 0 || c.RetentionInterval > 0, "RETENTION_INTERVAL must be positive")
+	check(c.WatchMode == "notify" || c.WatchMode == "poll" || c.WatchMode == "both", "WATCH_MODE must be notify, poll or both, got %q", c.WatchMode)
+	check(c.WatchPollInterval > 0, "WATCH_POLL_INTERVAL must be positive")
+	check(c.WatchSettleTime >= 0, "WATCH_SETTLE_TIME must not be negative")
+	check(c.WatchReconcile >= 0, "WATCH_RECONCILE must not be negative")
+	check(c.S3Bucket == "" || c.S3PollInterval > 0, "S3_POLL_INTERVAL must be positive")
+	check((c.S3AccessKeyID == "") == (c.S3SecretAccessKey == ""), "S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+	check(c.GRPCAddr == "" || (c.GRPCTLSCert != "" && c.GRPCTLSKey != ""), "GRPC_TLS_CERT and GRPC_TLS_KEY are required with GRPC_ADDR")
+	check(c.GRPCMaxMessageBytes > 0, "GRPC_MAX_MESSAGE_BYTES must be positive")
+	check(c.ShutdownGrace > 0, "SHUTDOWN_GRACE must be positive")
+	check(c.HealthCheckInterval > 0, "HEALTH_CHECK_INTERVAL must be positive")
+	if c.AnomalyDetection {
+		_, err := parseAnomalyFields(c.AnomalyFields)
+		check(err == nil, "%v", err)
+		check(c.AnomalySigma > 0, "ANOMALY_SIGMA must be positive")
+		check(c.AnomalyAlpha > 0 && c.AnomalyAlpha < 1, "ANOMALY_ALPHA must be between 0 and 1")
+		check(c.AnomalyMinSamples >= 2, "ANOMALY_MIN_SAMPLES must be at least 2")
+	}
+	check(!c.AlarmCorrelation || c.AlarmField != "", "ALARM_FIELD is required with ALARM_CORRELATION")
+	check(c.AlarmDegradedRTT >= 0, "ALARM_DEGRADED_RTT can't be negative")
+	check(c.GrafanaToken == "" || c.GrafanaUser == "", "use only one of GRAFANA_TOKEN and GRAFANA_USER/GRAFANA_PASSWORD")
+	check(c.KibanaSpace == "" || strings.Trim(c.KibanaSpace, "abcdefghijklmnopqrstuvwxyz0123456789_-") == "",
+		"KIBANA_SPACE must be a space id (lowercase letters, digits, _ and -), got %q", c.KibanaSpace)
+	check(c.ReportSource == "raw" || c.ReportSource == "rollup", "REPORT_SOURCE must be raw or rollup, got %q", c.ReportSource)
+	check(c.ReportFormat == "html" || c.ReportFormat == "pdf", "REPORT_FORMAT must be html or pdf, got %q", c.ReportFormat)
+	check(c.ReportSchedule == "" || c.ReportSchedule == "daily" || c.ReportSchedule == "weekly", "REPORT_SCHEDULE must be daily, weekly or empty, got %q", c.ReportSchedule)
+	_, _, err = parseClock(c.ReportAt)
+	check(c.ReportSchedule == "" || err == nil, "REPORT_AT: %v", err)
+	check(c.ReportSchedule == "" || c.ReportSource != "raw" || c.KPIEnrich, "REPORT_SOURCE=raw reports on the KPI_ENRICH fields; enable KPI_ENRICH or use REPORT_SOURCE=rollup")
+	check(c.ReportGroupBy != "", "REPORT_GROUP_BY is required")
+	check(c.ReportSLAAvailability >= 0 && c.ReportSLAAvailability <= 100, "REPORT_SLA_AVAILABILITY must be between 0 and 100")
+	check(c.ReportSLALatency >= 0 && c.ReportSLALossPct >= 0, "REPORT_SLA_LATENCY_P95 and REPORT_SLA_LOSS_PCT can't be negative")
+	check(c.AdminToken == "" || c.HTTPAddr != "", "ADMIN_TOKEN needs HTTP_ADDR, the admin API is served there")
+	return errors.Join(errs...)
+}
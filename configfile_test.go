@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlattenConfig(t *testing.T) {
+	tests := []struct {
+		name, yaml string
+		want       map[string]string
+		err        string
+	}{
+		{"nested keys", "watch:\n  path: /data\n  recursive: true\nbulk:\n  size: 500\n  adaptive:\n    enabled: false\n",
+			map[string]string{"watch.path": "/data", "watch.recursive": "true", "bulk.size": "500", "bulk.adaptive.enabled": "false"}, ""},
+		{"lists", "watch:\n  include: [\"*.gz\", \"*.csv\"]\ntimestamp:\n  layouts:\n    - epoch_ms\n    - rfc3339\n",
+			map[string]string{"watch.include": "*.gz,*.csv", "timestamp.layouts": "epoch_ms|rfc3339"}, ""},
+		{"null leaves the default", "watch:\n  path: ~\n", map[string]string{}, ""},
+		{"yes stays a string", "elasticsearch:\n  compress: yes\n", map[string]string{"elasticsearch.compress": "yes"}, ""},
+		{"unknown keys", "watch:\n  pth: /data\n  parsr: csv\n", nil, "unknown keys: watch.parsr, watch.pth"},
+		{"mapping for a value", "watch:\n  path:\n    dir: /data\n", nil, "watch.path must be a value"},
+	}
+	for _, tt := range tests {
+		node, err := parseYAML([]byte(tt.yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		err = flattenConfig("", node.(map[string]interface{}), got)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Cleanup(func() {
+		for env := range fileEnv {
+			os.Unsetenv(env)
+			delete(fileEnv, env)
+		}
+	})
+	// 환경 변수가 파일보다 우선한다
+	t.Setenv("BULK_SIZE", "42")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(yaml string) {
+		if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bulk:\n  size: 500\n  workers: 3\nelasticsearch:\n  compress: yes\ntenants:\n  - name: a\n    password: secret\n")
+	cf, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cf.Tenants) != 1 || cf.Tenants[0].Password != "secret" {
+		t.Errorf("tenants = %+v", cf.Tenants)
+	}
+	config := loadConfig()
+	if config.BulkSize != 42 || config.BulkWorkers != 3 {
+		t.Errorf("BulkSize, BulkWorkers = %d, %d; want 42 from the environment, 3 from the file", config.BulkSize, config.BulkWorkers)
+	}
+	if err := config.validate(false); err == nil || !strings.Contains(err.Error(), `ES_COMPRESS="yes"`) {
+		t.Errorf("validate() = %v, want ES_COMPRESS rejected", err)
+	}
+	// 파일에서 지운 키는 기본값으로 돌아간다
+	write("bulk:\n  size: 500\n")
+	if _, err := loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, set := os.LookupEnv("BULK_WORKERS"); set {
+		t.Error("BULK_WORKERS still set after it was removed from the file")
+	}
+	if got := os.Getenv("BULK_SIZE"); got != "42" {
+		t.Errorf("BULK_SIZE = %q, the file overrode the environment", got)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// correlateSink joins the forward and reverse rows of exports that write
+// each direction of a session as its own row (CORRELATE_DIRECTIONS). Rows
+// with the same CORRELATE_KEY fields and @timestamp in the same
+// CORRELATE_INTERVAL, told apart by CORRELATE_DIRECTION_FIELD, become one
+// document: the forward row's measurements (directionFields and the
+// d/j/dv statistics, unprefixed in such exports) as ul_<name> and the
+// reverse row's as dl_<name>, like a twamp export row, so KPIs, rollups
+// and alerts work on it unchanged. Joined documents also get
+//
+//	owd_mean_est      (ul_dmean + dl_dmean) / 2, holds without synchronised clocks
+//	delay_asymmetry   ul_dmean - dl_dmean, only meaningful with synchronised clocks
+//	jitter_asymmetry  ul_jmean - dl_jmean
+//	loss_asymmetry    ul_lostperc - dl_lostperc
+//
+// and correlation=matched. A row whose other direction doesn't show up is
+// written alone as forward_only or reverse_only, and so are the rows still
+// waiting when the sink is flushed (end of a file, CHECKPOINT_ROWS): both
+// directions have to be in the same file, and not far apart with several
+// WORKERS. Rows without a known direction pass through untouched.
+type correlateSink struct {
+	next     recordSink
+	field    string
+	forward  map[string]bool
+	reverse  map[string]bool
+	key      []string
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[correlateKey]*correlateRow
+	seq     int
+}
+
+type correlateKey struct {
+	session string
+	start   time.Time
+}
+
+type correlateRow struct {
+	rec     Record
+	forward bool
+	seq     int // flush 때 들어온 순서대로 쓴다
+}
+
+func newCorrelateSink(next recordSink, config Config) *correlateSink {
+	set := func(list string) map[string]bool {
+		m := map[string]bool{}
+		for _, v := range splitList(list) {
+			m[strings.ToLower(v)] = true
+		}
+		return m
+	}
+	return &correlateSink{
+		next:     next,
+		field:    config.CorrelateDirectionField,
+		forward:  set(config.CorrelateForward),
+		reverse:  set(config.CorrelateReverse),
+		key:      splitList(config.CorrelateKey),
+		interval: config.CorrelateInterval,
+		pending:  map[correlateKey]*correlateRow{},
+	}
+}
+
+func (c *correlateSink) Add(rec Record) error {
+	dir := strings.ToLower(strings.TrimSpace(fmt.Sprint(rec[c.field])))
+	forward := c.forward[dir]
+	if !forward && !c.reverse[dir] {
+		return c.next.Add(rec)
+	}
+	parts := make([]string, len(c.key))
+	for i, k := range c.key {
+		parts[i] = fmt.Sprint(rec[k])
+	}
+	key := correlateKey{session: strings.Join(parts, "|"), start: recordTime(rec).Truncate(c.interval)}
+
+	c.mu.Lock()
+	other, ok := c.pending[key]
+	if ok && other.forward != forward {
+		delete(c.pending, key)
+		c.mu.Unlock()
+		fwd, rev := other.rec, rec
+		if forward {
+			fwd, rev = rec, other.rec
+		}
+		metricDirectionsCorrelated.Inc("matched")
+		return c.next.Add(c.join(fwd, rev))
+	}
+	c.seq++
+	c.pending[key] = &correlateRow{rec: rec, forward: forward, seq: c.seq}
+	c.mu.Unlock()
+	// 같은 방향이 두 번: 먼저 온 행은 짝 없이 쓴다
+	if ok {
+		return c.alone(other)
+	}
+	return nil
+}
+
+// Flush writes the rows still waiting for their other direction alone.
+func (c *correlateSink) Flush() error {
+	c.mu.Lock()
+	rows := make([]*correlateRow, 0, len(c.pending))
+	for k, r := range c.pending {
+		rows = append(rows, r)
+		delete(c.pending, k)
+	}
+	c.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq < rows[j].seq })
+	var firstErr error
+	for _, r := range rows {
+		if err := c.alone(r); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := c.next.Flush(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (c *correlateSink) Close() error {
+	if err := c.Flush(); err != nil {
+		logIngest.Error("error writing uncorrelated rows", "err", err)
+	}
+	return c.next.Close()
+}
+
+func (c *correlateSink) alone(r *correlateRow) error {
+	out := Record{}
+	result := "reverse_only"
+	if r.forward {
+		c.merge(out, r.rec, "ul_")
+		result = "forward_only"
+	} else {
+		c.merge(out, r.rec, "dl_")
+	}
+	out["correlation"] = result
+	metricDirectionsCorrelated.Inc(result)
+	return c.next.Add(out)
+}
+
+func (c *correlateSink) join(fwd, rev Record) Record {
+	out := Record{}
+	c.merge(out, fwd, "ul_")
+	c.merge(out, rev, "dl_")
+	if ul, ok := number(out, "ul_dmean"); ok {
+		if dl, ok := number(out, "dl_dmean"); ok {
+			out["owd_mean_est"] = (ul + dl) / 2
+			out["delay_asymmetry"] = ul - dl
+		}
+	}
+	for name, field := range map[string]string{"jitter_asymmetry": "jmean", "loss_asymmetry": "lostperc"} {
+		ul, ok1 := number(out, "ul_"+field)
+		dl, ok2 := number(out, "dl_"+field)
+		if ok1 && ok2 {
+			out[name] = ul - dl
+		}
+	}
+	out["correlation"] = "matched"
+	return out
+}
+
+// merge copies rec into out, its measurements under prefix and the other
+// fields where out doesn't have them yet.
+func (c *correlateSink) merge(out, rec Record, prefix string) {
+	for k, v := range rec {
+		if k == c.field {
+			continue
+		}
+		typ, ok := directionMetric(k)
+		if !ok {
+			setMissing(out, k, v)
+			continue
+		}
+		// 스키마에 없는 열은 문자열로 들어온다
+		if s, isString := v.(string); isString {
+			if n, err := convertValue(typ, s); err == nil {
+				v = n
+			}
+		}
+		out[prefix+k] = v
+	}
+}
+
+// directionMetric reports whether name is a per-direction measurement
+// (without its ul_/dl_ prefix) and its type.
+func directionMetric(name string) (fieldType, bool) {
+	if typ, ok := directionFields[name]; ok {
+		return typ, true
+	}
+	for _, prefix := range []string{"dv", "d", "j"} {
+		if stat, ok := strings.CutPrefix(name, prefix); ok {
+			for _, s := range directionStatSuffixes {
+				if stat == s {
+					return typeFloat, true
+				}
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,88 @@
+//go:build ignore
+
+// cp949_gen writes cp949_table.go from the Unicode mapping of code page
+// 949 (https://www.unicode.org/Public/MAPPINGS/VENDORS/MICSFT/WINDOWS/CP949.TXT):
+//
+//	go run cp949_gen.go CP949.TXT
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: go run cp949_gen.go CP949.TXT")
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	// cp949.go 의 cp949Index 와 같은 배치
+	trail := func(b int) int {
+		switch {
+		case b >= 0x41 && b <= 0x5a:
+			return b - 0x41
+		case b >= 0x61 && b <= 0x7a:
+			return b - 0x61 + 26
+		case b >= 0x81 && b <= 0xfe:
+			return b - 0x81 + 52
+		}
+		return -1
+	}
+	var table [126 * 178]uint16
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		code, err1 := strconv.ParseUint(fields[0], 0, 16)
+		r, err2 := strconv.ParseUint(fields[1], 0, 16)
+		if err1 != nil || err2 != nil {
+			log.Fatalf("bad line %q", scanner.Text())
+		}
+		if code < 0x100 {
+			continue
+		}
+		lead, t := int(code>>8), trail(int(code&0xff))
+		if lead < 0x81 || lead > 0xfe || t < 0 {
+			log.Fatalf("code %#x out of range", code)
+		}
+		table[(lead-0x81)*178+t] = uint16(r)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by go run cp949_gen.go CP949.TXT; DO NOT EDIT.\n\npackage main\n\n")
+	fmt.Fprintf(&buf, "// cp949Table maps the %d double-byte codes of CP949 to Unicode, 0 where\n// a code is undefined; see cp949Index.\n", n)
+	fmt.Fprintf(&buf, "var cp949Table = [126 * 178]uint16{\n")
+	for i := 0; i < len(table); i += 12 {
+		for j := i; j < i+12 && j < len(table); j++ {
+			fmt.Fprintf(&buf, "0x%04x, ", table[j])
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("cp949_table.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
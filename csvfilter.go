@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"unicode/utf8"
+)
+
+// lineFilter drops the lines of a CSV document encoding/csv shouldn't see:
+// the first skip lines, comment lines (Comments prefixes) and, from the
+// first line footer matches, the rest of the file. Dropped lines are
+// passed on empty, which csv.Reader skips but still counts, so the line
+// numbers of rows and rejects stay those of the file. Quotes are followed
+// like csv.Reader does, and a line continuing a quoted field is never
+// dropped.
+type lineFilter struct {
+	r        *bufio.Reader
+	skip     int
+	comments [][]byte
+	footer   *regexp.Regexp
+	comma    []byte
+
+	line   int
+	quoted bool   // the last line ended inside a quoted field
+	long   []byte // a line longer than the bufio buffer
+	out    []byte // what Read hands out next
+	done   bool
+}
+
+func newLineFilter(r io.Reader, f csvFormat) *lineFilter {
+	lf := &lineFilter{r: bufio.NewReaderSize(r, 64<<10), skip: f.SkipLines, footer: f.FooterLine}
+	for _, c := range f.Comments {
+		lf.comments = append(lf.comments, []byte(c))
+	}
+	lf.comma = utf8.AppendRune(nil, f.Comma)
+	return lf
+}
+
+func (f *lineFilter) Read(p []byte) (int, error) {
+	for len(f.out) == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		line, err := f.next()
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			f.done = true
+			if err != io.EOF {
+				return 0, err
+			}
+			continue
+		}
+		f.out = f.filter(line)
+		if f.done {
+			// footer 뒤도 끝까지 읽어야 gzip checksum 과 잘린 파일을 확인한다
+			if _, err := io.Copy(io.Discard, f.r); err != nil {
+				return 0, err
+			}
+		}
+	}
+	n := copy(p, f.out)
+	f.out = f.out[n:]
+	return n, nil
+}
+
+// next reads one line with its newline (none at the end of the file).
+func (f *lineFilter) next() ([]byte, error) {
+	line, err := f.r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	f.long = append(f.long[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = f.r.ReadSlice('\n')
+		f.long = append(f.long, line...)
+	}
+	return f.long, err
+}
+
+var emptyLine = []byte("\n")
+
+// filter returns what is passed on for line.
+func (f *lineFilter) filter(line []byte) []byte {
+	f.line++
+	if f.line <= f.skip {
+		return emptyLine
+	}
+	if !f.quoted {
+		text := bytes.TrimRight(line, "\r\n")
+		if f.line == 1 {
+			text = bytes.TrimPrefix(text, []byte("\ufeff"))
+		}
+		if f.footer != nil && f.footer.Match(text) {
+			f.done = true
+			return nil
+		}
+		for _, c := range f.comments {
+			if bytes.HasPrefix(text, c) {
+				return emptyLine
+			}
+		}
+	}
+	f.scanQuotes(line)
+	return line
+}
+
+// scanQuotes follows the quoted fields of line: a quote opens one at the
+// start of a field and closes it before a delimiter or the end of the
+// line; "" and (with lazy quotes) any other quote are part of the field.
+func (f *lineFilter) scanQuotes(line []byte) {
+	for i := 0; i < len(line); i++ {
+		if line[i] != '"' {
+			continue
+		}
+		if !f.quoted {
+			f.quoted = i == 0 || bytes.HasSuffix(line[:i], f.comma)
+			continue
+		}
+		rest := line[i+1:]
+		switch {
+		case len(rest) > 0 && rest[0] == '"':
+			i++
+		case len(rest) == 0 || rest[0] == '\r' || rest[0] == '\n' || bytes.HasPrefix(rest, f.comma):
+			f.quoted = false
+		}
+	}
+}
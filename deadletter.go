@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deadLetter is one line of a dead-letter NDJSON file.
+type deadLetter struct {
+	Meta     json.RawMessage `json:"meta"`
+	Document json.RawMessage `json:"document"`
+	Status   int             `json:"status,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Reason   string          `json:"reason"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// DeadLetterQueue appends documents Elasticsearch refused to daily
+// NDJSON files under dir so they can be replayed later.
+type DeadLetterQueue struct {
+	dir string
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+func newDeadLetterQueue(dir string) (*DeadLetterQueue, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating dead-letter directory: %w", err)
+	}
+	return &DeadLetterQueue{dir: dir}, nil
+}
+
+func (q *DeadLetterQueue) Write(item bulkItem, status int, errType, reason string) error {
+	if q == nil {
+		logDeadLtr.Warn("dropping rejected document, no DEAD_LETTER_DIR", "reason", reason)
+		return nil
+	}
+	line, err := json.Marshal(deadLetter{
+		Meta:     bytes.TrimSpace(item.meta),
+		Document: bytes.TrimSpace(item.doc),
+		Status:   status,
+		Type:     errType,
+		Reason:   reason,
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	day := time.Now().UTC().Format("2006-01-02")
+	if q.file == nil || q.day != day {
+		if q.file != nil {
+			q.file.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(q.dir, deadLetterFile(day)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("error opening dead-letter file: %w", err)
+		}
+		q.file, q.day = f, day
+	}
+	if _, err := q.file.Write(line); err != nil {
+		return err
+	}
+	metricDeadLettered.Inc()
+	return nil
+}
+
+// deadLetterFile is the name of the dead-letter file of day (UTC).
+func deadLetterFile(day string) string {
+	return "dead-letter-" + day + ".ndjson"
+}
+
+func (q *DeadLetterQueue) Close() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
+
+// replayDeadLetters re-sends every dead-letter file in dir to Elasticsearch.
+// Each file is renamed to *.replaying while it is read and removed once its
+// documents have been handed off; anything rejected again lands in a new
+// dead-letter file. A file whose replay fails or is interrupted stays
+// *.replaying and is replayed first the next time. Today's file is left
+// alone: a running twamp may still be appending to it.
+func replayDeadLetters(ctx context.Context, dir string, indexer *BulkIndexer, batchSize int) error {
+	files, err := deadLetterFiles(dir, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		replaying := path
+		if strings.HasSuffix(path, ".ndjson") {
+			replaying = strings.TrimSuffix(path, ".ndjson") + ".replaying"
+			if err := os.Rename(path, replaying); err != nil {
+				return fmt.Errorf("error claiming %s: %w", path, err)
+			}
+		}
+		n, err := replayFile(ctx, replaying, indexer, batchSize)
+		if err != nil {
+			return fmt.Errorf("error replaying %s: %w", replaying, err)
+		}
+		if err := os.Remove(replaying); err != nil {
+			return err
+		}
+		logDeadLtr.Info("replayed dead letters", "documents", n, "file", path)
+	}
+	return nil
+}
+
+// deadLetterFiles lists the files replayDeadLetters sends, in order: the
+// interrupted *.replaying ones, then the *.ndjson ones except the file of
+// now's day.
+func deadLetterFiles(dir string, now time.Time) ([]string, error) {
+	// 같은 날의 새 dead-letter 파일이 생겼을 수 있어 이름을 되돌리지 않는다
+	interrupted, err := filepath.Glob(filepath.Join(dir, "*.replaying"))
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(interrupted)
+	sort.Strings(files)
+	today := deadLetterFile(now.UTC().Format("2006-01-02"))
+	for _, path := range files {
+		if filepath.Base(path) == today {
+			logDeadLtr.Info("skipping today's dead-letter file, it may still be written to", "file", path)
+			continue
+		}
+		interrupted = append(interrupted, path)
+	}
+	return interrupted, nil
+}
+
+func replayFile(ctx context.Context, path string, indexer *BulkIndexer, batchSize int) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	var batch []bulkItem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), indexer.flushBytes+64*1024)
+	for scanner.Scan() {
+		var dl deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			logDeadLtr.Warn("skipping invalid dead-letter line", "file", path, "err", err)
+			continue
+		}
+		batch = append(batch, bulkItem{
+			meta: append([]byte(dl.Meta), '\n'),
+			doc:  append([]byte(dl.Document), '\n'),
+		})
+		n++
+		if len(batch) >= batchSize {
+			if err := indexer.writeItems(ctx, batch); err != nil {
+				logDeadLtr.Error("error replaying batch", "file", path, "err", err)
+			}
+			batch = nil
+			// 취소되면 파일을 남겨 다음 replay 가 나머지를 보낸다
+			if ctx.Err() != nil {
+				return n, ctx.Err()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	if len(batch) > 0 {
+		if err := indexer.writeItems(ctx, batch); err != nil {
+			logDeadLtr.Error("error replaying batch", "file", path, "err", err)
+		}
+	}
+	return n, ctx.Err()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterFiles(t *testing.T) {
+	now := time.Date(2024, 1, 5, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"older days", []string{"dead-letter-2024-01-04.ndjson", "dead-letter-2024-01-03.ndjson"}, []string{"dead-letter-2024-01-03.ndjson", "dead-letter-2024-01-04.ndjson"}},
+		{"today is skipped", []string{"dead-letter-2024-01-05.ndjson", "dead-letter-2024-01-04.ndjson"}, []string{"dead-letter-2024-01-04.ndjson"}},
+		{"interrupted first", []string{"dead-letter-2024-01-02.ndjson", "dead-letter-2024-01-04.replaying"}, []string{"dead-letter-2024-01-04.replaying", "dead-letter-2024-01-02.ndjson"}},
+		// 오늘 파일도 중단된 replay 면 이미 queue 가 쓰는 파일이 아니다
+		{"interrupted today", []string{"dead-letter-2024-01-05.replaying", "dead-letter-2024-01-05.ndjson"}, []string{"dead-letter-2024-01-05.replaying"}},
+		{"other files", []string{"notes.txt", "dead-letter-2024-01-04.ndjson.tmp"}, nil},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range tt.files {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths, err := deadLetterFiles(dir, now)
+		var got []string
+		for _, p := range paths {
+			got = append(got, filepath.Base(p))
+		}
+		if err != nil || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
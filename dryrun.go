@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// dryRunWriter receives the bulk payloads in dry-run mode instead of
+// Elasticsearch. It's shared by every indexer, so each request is written
+// whole and never interleaved with another.
+type dryRunWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+// newDryRunWriter writes to path, appending, or to stdout for "-".
+func newDryRunWriter(path string) (*dryRunWriter, error) {
+	if path == "" || path == "-" {
+		return &dryRunWriter{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunWriter{w: f, f: f}, nil
+}
+
+// write writes items exactly as the bulk request body would be sent.
+func (d *dryRunWriter) write(items []bulkItem) error {
+	buf := bulkBody(items)
+	defer putBulkBuffer(buf)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.w.Write(buf.Bytes())
+	return err
+}
+
+func (d *dryRunWriter) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	return d.f.Close()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// ecsVersion is the Elastic Common Schema version the documents follow.
+const ecsVersion = "8.11.0"
+
+// ecsFields places the schema's fields in Elastic Common Schema. Anything
+// not listed goes under the custom twamp.* namespace, e.g. ul_dmean ->
+// twamp.ul_dmean.
+var ecsFields = map[string]string{
+	"@timestamp":           "@timestamp",
+	"source_ip":            "source.ip",
+	"source_port":          "source.port",
+	"destination_ip":       "destination.ip",
+	"destination_port":     "destination.port",
+	"source_ne":            "observer.name",
+	"source_hostname":      "source.domain",
+	"destination_hostname": "destination.domain",
+	"Serial":               "observer.serial_number",
+	"Model":                "observer.product",
+	"Interface":            "observer.ingress.interface.name",
+	"session_id":           "twamp.session.id",
+	"session_name":         "twamp.session.name",
+	"session_type":         "twamp.session.type",
+	"Type":                 "twamp.export.type",
+	"CSVexport Version":    "twamp.export.version",
+}
+
+// ecsPath returns where field goes in an ECS document.
+func ecsPath(field string) string {
+	if p, ok := ecsFields[field]; ok {
+		return p
+	}
+	// GeoIP: source_geo_city_name -> source.geo.city_name, source_as_number -> source.as.number
+	for _, side := range []string{"source", "destination"} {
+		if rest, ok := strings.CutPrefix(field, side+"_geo_"); ok {
+			return side + ".geo." + rest
+		}
+		if rest, ok := strings.CutPrefix(field, side+"_as_"); ok {
+			return side + ".as." + strings.Replace(rest, "organization_", "organization.", 1)
+		}
+	}
+	return "twamp." + strings.ReplaceAll(field, " ", "_")
+}
+
+// toECS converts a record into an ECS document (DOCUMENT_FORMAT=ecs). It
+// runs after MAPPING_FILE, so renamed fields land under twamp.* too.
+func toECS(rec Record) Record {
+	doc := Record{
+		"ecs": map[string]interface{}{"version": ecsVersion},
+		"event": map[string]interface{}{
+			"kind":     "metric",
+			"category": []string{"network"},
+			"type":     []string{"info"},
+			"module":   "twamp",
+			"dataset":  "twamp.session",
+		},
+		"network": map[string]interface{}{"protocol": "twamp", "transport": "udp"},
+	}
+	for k, v := range rec {
+		setPath(doc, ecsPath(k), v)
+	}
+	// 측정 구간 길이 (event.duration 은 ns)
+	if ms, ok := number(rec, "interval_ms"); ok {
+		setPath(doc, "event.duration", int64(ms*1e6))
+	}
+	if s, ok := rec["source_ip"].(string); ok {
+		if ip := net.ParseIP(s); ip != nil {
+			network := "ipv6"
+			if ip.To4() != nil {
+				network = "ipv4"
+			}
+			setPath(doc, "network.type", network)
+		}
+	}
+	return doc
+}
+
+// setPath stores v at a dotted path, creating the objects on the way.
+func setPath(doc map[string]interface{}, path string, v interface{}) {
+	if path == "@timestamp" {
+		doc[path] = v
+		return
+	}
+	parts := strings.Split(path, ".")
+	m := doc
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[p] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = v
+}
+
+// ecsProperties turns the flat template properties into ECS ones.
+func ecsProperties(props map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	keyword := map[string]string{"type": "keyword"}
+	for _, p := range []string{"ecs.version", "event.kind", "event.category", "event.type",
+		"event.module", "event.dataset", "network.protocol", "network.transport", "network.type"} {
+		setProperty(out, p, keyword)
+	}
+	setProperty(out, "event.duration", map[string]string{"type": "long"})
+	for k, v := range props {
+		setProperty(out, ecsPath(k), v)
+	}
+	setProperty(out, "source.ip", map[string]string{"type": "ip"})
+	setProperty(out, "destination.ip", map[string]string{"type": "ip"})
+	return out
+}
+
+// setProperty is setPath for mappings, where objects nest under "properties".
+func setProperty(props map[string]interface{}, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	if path == "@timestamp" {
+		parts = []string{path}
+	}
+	m := props
+	for _, p := range parts[:len(parts)-1] {
+		obj, ok := m[p].(map[string]interface{})
+		if !ok {
+			obj = map[string]interface{}{"properties": map[string]interface{}{}}
+			m[p] = obj
+		}
+		m = obj["properties"].(map[string]interface{})
+	}
+	m[parts[len(parts)-1]] = v
+}
+
+// recordField looks name up in a flat record or, for ECS documents, by
+// dotted path and by the field's ECS location, so index placeholders and
+// the Kafka key work with either DOCUMENT_FORMAT.
+func recordField(rec Record, name string) (interface{}, bool) {
+	if v, ok := rec[name]; ok {
+		return v, true
+	}
+	if v, ok := getPath(rec, name); ok {
+		return v, true
+	}
+	return getPath(rec, ecsPath(name))
+}
+
+func getPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	m := doc
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	v, ok := m[parts[len(parts)-1]]
+	return v, ok
+}
+
+// ecsAdded are the objects toECS adds on its own; fromECS leaves them out.
+var ecsAdded = map[string]bool{"ecs": true, "event": true, "network": true}
+
+// fromECS turns an ECS document back into a flat record with the schema's
+// names (ul_dmean for twamp.ul_dmean), for twamp reindex. Paths it doesn't
+// know keep their dotted name.
+func fromECS(doc Record) Record {
+	names := make(map[string]string, len(ecsFields))
+	for field, p := range ecsFields {
+		names[p] = field
+	}
+	rec := Record{}
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			p := prefix + k
+			if prefix == "" && ecsAdded[k] {
+				continue
+			}
+			if obj, ok := v.(map[string]interface{}); ok && p != "source.geo.location" && p != "destination.geo.location" {
+				walk(p+".", obj)
+				continue
+			}
+			rec[ecsField(names, p)] = v
+		}
+	}
+	walk("", doc)
+	return rec
+}
+
+// ecsField is ecsPath the other way round.
+func ecsField(names map[string]string, p string) string {
+	if field, ok := names[p]; ok {
+		return field
+	}
+	if rest, ok := strings.CutPrefix(p, "twamp."); ok {
+		return rest
+	}
+	for _, side := range []string{"source", "destination"} {
+		if rest, ok := strings.CutPrefix(p, side+".geo."); ok {
+			return side + "_geo_" + rest
+		}
+		if rest, ok := strings.CutPrefix(p, side+".as."); ok {
+			return side + "_as_" + strings.Replace(rest, "organization.", "organization_", 1)
+		}
+	}
+	return p
+}
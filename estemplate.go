@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// bootstrapTemplate creates or updates the ILM policy and the index
+// template covering config.ESIndex (the indices behind ES_WRITE_ALIAS,
+// whose first index it creates too), so the TWAMP fields get proper
+// mappings without anyone managing them by hand. PUT is idempotent, so
+// this runs on every start and picks up schema changes.
+func bootstrapTemplate(ctx context.Context, es *elasticsearch.Client, schema *Schema, mapping *fieldMapping, index *indexTemplate, config Config) error {
+	ilm := config.ILMPolicy != "" && !config.openSearch()
+	policy := config.ILMPolicy
+	// rollover 단계는 alias 가 있는 index 에만: pipeline/tenant index 의 정책과 따로 둔다
+	aliasRollover := config.ESWriteAlias != "" && config.ILMRollover == "ilm"
+	if aliasRollover {
+		policy += "-rollover"
+	}
+	if ilm {
+		if err := putJSON(ctx, es, "ILM policy "+policy, func(body io.Reader) (*esapi.Response, error) {
+			return es.ILM.PutLifecycle(policy, es.ILM.PutLifecycle.WithBody(body), es.ILM.PutLifecycle.WithContext(ctx))
+		}, ilmPolicy(config)); err != nil {
+			return err
+		}
+	}
+
+	settings := map[string]interface{}{}
+	if ilm {
+		settings["index.lifecycle.name"] = policy
+		if aliasRollover {
+			settings["index.lifecycle.rollover_alias"] = config.ESWriteAlias
+		}
+	}
+	mappings := schema.esMappings()
+	if config.GeoIPCityDB != "" || config.GeoIPASNDB != "" {
+		props := mappings["properties"].(map[string]interface{})
+		for _, side := range []string{"source", "destination"} {
+			props[side+"_geo_location"] = map[string]string{"type": "geo_point"}
+			props[side+"_as_number"] = map[string]string{"type": "long"}
+		}
+	}
+	if mapping != nil {
+		mappings["properties"] = mapping.mapProperties(mappings["properties"].(map[string]interface{}))
+	}
+	if config.DocumentFormat == "ecs" {
+		mappings["properties"] = ecsProperties(mappings["properties"].(map[string]interface{}))
+	}
+	if config.ESWriteAlias == "" {
+		return putIndexTemplate(ctx, es, config.ESTemplateName, index.Pattern(), settings, mappings, config.ESDataStream)
+	}
+	// 첫 index 가 template 을 받도록 template 을 먼저 만든다
+	if err := putIndexTemplate(ctx, es, config.ESTemplateName, writeAliasPattern(config.ESWriteAlias), settings, mappings, false); err != nil {
+		return err
+	}
+	return bootstrapWriteAlias(ctx, es, config.ESWriteAlias)
+}
+
+func putIndexTemplate(ctx context.Context, es *elasticsearch.Client, name, pattern string, settings, mappings map[string]interface{}, dataStream bool) error {
+	tmpl := map[string]interface{}{
+		"index_patterns": []string{pattern},
+		// 패턴이 겹치면 (twamp-data-* 와 twamp-data-vendor-a-*) 더 구체적인 쪽이 이긴다
+		"priority": 200 + len(strings.TrimSuffix(pattern, "*")),
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": mappings,
+		},
+		"_meta": map[string]interface{}{"managed_by": "twamp"},
+	}
+	if dataStream {
+		// 패턴에 맞는 이름으로 처음 create 하면 data stream 이 자동 생성된다
+		tmpl["data_stream"] = map[string]interface{}{}
+	}
+	return putJSON(ctx, es, "index template "+name, func(body io.Reader) (*esapi.Response, error) {
+		return es.Indices.PutIndexTemplate(name, body, es.Indices.PutIndexTemplate.WithContext(ctx))
+	}, tmpl)
+}
+
+// ilmPolicy builds hot -> warm -> delete phases; an empty ILM_*_AFTER skips
+// that phase. Data streams and a write alias rolled over by ILM also roll
+// over in the hot phase.
+func ilmPolicy(config Config) map[string]interface{} {
+	hot := map[string]interface{}{"set_priority": map[string]int{"priority": 100}}
+	if config.ESDataStream || config.ESWriteAlias != "" && config.ILMRollover == "ilm" {
+		if rollover := rolloverConditions(config); len(rollover) > 0 {
+			hot["rollover"] = rollover
+		}
+	}
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{"min_age": "0ms", "actions": hot},
+	}
+	if config.ILMWarmAfter != "" {
+		phases["warm"] = map[string]interface{}{
+			"min_age": config.ILMWarmAfter,
+			"actions": map[string]interface{}{
+				"set_priority": map[string]int{"priority": 50},
+				"forcemerge":   map[string]int{"max_num_segments": 1},
+				"readonly":     map[string]interface{}{},
+			},
+		}
+	}
+	if config.ILMDeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": config.ILMDeleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	return map[string]interface{}{"policy": map[string]interface{}{"phases": phases}}
+}
+
+// esMappings maps schema columns to Elasticsearch field types. Columns we
+// don't know about (vendor extras, sender stats) fall under the dynamic
+// templates: strings as keyword, numbers as double.
+func (s *Schema) esMappings() map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, f := range s.fields {
+		props[f.Name] = map[string]string{"type": esFieldType(f)}
+	}
+	return map[string]interface{}{
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{"strings_as_keyword": map[string]interface{}{
+				"match_mapping_type": "string",
+				"mapping":            map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+			}},
+			map[string]interface{}{"numbers_as_double": map[string]interface{}{
+				"match_mapping_type": "long",
+				"mapping":            map[string]string{"type": "double"},
+			}},
+		},
+		"properties": props,
+	}
+}
+
+func esFieldType(f schemaField) string {
+	switch f.Type {
+	case typeInt:
+		return "long"
+	case typeFloat:
+		return "double"
+	case typeBool:
+		return "boolean"
+	case typeDate:
+		return "date"
+	}
+	if strings.HasSuffix(f.Name, "_ip") {
+		return "ip"
+	}
+	return "keyword"
+}
+
+func putJSON(ctx context.Context, es *elasticsearch.Client, what string, do func(io.Reader) (*esapi.Response, error), body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := do(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", what, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("error creating %s: [%d] %s", what, res.StatusCode, msg)
+	}
+	logES.Info("created/updated " + what)
+	return nil
+}
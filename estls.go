@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// esTLSConfig builds the client TLS settings for ES_SERVER: system roots
+// plus ES_CA_CERT, an optional client certificate for mTLS, and
+// verification turned off only when ES_TLS_INSECURE_SKIP_VERIFY is set.
+func esTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.ESCACert != "" {
+		pem, err := os.ReadFile(config.ESCACert)
+		if err != nil {
+			return nil, fmt.Errorf("ES_CA_CERT: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ES_CA_CERT: no PEM certificates in %s", config.ESCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ESClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ESClientCert, config.ESClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("ES_CLIENT_CERT/ES_CLIENT_KEY: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.ESInsecureSkipVerify {
+		logES.Warn("ES_TLS_INSECURE_SKIP_VERIFY is set, the Elasticsearch certificate is not verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// checkTLSHandshake does one handshake against an https ES_SERVER.
+// Certificate problems are returned as errors; an unreachable server is
+// only logged since the cluster may come up after us (retries cover it).
+func checkTLSHandshake(server string, tlsConfig *tls.Config) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("ES_SERVER: %w", err)
+	}
+	if u.Scheme != "https" {
+		return nil
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	conf := tlsConfig.Clone()
+	conf.ServerName = u.Hostname()
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, conf)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		var alert tls.AlertError
+		if errors.As(err, &certErr) || errors.As(err, &alert) {
+			return fmt.Errorf("TLS handshake with %s failed (check ES_CA_CERT / ES_CLIENT_CERT): %w", host, err)
+		}
+		logES.Warn("TLS handshake not possible yet", "host", host, "err", err)
+		return nil
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		logES.Info("TLS handshake ok", "host", host, "version", tls.VersionName(state.Version),
+			"subject", cert.Subject.CommonName, "issuer", cert.Issuer.CommonName, "expires", cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
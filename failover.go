@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+)
+
+// esFailover moves the Elasticsearch output to ES_SECONDARY_SERVER when
+// the primary has failed for ES_FAILOVER_AFTER straight (connection errors
+// and 429/5xx on every bulk request, no success in between). It is shared
+// by all the indexers (main, rollup, alerts, ...), so they switch together.
+//
+// While failed over, every request the secondary indexed is also kept in
+// ES_RECONCILE_DIR, the gap. The primary is pinged every
+// ES_FAILBACK_CHECK; once it answers, new requests go back to it, queued
+// behind the gap in order, and the gap is sent to it oldest first until
+// it's empty, so the primary ends up with everything. The secondary keeps
+// what it got during the outage.
+type esFailover struct {
+	primary, secondary *elasticsearch.Client
+	after, check       time.Duration
+	refresh            string
+	gap                *diskSpool
+
+	mu           sync.Mutex
+	failingSince time.Time // zero while the primary answers
+	failedOver   bool
+	gapFull      bool
+}
+
+const (
+	clusterPrimary   = "primary"
+	clusterSecondary = "secondary"
+)
+
+func newESFailover(primary, secondary *elasticsearch.Client, config Config) (*esFailover, error) {
+	gap, err := openDiskSpool(config.ESReconcileDir, config.SpoolMaxBytes, metricReconcileBytes, metricReconcileRequests)
+	if err != nil {
+		return nil, fmt.Errorf("ES_RECONCILE_DIR: %w", err)
+	}
+	f := &esFailover{
+		primary: primary, secondary: secondary, refresh: config.ESRefresh,
+		after: config.ESFailoverAfter, check: config.ESFailbackCheck, gap: gap,
+	}
+	metricESActiveCluster.Set(1, clusterPrimary)
+	metricESActiveCluster.Set(0, clusterSecondary)
+	return f, nil
+}
+
+// client returns the cluster bulk requests go to now.
+func (f *esFailover) client() (*elasticsearch.Client, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedOver {
+		return f.secondary, clusterSecondary
+	}
+	return f.primary, clusterPrimary
+}
+
+// observe records the outcome of a bulk request to the primary and fails
+// over once it has been failing for ES_FAILOVER_AFTER.
+func (f *esFailover) observe(cluster string, err error) {
+	if cluster != clusterPrimary {
+		return
+	}
+	_, down := err.(retryableError)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !down {
+		f.failingSince = time.Time{}
+		return
+	}
+	if f.failingSince.IsZero() {
+		f.failingSince = time.Now()
+	}
+	if f.failedOver || time.Since(f.failingSince) < f.after {
+		return
+	}
+	f.failedOver, f.gapFull = true, false
+	f.switched(clusterSecondary)
+	logES.Warn("primary Elasticsearch failing, switching to the secondary", "failing_for", time.Since(f.failingSince).Round(time.Second).String(), "err", err)
+	notify(notification{
+		Event:    eventFailover,
+		Severity: "critical",
+		Title:    "Elasticsearch failover to the secondary cluster",
+		Text:     fmt.Sprintf("the primary cluster has been failing for %s (%v); indexing into the secondary until it is back", time.Since(f.failingSince).Round(time.Second), err),
+	})
+}
+
+func (f *esFailover) switched(to string) {
+	metricESFailovers.Inc(to)
+	metricESActiveCluster.Set(0, clusterPrimary)
+	metricESActiveCluster.Set(0, clusterSecondary)
+	metricESActiveCluster.Set(1, to)
+}
+
+// indexed keeps the items the secondary took, for the primary.
+func (f *esFailover) indexed(cluster string, items []bulkItem) {
+	metricESClusterDocuments.Add(float64(len(items)), cluster)
+	if cluster != clusterSecondary || len(items) == 0 {
+		return
+	}
+	ok, err := f.gap.write(items)
+	if err == nil && ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		logES.Error("error writing reconcile gap, the documents are only on the secondary", "documents", len(items), "err", err)
+	} else if !f.gapFull {
+		logES.Error("reconcile gap full, further documents are only on the secondary", "max_bytes", f.gap.maxBytes)
+		f.gapFull = true
+	}
+}
+
+// queue puts items behind the gap still being reconciled into the
+// primary, so it gets them in order. It returns false when they can be
+// sent directly.
+func (f *esFailover) queue(items []bulkItem) bool {
+	f.mu.Lock()
+	failedOver := f.failedOver
+	f.mu.Unlock()
+	if failedOver || !f.gap.active() {
+		return false
+	}
+	ok, err := f.gap.write(items)
+	if err != nil {
+		logES.Error("error writing reconcile gap", "err", err)
+	}
+	return ok && err == nil
+}
+
+// run pings the primary while failed over and reconciles the gap every
+// ES_FAILBACK_CHECK until ctx is done.
+func (f *esFailover) run(ctx context.Context) {
+	ticker := time.NewTicker(f.check)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, cluster := f.client(); cluster == clusterSecondary && !f.failback(ctx) {
+			continue
+		}
+		f.reconcile(ctx)
+	}
+}
+
+// failback switches back to the primary if it answers a ping.
+func (f *esFailover) failback(ctx context.Context) bool {
+	res, err := f.primary.Ping(f.primary.Ping.WithContext(ctx))
+	if err == nil {
+		res.Body.Close()
+		if res.IsError() {
+			err = fmt.Errorf("ping: %s", res.Status())
+		}
+	}
+	if err != nil {
+		logES.Debug("primary Elasticsearch still unavailable", "err", err)
+		return false
+	}
+	f.mu.Lock()
+	f.failedOver, f.failingSince = false, time.Time{}
+	f.switched(clusterPrimary)
+	f.mu.Unlock()
+	requests := f.gap.count()
+	logES.Info("primary Elasticsearch back, switching to it and reconciling the gap", "requests", requests)
+	notify(notification{
+		Event:    eventFailover,
+		Severity: "info",
+		Title:    "Elasticsearch back on the primary cluster",
+		Text:     fmt.Sprintf("the primary cluster answers again; %d bulk requests indexed into the secondary meanwhile are re-indexed into it", requests),
+	})
+	return true
+}
+
+// reconcile sends the gap to the primary oldest first. It stops when the
+// primary fails again; the rest is sent on a later tick.
+func (f *esFailover) reconcile(ctx context.Context) {
+	sent, docs := 0, 0
+	for ctx.Err() == nil {
+		path, items, err := f.gap.oldest()
+		if path == "" {
+			break
+		}
+		if err != nil {
+			logES.Error("error reading reconcile gap segment, dropping it", "file", path, "err", err)
+			f.gap.remove(path)
+			continue
+		}
+		retry, rejected, err := sendBulk(ctx, "elasticsearch", items, f.primary, f.refresh)
+		f.observe(clusterPrimary, err)
+		if err != nil {
+			if _, ok := err.(retryableError); ok {
+				logES.Warn("primary Elasticsearch unavailable, reconciling later", "err", err)
+				return
+			}
+			logES.Error("error reconciling request, its documents are only on the secondary", "file", filepath.Base(path), "documents", len(items), "err", err)
+		} else {
+			failed := 0
+			for _, r := range rejected {
+				// 409: 이미 primary 에 있는 문서
+				if r.status != http.StatusConflict {
+					failed++
+				}
+			}
+			if failed > 0 {
+				logES.Error("documents rejected by the primary while reconciling, they are only on the secondary", "documents", failed)
+			}
+			// 429 등은 gap 끝에 다시 넣는다
+			if len(retry) > 0 {
+				if ok, err := f.gap.write(retry); !ok || err != nil {
+					logES.Error("error requeueing documents to reconcile", "documents", len(retry), "err", err)
+				}
+			}
+			n := len(items) - len(retry) - len(rejected)
+			metricESClusterDocuments.Add(float64(n), "reconciled")
+			docs += n
+		}
+		f.gap.remove(path)
+		sent++
+	}
+	if sent > 0 && !f.gap.active() {
+		logES.Info("reconcile gap re-indexed into the primary", "requests", sent, "documents", docs)
+	}
+}
+
+// indexedItems returns the items of a bulk request that weren't retried
+// or rejected.
+func indexedItems(items, retry []bulkItem, rejected []rejectedItem) []bulkItem {
+	if len(retry) == 0 && len(rejected) == 0 {
+		return items
+	}
+	failed := make(map[*byte]bool, len(retry)+len(rejected))
+	for _, item := range retry {
+		failed[&item.meta[0]] = true
+	}
+	for _, r := range rejected {
+		failed[&r.item.meta[0]] = true
+	}
+	var out []bulkItem
+	for _, item := range items {
+		if !failed[&item.meta[0]] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
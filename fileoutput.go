@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileOutput appends records as NDJSON to a local file (OUTPUT=file), for
+// debugging, air-gapped transfer or other tools. The file is rotated once
+// it reaches FILE_OUTPUT_MAX_BYTES or has been written to for
+// FILE_OUTPUT_ROTATE: it's renamed with the time, e.g.
+// twamp-output-20240501T120000.000Z.ndjson, gzipped with FILE_OUTPUT_GZIP,
+// and only the newest FILE_OUTPUT_KEEP rotated files are kept. The file
+// being written stays plain so it can be followed with tail -f.
+type fileOutput struct {
+	path     string
+	maxBytes int64
+	rotate   time.Duration
+	gzip     bool
+	keep     int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newFileOutput(config Config) *fileOutput {
+	return &fileOutput{
+		path: config.FileOutputPath, maxBytes: int64(config.FileOutputMaxBytes),
+		rotate: config.FileOutputRotate, gzip: config.FileOutputGzip, keep: config.FileOutputKeep,
+	}
+}
+
+func (o *fileOutput) Name() string { return "file" }
+
+// recyclesRecords: Write encodes the records before it returns.
+func (o *fileOutput) recyclesRecords() {}
+
+func (o *fileOutput) Write(ctx context.Context, records []Record) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var buf []byte
+	for _, rec := range records {
+		var err error
+		if buf, err = appendRecordJSON(buf, rec); err != nil {
+			return fmt.Errorf("error marshalling record: %w", err)
+		}
+		buf = append(buf, '\n')
+	}
+	if o.file == nil {
+		if err := o.openLocked(); err != nil {
+			return err
+		}
+	}
+	// 이전 실행이 남긴 파일도 크기가 넘었으면 돌린다
+	if o.due(len(buf)) {
+		if err := o.rotateLocked(); err != nil {
+			logOutput.Error("error rotating file output, appending to it", "file", o.path, "err", err)
+		}
+		if o.file == nil {
+			if err := o.openLocked(); err != nil {
+				return err
+			}
+		}
+	}
+	n, err := o.file.Write(buf)
+	o.size += int64(n)
+	if err != nil {
+		metricBulkFailures.Inc(o.Name())
+		return err
+	}
+	metricDocumentsIndexed.Add(float64(len(records)), o.Name())
+	return nil
+}
+
+// due reports whether the file is rotated before n more bytes go in. A
+// batch larger than FILE_OUTPUT_MAX_BYTES still goes into one file.
+func (o *fileOutput) due(n int) bool {
+	if o.size == 0 {
+		return false
+	}
+	return o.maxBytes > 0 && o.size+int64(n) > o.maxBytes || o.rotate > 0 && time.Since(o.opened) >= o.rotate
+}
+
+func (o *fileOutput) openLocked() error {
+	f, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.file, o.size, o.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// rotateLocked closes the file and moves it aside; the next Write opens a
+// new one.
+func (o *fileOutput) rotateLocked() error {
+	if err := o.file.Close(); err != nil {
+		return err
+	}
+	o.file = nil
+	ext := filepath.Ext(o.path)
+	base := strings.TrimSuffix(o.path, ext)
+	rotated := base + "-" + time.Now().UTC().Format("20060102T150405.000Z") + ext
+	if err := os.Rename(o.path, rotated); err != nil {
+		return err
+	}
+	if o.gzip {
+		_, err := gzipFile(rotated, rotated+".gz", func(string) error { return nil })
+		if err == nil {
+			err = os.Remove(rotated)
+			rotated += ".gz"
+		}
+		if err != nil {
+			logOutput.Error("error compressing rotated file output", "file", rotated, "err", err)
+		}
+	}
+	logOutput.Info("file output rotated", "file", rotated, "bytes", o.size)
+	if o.keep > 0 {
+		o.prune(base+"-*"+ext, base+"-*"+ext+".gz")
+	}
+	return nil
+}
+
+// prune removes the oldest rotated files past FILE_OUTPUT_KEEP. The
+// names sort by their time.
+func (o *fileOutput) prune(patterns ...string) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		files = append(files, matches...)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.TrimSuffix(files[i], ".gz") < strings.TrimSuffix(files[j], ".gz")
+	})
+	for len(files) > o.keep {
+		if err := os.Remove(files[0]); err != nil {
+			logOutput.Error("error removing old file output", "file", files[0], "err", err)
+		}
+		files = files[1:]
+	}
+}
+
+func (o *fileOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpConn is a minimal passive-mode FTP client: login, list and retrieve.
+type ftpConn struct {
+	conn *net.TCPConn
+	text *textproto.Conn
+	host string
+}
+
+func dialFTP(ctx context.Context, h *pullHost) (*ftpConn, error) {
+	addr := net.JoinHostPort(h.Host, strconv.Itoa(h.port()))
+	d := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{conn: conn.(*net.TCPConn), text: textproto.NewConn(conn), host: h.Host}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		c.Close()
+		return nil, err
+	}
+	code, _, err := c.cmd(0, "USER %s", h.Username)
+	if err == nil && code == 331 {
+		_, _, err = c.cmd(230, "PASS %s", h.password())
+	} else if err == nil && code != 230 {
+		err = fmt.Errorf("unexpected reply %d to USER", code)
+	}
+	if err == nil {
+		_, _, err = c.cmd(200, "TYPE I")
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("FTP login to %s: %w", addr, err)
+	}
+	return c, nil
+}
+
+// cmd sends a command and reads the reply; expect 0 accepts any code.
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expect)
+}
+
+// passive opens the data connection (EPSV, falling back to PASV). The
+// control connection's host is used either way, which also works behind NAT.
+func (c *ftpConn) passive() (net.Conn, error) {
+	port := 0
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start+4 {
+			return nil, fmt.Errorf("can't parse EPSV reply %q", msg)
+		}
+		port, _ = strconv.Atoi(msg[start+4 : end])
+	} else {
+		_, msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("can't parse PASV reply %q", msg)
+		}
+		f := strings.Split(msg[start+1:end], ",")
+		if len(f) != 6 {
+			return nil, fmt.Errorf("can't parse PASV reply %q", msg)
+		}
+		p1, _ := strconv.Atoi(f[4])
+		p2, _ := strconv.Atoi(f[5])
+		port = p1<<8 | p2
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), 30*time.Second)
+}
+
+// transfer runs a data command and hands the data connection to fn.
+func (c *ftpConn) transfer(fn func(io.Reader) error, format string, args ...interface{}) error {
+	data, err := c.passive()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	if _, _, err := c.cmd(1, format, args...); err != nil {
+		return err
+	}
+	err = fn(data)
+	data.Close()
+	if _, _, rerr := c.text.ReadResponse(226); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// list returns the regular files in dir, from MLSD when the server
+// supports it, else NLST plus SIZE for each name.
+func (c *ftpConn) list(dir string) ([]remoteFile, error) {
+	var files []remoteFile
+	err := c.transfer(func(r io.Reader) error {
+		lines, err := readLines(r)
+		for _, line := range lines {
+			facts, name, ok := strings.Cut(line, " ")
+			if !ok {
+				continue
+			}
+			f := remoteFile{name: name}
+			isFile := false
+			for _, fact := range strings.Split(facts, ";") {
+				k, v, _ := strings.Cut(fact, "=")
+				switch strings.ToLower(k) {
+				case "type":
+					isFile = strings.EqualFold(v, "file")
+				case "size":
+					f.size, _ = strconv.ParseInt(v, 10, 64)
+				case "modify":
+					f.modified = v
+				}
+			}
+			if isFile {
+				files = append(files, f)
+			}
+		}
+		return err
+	}, "MLSD %s", dir)
+	if err == nil {
+		return files, nil
+	}
+	if te, ok := err.(*textproto.Error); !ok || te.Code < 500 {
+		return nil, err
+	}
+
+	var names []string
+	err = c.transfer(func(r io.Reader) error {
+		var err error
+		names, err = readLines(r)
+		return err
+	}, "NLST %s", dir)
+	if err != nil {
+		return nil, err
+	}
+	files = files[:0]
+	for _, name := range names {
+		name = path.Base(name)
+		// 디렉토리는 SIZE 가 실패한다
+		_, msg, err := c.cmd(213, "SIZE %s", path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+		files = append(files, remoteFile{name: name, size: size})
+	}
+	return files, nil
+}
+
+func (c *ftpConn) retrieve(remote, dest string) error {
+	return c.transfer(func(r io.Reader) error {
+		return writeFileAtomic(dest, r)
+	}, "RETR %s", remote)
+}
+
+func (c *ftpConn) Close() error {
+	c.text.Cmd("QUIT")
+	return c.text.Close()
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, err
+}
+
+// writeFileAtomic copies r to dest through dest.part.
+func writeFileAtomic(dest string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"sync"
+)
+
+// mmdbReader reads MaxMind DB files (GeoLite2/GeoIP2 City and ASN); the
+// whole file is kept in memory, lookups do no I/O.
+// Format: https://maxmind.github.io/MaxMind-DB/
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dbType     string
+	data       []byte // data section
+	ipv4Start  uint
+}
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GeoIP database: %w", err)
+	}
+	start := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if start < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	start += len(mmdbMetadataMarker)
+	meta, _, err := (&mmdbReader{data: buf[start:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid metadata: %w", path, err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid metadata", path)
+	}
+	r := &mmdbReader{buf: buf}
+	r.nodeCount, _ = mmdbUint(m["node_count"])
+	r.recordSize, _ = mmdbUint(m["record_size"])
+	r.ipVersion, _ = mmdbUint(m["ip_version"])
+	r.dbType, _ = m["database_type"].(string)
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, r.recordSize)
+	}
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	if treeSize+16 > uint(start) {
+		return nil, fmt.Errorf("%s: search tree larger than the file", path)
+	}
+	r.data = buf[treeSize+16 : start-len(mmdbMetadataMarker)]
+
+	// IPv6 트리 안의 IPv4 는 ::/96 아래에 있다
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func mmdbUint(v interface{}) (uint, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n), true
+	case uint32:
+		return uint(n), true
+	case uint16:
+		return uint(n), true
+	}
+	return 0, false
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *mmdbReader) record(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the record for ip, or nil if the database has none.
+func (r *mmdbReader) lookup(ip netip.Addr) (map[string]interface{}, error) {
+	ip = ip.Unmap()
+	node := uint(0)
+	var bits []byte
+	if ip.Is4() {
+		a := ip.As4()
+		bits = a[:]
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		a := ip.As16()
+		bits = a[:]
+	}
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(bits[i/8]>>(7-i%8)&1))
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("invalid search tree")
+	}
+	v, _, err := r.decode(node - r.nodeCount - 16)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+// decode reads the data field at offset and returns it with the offset
+// just past it.
+func (r *mmdbReader) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(r.data)) {
+		return nil, 0, errors.New("data offset out of range")
+	}
+	ctrl := r.data[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+	if typ == 1 {
+		// pointer: 가리키는 값을 읽고, 다음 위치는 pointer 바로 뒤
+		ss, vvv := uint(ctrl>>3)&3, uint(ctrl&7)
+		if offset+ss+1 > uint(len(r.data)) {
+			return nil, 0, errors.New("truncated pointer")
+		}
+		b := r.data[offset : offset+ss+1]
+		var p uint
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(b[0])
+		case 1:
+			p = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		v, _, err := r.decode(p)
+		return v, offset + ss + 1, err
+	}
+	if typ == 0 {
+		if offset >= uint(len(r.data)) {
+			return nil, 0, errors.New("truncated extended type")
+		}
+		typ = 7 + uint(r.data[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(r.data)) {
+			return nil, 0, errors.New("truncated size")
+		}
+		b := r.data[offset : offset+n]
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	switch typ {
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := r.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := r.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, _ := k.(string)
+			m[key], offset = v, next
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := r.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, offset = append(a, v), next
+		}
+		return a, offset, nil
+	case 14: // boolean, 값이 size 에 들어 있다
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(r.data)) {
+		return nil, 0, errors.New("truncated data")
+	}
+	b := r.data[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4:
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9, 10:
+		// uint128 은 상위 비트를 버린다 (GeoIP 에서는 쓰이지 않음)
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// mmdbPath walks nested maps and arrays, e.g. "subdivisions", 0, "names", "en".
+func mmdbPath(v interface{}, path ...interface{}) interface{} {
+	for _, p := range path {
+		switch k := p.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[k]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || k >= len(a) {
+				return nil
+			}
+			v = a[k]
+		}
+	}
+	return v
+}
+
+// geoIPEnricher adds <side>_geo_* (location as a geo_point) and
+// <side>_as_* fields for source_ip and destination_ip. Private addresses
+// are skipped; results are cached per address.
+type geoIPEnricher struct {
+	city, asn *mmdbReader
+
+	mu    sync.Mutex
+	cache map[netip.Addr]map[string]interface{}
+}
+
+// geoIPCacheSize bounds the cache; probes talk to a fixed set of
+// endpoints, so it's only reset if that assumption doesn't hold.
+const geoIPCacheSize = 10000
+
+func newGeoIPEnricher(cityPath, asnPath string) (*geoIPEnricher, error) {
+	e := &geoIPEnricher{cache: map[netip.Addr]map[string]interface{}{}}
+	var err error
+	if cityPath != "" {
+		if e.city, err = openMMDB(cityPath); err != nil {
+			return nil, err
+		}
+		logGeoIP.Info("GeoIP database loaded", "file", cityPath, "type", e.city.dbType)
+	}
+	if asnPath != "" {
+		if e.asn, err = openMMDB(asnPath); err != nil {
+			return nil, err
+		}
+		logGeoIP.Info("GeoIP database loaded", "file", asnPath, "type", e.asn.dbType)
+	}
+	return e, nil
+}
+
+func (e *geoIPEnricher) Enrich(rec Record) Record {
+	for _, side := range []string{"source", "destination"} {
+		s, _ := rec[side+"_ip"].(string)
+		ip, err := netip.ParseAddr(normalizeIP(s))
+		if err != nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		for k, v := range e.fields(ip) {
+			setMissing(rec, side+"_"+k, v)
+		}
+	}
+	return rec
+}
+
+func (e *geoIPEnricher) fields(ip netip.Addr) map[string]interface{} {
+	e.mu.Lock()
+	f, ok := e.cache[ip]
+	e.mu.Unlock()
+	if ok {
+		return f
+	}
+
+	f = map[string]interface{}{}
+	if e.city != nil {
+		rec, err := e.city.lookup(ip)
+		if err != nil {
+			logGeoIP.Warn("GeoIP lookup failed", "ip", ip.String(), "err", err)
+		}
+		lat, ok1 := mmdbPath(rec, "location", "latitude").(float64)
+		lon, ok2 := mmdbPath(rec, "location", "longitude").(float64)
+		if ok1 && ok2 {
+			f["geo_location"] = map[string]float64{"lat": lat, "lon": lon}
+		}
+		for k, path := range map[string][]interface{}{
+			"geo_continent_code":   {"continent", "code"},
+			"geo_country_iso_code": {"country", "iso_code"},
+			"geo_country_name":     {"country", "names", "en"},
+			"geo_region_name":      {"subdivisions", 0, "names", "en"},
+			"geo_city_name":        {"city", "names", "en"},
+		} {
+			if v, ok := mmdbPath(rec, path...).(string); ok && v != "" {
+				f[k] = v
+			}
+		}
+	}
+	if e.asn != nil {
+		rec, err := e.asn.lookup(ip)
+		if err != nil {
+			logGeoIP.Warn("ASN lookup failed", "ip", ip.String(), "err", err)
+		}
+		if n, ok := mmdbUint(mmdbPath(rec, "autonomous_system_number")); ok {
+			f["as_number"] = n
+		}
+		if v, ok := mmdbPath(rec, "autonomous_system_organization").(string); ok && v != "" {
+			f["as_organization_name"] = v
+		}
+	}
+
+	e.mu.Lock()
+	if len(e.cache) >= geoIPCacheSize {
+		e.cache = map[netip.Addr]map[string]interface{}{}
+	}
+	e.cache[ip] = f
+	e.mu.Unlock()
+	return f
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// grafanaProvisioner sets Grafana up for the indexed data over its HTTP
+// API (`twamp provision-grafana`): the GRAFANA_FOLDER folder, an
+// Elasticsearch datasource for the index pattern and the TWAMP dashboards
+// in the folder. Everything has a fixed uid and is overwritten, so running
+// it again after an upgrade updates the dashboards (edits made in Grafana
+// are lost; save a copy under another name to keep them).
+type grafanaProvisioner struct {
+	url     string
+	token   string
+	user    string
+	pass    string
+	folder  string
+	client  *http.Client
+	ds      grafanaDatasource
+	groupBy string
+}
+
+type grafanaDatasource struct {
+	name  string
+	url   string
+	index string
+	user  string
+	pass  string
+}
+
+const (
+	grafanaFolderUID     = "twamp"
+	grafanaDatasourceUID = "twamp-es"
+	// dashboard 의 그룹 변수 이름
+	grafanaVariable = "group"
+)
+
+func newGrafanaProvisioner(config Config, index, groupBy string) *grafanaProvisioner {
+	esURL := config.GrafanaESURL
+	if esURL == "" {
+		esURL = config.ESServer
+	}
+	return &grafanaProvisioner{
+		url:    strings.TrimRight(config.GrafanaURL, "/"),
+		token:  config.GrafanaToken,
+		user:   config.GrafanaUser,
+		pass:   config.GrafanaPassword,
+		folder: config.GrafanaFolder,
+		client: &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}},
+		ds: grafanaDatasource{
+			name: config.GrafanaDatasource, url: esURL, index: index,
+			user: config.ESUser, pass: config.ESPassword,
+		},
+		groupBy: groupBy,
+	}
+}
+
+// provision creates or updates the folder, the datasource and the
+// dashboards.
+func (g *grafanaProvisioner) provision() error {
+	if err := g.folderExists(); err != nil {
+		return err
+	}
+	if err := g.putDatasource(); err != nil {
+		return err
+	}
+	for _, d := range g.dashboards() {
+		body := map[string]interface{}{"dashboard": d, "folderUid": grafanaFolderUID, "overwrite": true, "message": "twamp provision-grafana"}
+		if _, err := g.do(http.MethodPost, "/api/dashboards/db", body); err != nil {
+			return fmt.Errorf("error importing dashboard %q: %w", d["title"], err)
+		}
+		logHTTP.Info("imported Grafana dashboard", "title", d["title"], "uid", d["uid"])
+	}
+	return nil
+}
+
+// print writes the datasource and dashboards as JSON to w instead, for an
+// import by hand.
+func (g *grafanaProvisioner) print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	ds := g.datasource()
+	delete(ds, "secureJsonData")
+	return enc.Encode(map[string]interface{}{"datasource": ds, "dashboards": g.dashboards()})
+}
+
+func (g *grafanaProvisioner) folderExists() error {
+	status, err := g.do(http.MethodGet, "/api/folders/"+grafanaFolderUID, nil)
+	if status == http.StatusNotFound {
+		_, err = g.do(http.MethodPost, "/api/folders", map[string]string{"uid": grafanaFolderUID, "title": g.folder})
+		if err == nil {
+			logHTTP.Info("created Grafana folder", "title", g.folder)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error creating Grafana folder %q: %w", g.folder, err)
+	}
+	return nil
+}
+
+func (g *grafanaProvisioner) putDatasource() error {
+	status, err := g.do(http.MethodGet, "/api/datasources/uid/"+grafanaDatasourceUID, nil)
+	switch {
+	case status == http.StatusNotFound:
+		_, err = g.do(http.MethodPost, "/api/datasources", g.datasource())
+	case err == nil:
+		_, err = g.do(http.MethodPut, "/api/datasources/uid/"+grafanaDatasourceUID, g.datasource())
+	}
+	if err != nil {
+		return fmt.Errorf("error creating Grafana datasource %q: %w", g.ds.name, err)
+	}
+	logHTTP.Info("created/updated Grafana datasource", "name", g.ds.name, "index", g.ds.index)
+	return nil
+}
+
+func (g *grafanaProvisioner) datasource() map[string]interface{} {
+	ds := map[string]interface{}{
+		"uid":    grafanaDatasourceUID,
+		"name":   g.ds.name,
+		"type":   "elasticsearch",
+		"access": "proxy",
+		"url":    g.ds.url,
+		// index 는 Grafana 10 부터, database 는 그 이전 버전이 읽는다
+		"database": g.ds.index,
+		"jsonData": map[string]interface{}{"index": g.ds.index, "timeField": "@timestamp", "maxConcurrentShardRequests": 5},
+	}
+	if g.ds.user != "" {
+		ds["basicAuth"] = true
+		ds["basicAuthUser"] = g.ds.user
+		ds["secureJsonData"] = map[string]string{"basicAuthPassword": g.ds.pass}
+	}
+	return ds
+}
+
+// do sends a JSON request to the Grafana API and returns the status; a
+// status of 300 or more is also an error.
+func (g *grafanaProvisioner) do(method, path string, body interface{}) (int, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, g.url+path, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	} else if g.user != "" {
+		req.SetBasicAuth(g.user, g.pass)
+	}
+	res, err := g.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("%s %s: [%d] %s", method, path, res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return res.StatusCode, nil
+}
+
+// dashboards are the curated dashboards, built on the KPI_ENRICH fields
+// (rtt_mean in µs, loss_pct, available) and grouped by g.groupBy.
+func (g *grafanaProvisioner) dashboards() []map[string]interface{} {
+	ds := map[string]string{"type": "elasticsearch", "uid": grafanaDatasourceUID}
+	group := func(size string) map[string]interface{} {
+		return map[string]interface{}{"id": "3", "type": "terms", "field": g.groupBy,
+			"settings": map[string]string{"size": size, "order": "desc", "orderBy": "_count", "min_doc_count": "1"}}
+	}
+	histogram := map[string]interface{}{"id": "2", "type": "date_histogram", "field": "@timestamp", "settings": map[string]string{"interval": "auto"}}
+	target := func(metrics []map[string]interface{}, buckets ...map[string]interface{}) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"refId": "A", "datasource": ds, "timeField": "@timestamp",
+			"query":   g.groupBy + `:$` + grafanaVariable,
+			"metrics": metrics, "bucketAggs": buckets,
+		}}
+	}
+	metric := func(id, typ, field string, settings map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{"id": id, "type": typ, "field": field}
+		if settings != nil {
+			m["settings"] = settings
+		}
+		return m
+	}
+	panel := func(id int, typ, title string, x, y, w, h int, unit string, targets []interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id": id, "type": typ, "title": title, "datasource": ds, "targets": targets,
+			"gridPos":     map[string]int{"x": x, "y": y, "w": w, "h": h},
+			"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": unit}, "overrides": []interface{}{}},
+		}
+	}
+	latencyHeatmap := panel(1, "heatmap", "RTT distribution", 0, 0, 24, 10, "µs",
+		target([]map[string]interface{}{metric("1", "avg", "rtt_mean", nil)}, group("500"), histogram))
+	latencyHeatmap["options"] = map[string]interface{}{
+		"calculate": true, "color": map[string]interface{}{"scheme": "Oranges", "mode": "scheme"},
+		"yAxis": map[string]interface{}{"unit": "µs"}, "cellGap": 1,
+	}
+	latencyPercentiles := panel(2, "timeseries", "RTT p50 / p95 / p99", 0, 10, 24, 9, "µs",
+		target([]map[string]interface{}{metric("1", "percentiles", "rtt_mean", map[string]interface{}{"percents": []string{"50", "95", "99"}})}, histogram))
+	latencyBySession := panel(3, "timeseries", "Mean RTT by "+g.groupBy, 0, 19, 24, 10, "µs",
+		target([]map[string]interface{}{metric("1", "avg", "rtt_mean", nil)}, group("10"), histogram))
+
+	lossByLink := panel(1, "timeseries", "Loss by "+g.groupBy, 0, 0, 24, 10, "percent",
+		target([]map[string]interface{}{metric("1", "avg", "loss_pct", nil)}, group("20"), histogram))
+	topLoss := panel(2, "bargauge", "Worst loss", 0, 10, 12, 12, "percent",
+		target([]map[string]interface{}{metric("1", "avg", "loss_pct", nil)}, group("20")))
+	topLoss["options"] = map[string]interface{}{"orientation": "horizontal", "displayMode": "gradient",
+		"reduceOptions": map[string]interface{}{"calcs": []string{"lastNotNull"}, "values": true}}
+	lossDirections := panel(3, "timeseries", "Uplink / downlink loss", 12, 10, 12, 12, "percent",
+		target([]map[string]interface{}{metric("1", "avg", "ul_loss_pct", nil), metric("4", "avg", "dl_loss_pct", nil)}, histogram))
+
+	availability := panel(1, "stat", "Availability", 0, 0, 6, 5, "percentunit",
+		target([]map[string]interface{}{metric("1", "avg", "available", nil)}, histogram))
+	availability["options"] = map[string]interface{}{"reduceOptions": map[string]interface{}{"calcs": []string{"mean"}}, "colorMode": "background"}
+	availability["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["thresholds"] = map[string]interface{}{
+		"mode": "absolute", "steps": []map[string]interface{}{{"color": "red", "value": nil}, {"color": "orange", "value": 0.99}, {"color": "green", "value": 0.999}},
+	}
+	p95 := panel(2, "stat", "RTT p95", 6, 0, 6, 5, "µs",
+		target([]map[string]interface{}{metric("1", "percentiles", "rtt_mean", map[string]interface{}{"percents": []string{"95"}})}, histogram))
+	p95["options"] = map[string]interface{}{"reduceOptions": map[string]interface{}{"calcs": []string{"max"}}}
+	loss := panel(3, "stat", "Loss", 12, 0, 6, 5, "percent",
+		target([]map[string]interface{}{metric("1", "avg", "loss_pct", nil)}, histogram))
+	loss["options"] = map[string]interface{}{"reduceOptions": map[string]interface{}{"calcs": []string{"mean"}}}
+	sessions := panel(4, "stat", "Measured "+g.groupBy, 18, 0, 6, 5, "none",
+		target([]map[string]interface{}{metric("1", "cardinality", g.groupBy, nil)}, histogram))
+	sessions["options"] = map[string]interface{}{"reduceOptions": map[string]interface{}{"calcs": []string{"max"}}}
+	summary := panel(5, "table", "SLA per "+g.groupBy, 0, 5, 24, 16, "none",
+		target([]map[string]interface{}{
+			metric("1", "avg", "available", nil),
+			metric("4", "percentiles", "rtt_mean", map[string]interface{}{"percents": []string{"50", "95", "99"}}),
+			metric("5", "avg", "loss_pct", nil),
+			metric("6", "count", "", nil),
+		}, group("1000")))
+
+	dashboard := func(uid, title string, panels ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"uid": uid, "title": title, "tags": []string{"twamp"}, "timezone": "browser", "schemaVersion": 39,
+			"time": map[string]string{"from": "now-24h", "to": "now"}, "refresh": "1m",
+			"templating": map[string]interface{}{"list": []interface{}{map[string]interface{}{
+				"name": grafanaVariable, "label": g.groupBy, "type": "query", "datasource": ds,
+				"query":      fmt.Sprintf(`{"find": "terms", "field": %q, "size": 1000}`, g.groupBy),
+				"multi":      true,
+				"includeAll": true, "allValue": "*", "refresh": 2, "sort": 1,
+				"current": map[string]interface{}{"text": "All", "value": "$__all"},
+			}}},
+			"panels": panels,
+		}
+	}
+	return []map[string]interface{}{
+		dashboard("twamp-latency", "TWAMP latency", latencyHeatmap, latencyPercentiles, latencyBySession),
+		dashboard("twamp-loss", "TWAMP loss by link", lossByLink, topLoss, lossDirections),
+		dashboard("twamp-sla", "TWAMP SLA summary", availability, p95, loss, sessions, summary),
+	}
+}
+
+func provisionGrafana(config Config, index, groupBy string, print bool) error {
+	g := newGrafanaProvisioner(config, index, groupBy)
+	if print {
+		return g.print(os.Stdout)
+	}
+	if g.url == "" {
+		return fmt.Errorf("GRAFANA_URL is required, or -print the dashboards")
+	}
+	if g.ds.url == "" {
+		return fmt.Errorf("GRAFANA_ES_URL or ES_SERVER is required for the datasource")
+	}
+	return g.provision()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// gRPC status codes used by the ingest service.
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnauthenticated = 16
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+	grpcResourceLimit   = 8
+)
+
+const grpcStreamMethod = "/twamp.v1.TwampIngest/Stream"
+
+// grpcIngest serves twamp.v1.TwampIngest (proto/twamp_ingest.proto) over
+// the HTTP/2 support in net/http, so no gRPC runtime is needed. Records
+// go to the same sink as files.
+type grpcIngest struct {
+	sink     recordSink
+	token    string
+	maxBytes int
+}
+
+// startGRPCServer listens on addr with TLS (HTTP/2 needs it in net/http).
+func startGRPCServer(config Config, sink recordSink) *http.Server {
+	g := &grpcIngest{sink: sink, token: config.GRPCToken, maxBytes: config.GRPCMaxMessageBytes}
+	srv := &http.Server{Addr: config.GRPCAddr, Handler: g}
+	go func() {
+		if err := srv.ListenAndServeTLS(config.GRPCTLSCert, config.GRPCTLSKey); err != nil && err != http.ErrServerClosed {
+			logGRPC.Error("error serving gRPC", "addr", config.GRPCAddr, "err", err)
+		}
+	}()
+	logGRPC.Info("gRPC ingest listening", "addr", config.GRPCAddr)
+	return srv
+}
+
+func (g *grpcIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost || !bytes.HasPrefix([]byte(r.Header.Get("Content-Type")), []byte("application/grpc")) {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.URL.Path != grpcStreamMethod {
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	if g.token != "" {
+		want := "Bearer " + g.token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			grpcStatus(w, grpcUnauthenticated, "invalid token")
+			return
+		}
+	}
+	gzipped := r.Header.Get("Grpc-Encoding") == "gzip"
+
+	accepted, rejected := uint64(0), uint64(0)
+	br := bufio.NewReader(r.Body)
+	for {
+		msg, err := readGRPCMessage(br, gzipped, g.maxBytes)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			code := grpcInvalidArgument
+			if errors.Is(err, errGRPCTooLarge) {
+				code = grpcResourceLimit
+			}
+			g.sink.Flush()
+			grpcStatus(w, code, err.Error())
+			return
+		}
+		rec, err := decodeTwampRecord(msg)
+		if err != nil {
+			rejected++
+			metricParseErrors.Inc()
+			continue
+		}
+		metricRowsParsed.Inc()
+		metricGRPCRecords.Inc()
+		if err := g.sink.Add(rec); err != nil {
+			logGRPC.Error("error indexing record", "err", err)
+			rejected++
+			continue
+		}
+		accepted++
+	}
+	if err := g.sink.Flush(); err != nil {
+		grpcStatus(w, grpcInternal, err.Error())
+		return
+	}
+
+	// IngestSummary { accepted = 1; rejected = 2; }
+	var body []byte
+	body = appendVarintField(body, 1, accepted)
+	body = appendVarintField(body, 2, rejected)
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(frame, body...))
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+	w.Header().Set("Grpc-Message", "")
+}
+
+// grpcStatus ends the call with an error status in the trailers.
+func grpcStatus(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+}
+
+var errGRPCTooLarge = errors.New("message exceeds GRPC_MAX_MESSAGE_BYTES")
+
+// readGRPCMessage reads one length-prefixed message: 1 byte compressed
+// flag, 4 bytes big-endian length, then the payload.
+func readGRPCMessage(r io.Reader, gzipped bool, maxBytes int) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated message header")
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if int64(n) > int64(maxBytes) {
+		return nil, errGRPCTooLarge
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.New("truncated message")
+	}
+	if head[0] == 0 {
+		return msg, nil
+	}
+	if !gzipped {
+		return nil, errors.New("compressed message without grpc-encoding")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxBytes {
+		return nil, errGRPCTooLarge
+	}
+	return out, nil
+}
+
+// decodeTwampRecord decodes a TwampRecord into the same Record shape the
+// CSV path produces: labels as strings, metrics as float64 and
+// @timestamp in RFC 3339.
+func decodeTwampRecord(msg []byte) (Record, error) {
+	rec := Record{}
+	ts := int64(0)
+	err := walkProto(msg, func(field int, wire int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			ts = int64(v)
+		case 2, 3:
+			var key, str string
+			var num float64
+			err := walkProto(b, func(f, wt int, v uint64, b []byte) error {
+				switch {
+				case f == 1 && wt == 2:
+					key = string(b)
+				case f == 2 && wt == 1:
+					num = math.Float64frombits(v)
+				case f == 2 && wt == 2:
+					str = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if key == "" {
+				return errors.New("map entry without key")
+			}
+			if field == 2 {
+				rec[key] = num
+			} else {
+				rec[key] = str
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := time.Now()
+	if ts != 0 {
+		t = time.Unix(0, ts)
+	}
+	rec["@timestamp"] = t.UTC().Format(time.RFC3339Nano)
+	return rec, nil
+}
+
+// walkProto calls fn for each field of a protobuf message: varint and
+// fixed values in v, length-delimited ones in b.
+func walkProto(msg []byte, fn func(field, wire int, v uint64, b []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("bad field tag")
+		}
+		msg = msg[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var b []byte
+		switch wire {
+		case 0:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("bad varint")
+			}
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			v, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errors.New("truncated length-delimited field")
+			}
+			b, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			v, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+		if err := fn(field, wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
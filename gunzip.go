@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// gzipReadAhead is GZIP_BLOCKS and GZIP_BLOCK_SIZE, set at startup.
+var gzipReadAhead struct{ blocks, blockSize int }
+
+// newGzipReader returns the decompressed stream of the gzip data in br:
+// compress/gzip with a single block, the read-ahead reader otherwise.
+func newGzipReader(br *bufio.Reader) (io.ReadCloser, error) {
+	if gzipReadAhead.blocks <= 1 {
+		return gzip.NewReader(br)
+	}
+	return newReadAheadGzip(br, gzipReadAhead.blocks, max(gzipReadAhead.blockSize, 4096))
+}
+
+// readAheadGzip decompresses a gzip stream (all its members, like
+// compress/gzip) in a pipeline: one goroutine inflates into blocks, one
+// checks their CRC-32 and the reader hands them out, up to blocks ahead
+// of the parser. It is read-ahead, not parallel decompression: a DEFLATE
+// stream can only be inflated in order (pgzip's reader works the same
+// way), so inflating still runs on one core and a file is never read
+// faster than it inflates. What it saves is the parser, the checksum and
+// inflating waiting for each other, which needs spare cores; with one
+// core it is as fast as compress/gzip (BenchmarkGunzip).
+type readAheadGzip struct {
+	out  chan gzipBlock // 검사까지 끝난 블록, 순서대로
+	free chan []byte
+	done chan struct{}
+	once sync.Once
+
+	// 버퍼는 최대 blocks+2 개: 채우는 중, 대기 중, 읽는 중 (inflate 만 만든다)
+	allocated int
+	limit     int
+	size      int
+
+	cur []byte
+	off int
+	err error
+}
+
+// gzipBlock is inflated data, a member's trailer (end) or an error.
+type gzipBlock struct {
+	buf       []byte
+	end       bool
+	crc, size uint32
+	err       error
+}
+
+func newReadAheadGzip(br *bufio.Reader, blocks, blockSize int) (*readAheadGzip, error) {
+	// 첫 헤더는 바로 읽어서 gzip.NewReader 처럼 여기서 실패한다
+	if err := readGzipHeader(br); err != nil {
+		return nil, err
+	}
+	z := &readAheadGzip{
+		out:   make(chan gzipBlock, blocks),
+		free:  make(chan []byte, blocks+2),
+		done:  make(chan struct{}),
+		limit: blocks + 2,
+		size:  blockSize,
+	}
+	inflated := make(chan gzipBlock, blocks)
+	go z.inflate(br, inflated)
+	go z.check(inflated)
+	return z, nil
+}
+
+// buffer returns a free block, or nil once the reader is closed.
+func (z *readAheadGzip) buffer() []byte {
+	select {
+	case buf := <-z.free:
+		return buf
+	default:
+	}
+	if z.allocated < z.limit {
+		z.allocated++
+		return make([]byte, z.size)
+	}
+	select {
+	case buf := <-z.free:
+		return buf
+	case <-z.done:
+		return nil
+	}
+}
+
+func (z *readAheadGzip) send(ch chan<- gzipBlock, b gzipBlock) bool {
+	select {
+	case ch <- b:
+		return true
+	case <-z.done:
+		return false
+	}
+}
+
+// inflate decompresses member after member into blocks, each member
+// followed by its trailer.
+func (z *readAheadGzip) inflate(br *bufio.Reader, inflated chan<- gzipBlock) {
+	defer close(inflated)
+	// bufio.Reader 는 io.ByteReader 라 flate 가 member 끝을 넘어 읽지 않는다
+	fr := flate.NewReader(br)
+	for {
+		for {
+			buf := z.buffer()
+			if buf == nil {
+				return
+			}
+			n, err := readBlock(fr, buf)
+			if n == 0 {
+				z.release(buf)
+			} else if !z.send(inflated, gzipBlock{buf: buf[:n]}) {
+				return
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				z.send(inflated, gzipBlock{err: err})
+				return
+			}
+		}
+		var trailer [8]byte
+		if _, err := io.ReadFull(br, trailer[:]); err != nil {
+			z.send(inflated, gzipBlock{err: io.ErrUnexpectedEOF})
+			return
+		}
+		if !z.send(inflated, gzipBlock{end: true, crc: binary.LittleEndian.Uint32(trailer[:4]), size: binary.LittleEndian.Uint32(trailer[4:])}) {
+			return
+		}
+		// 다음 member 가 있으면 이어서 (bgzip 이나 이어 붙인 .gz)
+		if _, err := br.Peek(1); err == io.EOF {
+			return
+		}
+		if err := readGzipHeader(br); err != nil {
+			z.send(inflated, gzipBlock{err: err})
+			return
+		}
+		fr.(flate.Resetter).Reset(br, nil)
+	}
+}
+
+// readBlock fills buf from r; err is io.EOF at the end of the stream.
+func readBlock(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// check computes the CRC-32 and size of every member and compares them
+// with its trailer, like compress/gzip does at the end of a member.
+func (z *readAheadGzip) check(inflated <-chan gzipBlock) {
+	defer close(z.out)
+	var crc, size uint32
+	for b := range inflated {
+		if b.end {
+			if b.crc != crc || b.size != size {
+				z.send(z.out, gzipBlock{err: gzip.ErrChecksum})
+				return
+			}
+			crc, size = 0, 0
+			continue
+		}
+		if b.err == nil {
+			crc = crc32.Update(crc, crc32.IEEETable, b.buf)
+			size += uint32(len(b.buf))
+		}
+		if !z.send(z.out, b) {
+			return
+		}
+	}
+}
+
+func (z *readAheadGzip) Read(p []byte) (int, error) {
+	for z.off == len(z.cur) {
+		if z.cur != nil {
+			z.release(z.cur)
+			z.cur, z.off = nil, 0
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+		b, ok := <-z.out
+		if !ok {
+			z.err = io.EOF
+			continue
+		}
+		if b.err != nil {
+			z.err = b.err
+			continue
+		}
+		z.cur = b.buf
+	}
+	n := copy(p, z.cur[z.off:])
+	z.off += n
+	return n, nil
+}
+
+func (z *readAheadGzip) release(buf []byte) {
+	select {
+	case z.free <- buf[:cap(buf)]:
+	default:
+	}
+}
+
+// Close stops the goroutines; it doesn't close the underlying reader.
+func (z *readAheadGzip) Close() error {
+	z.once.Do(func() { close(z.done) })
+	return nil
+}
+
+// readGzipHeader reads a gzip member header (RFC 1952) up to the DEFLATE
+// data.
+func readGzipHeader(br *bufio.Reader) error {
+	var h [10]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	const (
+		flagHCRC    = 1 << 1
+		flagExtra   = 1 << 2
+		flagName    = 1 << 3
+		flagComment = 1 << 4
+	)
+	flags := h[3]
+	if h[0] != 0x1f || h[1] != 0x8b || h[2] != 8 || flags&0xe0 != 0 {
+		return gzip.ErrHeader
+	}
+	if flags&flagExtra != 0 {
+		var n [2]byte
+		if _, err := io.ReadFull(br, n[:]); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if _, err := br.Discard(int(binary.LittleEndian.Uint16(n[:]))); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	for _, flag := range []byte{flagName, flagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		// 버퍼보다 긴 이름도 0 이 나올 때까지 버린다
+		_, err := br.ReadSlice(0)
+		for err == bufio.ErrBufferFull {
+			_, err = br.ReadSlice(0)
+		}
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	if flags&flagHCRC != 0 {
+		if _, err := br.Discard(2); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+func gzipMembers(t testing.TB, members ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, m := range members {
+		zw := gzip.NewWriter(&buf)
+		zw.Name = "member.csv"
+		if _, err := zw.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReadAheadGzip(t *testing.T) {
+	long := string(bytes.Repeat([]byte("2024-01-05 10:30:00,12.5,0.25\n"), 2000))
+	corrupt := gzipMembers(t, "abc")
+	corrupt[len(corrupt)-8] ^= 0xff // CRC
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  error
+	}{
+		{"single", gzipMembers(t, "a,b\n1,2\n"), "a,b\n1,2\n", nil},
+		{"several blocks", gzipMembers(t, long), long, nil},
+		{"members", gzipMembers(t, "a,b\n", "", long), "a,b\n" + long, nil},
+		{"checksum", corrupt, "", gzip.ErrChecksum},
+		{"truncated", gzipMembers(t, long)[:200], "", io.ErrUnexpectedEOF},
+		{"trailing garbage", append(gzipMembers(t, "a"), 'x'), "", io.ErrUnexpectedEOF},
+		{"not gzip", []byte("a,b\n1,2\n3,4\n"), "", gzip.ErrHeader},
+	}
+	for _, tt := range tests {
+		for _, blocks := range []int{1, 4} {
+			gzipReadAhead.blocks, gzipReadAhead.blockSize = blocks, 4096
+			zr, err := newGzipReader(bufio.NewReader(bytes.NewReader(tt.data)))
+			var got []byte
+			if err == nil {
+				got, err = io.ReadAll(zr)
+				zr.Close()
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("%s, %d blocks: err = %v, want %v", tt.name, blocks, err, tt.err)
+				}
+				continue
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("%s, %d blocks: got %d bytes, %v; want %d bytes", tt.name, blocks, len(got), err, len(tt.want))
+			}
+		}
+	}
+	gzipReadAhead.blocks, gzipReadAhead.blockSize = 0, 0
+}
+
+// BenchmarkGunzip compares compress/gzip with the read-ahead reader, on
+// its own (copy) and in front of the parser (parse), where it pays off.
+func BenchmarkGunzip(b *testing.B) {
+	data := benchCSV(b, 20000)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	schema := benchSchema(b)
+	parse, err := lookupParser("twamp", csvOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	consumers := []struct {
+		name    string
+		consume func(b *testing.B, r io.Reader) int
+	}{
+		{"copy", func(b *testing.B, r io.Reader) int {
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				b.Fatal(err)
+			}
+			return 0
+		}},
+		{"parse", func(b *testing.B, r io.Reader) int {
+			reader, err := parse("bench.csv", r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var buf []byte
+			rows := 0
+			for {
+				row, err := reader.Next()
+				if err == io.EOF {
+					return rows
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				rec, err := schema.Convert(reader.Header(), row)
+				if err != nil {
+					b.Fatal(err)
+				}
+				buf, _ = appendRecordJSON(buf[:0], rec)
+				rows++
+			}
+		}},
+	}
+	readers := []struct {
+		name   string
+		blocks int
+	}{
+		{"compress/gzip", 1},
+		{"read-ahead", 4},
+	}
+	for _, c := range consumers {
+		for _, r := range readers {
+			b.Run(c.name+"/"+r.name, func(b *testing.B) {
+				gzipReadAhead.blocks, gzipReadAhead.blockSize = r.blocks, 1<<20
+				defer func() { gzipReadAhead.blocks, gzipReadAhead.blockSize = 0, 0 }()
+				b.SetBytes(int64(len(data)))
+				rows := 0
+				for i := 0; i < b.N; i++ {
+					zr, err := newGzipReader(bufio.NewReader(bytes.NewReader(gz.Bytes())))
+					if err != nil {
+						b.Fatal(err)
+					}
+					rows += c.consume(b, zr)
+					zr.Close()
+				}
+				if rows > 0 {
+					reportRows(b, rows)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+)
+
+// healthChecker backs /healthz (liveness) and /readyz (readiness).
+//
+// Liveness only fails when something is broken for good, e.g. the watcher
+// loop exited or hangs, or an output write has been stuck for
+// OUTPUT_STALL_TIMEOUT; it also drives the systemd watchdog. Readiness
+// also fails on transient conditions: the job queue is full or the last
+// Elasticsearch cluster health check failed.
+type healthChecker struct {
+	mu sync.Mutex
+
+	watching    bool // watch 모드일 때만 watcher 상태를 본다
+	watcher     watcherState
+	watcherBeat time.Time
+	stopping    bool
+	queue       func() (length, capacity int)
+
+	outputs      []*fanOut
+	stallTimeout time.Duration
+
+	esEnabled   bool
+	esCluster   string // primary or, failed over, secondary
+	esStatus    string
+	esErr       error
+	esCheckedAt time.Time
+}
+
+type watcherState int
+
+const (
+	watcherStarting watcherState = iota
+	watcherRunning
+	watcherStopped
+)
+
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{}
+}
+
+func (h *healthChecker) setWatching(queue func() (int, int)) {
+	h.mu.Lock()
+	h.watching, h.queue = true, queue
+	h.mu.Unlock()
+}
+
+// setStopping makes /readyz fail while the process drains on shutdown.
+func (h *healthChecker) setStopping() {
+	h.mu.Lock()
+	h.stopping = true
+	h.mu.Unlock()
+}
+
+func (h *healthChecker) setWatcher(s watcherState) {
+	h.mu.Lock()
+	h.watcher, h.watcherBeat = s, time.Now()
+	h.mu.Unlock()
+}
+
+// watcherHeartbeat is how often the watcher loop checks in; it counts as
+// hung after watcherHangAfter without one.
+const (
+	watcherHeartbeat = 10 * time.Second
+	watcherHangAfter = 6 * watcherHeartbeat
+)
+
+// beat is the watcher loop checking in.
+func (h *healthChecker) beat() {
+	h.mu.Lock()
+	h.watcherBeat = time.Now()
+	h.mu.Unlock()
+}
+
+// setOutputs has liveness fail when a write to one of the outputs takes
+// longer than timeout (0: off).
+func (h *healthChecker) setOutputs(timeout time.Duration, outputs ...*fanOut) {
+	h.mu.Lock()
+	h.outputs, h.stallTimeout = outputs, timeout
+	h.mu.Unlock()
+}
+
+// pollElasticsearch checks the health of the cluster active returns (the
+// one indexed into) every interval until ctx is done. A red cluster or an
+// unreachable one makes the process not ready.
+func (h *healthChecker) pollElasticsearch(ctx context.Context, active func() (*elasticsearch.Client, string), interval time.Duration) {
+	h.mu.Lock()
+	h.esEnabled = true
+	h.mu.Unlock()
+	for {
+		es, cluster := active()
+		status, err := clusterHealth(ctx, es, interval)
+		h.mu.Lock()
+		h.esCluster, h.esStatus, h.esErr, h.esCheckedAt = cluster, status, err, time.Now()
+		h.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func clusterHealth(ctx context.Context, es *elasticsearch.Client, timeout time.Duration) (string, error) {
+	if es == nil {
+		return "", fmt.Errorf("no elasticsearch client")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res, err := es.Cluster.Health(es.Cluster.Health.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("cluster health: %s", res.Status())
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("error parsing cluster health: %w", err)
+	}
+	return body.Status, nil
+}
+
+func (h *healthChecker) liveness() map[string]healthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	checks := map[string]healthCheck{}
+	if h.watching {
+		switch since := time.Since(h.watcherBeat); {
+		case h.watcher == watcherStopped:
+			checks["watcher"] = healthCheck{Detail: "watcher loop exited"}
+		case h.watcher == watcherRunning && since > watcherHangAfter:
+			checks["watcher"] = healthCheck{Detail: fmt.Sprintf("watcher loop not responding for %s", since.Truncate(time.Second))}
+		default:
+			checks["watcher"] = healthCheck{OK: true}
+		}
+	}
+	if h.stallTimeout > 0 {
+		for _, f := range h.outputs {
+			for name, d := range f.writing() {
+				if d > h.stallTimeout {
+					checks["output "+name] = healthCheck{Detail: fmt.Sprintf("write running for %s", d.Truncate(time.Second))}
+				} else if _, ok := checks["output "+name]; !ok {
+					checks["output "+name] = healthCheck{OK: true}
+				}
+			}
+		}
+	}
+	return checks
+}
+
+func (h *healthChecker) readiness() map[string]healthCheck {
+	checks := h.liveness()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopping {
+		checks["shutdown"] = healthCheck{Detail: "shutting down"}
+	}
+	if h.watching {
+		if h.watcher == watcherStarting {
+			checks["watcher"] = healthCheck{Detail: "processing backlog"}
+		}
+		if h.queue != nil {
+			n, c := h.queue()
+			checks["queue"] = healthCheck{OK: c == 0 || n < c, Detail: fmt.Sprintf("%d/%d queued", n, c)}
+		}
+	}
+	if h.esEnabled {
+		switch {
+		case h.esCheckedAt.IsZero():
+			checks["elasticsearch"] = healthCheck{Detail: "not checked yet"}
+		case h.esErr != nil:
+			checks["elasticsearch"] = healthCheck{Detail: h.esErr.Error()}
+		case h.esCluster == clusterSecondary:
+			checks["elasticsearch"] = healthCheck{OK: h.esStatus != "red", Detail: "failed over, secondary cluster " + h.esStatus}
+		default:
+			checks["elasticsearch"] = healthCheck{OK: h.esStatus != "red", Detail: "cluster " + h.esStatus}
+		}
+	}
+	return checks
+}
+
+func (h *healthChecker) livezHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, h.liveness())
+}
+
+func (h *healthChecker) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, h.readiness())
+}
+
+func writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(checks)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+)
+
+// startHTTPServer serves /metrics, /healthz, /readyz and /status on addr,
+// and the admin API when enabled. An empty addr disables the listener.
+func startHTTPServer(addr string, health *healthChecker, admin *adminAPI) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", health.livezHandler)
+	mux.HandleFunc("/readyz", health.readyzHandler)
+	mux.HandleFunc("/status", progress.statusHandler)
+	admin.register(mux)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logHTTP.Error("error serving HTTP", "addr", addr, "err", err)
+		}
+	}()
+	return srv
+}
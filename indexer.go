@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/esapi"
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+)
+
+type bulkItem struct {
+	meta []byte
+	doc  []byte
+}
+
+// BulkIndexer is the Elasticsearch output. Each Write is split into bulk
+// requests of at most flushBytes, sent by up to cap(workers) goroutines
+// shared by all writers, so a slow cluster blocks Write (and through the
+// output queue the file workers) instead of piling up memory.
+type BulkIndexer struct {
+	name  string // output name, "elasticsearch" when empty
+	es    *elasticsearch.Client
+	retry retryPolicy
+	dlq   *DeadLetterQueue
+	index *indexTemplate
+	// pipelines 의 index 설정, 레코드의 pipeline 필드로 고른다
+	pipelineIndex map[string]*indexTemplate
+
+	dataStream bool
+	duplicates string
+	idField    string            // set: the field holding the _id, for state documents updated in place
+	idTemplate *recordTemplate   // ES_ID_TEMPLATE, nil: the default _id
+	routing    *recordTemplate   // ES_ROUTING_TEMPLATE
+	pipeline   string            // ES_PIPELINE, the ingest pipeline
+	refresh    string            // ES_REFRESH
+	spool      *diskSpool        // SPOOL_DIR, nil if disabled
+	failover   *esFailover       // ES_SECONDARY_SERVER, nil if not set
+	skip       func(Record) bool // records another indexer takes (tenants)
+	flushBytes int
+	timeout    time.Duration // BULK_TIMEOUT per bulk request, 0: none
+	workers    chan struct{}
+	limit      *bulkLimiter // shared by the indexers of one cluster
+	batch      *batchTuner  // BULK_ADAPTIVE, nil if disabled
+
+	// onFailure is called for every document that can't be indexed;
+	// the default writes it to the dead-letter queue.
+	onFailure func(item bulkItem, status int, errType, reason string)
+
+	// dryRun, when set, gets the bulk payloads instead of Elasticsearch.
+	dryRun *dryRunWriter
+
+	stats     bulkStats
+	itemBytes atomic.Int64 // 지난 Write 의 action+문서 평균 크기, 버퍼 크기 추정용
+}
+
+// bulkStats counts documents and requests over the indexer's lifetime.
+type bulkStats struct {
+	added, indexed, failed, duplicates atomic.Int64
+	requests, retries, errs            atomic.Int64
+}
+
+func (s *bulkStats) String() string {
+	return fmt.Sprintf("added=%d indexed=%d duplicates=%d failed=%d requests=%d retries=%d request_errors=%d",
+		s.added.Load(), s.indexed.Load(), s.duplicates.Load(), s.failed.Load(), s.requests.Load(), s.retries.Load(), s.errs.Load())
+}
+
+func newBulkIndexer(es *elasticsearch.Client, dlq *DeadLetterQueue, config Config) (*BulkIndexer, error) {
+	index, err := parseIndexTemplate(config.dataIndex())
+	if err != nil {
+		return nil, err
+	}
+	if config.ESDataStream && index.HasDate() {
+		return nil, fmt.Errorf("ES_INDEX %q: date placeholders can't be used with data streams, rollover is handled by ILM", config.ESIndex)
+	}
+	b := &BulkIndexer{
+		es:         es,
+		dlq:        dlq,
+		retry:      config.retryPolicy(),
+		index:      index,
+		dataStream: config.ESDataStream,
+		duplicates: config.ESDuplicates,
+		refresh:    config.ESRefresh,
+		flushBytes: config.BulkFlushBytes,
+		timeout:    config.BulkTimeout,
+		workers:    make(chan struct{}, config.BulkWorkers),
+		batch:      newBatchTuner(config),
+	}
+	b.onFailure = b.deadLetter
+	return b, nil
+}
+
+// Stats returns a snapshot of the indexer's counters.
+func (b *BulkIndexer) Stats() string { return b.stats.String() }
+
+func (b *BulkIndexer) Name() string {
+	if b.name != "" {
+		return b.name
+	}
+	return "elasticsearch"
+}
+
+func (b *BulkIndexer) tuner() *batchTuner { return b.batch }
+
+// recyclesRecords: Write encodes the records into the bulk body, the
+// spool and dead letters get bytes.
+func (b *BulkIndexer) recyclesRecords() {}
+
+func (b *BulkIndexer) Write(ctx context.Context, records []Record) error {
+	items := make([]bulkItem, 0, len(records))
+	// 모든 action/문서 줄을 지난 Write 의 평균 크기로 미리 잡은 버퍼 하나에 쓴다
+	var body bytes.Buffer
+	body.Grow(int(b.itemBytes.Load())*len(records) + 4096)
+	type span struct{ meta, doc, end int }
+	spans := make([]span, 0, len(records))
+	for _, rec := range records {
+		if b.skip != nil && b.skip(rec) {
+			continue
+		}
+		// data stream 은 @timestamp 가 없으면 거부하므로 수집 시각으로 채운다.
+		// rec 는 다른 output 과 공유되므로 복사본에 넣는다.
+		if _, ok := rec["@timestamp"]; !ok && b.dataStream {
+			withTS := make(Record, len(rec)+1)
+			for k, v := range rec {
+				withTS[k] = v
+			}
+			withTS["@timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+			rec = withTS
+		}
+		// Elasticsearch 메타데이터
+		index := b.indexFor(rec).Resolve(rec)
+		if logDocuments {
+			logES.Debug("document", "index", index, "doc", rec)
+		}
+		start := body.Len()
+		op := "create"
+		id := b.documentID(rec)
+		if id != "" && b.duplicates == "overwrite" {
+			op = "index"
+		}
+		body.WriteString(`{ "`)
+		body.WriteString(op)
+		body.WriteString(`" : { "_index" : `)
+		body.Write(strconv.AppendQuote(body.AvailableBuffer(), index))
+		if id != "" {
+			body.WriteString(`, "_id" : `)
+			body.WriteString(jsonString(id))
+		}
+		// routing 과 pipeline 은 action 마다 넣어 spool/reconcile 로 다시 보낼 때도 유지된다
+		if b.routing != nil {
+			if r := b.routing.Resolve(rec); r != "" {
+				body.WriteString(`, "routing" : `)
+				body.WriteString(jsonString(r))
+			}
+		}
+		if b.pipeline != "" {
+			body.WriteString(`, "pipeline" : `)
+			body.WriteString(jsonString(b.pipeline))
+		}
+		body.WriteString(" } }\n")
+		doc := body.Len()
+		data, err := appendRecordJSON(body.AvailableBuffer(), rec)
+		if err != nil {
+			// 이 레코드만 dead-letter 로 보내고 나머지는 계속 보낸다
+			meta := append([]byte(nil), body.Bytes()[start:doc]...)
+			body.Truncate(start)
+			logES.Warn("record can't be encoded, dead-lettering it", "index", index, "err", err)
+			b.stats.added.Add(1)
+			b.fail(bulkItem{meta: meta, doc: appendRecordJSONLossy(nil, rec)}, 0, "encode_error", err.Error())
+			continue
+		}
+		body.Write(append(data, '\n'))
+		spans = append(spans, span{start, doc, body.Len()})
+	}
+	// 버퍼가 중간에 커졌을 수 있어 다 쓴 뒤에 잘라낸다; 재시도/spool 로 남는 item 은 버퍼 전체를 붙잡는다
+	data := body.Bytes()
+	for _, s := range spans {
+		items = append(items, bulkItem{meta: data[s.meta:s.doc:s.doc], doc: data[s.doc:s.end:s.end]})
+	}
+	if len(items) > 0 {
+		b.itemBytes.Store(int64(len(data) / len(items)))
+	}
+	b.stats.added.Add(int64(len(items)))
+	return b.writeItems(ctx, items)
+}
+
+// jsonString quotes s for the bulk metadata lines; _id and routing from
+// ES_ID_TEMPLATE/ES_ROUTING_TEMPLATE can hold anything.
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// indexFor returns the index template of the record's pipeline, or
+// ES_INDEX.
+func (b *BulkIndexer) indexFor(rec Record) *indexTemplate {
+	if len(b.pipelineIndex) > 0 {
+		if name, ok := recordField(rec, pipelineField); ok {
+			if t, ok := b.pipelineIndex[fmt.Sprint(name)]; ok {
+				return t
+			}
+		}
+	}
+	return b.index
+}
+
+// documentID hashes session_id, @timestamp and stat_round into the _id,
+// so a re-delivered file maps onto the documents it already produced.
+// Records without a session or timestamp get none (ES_DUPLICATES=allow
+// never sets one). With idField the id is that field's value, with
+// idTemplate what ES_ID_TEMPLATE makes of the record.
+func (b *BulkIndexer) documentID(rec Record) string {
+	if b.idField != "" {
+		if id, ok := recordField(rec, b.idField); ok {
+			return fmt.Sprint(id)
+		}
+		return ""
+	}
+	if b.duplicates == "allow" {
+		return ""
+	}
+	if b.idTemplate != nil {
+		return b.idTemplate.Resolve(rec)
+	}
+	session, ok1 := recordField(rec, "session_id")
+	ts, ok2 := recordField(rec, "@timestamp")
+	if !ok1 || !ok2 {
+		return ""
+	}
+	round, _ := recordField(rec, "stat_round")
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v|%v|%v", session, ts, round)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// writeItems sends items in chunks of at most flushBytes and waits for
+// all of them; the first error is returned.
+func (b *BulkIndexer) writeItems(ctx context.Context, items []bulkItem) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	flush := func(chunk []bulkItem) {
+		b.workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-b.workers
+				wg.Done()
+			}()
+			if err := b.bulkInsert(ctx, chunk); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	start, size := 0, 0
+	for i, item := range items {
+		size += len(item.meta) + len(item.doc)
+		if size >= b.flushBytes || i == len(items)-1 {
+			flush(items[start : i+1])
+			start, size = i+1, 0
+		}
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (b *BulkIndexer) Close() error {
+	logES.Info("bulk indexer stats", "index", b.index.Pattern(), "stats", b.Stats())
+	return nil
+}
+
+// bulkInsert sends items and re-sends the ones that failed with a retryable
+// status until the retry policy gives up. Documents that can't be indexed
+// are written to the dead-letter queue.
+func (b *BulkIndexer) bulkInsert(ctx context.Context, items []bulkItem) error {
+	if b.dryRun != nil {
+		b.stats.requests.Add(1)
+		if err := b.dryRun.write(items); err != nil {
+			b.stats.errs.Add(1)
+			return fmt.Errorf("error writing dry-run output: %w", err)
+		}
+		b.stats.indexed.Add(int64(len(items)))
+		return nil
+	}
+	// 장애 중에는 먼저 쌓인 요청들 뒤에 줄을 세운다
+	if b.spool != nil && b.spool.active() && b.spoolItems(items) {
+		return nil
+	}
+	// failback 후에는 secondary 에 들어간 gap 뒤에 줄을 세운다
+	if b.failover != nil && b.failover.queue(items) {
+		return nil
+	}
+	return b.insert(ctx, items, false)
+}
+
+// insert is bulkInsert without the spool in front. When the cluster can't
+// be reached, fresh items are spooled once the retries are used up;
+// spooled ones (drainSpool) return the retryableError right away.
+func (b *BulkIndexer) insert(ctx context.Context, items []bulkItem, spooled bool) error {
+	pending := items
+	dead, reason := 0, ""
+	defer func() {
+		if dead > 0 {
+			notify(notification{
+				Event:    eventDeadLetter,
+				Severity: "warning",
+				Title:    fmt.Sprintf("%d documents dead-lettered", dead),
+				Text:     fmt.Sprintf("%d documents could not be indexed and were written to the dead-letter queue: %s", dead, reason),
+			})
+		}
+	}()
+	for attempt := 1; ; attempt++ {
+		if err := b.limit.wait(ctx, b.Name(), len(pending)); err != nil {
+			// 종료로 취소: 보내지 못한 문서도 spool 이나 dead-letter 에 남긴다
+			return b.cancelled(pending, spooled, err, &dead, &reason)
+		}
+		es, cluster := b.es, clusterPrimary
+		if b.failover != nil {
+			es, cluster = b.failover.client()
+		}
+		start := time.Now()
+		retry, rejected, err := b.send(ctx, pending, es)
+		metricBulkLatency.Observe(time.Since(start).Seconds(), b.Name())
+		if b.failover != nil {
+			b.failover.observe(cluster, err)
+			if err == nil {
+				b.failover.indexed(cluster, indexedItems(pending, retry, rejected))
+			}
+		}
+		_, failed := err.(retryableError)
+		b.batch.observe(b.Name(), time.Since(start), failed || len(retry) > 0)
+		b.stats.requests.Add(1)
+		trace := spanFromContext(ctx)
+		trace.add("es.bulk.requests", 1)
+		if err != nil {
+			metricBulkFailures.Inc(b.Name())
+			b.stats.errs.Add(1)
+			trace.add("es.bulk.errors", 1)
+		} else {
+			indexed := len(pending) - len(retry) - len(rejected)
+			metricDocumentsIndexed.Add(float64(indexed), b.Name())
+			b.stats.indexed.Add(int64(indexed))
+			trace.add("es.bulk.indexed", indexed)
+			trace.add("es.bulk.retried", len(retry))
+			trace.add("es.bulk.rejected", len(rejected))
+		}
+		for _, r := range rejected {
+			// 같은 _id 로 create: 이미 색인된 문서라 건너뛴다
+			if r.status == http.StatusConflict && b.duplicates == "skip" {
+				metricDocumentsDuplicate.Inc(b.Name())
+				b.stats.duplicates.Add(1)
+				continue
+			}
+			b.fail(r.item, r.status, r.errType, r.reason)
+			dead, reason = dead+1, r.errType+": "+r.reason
+		}
+		if err == nil && len(retry) == 0 {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(retryableError); !ok {
+				b.deadLetterAll(pending, 0, "", err.Error())
+				dead, reason = dead+len(pending), err.Error()
+				return err
+			}
+		} else {
+			pending = retry
+		}
+		if err != nil && b.spool != nil {
+			if spooled {
+				return err
+			}
+			if attempt >= b.retry.MaxAttempts && b.spoolItems(pending) {
+				return nil
+			}
+		}
+		if attempt >= b.retry.MaxAttempts {
+			if err != nil {
+				err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			} else {
+				err = fmt.Errorf("giving up after %d attempts: %d documents not indexed", attempt, len(pending))
+			}
+			b.deadLetterAll(pending, 0, "", err.Error())
+			dead, reason = dead+len(pending), err.Error()
+			return err
+		}
+		wait := b.retry.backoff(attempt)
+		metricBulkRetries.Inc(b.Name())
+		b.stats.retries.Add(1)
+		if err != nil {
+			logES.Warn("bulk request failed, retrying", "attempt", attempt, "max_attempts", b.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		} else {
+			logES.Warn("documents rejected, retrying", "documents", len(pending), "attempt", attempt, "max_attempts", b.retry.MaxAttempts, "wait", wait.String())
+		}
+		select {
+		case <-ctx.Done():
+			return b.cancelled(pending, spooled, ctx.Err(), &dead, &reason)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// cancelled handles the items insert couldn't send before ctx ended:
+// spooled ones stay in their segment (a retryableError keeps it), others
+// are dead-lettered.
+func (b *BulkIndexer) cancelled(pending []bulkItem, spooled bool, err error, dead *int, reason *string) error {
+	if spooled {
+		return retryableError{err}
+	}
+	b.deadLetterAll(pending, 0, "", err.Error())
+	*dead, *reason = *dead+len(pending), err.Error()
+	return err
+}
+
+// send is sendBulk with BULK_TIMEOUT: a request the cluster doesn't answer
+// in time is cancelled and comes back as a retryableError, so a hung
+// connection costs an attempt instead of blocking the output.
+func (b *BulkIndexer) send(ctx context.Context, items []bulkItem, es *elasticsearch.Client) ([]bulkItem, []rejectedItem, error) {
+	if b.timeout <= 0 {
+		return sendBulk(ctx, b.Name(), items, es, b.refresh)
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	retry, rejected, err := sendBulk(reqCtx, b.Name(), items, es, b.refresh)
+	if err != nil && ctx.Err() == nil && errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+		err = retryableError{fmt.Errorf("no bulk response within BULK_TIMEOUT (%s): %w", b.timeout, context.DeadlineExceeded)}
+	}
+	return retry, rejected, err
+}
+
+func (b *BulkIndexer) fail(item bulkItem, status int, errType, reason string) {
+	b.stats.failed.Add(1)
+	b.onFailure(item, status, errType, reason)
+}
+
+func (b *BulkIndexer) deadLetter(item bulkItem, status int, errType, reason string) {
+	if err := b.dlq.Write(item, status, errType, reason); err != nil {
+		logES.Error("error writing dead letter", "err", err)
+	}
+}
+
+func (b *BulkIndexer) deadLetterAll(items []bulkItem, status int, errType, reason string) {
+	for _, item := range items {
+		b.fail(item, status, errType, reason)
+	}
+}
+
+type retryableError struct{ error }
+
+func (e retryableError) Unwrap() error { return e.error }
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+type rejectedItem struct {
+	item    bulkItem
+	status  int
+	errType string
+	reason  string
+}
+
+// sendBulk performs a single bulk request. It returns the items that were
+// rejected with a retryable status and those rejected for good; a
+// retryableError means the whole request should be sent again. A 200
+// response can still carry failed items: they are counted by error type
+// and summed up in one warning per request.
+func sendBulk(ctx context.Context, name string, items []bulkItem, es *elasticsearch.Client, refresh string) ([]bulkItem, []rejectedItem, error) {
+	buf := bulkBody(items)
+	defer putBulkBuffer(buf)
+
+	req := esapi.BulkRequest{Body: bytes.NewReader(buf.Bytes())}
+	// false 는 기본값이라 보내지 않는다
+	if refresh != "false" {
+		req.Refresh = refresh
+	}
+
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return nil, nil, retryableError{fmt.Errorf("failure indexing batch: %w", err)}
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		var resBody map[string]interface{}
+		if decodeErr := json.NewDecoder(res.Body).Decode(&resBody); decodeErr != nil {
+			err = fmt.Errorf("error parsing the response body: %w", decodeErr)
+		} else {
+			err = fmt.Errorf("error indexing batch: [%d] %s", res.StatusCode, resBody)
+		}
+		if retryableStatus(res.StatusCode) {
+			return nil, nil, retryableError{err}
+		}
+		return nil, nil, err
+	}
+
+	var resBody bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&resBody); err != nil {
+		return nil, nil, fmt.Errorf("error parsing the response body: %w", err)
+	}
+
+	var retry []bulkItem
+	var rejected []rejectedItem
+	failures := map[string]int{} // error type -> items
+	var example string
+	if resBody.Errors {
+		for i, result := range resBody.Items {
+			if i >= len(items) {
+				break
+			}
+			for _, r := range result {
+				if r.Status < 300 {
+					continue
+				}
+				errType, reason := fmt.Sprintf("status_%d", r.Status), ""
+				if r.Error != nil {
+					errType, reason = r.Error.Type, r.Error.Reason
+				}
+				// 409 는 duplicates 정책에 따라 insert 에서 따로 센다
+				if r.Status != http.StatusConflict {
+					failures[errType]++
+					if example == "" {
+						example = errType + ": " + reason
+					}
+					logES.Debug("document rejected", "status", r.Status, "type", errType, "reason", reason)
+				}
+				if retryableStatus(r.Status) {
+					retry = append(retry, items[i])
+					continue
+				}
+				rejected = append(rejected, rejectedItem{item: items[i], status: r.Status, errType: errType, reason: reason})
+			}
+		}
+	}
+	indexed := len(items) - len(retry) - len(rejected)
+	if len(failures) > 0 {
+		types := make([]string, 0, len(failures))
+		for t, n := range failures {
+			metricBulkItemFailures.Add(float64(n), name, t)
+			spanFromContext(ctx).add("es.bulk.failures."+t, n)
+			types = append(types, fmt.Sprintf("%s=%d", t, n))
+		}
+		sort.Strings(types)
+		logES.Warn("bulk request partially failed", "indexed", indexed, "retry", len(retry), "rejected", len(rejected), "errors", strings.Join(types, ","), "example", example)
+	}
+	logES.Debug("bulk request done", "indexed", indexed, "retry", len(retry), "rejected", len(rejected))
+	return retry, rejected, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestInsertCancelledWhileThrottled(t *testing.T) {
+	tests := []struct {
+		name    string
+		spooled bool
+		dead    int
+	}{
+		{"fresh items are dead-lettered", false, 2},
+		{"spooled items stay in the spool", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 빈 bucket: 다음 요청은 1초를 기다린다
+			limit := &bulkLimiter{requests: newTokenBucket(1)}
+			limit.requests.tokens = 0
+			b := &BulkIndexer{limit: limit}
+			dead := 0
+			b.onFailure = func(bulkItem, int, string, string) { dead++ }
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := b.insert(ctx, benchItems(2), tt.spooled)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("err = %v, want context.Canceled", err)
+			}
+			if _, retryable := err.(retryableError); retryable != tt.spooled {
+				t.Errorf("retryable = %v, want %v", retryable, tt.spooled)
+			}
+			if dead != tt.dead {
+				t.Errorf("%d items dead-lettered, want %d", dead, tt.dead)
+			}
+		})
+	}
+}
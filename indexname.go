@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// indexTemplate resolves an index name per record. Besides literal text it
+// understands two Logstash-style placeholders:
+//
+//	%{+yyyy.MM.dd}  @timestamp (UTC) in Joda-style date format
+//	%{field}        value of a record field, e.g. %{system_id}
+//
+// so ES_INDEX="twamp-data-%{system_id}-%{+yyyy.MM.dd}" gives one index per
+// device per day.
+type indexTemplate struct {
+	parts []indexPart
+}
+
+type indexPart struct {
+	literal string
+	field   string
+	layout  string // Go time layout for %{+...}
+}
+
+func parseIndexTemplate(s string) (*indexTemplate, error) {
+	t := &indexTemplate{}
+	for s != "" {
+		i := strings.Index(s, "%{")
+		if i < 0 {
+			t.parts = append(t.parts, indexPart{literal: s})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, indexPart{literal: s[:i]})
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated %%{ in index template %q", s)
+		}
+		name := s[i+2 : i+end]
+		s = s[i+end+1:]
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("empty %%{} in index template")
+		case name[0] == '+':
+			layout, err := jodaLayout(name[1:])
+			if err != nil {
+				return nil, err
+			}
+			t.parts = append(t.parts, indexPart{layout: layout})
+		default:
+			t.parts = append(t.parts, indexPart{field: name})
+		}
+	}
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("index template is empty")
+	}
+	return t, nil
+}
+
+// HasDate reports whether the template contains a %{+...} placeholder.
+func (t *indexTemplate) HasDate() bool {
+	for _, p := range t.parts {
+		if p.layout != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Pattern returns an index pattern matching every index the template can
+// produce, e.g. "twamp-data-*" for "twamp-data-%{+yyyy.MM.dd}".
+func (t *indexTemplate) Pattern() string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.literal != "" {
+			b.WriteString(p.literal)
+		} else if !strings.HasSuffix(b.String(), "*") {
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
+
+func (t *indexTemplate) Resolve(rec Record) string {
+	var b strings.Builder
+	var ts time.Time
+	for _, p := range t.parts {
+		switch {
+		case p.layout != "":
+			if ts.IsZero() {
+				ts = recordTime(rec)
+			}
+			b.WriteString(ts.Format(p.layout))
+		case p.field != "":
+			v, ok := recordField(rec, p.field)
+			if !ok || v == nil || fmt.Sprint(v) == "" {
+				b.WriteString("unknown")
+				continue
+			}
+			b.WriteString(sanitizeIndexPart(fmt.Sprint(v)))
+		default:
+			b.WriteString(p.literal)
+		}
+	}
+	return b.String()
+}
+
+// recordTime returns the record's @timestamp, or now if it has none.
+func recordTime(rec Record) time.Time {
+	if s, ok := rec["@timestamp"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
+// sanitizeIndexPart lowercases v and replaces characters Elasticsearch
+// doesn't allow in index names.
+func sanitizeIndexPart(v string) string {
+	v = strings.ToLower(v)
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', '*', '?', '"', '<', '>', '|', ' ', ',', '#', ':':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+var jodaTokens = []struct{ joda, layout string }{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MM", "01"},
+	{"dd", "02"},
+	{"HH", "15"},
+	{"mm", "04"},
+	{"ss", "05"},
+}
+
+// jodaLayout converts the subset of Joda date patterns used in index names
+// (yyyy, yy, MM, dd, HH, mm, ss and separators) to a Go layout.
+func jodaLayout(pattern string) (string, error) {
+	var b strings.Builder
+next:
+	for pattern != "" {
+		for _, tok := range jodaTokens {
+			if strings.HasPrefix(pattern, tok.joda) {
+				b.WriteString(tok.layout)
+				pattern = pattern[len(tok.joda):]
+				continue next
+			}
+		}
+		c := pattern[0]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			return "", fmt.Errorf("unsupported date pattern %q in index template", pattern)
+		}
+		b.WriteByte(c)
+		pattern = pattern[1:]
+	}
+	return b.String(), nil
+}
+
+// recordTemplate is ES_ID_TEMPLATE / ES_ROUTING_TEMPLATE: a Go
+// text/template over the record's fields, e.g.
+//
+//	{{.session_id}}-{{index . "@timestamp"}}
+//	{{hash .source_ip .destination_ip}}
+//	{{lower .system_id}}
+//
+// hash is the hex SHA-256 (first 16 bytes) of its arguments joined by |,
+// like the default _id. A record missing a field gets no value.
+type recordTemplate struct {
+	tmpl *template.Template
+}
+
+var recordTemplateFuncs = template.FuncMap{
+	"hash": func(args ...interface{}) string {
+		parts := make([]string, len(args))
+		for i, a := range args {
+			parts[i] = fmt.Sprint(a)
+		}
+		sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+		return hex.EncodeToString(sum[:16])
+	},
+	"lower": func(v interface{}) string { return strings.ToLower(fmt.Sprint(v)) },
+	"upper": func(v interface{}) string { return strings.ToUpper(fmt.Sprint(v)) },
+}
+
+// parseRecordTemplate returns nil for an empty s.
+func parseRecordTemplate(name, s string) (*recordTemplate, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Funcs(recordTemplateFuncs).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &recordTemplate{tmpl: t}, nil
+}
+
+// Resolve returns the value for rec, "" if a field is missing or the
+// result is empty.
+func (t *recordTemplate) Resolve(rec Record) string {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, map[string]interface{}(rec)); err != nil {
+		return ""
+	}
+	s := strings.TrimSpace(b.String())
+	if strings.Contains(s, "<no value>") {
+		return ""
+	}
+	return s
+}
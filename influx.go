@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxOutput writes records as InfluxDB line protocol (OUTPUT=influxdb):
+// one point per record in INFLUX_MEASUREMENT, INFLUX_TAGS as tags and the
+// fields matching INFLUX_FIELDS (the KPIs by default), timestamped with
+// @timestamp. INFLUX_BUCKET writes to the v2 API (/api/v2/write),
+// INFLUX_DATABASE to the v1 one (/write, also VictoriaMetrics and
+// Telegraf's http_listener).
+type InfluxOutput struct {
+	endpoint    string
+	token       string
+	measurement string
+	tags        []string
+	fields      []string
+	client      *http.Client
+	retry       retryPolicy
+}
+
+func newInfluxOutput(config Config) (*InfluxOutput, error) {
+	if config.InfluxURL == "" || (config.InfluxBucket == "") == (config.InfluxDatabase == "") {
+		return nil, errors.New("INFLUX_URL and one of INFLUX_BUCKET or INFLUX_DATABASE are required for the influxdb output")
+	}
+	u, err := url.Parse(strings.TrimSuffix(config.InfluxURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INFLUX_URL: %w", err)
+	}
+	q := url.Values{"precision": {"ns"}}
+	if config.InfluxBucket != "" {
+		u.Path += "/api/v2/write"
+		q.Set("bucket", config.InfluxBucket)
+		q.Set("org", config.InfluxOrg)
+	} else {
+		u.Path += "/write"
+		q.Set("db", config.InfluxDatabase)
+	}
+	u.RawQuery = q.Encode()
+	return &InfluxOutput{
+		endpoint:    u.String(),
+		token:       config.InfluxToken,
+		measurement: config.InfluxMeasurement,
+		tags:        splitList(config.InfluxTags),
+		fields:      splitList(config.InfluxFields),
+		client:      &http.Client{Timeout: 30 * time.Second},
+		retry:       config.retryPolicy(),
+	}, nil
+}
+
+func (o *InfluxOutput) Name() string { return "influxdb" }
+
+func (o *InfluxOutput) Close() error { return nil }
+
+func (o *InfluxOutput) Write(ctx context.Context, records []Record) error {
+	var body bytes.Buffer
+	points := 0
+	for _, rec := range records {
+		if o.appendPoint(&body, rec) {
+			points++
+		}
+	}
+	if points == 0 {
+		return nil
+	}
+	payload := body.Bytes()
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := o.post(ctx, payload)
+		metricBulkLatency.Observe(time.Since(start).Seconds(), o.Name())
+		if err == nil {
+			metricDocumentsIndexed.Add(float64(points), o.Name())
+			logOutput.Debug("points written", "output", o.Name(), "points", points)
+			return nil
+		}
+		metricBulkFailures.Inc(o.Name())
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= o.retry.MaxAttempts {
+			return fmt.Errorf("giving up on influxdb write after %d attempts: %w", attempt, err)
+		}
+		wait := o.retry.backoff(attempt)
+		metricBulkRetries.Inc(o.Name())
+		logOutput.Warn("influxdb write failed, retrying", "attempt", attempt, "max_attempts", o.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (o *InfluxOutput) post(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Token "+o.token)
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	err = fmt.Errorf("influxdb write: %s: %s", res.Status, bytes.TrimSpace(msg))
+	if retryableStatus(res.StatusCode) || res.StatusCode == http.StatusInternalServerError {
+		return retryableError{err}
+	}
+	return err
+}
+
+// appendPoint writes rec as one line; records without a matching field or
+// a timestamp are skipped.
+func (o *InfluxOutput) appendPoint(buf *bytes.Buffer, rec Record) bool {
+	ts, _ := recordField(rec, "@timestamp")
+	t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(ts))
+	if err != nil {
+		return false
+	}
+	flat := map[string]interface{}{}
+	flattenRecord(flat, "", rec)
+	var fields []string
+	for k, v := range flat {
+		// ECS 문서는 측정값이 twamp.* 아래에 있다
+		k = strings.TrimPrefix(k, "twamp.")
+		if !matchesField(o.fields, k) {
+			continue
+		}
+		if f, ok := lineFieldValue(v); ok {
+			fields = append(fields, lineEscape(k, ",= ")+"="+f)
+		}
+	}
+	if len(fields) == 0 {
+		return false
+	}
+	sort.Strings(fields)
+
+	buf.WriteString(lineEscape(o.measurement, ", "))
+	for _, tag := range o.tags {
+		v, ok := recordField(rec, tag)
+		if !ok {
+			continue
+		}
+		s := fmt.Sprint(v)
+		if s == "" {
+			continue
+		}
+		buf.WriteString("," + lineEscape(tag, ",= ") + "=" + lineEscape(s, ",= "))
+	}
+	buf.WriteString(" " + strings.Join(fields, ",") + " " + strconv.FormatInt(t.UnixNano(), 10) + "\n")
+	return true
+}
+
+func flattenRecord(out map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenRecord(out, prefix+k+".", nested)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+func lineFieldValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	case int:
+		return strconv.Itoa(v) + "i", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`, true
+	}
+	return "", false
+}
+
+// lineEscape backslash-escapes chars in a line protocol name or tag value.
+func lineEscape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
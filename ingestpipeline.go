@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// defaultIngestPipeline is the name `twamp provision-ingest-pipeline`
+// installs under when ES_PIPELINE is empty.
+const defaultIngestPipeline = "twamp"
+
+// ingestPipeline is the recommended ingest pipeline for the raw
+// documents, for clusters that transform on their side:
+//
+//   - date re-parses @timestamp (ISO 8601, or epoch ms/s from other
+//     shippers) so it is always a proper date
+//   - fingerprint hashes session_id, @timestamp and stat_round (the
+//     default _id) into fingerprint, event.hash with DOC_FORMAT=ecs, for
+//     de-duplication and joins across indices
+//   - set stamps when the cluster got the document (ingested_at,
+//     event.ingested)
+//
+// A document a processor fails on is indexed anyway, with ingest_error.
+func ingestPipeline(config Config) map[string]interface{} {
+	field, fingerprint, ingested := func(name string) string { return name }, "fingerprint", "ingested_at"
+	if config.DocumentFormat == "ecs" {
+		field, fingerprint, ingested = ecsPath, "event.hash", "event.ingested"
+	}
+	return map[string]interface{}{
+		"description": "TWAMP measurements: @timestamp parsing, fingerprint and ingest time (twamp provision-ingest-pipeline)",
+		"processors": []interface{}{
+			map[string]interface{}{"date": map[string]interface{}{
+				"field":        "@timestamp",
+				"target_field": "@timestamp",
+				"formats":      []string{"ISO8601", "UNIX_MS", "UNIX"},
+				"if":           "ctx['@timestamp'] != null",
+			}},
+			map[string]interface{}{"fingerprint": map[string]interface{}{
+				"fields":         []string{field("session_id"), "@timestamp", field("stat_round")},
+				"target_field":   fingerprint,
+				"method":         "SHA-256",
+				"ignore_missing": true,
+			}},
+			map[string]interface{}{"set": map[string]interface{}{
+				"field": ingested,
+				"value": "{{{_ingest.timestamp}}}",
+			}},
+		},
+		"on_failure": []interface{}{
+			map[string]interface{}{"set": map[string]interface{}{
+				"field": "ingest_error",
+				"value": "{{ _ingest.on_failure_processor_type }}: {{ _ingest.on_failure_message }}",
+			}},
+		},
+	}
+}
+
+// provisionIngestPipeline installs the ingest pipeline as name on every
+// cluster (the secondary too, its documents name the same pipeline), or
+// writes it to stdout with print.
+func provisionIngestPipeline(ctx context.Context, clusters []*elasticsearch.Client, name string, config Config, print bool) error {
+	body := ingestPipeline(config)
+	if print {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(body)
+	}
+	for _, es := range clusters {
+		if err := putJSON(ctx, es, "ingest pipeline "+name, func(r io.Reader) (*esapi.Response, error) {
+			return es.Ingest.PutPipeline(name, r, es.Ingest.PutPipeline.WithContext(ctx))
+		}, body); err != nil {
+			return err
+		}
+	}
+	if config.ESPipeline != name {
+		logES.Info("set ES_PIPELINE to send the documents through the pipeline", "pipeline", name)
+	}
+	return nil
+}
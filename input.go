@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZip  = []byte("PK\x03\x04")
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicTar  = []byte("ustar")
+)
+
+const tarMagicOffset = 257
+
+// forEachCSVStream detects the container format of path by its magic bytes
+// (plain CSV, gzip, zip, tar, zstd, or nested combinations such as .tar.gz)
+// and calls fn once per CSV document it contains.
+func forEachCSVStream(filePath string, progress *fileProgress, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, len(magicZip))
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// zip 은 central directory 때문에 ReaderAt 이 필요해서 따로 처리
+	if bytes.HasPrefix(head[:n], magicZip) {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return forEachZipMember(progress.readerAt(f), info.Size(), filePath, fn)
+	}
+	return forEachStream(path.Base(filePath), progress.reader(f), fn)
+}
+
+func forEachZipMember(r io.ReaderAt, size int64, name string, fn func(string, io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("error reading zip archive: %w", err)
+	}
+	for _, member := range zr.File {
+		if member.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := member.Open()
+		if err != nil {
+			return fmt.Errorf("error opening %s in %s: %w", member.Name, name, err)
+		}
+		err = forEachStream(name+"!"+member.Name, rc, fn)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachStream unwraps compression layers from r until it reaches a tar
+// archive or plain data.
+func forEachStream(name string, r io.Reader, fn func(string, io.Reader) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, _ := br.Peek(tarMagicOffset + len(magicTar))
+
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		gz, err := newGzipReader(br)
+		if err != nil {
+			return fmt.Errorf("error reading gzip stream %s: %w", name, err)
+		}
+		defer gz.Close()
+		return forEachStream(name, gz, fn)
+
+	case bytes.HasPrefix(head, magicZstd):
+		zr, wait, err := zstdReader(br)
+		if err != nil {
+			return fmt.Errorf("error reading zstd stream %s: %w", name, err)
+		}
+		return wait(forEachStream(name, zr, fn))
+
+	case len(head) >= tarMagicOffset+len(magicTar) && bytes.Equal(head[tarMagicOffset:], magicTar):
+		tr := tar.NewReader(br)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading tar archive %s: %w", name, err)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := forEachStream(name+"!"+hdr.Name, tr, fn); err != nil {
+				return err
+			}
+		}
+
+	case bytes.HasPrefix(head, magicZip):
+		// zip nested in another container: it needs a ReaderAt, so spool it
+		// to a temp file instead of memory
+		tmp, err := os.CreateTemp("", "twamp-nested-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		size, err := io.Copy(tmp, br)
+		if err != nil {
+			return fmt.Errorf("error buffering zip archive %s: %w", name, err)
+		}
+		return forEachZipMember(tmp, size, name, fn)
+
+	default:
+		return fn(name, br)
+	}
+}
+
+// zstdReader decompresses through the zstd command line tool since the
+// standard library has no zstd decoder. wait must be called with the
+// error of reading the stream: on nil it reads what's left and waits for
+// zstd, otherwise it kills zstd and returns that error.
+func zstdReader(r io.Reader) (io.Reader, func(error) error, error) {
+	bin, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, nil, errors.New("zstd input requires the zstd binary in PATH")
+	}
+	cmd := exec.Command(bin, "-dc")
+	cmd.Stdin = r
+	// 죽인 뒤 입력을 복사하던 goroutine 을 오래 기다리지 않는다
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	wait := func(readErr error) error {
+		if readErr != nil {
+			// 나머지를 다 풀지 않는다
+			cmd.Process.Kill()
+			cmd.Wait()
+			return readErr
+		}
+		io.Copy(io.Discard, out)
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("zstd: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil
+	}
+	return out, wait, nil
+}
+
+// zstdInput reports whether the include patterns of FILE_PATH or of a
+// pipeline let .zst files through, which then need the zstd binary.
+func (c Config) zstdInput() bool {
+	const probe = "input.zst"
+	if newFileFilter(c.IncludePatterns, c.ExcludePatterns).Match(probe) {
+		return true
+	}
+	for _, p := range c.Pipelines {
+		f := fileFilter{include: p.Include, exclude: p.Exclude}
+		if len(f.include) == 0 {
+			f.include = splitList(c.IncludePatterns)
+		}
+		if f.Match(probe) {
+			return true
+		}
+	}
+	return false
+}
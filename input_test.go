@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zipOf(t *testing.T, name, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(data))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdOf(t *testing.T, data io.Reader) []byte {
+	t.Helper()
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("no zstd binary")
+	}
+	cmd := exec.Command("zstd", "-c", "-1")
+	cmd.Stdin = data
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestForEachCSVStream(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(t *testing.T) []byte
+		want []string // 이름=내용
+	}{
+		{"plain", func(t *testing.T) []byte { return []byte("a,b\n") }, []string{"in=a,b\n"}},
+		{"gzip", func(t *testing.T) []byte { return gzipMembers(t, "a,b\n") }, []string{"in=a,b\n"}},
+		{"zip", func(t *testing.T) []byte { return zipOf(t, "x.csv", "a,b\n") }, []string{"DIR/in!x.csv=a,b\n"}},
+		{"tar with a zip", func(t *testing.T) []byte { return tarOf(t, "x.zip", zipOf(t, "x.csv", "a,b\n")) }, []string{"in!x.zip!x.csv=a,b\n"}},
+		{"zstd tar", func(t *testing.T) []byte { return zstdOf(t, bytes.NewReader(tarOf(t, "x.csv", []byte("a,b\n")))) }, []string{"in!x.csv=a,b\n"}},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "in")
+		if err := os.WriteFile(path, tt.data(t), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		err := forEachCSVStream(path, nil, func(name string, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			got = append(got, strings.ReplaceAll(name, dir, "DIR")+"="+string(data))
+			return err
+		})
+		if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// TestZstdStopsOnError checks that an error while reading a zstd stream
+// kills zstd instead of reading the rest of it.
+func TestZstdStopsOnError(t *testing.T) {
+	noise := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(noise)
+	frame := zstdOf(t, bytes.NewReader(noise))
+	// 나머지가 오지 않는 입력: 끝까지 읽으려 하면 멈춘다
+	pr, pw := io.Pipe()
+	go pw.Write(frame[:len(frame)/2])
+	timer := time.AfterFunc(10*time.Second, func() { pw.Close() })
+	defer timer.Stop()
+	stop := errors.New("stop")
+	start := time.Now()
+	err := forEachStream("in.zst", pr, func(name string, r io.Reader) error {
+		r.Read(make([]byte, 4096))
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("err = %v, want %v", err, stop)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s, zstd wasn't stopped", d)
+	}
+}
+
+func TestZstdInput(t *testing.T) {
+	tests := []struct {
+		include, exclude string
+		pipelines        []pipelineConfig
+		want             bool
+	}{
+		{"*.gz,*.csv,*.zst", "", nil, true},
+		{"*.gz,*.csv", "", nil, false},
+		{"*.gz,*.zst", "*.zst", nil, false},
+		{"*.gz", "", []pipelineConfig{{Include: []string{"**/*.zst"}}}, true},
+		{"*.zst", "*.zst", []pipelineConfig{{}}, true},
+		{"*.gz", "", []pipelineConfig{{}}, false},
+	}
+	for _, tt := range tests {
+		c := Config{IncludePatterns: tt.include, ExcludePatterns: tt.exclude, Pipelines: tt.pipelines}
+		if got := c.zstdInput(); got != tt.want {
+			t.Errorf("include %q exclude %q pipelines %v: zstdInput() = %v, want %v", tt.include, tt.exclude, tt.pipelines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// inventoryEntry is what the inventory knows about a device or session.
+type inventoryEntry struct {
+	Hostname string
+	Site     string
+	Region   string
+	Role     string
+}
+
+// inventory is one snapshot of INVENTORY_FILE or NetBox, kept in memory and
+// swapped whole on refresh.
+type inventory struct {
+	byIP      map[string]inventoryEntry
+	bySession map[string]inventoryEntry
+}
+
+func newInventory() *inventory {
+	return &inventory{byIP: map[string]inventoryEntry{}, bySession: map[string]inventoryEntry{}}
+}
+
+// inventoryEnricher adds source_/destination_ hostname, site, region and
+// role from the device owning each IP, and link_* (usually just link_role)
+// from the session. Fields already in the record are kept.
+type inventoryEnricher struct {
+	source  string
+	load    func(ctx context.Context) (*inventory, error)
+	current atomic.Pointer[inventory]
+}
+
+func newInventoryEnricher(config Config) (*inventoryEnricher, error) {
+	e := &inventoryEnricher{}
+	switch {
+	case config.InventoryFile != "":
+		e.source = config.InventoryFile
+		e.load = func(context.Context) (*inventory, error) { return loadInventoryCSV(config.InventoryFile) }
+	case config.NetBoxURL != "":
+		nb, err := newNetBoxClient(config.NetBoxURL, config.NetBoxToken)
+		if err != nil {
+			return nil, err
+		}
+		e.source = config.NetBoxURL
+		e.load = nb.load
+	default:
+		return nil, errors.New("no inventory source")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := e.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *inventoryEnricher) refresh(ctx context.Context) error {
+	inv, err := e.load(ctx)
+	if err != nil {
+		return err
+	}
+	e.current.Store(inv)
+	logInvent.Info("inventory loaded", "source", e.source, "addresses", len(inv.byIP), "sessions", len(inv.bySession))
+	return nil
+}
+
+// run reloads the inventory every interval; a failed refresh keeps the
+// previous snapshot.
+func (e *inventoryEnricher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.refresh(ctx); err != nil && ctx.Err() == nil {
+				logInvent.Error("error refreshing inventory, keeping the previous one", "source", e.source, "err", err)
+			}
+		}
+	}
+}
+
+func (e *inventoryEnricher) Enrich(rec Record) Record {
+	inv := e.current.Load()
+	for _, side := range []string{"source", "destination"} {
+		ip, _ := rec[side+"_ip"].(string)
+		if entry, ok := inv.byIP[normalizeIP(ip)]; ok {
+			setEntry(rec, side+"_", entry)
+		}
+	}
+	if id, ok := rec["session_id"]; ok {
+		if entry, ok := inv.bySession[fmt.Sprint(id)]; ok {
+			setEntry(rec, "link_", entry)
+		}
+	}
+	return rec
+}
+
+func setEntry(rec Record, prefix string, e inventoryEntry) {
+	for k, v := range map[string]string{"hostname": e.Hostname, "site": e.Site, "region": e.Region, "role": e.Role} {
+		if v != "" {
+			setMissing(rec, prefix+k, v)
+		}
+	}
+}
+
+// normalizeIP drops a prefix length and zone so "10.0.0.1/32" and
+// "10.0.0.1" are the same key; anything unparsable is kept as is.
+func normalizeIP(s string) string {
+	s = strings.TrimSpace(s)
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Addr().String()
+	}
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a.WithZone("").String()
+	}
+	return s
+}
+
+// loadInventoryCSV reads an inventory CSV with a header row; each row needs
+// ip or session_id, the other columns are optional:
+//
+//	ip,session_id,hostname,site,region,role
+//	10.1.0.1,,seoul-pe-01,SEL1,kr-central,pe
+//	,278,,,,backbone
+func loadInventoryCSV(path string) (*inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory file: %w", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory file %s: %w", path, err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	_, hasIP := col["ip"]
+	_, hasSession := col["session_id"]
+	if !hasIP && !hasSession {
+		return nil, fmt.Errorf("inventory file %s: needs an ip or session_id column", path)
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	inv := newInventory()
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return inv, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading inventory file %s: %w", path, err)
+		}
+		entry := inventoryEntry{
+			Hostname: get(row, "hostname"),
+			Site:     get(row, "site"),
+			Region:   get(row, "region"),
+			Role:     get(row, "role"),
+		}
+		if ip := get(row, "ip"); ip != "" {
+			inv.byIP[normalizeIP(ip)] = entry
+		}
+		if id := get(row, "session_id"); id != "" {
+			inv.bySession[id] = entry
+		}
+	}
+}
+
+// netBoxClient reads devices (name, site, role, primary IPs) and sites
+// (region) from the NetBox REST API.
+type netBoxClient struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+func newNetBoxClient(base, token string) (*netBoxClient, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("INVENTORY_NETBOX_URL: invalid URL %q", base)
+	}
+	return &netBoxClient{base: u, token: token, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type netBoxRef struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type netBoxDevice struct {
+	Name       string     `json:"name"`
+	Site       *netBoxRef `json:"site"`
+	Role       *netBoxRef `json:"role"`
+	DeviceRole *netBoxRef `json:"device_role"` // NetBox < 3.6
+	PrimaryIP4 *struct {
+		Address string `json:"address"`
+	} `json:"primary_ip4"`
+	PrimaryIP6 *struct {
+		Address string `json:"address"`
+	} `json:"primary_ip6"`
+}
+
+type netBoxSite struct {
+	Slug   string     `json:"slug"`
+	Region *netBoxRef `json:"region"`
+}
+
+func (c *netBoxClient) load(ctx context.Context) (*inventory, error) {
+	var sites []netBoxSite
+	if err := c.list(ctx, "/api/dcim/sites/", &sites); err != nil {
+		return nil, err
+	}
+	regions := map[string]string{}
+	for _, s := range sites {
+		if s.Region != nil {
+			regions[s.Slug] = s.Region.Name
+		}
+	}
+	var devices []netBoxDevice
+	if err := c.list(ctx, "/api/dcim/devices/", &devices); err != nil {
+		return nil, err
+	}
+
+	inv := newInventory()
+	for _, d := range devices {
+		entry := inventoryEntry{Hostname: d.Name}
+		if d.Site != nil {
+			entry.Site, entry.Region = d.Site.Name, regions[d.Site.Slug]
+		}
+		if d.Role != nil {
+			entry.Role = d.Role.Name
+		} else if d.DeviceRole != nil {
+			entry.Role = d.DeviceRole.Name
+		}
+		if d.PrimaryIP4 != nil {
+			inv.byIP[normalizeIP(d.PrimaryIP4.Address)] = entry
+		}
+		if d.PrimaryIP6 != nil {
+			inv.byIP[normalizeIP(d.PrimaryIP6.Address)] = entry
+		}
+	}
+	return inv, nil
+}
+
+// list fetches every page of a NetBox list endpoint into out (a slice).
+func (c *netBoxClient) list(ctx context.Context, path string, out interface{}) error {
+	next := c.base.JoinPath(path).String() + "?limit=1000"
+	var all []json.RawMessage
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Token "+c.token)
+		}
+		res, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Next    string            `json:"next"`
+			Results []json.RawMessage `json:"results"`
+		}
+		if res.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+			return fmt.Errorf("NetBox GET %s: [%d] %s", path, res.StatusCode, strings.TrimSpace(string(msg)))
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error parsing NetBox response for %s: %w", path, err)
+		}
+		all = append(all, page.Results...)
+		next = page.Next
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
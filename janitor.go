@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// janitor removes (or gzips) files older than maxAge: source files the
+// ledger marks as ingested, dead letters and quarantined files. With
+// dryRun it only logs what it would do.
+type janitor struct {
+	state    *stateStore
+	dirs     []string
+	maxAge   time.Duration
+	compress bool
+	dryRun   bool
+}
+
+func newJanitor(config Config, state *stateStore) (*janitor, error) {
+	j := &janitor{
+		state:  state,
+		dirs:   []string{config.DeadLetterDir, config.ErrorsDir},
+		maxAge: config.RetentionMaxAge,
+		dryRun: config.RetentionDryRun,
+	}
+	switch config.RetentionAction {
+	case "delete":
+	case "compress":
+		j.compress = true
+	default:
+		return nil, fmt.Errorf("RETENTION_ACTION: unknown action %q (delete, compress)", config.RetentionAction)
+	}
+	return j, nil
+}
+
+func (j *janitor) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		j.sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *janitor) sweep() {
+	cutoff := time.Now().Add(-j.maxAge)
+	var files []string
+	for _, e := range j.state.Entries("") {
+		if e.Status == statusDone || e.Status == statusDuplicate {
+			files = append(files, e.Path)
+		}
+	}
+	for _, dir := range j.dirs {
+		if dir == "" {
+			continue
+		}
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	count, reclaimed := 0, int64(0)
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if j.compress && isCompressed(path) {
+			continue
+		}
+		n, err := j.clean(path, info.Size())
+		if err != nil {
+			logJanitor.Error("error cleaning file", "file", path, "err", err)
+			continue
+		}
+		count++
+		reclaimed += n
+	}
+	if count > 0 {
+		logJanitor.Info("retention sweep done", "files", count, "reclaimed_bytes", reclaimed, "dry_run", j.dryRun)
+	}
+}
+
+// clean applies the action to one file and returns the bytes freed.
+func (j *janitor) clean(path string, size int64) (int64, error) {
+	action := "delete"
+	if j.compress {
+		action = "compress"
+	}
+	if j.dryRun {
+		logJanitor.Info("would "+action, "file", path, "bytes", size)
+		return size, nil
+	}
+	if !j.compress {
+		if err := os.Remove(path); err != nil {
+			return 0, err
+		}
+		metricJanitorFiles.Inc(action)
+		metricJanitorReclaimed.Add(float64(size), action)
+		return size, nil
+	}
+
+	dest := path + ".gz"
+	compressed, err := gzipFile(path, dest, func(tmp string) error {
+		// 감시 디렉토리에 .gz 가 새로 생기므로, rename 전에 ledger 에 처리 완료로 옮겨둔다
+		return j.state.Moved(path, dest, tmp)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+	freed := size - compressed
+	if freed < 0 {
+		freed = 0
+	}
+	metricJanitorFiles.Inc(action)
+	metricJanitorReclaimed.Add(float64(freed), action)
+	return freed, nil
+}
+
+// gzipFile writes src compressed to a temp file next to dst, calls
+// beforeRename with the temp path and renames it to dst. It returns the
+// compressed size.
+func gzipFile(src, dst string, beforeRename func(tmp string) error) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return 0, err
+	}
+	if err := beforeRename(tmp); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return 0, err
+	}
+	// 원본의 수정 시각 유지
+	if src, err := in.Stat(); err == nil {
+		os.Chtimes(dst, src.ModTime(), src.ModTime())
+	}
+	return info.Size(), nil
+}
+
+func isCompressed(path string) bool {
+	for _, ext := range []string{".gz", ".tgz", ".zip", ".zst"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
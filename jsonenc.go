@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// appendRecordJSON appends rec as JSON, byte for byte what json.Marshal
+// makes of it (sorted keys, HTML-safe strings, the same float format),
+// for the documents of the outputs. The types records hold (strings,
+// int64, float64, bool, nested objects and lists) are written directly
+// instead of through reflection; anything else goes to json.Marshal.
+// NaN and Inf, which json.Marshal refuses, are written as null.
+func appendRecordJSON(dst []byte, rec Record) ([]byte, error) {
+	return appendJSONObject(dst, rec)
+}
+
+// appendRecordJSONLossy is appendRecordJSON with the values that can't be
+// encoded written as their text, for the dead-letter queue.
+func appendRecordJSONLossy(dst []byte, rec Record) []byte {
+	m := make(map[string]interface{}, len(rec))
+	for k, v := range rec {
+		if _, err := appendJSONValue(nil, v); err != nil {
+			v = fmt.Sprint(v)
+		}
+		m[k] = v
+	}
+	dst, _ = appendJSONObject(dst, m)
+	return dst
+}
+
+// jsonKeyOrders keeps the sorted keys of objects encoded before. The
+// records of a file have the same fields, so the order found for one is
+// tried on the next before sorting again.
+var jsonKeyOrders = sync.Pool{New: func() any { return new([]string) }}
+
+func appendJSONObject(dst []byte, m map[string]interface{}) ([]byte, error) {
+	order := jsonKeyOrders.Get().(*[]string)
+	defer jsonKeyOrders.Put(order)
+	if len(*order) == len(m) {
+		start := len(dst)
+		out, ok, err := appendJSONObjectKeys(dst, m, *order)
+		if ok || err != nil {
+			return out, err
+		}
+		// 필드가 다르다: 지금까지 쓴 것은 버리고 정렬한다
+		dst = out[:start]
+	}
+	keys := (*order)[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	*order = keys
+	out, _, err := appendJSONObjectKeys(dst, m, keys)
+	return out, err
+}
+
+// appendJSONObjectKeys writes m in the order of keys, which has as many
+// keys as m; ok is false if one of them isn't in m.
+func appendJSONObjectKeys(dst []byte, m map[string]interface{}, keys []string) (out []byte, ok bool, err error) {
+	dst = append(dst, '{')
+	for i, k := range keys {
+		v, found := m[k]
+		if !found {
+			return dst, false, nil
+		}
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, k)
+		dst = append(dst, ':')
+		if dst, err = appendJSONValue(dst, v); err != nil {
+			return dst, true, err
+		}
+	}
+	return append(dst, '}'), true, nil
+}
+
+func appendJSONValue(dst []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(dst, "null"...), nil
+	case string:
+		return appendJSONString(dst, v), nil
+	case int64:
+		return strconv.AppendInt(dst, v, 10), nil
+	case int:
+		return strconv.AppendInt(dst, int64(v), 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return append(dst, "null"...), nil
+		}
+		return appendJSONFloat(dst, v), nil
+	case bool:
+		return strconv.AppendBool(dst, v), nil
+	case Record:
+		return appendJSONObject(dst, v)
+	case map[string]interface{}:
+		return appendJSONObject(dst, v)
+	case []string:
+		if v == nil {
+			return append(dst, "null"...), nil
+		}
+		dst = append(dst, '[')
+		for i, s := range v {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, s)
+		}
+		return append(dst, ']'), nil
+	case []interface{}:
+		if v == nil {
+			return append(dst, "null"...), nil
+		}
+		dst = append(dst, '[')
+		for i, e := range v {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			var err error
+			if dst, err = appendJSONValue(dst, e); err != nil {
+				return dst, err
+			}
+		}
+		return append(dst, ']'), nil
+	}
+	// 그 밖의 타입 (time.Time, map[string]float64 등) 은 encoding/json 에 맡긴다
+	b, err := json.Marshal(v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
+
+// appendJSONFloat formats f like encoding/json: no exponent between 1e-6
+// and 1e21, and e-7 rather than e-07.
+func appendJSONFloat(dst []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		if n := len(dst); n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes s like encoding/json with HTML escaping: <, >
+// and & as \u003c etc., invalid UTF-8 as U+FFFD, and U+2028/U+2029
+// escaped.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestAppendRecordJSON(t *testing.T) {
+	records := []Record{
+		{},
+		{"session_id": int64(7), "rtt_avg": 1.5, "available": true, "name": "<서울> &  ", "tiny": 1e-7, "big": 1e21},
+		{"tags": []string{"a", "b"}, "nested": Record{"x": []interface{}{int64(1), "y", nil}}, "none": nil},
+		// 키가 다른 레코드가 이어져도 순서 캐시가 맞아야 한다
+		{"a": int64(1), "b": int64(2)},
+		{"a": int64(1), "c": int64(3)},
+	}
+	for _, rec := range records {
+		want, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := appendRecordJSON(nil, rec)
+		if err != nil || string(got) != string(want) {
+			t.Errorf("appendRecordJSON(%v) = %s, %v; want %s", rec, got, err, want)
+		}
+	}
+
+	got, err := appendRecordJSON(nil, Record{"nan": math.NaN(), "inf": math.Inf(-1)})
+	if err != nil || string(got) != `{"inf":null,"nan":null}` {
+		t.Errorf("non-finite floats: %s, %v", got, err)
+	}
+}
+
+func BenchmarkEncodeRecord(b *testing.B) {
+	data := benchCSV(b, 1)
+	schema := benchSchema(b)
+	parse, err := lookupParser("twamp", csvOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	reader, err := parse("bench.csv", bytes.NewReader(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	row, err := reader.Next()
+	if err != nil {
+		b.Fatal(err)
+	}
+	rec, err := schema.Convert(reader.Header(), row)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("appendRecordJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = appendRecordJSON(buf[:0], rec)
+		}
+	})
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(rec)
+		}
+	})
+}
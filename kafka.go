@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Minimal Kafka producer speaking the wire protocol directly: Metadata v0
+// for partition leaders and Produce v3 with v2 record batches (Kafka 0.11+).
+// No compression, TLS or SASL.
+
+const (
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka error codes worth refreshing metadata and retrying for.
+var kafkaRetriableErrors = map[int16]string{
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_FOR_PARTITION",
+	7:  "REQUEST_TIMED_OUT",
+	13: "NETWORK_EXCEPTION",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+}
+
+type kafkaMessage struct {
+	key   []byte
+	value []byte
+	ts    time.Time
+}
+
+type kafkaBroker struct {
+	id   int32
+	addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	rd     *bufio.Reader
+	corrID int32
+}
+
+// KafkaProducer publishes records as JSON to one topic, keyed by session_id
+// so a session's measurements stay ordered on one partition.
+type KafkaProducer struct {
+	seeds    []string
+	topic    string
+	acks     int16
+	clientID string
+	timeout  time.Duration
+	retry    retryPolicy
+
+	metaMu  sync.Mutex
+	brokers map[int32]*kafkaBroker
+	leaders []int32 // partition -> leader node id
+}
+
+func newKafkaProducer(config Config) (*KafkaProducer, error) {
+	seeds := splitList(config.KafkaBrokers)
+	if len(seeds) == 0 || config.KafkaTopic == "" {
+		return nil, errors.New("KAFKA_BROKERS and KAFKA_TOPIC are required for the kafka output")
+	}
+	p := &KafkaProducer{
+		seeds:    seeds,
+		topic:    config.KafkaTopic,
+		acks:     int16(config.KafkaAcks),
+		clientID: config.KafkaClientID,
+		timeout:  10 * time.Second,
+		retry:    config.retryPolicy(),
+		brokers:  make(map[int32]*kafkaBroker),
+	}
+	if err := p.refreshMetadata(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *KafkaProducer) Name() string { return "kafka" }
+
+func (p *KafkaProducer) Write(ctx context.Context, records []Record) error {
+	msgs := make([]kafkaMessage, 0, len(records))
+	now := time.Now()
+	for _, rec := range records {
+		value, err := appendRecordJSON(nil, rec)
+		if err != nil {
+			return fmt.Errorf("error marshalling record: %w", err)
+		}
+		var key []byte
+		if id, ok := recordField(rec, "session_id"); ok {
+			key = []byte(fmt.Sprint(id))
+		}
+		msgs = append(msgs, kafkaMessage{key: key, value: value, ts: now})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return p.produce(ctx, msgs)
+}
+
+func (p *KafkaProducer) Close() error {
+	p.metaMu.Lock()
+	defer p.metaMu.Unlock()
+	for _, b := range p.brokers {
+		b.close()
+	}
+	return nil
+}
+
+// produce sends msgs, retrying partitions that failed with a retriable
+// error after refreshing metadata.
+func (p *KafkaProducer) produce(ctx context.Context, msgs []kafkaMessage) error {
+	p.metaMu.Lock()
+	numPartitions := len(p.leaders)
+	p.metaMu.Unlock()
+	if numPartitions == 0 {
+		return fmt.Errorf("topic %s has no partitions", p.topic)
+	}
+
+	byPartition := make(map[int32][]kafkaMessage)
+	for i, m := range msgs {
+		part := int32(i % numPartitions)
+		if m.key != nil {
+			part = int32(toPositive(murmur2(m.key)) % uint32(numPartitions))
+		}
+		byPartition[part] = append(byPartition[part], m)
+	}
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		failed, err := p.produceOnce(byPartition)
+		metricBulkLatency.Observe(time.Since(start).Seconds(), p.Name())
+		sent := 0
+		for part, ms := range byPartition {
+			if _, ok := failed[part]; !ok {
+				sent += len(ms)
+			}
+		}
+		metricDocumentsIndexed.Add(float64(sent), p.Name())
+		if len(failed) > 0 || err != nil {
+			metricBulkFailures.Inc(p.Name())
+		}
+		if len(failed) == 0 && err == nil {
+			logKafka.Debug("published", "messages", len(msgs), "topic", p.topic)
+			return nil
+		}
+		if attempt >= p.retry.MaxAttempts {
+			if err == nil {
+				err = fmt.Errorf("%d partitions failed", len(failed))
+			}
+			return fmt.Errorf("giving up on kafka produce after %d attempts: %w", attempt, err)
+		}
+		wait := p.retry.backoff(attempt)
+		metricBulkRetries.Inc(p.Name())
+		logKafka.Warn("produce failed, retrying", "attempt", attempt, "max_attempts", p.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if err := p.refreshMetadata(); err != nil {
+			logKafka.Error("error refreshing metadata", "err", err)
+		}
+		if len(failed) > 0 {
+			byPartition = failed
+		}
+	}
+}
+
+func (p *KafkaProducer) produceOnce(byPartition map[int32][]kafkaMessage) (map[int32][]kafkaMessage, error) {
+	p.metaMu.Lock()
+	byBroker := make(map[*kafkaBroker]map[int32][]kafkaMessage)
+	for part, ms := range byPartition {
+		var b *kafkaBroker
+		if int(part) < len(p.leaders) {
+			b = p.brokers[p.leaders[part]]
+		}
+		if b == nil {
+			p.metaMu.Unlock()
+			return byPartition, fmt.Errorf("no leader for partition %d", part)
+		}
+		if byBroker[b] == nil {
+			byBroker[b] = make(map[int32][]kafkaMessage)
+		}
+		byBroker[b][part] = ms
+	}
+	p.metaMu.Unlock()
+
+	failed := make(map[int32][]kafkaMessage)
+	var firstErr error
+	for b, parts := range byBroker {
+		errs, err := b.produce(p, parts)
+		if err != nil {
+			for part, ms := range parts {
+				failed[part] = ms
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for part, code := range errs {
+			if code == 0 {
+				continue
+			}
+			if _, retriable := kafkaRetriableErrors[code]; !retriable {
+				logKafka.Error("messages rejected", "messages", len(parts[part]), "topic", p.topic, "partition", part, "error_code", code)
+				continue
+			}
+			failed[part] = parts[part]
+			if firstErr == nil {
+				firstErr = fmt.Errorf("partition %d: %s", part, kafkaRetriableErrors[code])
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return failed, firstErr
+}
+
+func (p *KafkaProducer) refreshMetadata() error {
+	var lastErr error
+	for _, seed := range p.seeds {
+		b := &kafkaBroker{id: -1, addr: seed}
+		brokers, leaders, err := b.metadata(p)
+		b.close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p.metaMu.Lock()
+		for id, addr := range brokers {
+			if old, ok := p.brokers[id]; ok && old.addr == addr {
+				continue
+			} else if ok {
+				old.close()
+			}
+			p.brokers[id] = &kafkaBroker{id: id, addr: addr}
+		}
+		p.leaders = leaders
+		p.metaMu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("error fetching kafka metadata: %w", lastErr)
+}
+
+func (b *kafkaBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+// roundTrip sends one request and returns the response body (after the
+// correlation id).
+func (b *kafkaBroker) roundTrip(p *KafkaProducer, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		conn, err := net.DialTimeout("tcp", b.addr, p.timeout)
+		if err != nil {
+			return nil, err
+		}
+		b.conn, b.rd = conn, bufio.NewReader(conn)
+	}
+	b.corrID++
+
+	var req kafkaEncoder
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(b.corrID)
+	req.string(p.clientID)
+	req.raw(body)
+
+	frame := make([]byte, 4, 4+len(req.buf))
+	binary.BigEndian.PutUint32(frame, uint32(len(req.buf)))
+	frame = append(frame, req.buf...)
+
+	b.conn.SetDeadline(time.Now().Add(p.timeout))
+	if _, err := b.conn.Write(frame); err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return nil, err
+	}
+	if apiKey == kafkaAPIProduce && p.acks == 0 {
+		return nil, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(b.rd, size[:]); err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(b.rd, resp); err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != b.corrID {
+		b.conn.Close()
+		b.conn = nil
+		return nil, errors.New("kafka correlation id mismatch")
+	}
+	return resp[4:], nil
+}
+
+func (b *kafkaBroker) metadata(p *KafkaProducer) (map[int32]string, []int32, error) {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(p.topic)
+	resp, err := b.roundTrip(p, kafkaAPIMetadata, 0, req.buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := kafkaDecoder{buf: resp}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	var leaders []int32
+	for n := d.int32(); n > 0; n-- {
+		code := d.int16()
+		name := d.string()
+		if code != 0 {
+			return nil, nil, fmt.Errorf("metadata for topic %s: error %d", name, code)
+		}
+		for np := d.int32(); np > 0; np-- {
+			d.int16()
+			part := d.int32()
+			leader := d.int32()
+			d.int32Array()
+			d.int32Array()
+			for int(part) >= len(leaders) {
+				leaders = append(leaders, -1)
+			}
+			leaders[part] = leader
+		}
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return brokers, leaders, nil
+}
+
+// produce sends a Produce v3 request and returns the error code per partition.
+func (b *kafkaBroker) produce(p *KafkaProducer, parts map[int32][]kafkaMessage) (map[int32]int16, error) {
+	var req kafkaEncoder
+	req.int16(-1) // transactional_id null
+	req.int16(p.acks)
+	req.int32(int32(p.timeout / time.Millisecond))
+	req.int32(1)
+	req.string(p.topic)
+	req.int32(int32(len(parts)))
+	for part, ms := range parts {
+		req.int32(part)
+		batch := encodeRecordBatch(ms)
+		req.int32(int32(len(batch)))
+		req.raw(batch)
+	}
+
+	resp, err := b.roundTrip(p, kafkaAPIProduce, 3, req.buf)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	d := kafkaDecoder{buf: resp}
+	codes := make(map[int32]int16)
+	for n := d.int32(); n > 0; n-- {
+		d.string()
+		for np := d.int32(); np > 0; np-- {
+			part := d.int32()
+			codes[part] = d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return codes, d.err
+}
+
+// encodeRecordBatch builds an uncompressed v2 record batch.
+func encodeRecordBatch(ms []kafkaMessage) []byte {
+	first := ms[0].ts.UnixMilli()
+	maxTS := first
+	var records kafkaEncoder
+	for i, m := range ms {
+		ts := m.ts.UnixMilli()
+		if ts > maxTS {
+			maxTS = ts
+		}
+		var rec kafkaEncoder
+		rec.int8(0)
+		rec.varint(ts - first)
+		rec.varint(int64(i))
+		if m.key == nil {
+			rec.varint(-1)
+		} else {
+			rec.varint(int64(len(m.key)))
+			rec.raw(m.key)
+		}
+		rec.varint(int64(len(m.value)))
+		rec.raw(m.value)
+		rec.varint(0) // headers
+		records.varint(int64(len(rec.buf)))
+		records.raw(rec.buf)
+	}
+
+	// CRC 는 attributes 부터 끝까지
+	var body kafkaEncoder
+	body.int16(0) // attributes
+	body.int32(int32(len(ms) - 1))
+	body.int64(first)
+	body.int64(maxTS)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(ms)))
+	body.raw(records.buf)
+
+	var batch kafkaEncoder
+	batch.int64(0)                                // base offset
+	batch.int32(int32(4 + 1 + 4 + len(body.buf))) // length after this field
+	batch.int32(-1)                               // partition leader epoch
+	batch.int8(2)                                 // magic
+	batch.int32(int32(crc32.Checksum(body.buf, crc32c)))
+	batch.raw(body.buf)
+	return batch.buf
+}
+
+type kafkaEncoder struct{ buf []byte }
+
+func (e *kafkaEncoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+func (e *kafkaEncoder) raw(b []byte)  { e.buf = append(e.buf, b...) }
+func (e *kafkaEncoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errors.New("short kafka response")
+		return make([]byte, max(n, 0))
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.take(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.take(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.take(8))) }
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+func (d *kafkaDecoder) int32Array() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
+
+// murmur2 matches the Java client's default partitioner.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+func toPositive(n uint32) uint32 { return n & 0x7fffffff }
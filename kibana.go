@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// kibanaProvisioner pushes the TWAMP saved objects into Kibana 8.6 or
+// later (`twamp provision-kibana`): a data view (index pattern) for the
+// index, Lens visualizations on the KPI_ENRICH fields and a TWAMP overview
+// dashboard with them, in KIBANA_SPACE. Like provision-grafana the objects
+// have fixed ids and are overwritten. Kibana checks the credentials with
+// Elasticsearch, so it signs in with ES_API_KEY or ES_USER/ES_PASSWORD.
+type kibanaProvisioner struct {
+	url     string
+	apiKey  string
+	user    string
+	pass    string
+	client  *http.Client
+	index   string
+	groupBy string
+}
+
+const kibanaDataViewID = "twamp"
+
+func newKibanaProvisioner(config Config, index, groupBy string) *kibanaProvisioner {
+	url := strings.TrimRight(config.KibanaURL, "/")
+	if config.KibanaSpace != "" && config.KibanaSpace != "default" {
+		url += "/s/" + config.KibanaSpace
+	}
+	return &kibanaProvisioner{
+		url: url, apiKey: config.ESAPIKey, user: config.ESUser, pass: config.ESPassword,
+		client: &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}},
+		index:  index, groupBy: groupBy,
+	}
+}
+
+// kibanaObject is a saved object as _bulk_create and the .ndjson export
+// format take it.
+type kibanaObject struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+	References []kibanaReference      `json:"references"`
+}
+
+type kibanaReference struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (k *kibanaProvisioner) provision() error {
+	objects := k.objects()
+	body, err := json.Marshal(objects)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, k.url+"/api/saved_objects/_bulk_create?overwrite=true", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("kbn-xsrf", "twamp")
+	if k.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+k.apiKey)
+	} else if k.user != "" {
+		req.SetBasicAuth(k.user, k.pass)
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error creating Kibana saved objects: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("error creating Kibana saved objects: [%d] %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+	// 객체별 오류는 200 응답 안에 온다
+	var resp struct {
+		SavedObjects []struct {
+			Type  string `json:"type"`
+			ID    string `json:"id"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"saved_objects"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("error reading Kibana response: %w", err)
+	}
+	failed := 0
+	for _, o := range resp.SavedObjects {
+		if o.Error != nil {
+			logHTTP.Error("error creating Kibana saved object", "type", o.Type, "id", o.ID, "err", o.Error.Message)
+			failed++
+			continue
+		}
+		logHTTP.Info("created/updated Kibana saved object", "type", o.Type, "id", o.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d Kibana saved objects failed", failed, len(objects))
+	}
+	return nil
+}
+
+// print writes the objects as .ndjson, for Stack Management > Saved
+// objects > Import.
+func (k *kibanaProvisioner) print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, o := range k.objects() {
+		if err := enc.Encode(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objects are the data view, the Lens visualizations and the dashboard.
+func (k *kibanaProvisioner) objects() []kibanaObject {
+	dataView := kibanaObject{
+		Type: "index-pattern", ID: kibanaDataViewID, References: []kibanaReference{},
+		Attributes: map[string]interface{}{"title": k.index, "name": "TWAMP", "timeFieldName": "@timestamp"},
+	}
+	// Lens 컬럼
+	timeColumn := map[string]interface{}{"label": "@timestamp", "dataType": "date", "operationType": "date_histogram",
+		"sourceField": "@timestamp", "isBucketed": true, "scale": "interval", "params": map[string]interface{}{"interval": "auto"}}
+	groupColumn := func(size int, orderBy string) map[string]interface{} {
+		return map[string]interface{}{"label": k.groupBy, "dataType": "string", "operationType": "terms", "sourceField": k.groupBy,
+			"isBucketed": true, "scale": "ordinal",
+			"params": map[string]interface{}{"size": size, "orderBy": map[string]string{"type": "column", "columnId": orderBy}, "orderDirection": "desc"}}
+	}
+	average := func(label, field string) map[string]interface{} {
+		return map[string]interface{}{"label": label, "dataType": "number", "operationType": "average", "sourceField": field,
+			"isBucketed": false, "scale": "ratio", "customLabel": true}
+	}
+	percentile := func(label, field string, p int) map[string]interface{} {
+		return map[string]interface{}{"label": label, "dataType": "number", "operationType": "percentile", "sourceField": field,
+			"isBucketed": false, "scale": "ratio", "customLabel": true, "params": map[string]interface{}{"percentile": p}}
+	}
+	percent := func(c map[string]interface{}) map[string]interface{} {
+		c["params"] = map[string]interface{}{"format": map[string]interface{}{"id": "percent", "params": map[string]int{"decimals": 3}}}
+		return c
+	}
+	lens := func(id, title, visType string, columns map[string]map[string]interface{}, order []string, vis map[string]interface{}) kibanaObject {
+		cols := map[string]interface{}{}
+		for name, c := range columns {
+			cols[name] = c
+		}
+		vis["layerId"], vis["layerType"] = "layer1", "data"
+		if visType == "lnsXY" {
+			// XY 는 layer 목록, 나머지는 한 layer 를 바로 가진다
+			vis = map[string]interface{}{"legend": map[string]interface{}{"isVisible": true, "position": "right"},
+				"preferredSeriesType": vis["seriesType"], "valueLabels": "hide", "layers": []interface{}{vis}}
+		}
+		return kibanaObject{
+			Type: "lens", ID: id,
+			Attributes: map[string]interface{}{
+				"title": title, "visualizationType": visType,
+				"state": map[string]interface{}{
+					"datasourceStates": map[string]interface{}{"formBased": map[string]interface{}{"layers": map[string]interface{}{
+						"layer1": map[string]interface{}{"columns": cols, "columnOrder": order, "incompleteColumns": map[string]interface{}{}},
+					}}},
+					"visualization": vis,
+					"query":         map[string]string{"query": "", "language": "kuery"},
+					"filters":       []interface{}{},
+				},
+			},
+			References: []kibanaReference{{Type: "index-pattern", ID: kibanaDataViewID, Name: "indexpattern-datasource-layer-layer1"}},
+		}
+	}
+
+	visualizations := []kibanaObject{
+		lens("twamp-availability", "TWAMP availability", "lnsMetric",
+			map[string]map[string]interface{}{"m": percent(average("Availability", "available"))}, []string{"m"},
+			map[string]interface{}{"metricAccessor": "m"}),
+		lens("twamp-rtt-p95", "TWAMP RTT p95", "lnsMetric",
+			map[string]map[string]interface{}{"m": percentile("RTT p95 (µs)", "rtt_mean", 95)}, []string{"m"},
+			map[string]interface{}{"metricAccessor": "m"}),
+		lens("twamp-loss-metric", "TWAMP loss", "lnsMetric",
+			map[string]map[string]interface{}{"m": average("Loss %", "loss_pct")}, []string{"m"},
+			map[string]interface{}{"metricAccessor": "m"}),
+		lens("twamp-rtt-over-time", "TWAMP RTT p95 by "+k.groupBy, "lnsXY",
+			map[string]map[string]interface{}{"x": timeColumn, "y": percentile("RTT p95 (µs)", "rtt_mean", 95), "g": groupColumn(10, "y")},
+			[]string{"g", "x", "y"},
+			map[string]interface{}{"seriesType": "line", "xAccessor": "x", "accessors": []string{"y"}, "splitAccessor": "g"}),
+		lens("twamp-rtt-heatmap", "TWAMP RTT heatmap", "lnsHeatmap",
+			map[string]map[string]interface{}{"x": timeColumn, "g": groupColumn(30, "v"), "v": average("Mean RTT (µs)", "rtt_mean")},
+			[]string{"g", "x", "v"},
+			map[string]interface{}{"shape": "heatmap", "xAccessor": "x", "yAccessor": "g", "valueAccessor": "v",
+				"legend":     map[string]interface{}{"isVisible": true, "position": "right", "type": "heatmap_legend"},
+				"gridConfig": map[string]interface{}{"type": "heatmap_grid", "isCellLabelVisible": false, "isYAxisLabelVisible": true, "isXAxisLabelVisible": true}}),
+		lens("twamp-loss-over-time", "TWAMP loss by "+k.groupBy, "lnsXY",
+			map[string]map[string]interface{}{"x": timeColumn, "y": average("Loss %", "loss_pct"), "g": groupColumn(10, "y")},
+			[]string{"g", "x", "y"},
+			map[string]interface{}{"seriesType": "bar_stacked", "xAccessor": "x", "accessors": []string{"y"}, "splitAccessor": "g"}),
+		lens("twamp-sla-table", "TWAMP SLA per "+k.groupBy, "lnsDatatable",
+			map[string]map[string]interface{}{
+				"g": groupColumn(500, "a"), "a": percent(average("Availability", "available")),
+				"p": percentile("RTT p95 (µs)", "rtt_mean", 95), "l": average("Loss %", "loss_pct"),
+			},
+			[]string{"g", "a", "p", "l"},
+			map[string]interface{}{"columns": []map[string]string{{"columnId": "g"}, {"columnId": "a"}, {"columnId": "p"}, {"columnId": "l"}}}),
+	}
+
+	// 12 칸 metric 셋, 그 아래 48 칸 폭 차트
+	grid := [][4]int{{0, 0, 16, 8}, {16, 0, 16, 8}, {32, 0, 16, 8}, {0, 8, 48, 14}, {0, 22, 48, 14}, {0, 36, 48, 14}, {0, 50, 48, 16}}
+	var panels []map[string]interface{}
+	var refs []kibanaReference
+	for i, v := range visualizations {
+		name := fmt.Sprintf("panel_%d", i)
+		g := grid[i]
+		panels = append(panels, map[string]interface{}{
+			"panelIndex": fmt.Sprint(i + 1), "type": "lens", "panelRefName": name, "embeddableConfig": map[string]interface{}{},
+			"gridData": map[string]interface{}{"x": g[0], "y": g[1], "w": g[2], "h": g[3], "i": fmt.Sprint(i + 1)},
+		})
+		refs = append(refs, kibanaReference{Type: "lens", ID: v.ID, Name: name})
+	}
+	panelsJSON, _ := json.Marshal(panels)
+	dashboard := kibanaObject{
+		Type: "dashboard", ID: "twamp-overview", References: refs,
+		Attributes: map[string]interface{}{
+			"title":                 "TWAMP overview",
+			"description":           "Availability, delay and loss from twamp (KPI_ENRICH fields)",
+			"panelsJSON":            string(panelsJSON),
+			"optionsJSON":           `{"useMargins":true,"syncColors":false,"hidePanelTitles":false}`,
+			"timeRestore":           true,
+			"timeFrom":              "now-24h",
+			"timeTo":                "now",
+			"kibanaSavedObjectMeta": map[string]string{"searchSourceJSON": `{"query":{"query":"","language":"kuery"},"filter":[]}`},
+		},
+	}
+	return append(append([]kibanaObject{dataView}, visualizations...), dashboard)
+}
+
+func provisionKibana(config Config, index, groupBy string, print bool) error {
+	k := newKibanaProvisioner(config, index, groupBy)
+	if print {
+		return k.print(os.Stdout)
+	}
+	if config.KibanaURL == "" {
+		return fmt.Errorf("KIBANA_URL is required, or -print the saved objects")
+	}
+	return k.provision()
+}
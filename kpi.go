@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// stageSink runs each record through a processing stage before handing it
+// to the next sink. A stage returning nil drops the record.
+type stageSink struct {
+	next  recordSink
+	stage func(Record) Record
+}
+
+func withStage(next recordSink, stage func(Record) Record) recordSink {
+	return &stageSink{next: next, stage: stage}
+}
+
+func (s *stageSink) Add(rec Record) error {
+	if rec = s.stage(rec); rec == nil {
+		return nil
+	}
+	return s.next.Add(rec)
+}
+
+func (s *stageSink) Flush() error { return s.next.Flush() }
+func (s *stageSink) Close() error { return s.next.Close() }
+
+// kpiEnricher adds derived TWAMP KPIs so dashboards don't need scripted
+// fields. Fields the source already provides (e.g. from `twamp sender`)
+// are left alone.
+//
+//	rtt_min/mean/max        ul_d* + dl_d* (two-way delay)
+//	ul/dl/rtt_jitter        RFC 3550 estimator over successive stat rounds
+//	ul/dl_loss_pct,loss_pct lost / (received + lost) * 100
+//	available               1 if packets came back and loss_pct < threshold
+type kpiEnricher struct {
+	unavailableLossPct float64
+
+	mu       sync.Mutex
+	sessions map[string]*kpiSession
+}
+
+type kpiSession struct {
+	last   map[string]float64 // 직전 stat round 의 평균 지연 (방향별)
+	jitter map[string]float64
+}
+
+func newKPIEnricher(unavailableLossPct float64) *kpiEnricher {
+	return &kpiEnricher{unavailableLossPct: unavailableLossPct, sessions: make(map[string]*kpiSession)}
+}
+
+// SetUnavailableLossPct changes the availability threshold (config reload).
+func (k *kpiEnricher) SetUnavailableLossPct(pct float64) {
+	k.mu.Lock()
+	k.unavailableLossPct = pct
+	k.mu.Unlock()
+}
+
+func (k *kpiEnricher) Enrich(rec Record) Record {
+	for _, stat := range []string{"min", "mean", "max"} {
+		ul, ok1 := number(rec, "ul_d"+stat)
+		dl, ok2 := number(rec, "dl_d"+stat)
+		if ok1 && ok2 {
+			setMissing(rec, "rtt_"+stat, ul+dl)
+		}
+	}
+
+	k.jitter(rec)
+
+	var lost, total float64
+	for _, dir := range []string{"ul_", "dl_"} {
+		l, ok1 := number(rec, dir+"lostpkts")
+		rx, ok2 := number(rec, dir+"rxpkts")
+		if !ok1 || !ok2 {
+			continue
+		}
+		if l+rx > 0 {
+			setMissing(rec, dir+"loss_pct", l*100/(l+rx))
+		}
+		lost, total = lost+l, total+l+rx
+	}
+	if total > 0 {
+		setMissing(rec, "loss_pct", lost*100/total)
+	} else if p, ok := number(rec, "lostperc"); ok {
+		setMissing(rec, "loss_pct", p)
+	}
+
+	k.mu.Lock()
+	threshold := k.unavailableLossPct
+	k.mu.Unlock()
+	if loss, ok := number(rec, "loss_pct"); ok {
+		rx, hasRx := number(rec, "rxpkts")
+		if !hasRx {
+			ul, _ := number(rec, "ul_rxpkts")
+			dl, _ := number(rec, "dl_rxpkts")
+			rx = ul + dl
+		}
+		available := 0
+		if rx > 0 && loss < threshold {
+			available = 1
+		}
+		setMissing(rec, "available", available)
+	}
+	return rec
+}
+
+// kpiSeries are the KPIs the metrics outputs (remote_write, OTLP) export:
+// twamp_<name>_<unit> in Prometheus, twamp.<name> in OpenTelemetry, with
+// label=value telling the series of one metric apart. Delays are
+// microseconds in the records and seconds in the metrics.
+var kpiSeries = []struct {
+	field, name, unit, label, value string
+	scale                           float64
+}{
+	{"rtt_min", "rtt", "seconds", "stat", "min", 1e-6},
+	{"rtt_mean", "rtt", "seconds", "stat", "mean", 1e-6},
+	{"rtt_max", "rtt", "seconds", "stat", "max", 1e-6},
+	{"ul_jitter", "jitter", "seconds", "direction", "ul", 1e-6},
+	{"dl_jitter", "jitter", "seconds", "direction", "dl", 1e-6},
+	{"rtt_jitter", "jitter", "seconds", "direction", "rtt", 1e-6},
+	{"ul_loss_pct", "loss", "percent", "direction", "ul", 1},
+	{"dl_loss_pct", "loss", "percent", "direction", "dl", 1},
+	{"loss_pct", "loss", "percent", "direction", "both", 1},
+	{"available", "available", "", "", "", 1},
+}
+
+// jitter keeps a per-session RFC 3550 running estimate of the variation of
+// the mean delay between stat rounds.
+func (k *kpiEnricher) jitter(rec Record) {
+	id, ok := rec["session_id"]
+	if !ok {
+		return
+	}
+	key := fmt.Sprint(id)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s, ok := k.sessions[key]
+	if !ok {
+		s = &kpiSession{last: make(map[string]float64), jitter: make(map[string]float64)}
+		k.sessions[key] = s
+	}
+	for _, dir := range []string{"ul", "dl", "rtt"} {
+		d, ok := number(rec, dir+"_dmean")
+		if dir == "rtt" {
+			d, ok = number(rec, "rtt_mean")
+		}
+		if !ok {
+			continue
+		}
+		if prev, seen := s.last[dir]; seen {
+			s.jitter[dir] += (math.Abs(d-prev) - s.jitter[dir]) / 16
+			setMissing(rec, dir+"_jitter", s.jitter[dir])
+		}
+		s.last[dir] = d
+	}
+}
+
+func number(rec Record, key string) (float64, bool) {
+	return numericValue(rec[key])
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func setMissing(rec Record, key string, v interface{}) {
+	if _, ok := rec[key]; !ok {
+		rec[key] = v
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// 컴포넌트별 logger. setupLogging 전까지는 기본 text 핸들러로 기록된다.
+var (
+	logIngest  = slog.Default()
+	logES      = slog.Default()
+	logKafka   = slog.Default()
+	logOutput  = slog.Default()
+	logAlert   = slog.Default()
+	logNotify  = slog.Default()
+	logConfig  = slog.Default()
+	logHTTP    = slog.Default()
+	logTWAMP   = slog.Default()
+	logRollup  = slog.Default()
+	logDeadLtr = slog.Default()
+	logJanitor = slog.Default()
+	logS3      = slog.Default()
+	logPull    = slog.Default()
+	logGRPC    = slog.Default()
+	logInvent  = slog.Default()
+	logGeoIP   = slog.Default()
+	logWAL     = slog.Default()
+	logReport  = slog.Default()
+)
+
+// logDocuments turns on the per-document debug records (LOG_DOCUMENTS).
+var logDocuments bool
+
+// logLevels holds the level of every component logger, and of the default
+// one as "default", so the admin API can change them at runtime.
+var logLevels = map[string]*slog.LevelVar{}
+
+// setupLogging installs the LOG_FORMAT handler at LOG_LEVEL, writing to
+// stderr or LOG_FILE, and derives one logger per component; LOG_LEVELS
+// overrides the level per component, e.g. "elasticsearch=debug,kafka=warn".
+// The standard log package goes through the same handler so nothing is
+// written unstructured.
+func setupLogging(config Config) error {
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	overrides := map[string]slog.Level{}
+	for _, item := range splitList(config.LogLevels) {
+		name, lvl, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("LOG_LEVELS: %q is not component=level", item)
+		}
+		if overrides[strings.TrimSpace(name)], err = parseLogLevel(lvl); err != nil {
+			return fmt.Errorf("LOG_LEVELS: %w", err)
+		}
+	}
+
+	var out io.Writer = os.Stderr
+	if config.LogFile != "" {
+		f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("LOG_FILE: %w", err)
+		}
+		out = f
+	}
+	newHandler := func(lvl slog.Leveler) (slog.Handler, error) {
+		opts := &slog.HandlerOptions{Level: lvl}
+		switch strings.ToLower(config.LogFormat) {
+		case "", "text":
+			return slog.NewTextHandler(out, opts), nil
+		case "json":
+			return slog.NewJSONHandler(out, opts), nil
+		}
+		return nil, fmt.Errorf("LOG_FORMAT: unknown format %q (text, json)", config.LogFormat)
+	}
+	def := new(slog.LevelVar)
+	def.Set(level)
+	base, err := newHandler(def)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(base))
+	levels := map[string]*slog.LevelVar{"default": def}
+
+	for name, l := range map[string]**slog.Logger{
+		"ingest": &logIngest, "elasticsearch": &logES, "kafka": &logKafka,
+		"output": &logOutput, "alert": &logAlert, "notify": &logNotify,
+		"config": &logConfig, "http": &logHTTP, "twamp": &logTWAMP,
+		"rollup": &logRollup, "deadletter": &logDeadLtr, "janitor": &logJanitor,
+		"s3": &logS3, "pull": &logPull, "grpc": &logGRPC, "inventory": &logInvent,
+		"geoip": &logGeoIP, "wal": &logWAL, "report": &logReport,
+	} {
+		v := new(slog.LevelVar)
+		v.Set(level)
+		if lvl, ok := overrides[name]; ok {
+			v.Set(lvl)
+		}
+		h, _ := newHandler(v)
+		*l = slog.New(h).With("component", name)
+		levels[name] = v
+	}
+	logLevels = levels
+	logDocuments = config.LogDocuments
+	return nil
+}
+
+// setLogLevel changes the level of one component, or of every logger when
+// component is empty.
+func setLogLevel(component, level string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if component == "" {
+		for _, v := range logLevels {
+			v.Set(lvl)
+		}
+		return nil
+	}
+	v, ok := logLevels[component]
+	if !ok {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	v.Set(lvl)
+	return nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return lvl, err
+}
+
+// fatal logs at error level and exits; only for startup failures.
+func fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,179 +1,1506 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"compress/gzip"
 	"context"
-	"crypto/tls"
 	"encoding/csv"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/elastic/go-elasticsearch/esapi"
 	elasticsearch "github.com/elastic/go-elasticsearch/v8"
 	"github.com/fsnotify/fsnotify"
 	"github.com/joho/godotenv"
 )
 
-type TwampRecord struct {
-	SessionID       int    `json:"session_id"`
-	SourcePort      int    `json:"source_port"`
-	DestinationPort int    `json:"destination_port"`
-	Interval        int    `json:"interval"`
-	PacketRate      int    `json:"packet_rate"`
-	PacketSize      int    `json:"packet_size"`
-	StatRound       int    `json:"stat_round"`
-	IntervalMs      int    `json:"interval_ms"`
-	SyncStatus      int    `json:"sync_status"`
-	Timestamp       string `json:"@timestamp"`
-	AlarmID         string `json:"alarmid"`
-	// other fields as needed
-}
-
 func main() {
-	err := godotenv.Load(".env")
+	// Windows 서비스로 시작됐으면 SCM 에 연결 (service_windows.go)
+	startService()
+	defer stopService()
+	configPath := flag.String("config", os.Getenv("TWAMP_CONFIG"), "YAML config file; environment variables and .env override it")
+	dryRun := flag.Bool("dry-run", false, "write the bulk payloads to DRY_RUN_OUTPUT instead of sending them (same as DRY_RUN=true)")
+	flag.Usage = usage
+	flag.Parse()
+	cmd, args, err := parseCommand(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(2)
+	}
+
+	// --config 를 쓰면 .env 는 선택 사항
+	err = godotenv.Load(".env")
+	if err != nil && *configPath == "" {
+		fatal(logConfig, "error loading .env file", "err", err)
+	}
 
+	cf := &configFile{}
+	if *configPath != "" {
+		if cf, err = loadConfigFile(*configPath); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+	}
+	// 환경 변수로 넘겨야 reload 때 다시 읽은 설정과 같다
+	if *dryRun {
+		os.Setenv("DRY_RUN", "true")
+	}
+	config := loadConfig()
+	config.Pipelines, config.Tenants = cf.Pipelines, cf.Tenants
+	tempSuffixes = splitList(config.WatchTempSuffixes)
+	if err := setupLogging(config); err != nil {
+		fatal(logConfig, "invalid logging settings", "err", err)
+	}
+	// 명령별 플래그와 인자 확인
+	fs := cmd.flags()
+	limit := new(int)
+	resume := new(bool)
+	backfill := &backfillRun{file: config.BackfillCheckpoint}
+	var grafana struct {
+		index, groupBy string
+		print          bool
+	}
+	var kibana struct {
+		index, groupBy string
+		print          bool
+	}
+	var ingest struct {
+		name  string
+		print bool
+	}
+	var report struct {
+		period, date, out string
+		email             bool
+	}
+	reindexing := &reindexRun{}
+	switch cmd.name {
+	case "validate":
+		fs.IntVar(limit, "limit", 0, "print at most this many records (0 = all)")
+	case "backfill":
+		fs.BoolVar(&backfill.Recursive, "recursive", config.WatchRecursive, "descend into subdirectories (default WATCH_RECURSIVE)")
+		fs.StringVar(&backfill.Since, "since", "", "only files dated on or after this day, YYYY-MM-DD")
+		fs.StringVar(&backfill.Until, "until", "", "only files dated on or before this day, YYYY-MM-DD")
+		fs.IntVar(&backfill.Concurrency, "concurrency", config.Workers, "files ingested at once (default WORKERS)")
+		fs.BoolVar(resume, "resume", false, "continue the interrupted backfill saved in BACKFILL_CHECKPOINT")
+	case "provision-grafana":
+		fs.StringVar(&grafana.index, "index", "", "index pattern of the datasource (default ES_INDEX's)")
+		fs.StringVar(&grafana.groupBy, "group-by", "session_name", "field the dashboards group by, e.g. link_name with an inventory")
+		fs.BoolVar(&grafana.print, "print", false, "write the datasource and dashboards JSON to stdout instead")
+	case "provision-kibana":
+		fs.StringVar(&kibana.index, "index", "", "index pattern of the data view (default ES_INDEX's)")
+		fs.StringVar(&kibana.groupBy, "group-by", "session_name", "field the visualizations group by, e.g. link_name with an inventory")
+		fs.BoolVar(&kibana.print, "print", false, "write the saved objects as .ndjson to stdout instead, for Saved objects > Import")
+	case "provision-ingest-pipeline":
+		ingest.name = config.ESPipeline
+		if ingest.name == "" {
+			ingest.name = defaultIngestPipeline
+		}
+		fs.StringVar(&ingest.name, "name", ingest.name, "pipeline name (default ES_PIPELINE, or twamp)")
+		fs.BoolVar(&ingest.print, "print", false, "write the pipeline JSON to stdout instead, for PUT _ingest/pipeline/<name>")
+	case "report":
+		fs.StringVar(&report.period, "period", "daily", "daily, or weekly for Monday to Sunday")
+		fs.StringVar(&report.date, "date", "", "a day in the period, YYYY-MM-DD (default the last complete period)")
+		fs.StringVar(&config.ReportFormat, "format", config.ReportFormat, "html or pdf (default REPORT_FORMAT)")
+		fs.StringVar(&report.out, "out", "", "output file, - for stdout (default REPORT_DIR/sla-<period>-<date>.<format>)")
+		fs.BoolVar(&report.email, "email", false, "also mail the report through REPORT_EMAIL_CHANNEL")
+	case "reindex":
+		fs.StringVar(&reindexing.Source, "source", "", "index, alias or pattern to read, e.g. twamp-data-* (required)")
+		fs.StringVar(&reindexing.Format, "source-format", config.DocumentFormat, "DOCUMENT_FORMAT the source documents were indexed with, raw or ecs")
+		fs.StringVar(&reindexing.Mapping, "source-mapping", "", "MAPPING_FILE the source documents were indexed with, to undo its renames and conversions")
+		fs.StringVar(&reindexing.Since, "since", "", "only documents on or after this day, YYYY-MM-DD")
+		fs.StringVar(&reindexing.Until, "until", "", "only documents on or before this day, YYYY-MM-DD")
+		fs.IntVar(&reindexing.Size, "size", 1000, "documents per scroll page")
+	}
+	fs.Parse(args)
+	args = fs.Args()
+	switch cmd.name {
+	case "backfill":
+		if *resume {
+			exactArgs(fs, 0)
+		} else {
+			exactArgs(fs, 1)
+		}
+		if backfill, err = backfill.resume(fs, *resume, args); err != nil {
+			fatal(logIngest, "backfill failed", "err", err)
+		}
+		// 대기열 없이: 중단하면 처리 중인 파일만 끝내고 나머지는 -resume 으로
+		config.Workers, config.QueueSize = backfill.Concurrency, 0
+	case "validate":
+		exactArgs(fs, 1)
+	case "state":
+		if fs.NArg() > 1 {
+			exactArgs(fs, 1)
+		}
+	case "report":
+		exactArgs(fs, 0)
+		if report.period != "daily" && report.period != "weekly" {
+			fatal(logReport, "-period must be daily or weekly", "period", report.period)
+		}
+	case "reindex":
+		exactArgs(fs, 0)
+		if reindexing.Source == "" || reindexing.Size <= 0 || reindexing.Format != "raw" && reindexing.Format != "ecs" {
+			fs.Usage()
+			os.Exit(2)
+		}
+		if reindexing.Mapping != "" {
+			if reindexing.mapping, err = loadFieldMapping(reindexing.Mapping); err != nil {
+				fatal(logConfig, "invalid -source-mapping", "err", err)
+			}
+		}
+		// 원본 문서만 ES_INDEX 로 다시 쓴다; 집계/알람 등은 이미 이 행들을 봤다
+		config.Output, config.WALDir, config.NotifyFile = "elasticsearch", "", ""
+		config.RollupEnabled, config.AlertsFile, config.AnomalyDetection, config.AlarmCorrelation = false, "", false, false
+		config.SampleEvery, config.SampleInterval, config.CorrelateDirections = 1, 0, false
+		reindexing.kpi = config.KPIEnrich
+	default:
+		exactArgs(fs, 0)
+	}
+	if cmd.name != "state" && cmd.name != "validate" {
+		if err := config.validate(cmd.name == "watch"); err != nil {
+			fatal(logConfig, "invalid configuration", "err", err)
+		}
+	}
+
+	gzipReadAhead.blocks, gzipReadAhead.blockSize = config.GzipBlocks, config.GzipBlockSize
+
+	ts, err := newTimestampParser(config)
+	if err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
+	schema, err := newSchema(config.SchemaFile, cf.SchemaFields, ts)
+	if err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
+	// 설정 reload 시 교체된다; 처리 중인 파일은 시작할 때의 스키마를 쓴다
+	var schemaRef atomic.Pointer[Schema]
+	schemaRef.Store(schema)
+	parse, err := lookupParser(config.Parser, config.csvOptions())
+	if err == nil {
+		parse, err = withCharset(parse, config.Charset)
+	}
+	if err != nil {
+		fatal(logConfig, "invalid parser settings", "err", err)
+	}
+	var validation *validationRules
+	if config.ValidationFile != "" {
+		if validation, err = loadValidationRules(config.ValidationFile); err != nil {
+			fatal(logConfig, "invalid validation file", "err", err)
+		}
+	}
+	pipes, err := newPipelines(config, schema, ts)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		fatal(logConfig, "invalid pipelines", "err", err)
+	}
+	tenants, err := newTenants(config)
+	if err != nil {
+		fatal(logConfig, "invalid tenants", "err", err)
 	}
 
-	directoryPath := os.Getenv("FILE_PATH")
-	esURL := os.Getenv("ES_SERVER")
-	esUser := os.Getenv("ES_USER")
-	esPassword := os.Getenv("ES_PASSWORD")
+	// twamp validate <file>: 색인하지 않고 변환된 레코드를 stdout 에 출력
+	if cmd.name == "validate" {
+		if p := pipes.match(args[0]); p != nil {
+			schema, parse = p.schema, p.parse
+		}
+		var sink recordSink = newPrintSink(os.Stdout, *limit)
+		if len(tenants) > 0 {
+			sink = tenants.fileSink(withStage(sink, tenants.tag), args[0])
+		}
+		if config.KPIEnrich {
+			sink = withStage(sink, newKPIEnricher(config.KPIUnavailableLossPct).Enrich)
+		}
+		if config.OWDTimestampFields != "" {
+			sink = withStage(sink, newOWDEnricher(config).Enrich)
+		}
+		if config.CorrelateDirections {
+			sink = newCorrelateSink(sink, config)
+		}
+		if _, err := processFile(context.Background(), sink, schema, parse, args[0], fileCheckpoint{}, rowPolicy{Validate: validation, Timeout: config.FileTimeout}); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// twamp provision-grafana: datasource 와 dashboard 를 만들고 종료
+	if cmd.name == "provision-grafana" {
+		if grafana.index == "" {
+			index, err := parseIndexTemplate(config.dataIndex())
+			if err != nil {
+				fatal(logConfig, "invalid ES_INDEX", "err", err)
+			}
+			grafana.index = index.Pattern()
+		}
+		if err := provisionGrafana(config, grafana.index, grafana.groupBy, grafana.print); err != nil {
+			fatal(logHTTP, "provisioning Grafana failed", "err", err)
+		}
+		return
+	}
+
+	// twamp provision-kibana: saved objects 를 넣고 종료
+	if cmd.name == "provision-kibana" {
+		if kibana.index == "" {
+			index, err := parseIndexTemplate(config.dataIndex())
+			if err != nil {
+				fatal(logConfig, "invalid ES_INDEX", "err", err)
+			}
+			kibana.index = index.Pattern()
+		}
+		if err := provisionKibana(config, kibana.index, kibana.groupBy, kibana.print); err != nil {
+			fatal(logHTTP, "provisioning Kibana failed", "err", err)
+		}
+		return
+	}
 
+	tlsConfig, err := esTLSConfig(config)
+	if err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
 	cfg := elasticsearch.Config{
-		Addresses: []string{esURL},
-		Username:  esUser,
-		Password:  esPassword,
+		CloudID:      config.ESCloudID,
+		Username:     config.ESUser,
+		Password:     config.ESPassword,
+		APIKey:       config.ESAPIKey,
+		ServiceToken: config.ESToken,
+		// WAN 구간 대역폭 절감: 요청은 gzip 으로 보내고 응답은 Transport 가 gzip 으로 받는다
+		CompressRequestBody:      config.ESCompress,
+		CompressRequestBodyLevel: config.ESCompressLevel,
+		PoolCompressor:           config.ESCompress,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
 		},
 	}
+	if config.openSearch() {
+		cfg.Transport = openSearchTransport{next: cfg.Transport}
+		if config.ILMPolicy != "" {
+			logES.Info("OpenSearch has no ILM, ILM_POLICY is ignored (manage retention with ISM)", "policy", config.ILMPolicy)
+		}
+	}
+	// Cloud ID 를 쓰면 주소는 client 가 Cloud ID 에서 만든다
+	if config.ESServer != "" {
+		cfg.Addresses = []string{config.ESServer}
+	}
 
 	es, err := elasticsearch.NewClient(cfg)
 	if err != nil {
-		log.Printf("Error createing Elasticsearch client: %s", err)
+		logES.Error("error creating Elasticsearch client", "err", err)
+	}
+	// 다른 cluster/인증용 client: TLS 는 같고, 인증은 따로 주지 않으면 ES_* 것
+	esClient := func(server, user, password, apiKey string) (*elasticsearch.Client, error) {
+		c := cfg
+		if server != "" {
+			c.CloudID, c.Addresses = "", []string{server}
+		}
+		if user != "" || apiKey != "" {
+			c.Username, c.Password, c.APIKey, c.ServiceToken = user, password, apiKey, ""
+		}
+		return elasticsearch.NewClient(c)
+	}
+	var secondary *elasticsearch.Client
+	if config.ESSecondaryServer != "" {
+		if secondary, err = esClient(config.ESSecondaryServer, config.ESSecondaryUser, config.ESSecondaryPassword, config.ESSecondaryAPIKey); err != nil {
+			fatal(logES, "error creating the secondary Elasticsearch client", "err", err)
+		}
+	}
+
+	// twamp report: SLA 보고서를 쓰고 종료
+	if cmd.name == "report" {
+		reporter, err := newSLAReporter(es, config)
+		if err != nil {
+			fatal(logReport, "invalid report settings", "err", err)
+		}
+		period := reporter.lastPeriod(report.period, time.Now())
+		if report.date != "" {
+			day, err := time.ParseInLocation("2006-01-02", report.date, reporter.loc)
+			if err != nil {
+				fatal(logReport, "-date must be YYYY-MM-DD", "date", report.date)
+			}
+			period = reporter.periodOf(report.period, day)
+		}
+		if _, err := reporter.generate(context.Background(), period, report.out, report.email); err != nil {
+			fatal(logReport, "report failed", "err", err)
+		}
+		return
+	}
+
+	// twamp provision-ingest-pipeline: ingest pipeline 을 넣고 종료
+	if cmd.name == "provision-ingest-pipeline" {
+		clusters := []*elasticsearch.Client{es}
+		if secondary != nil {
+			clusters = append(clusters, secondary)
+		}
+		if err := provisionIngestPipeline(context.Background(), clusters, ingest.name, config, ingest.print); err != nil {
+			fatal(logES, "provisioning the ingest pipeline failed", "err", err)
+		}
+		return
+	}
+
+	dlq, err := newDeadLetterQueue(config.DeadLetterDir)
+	if err != nil {
+		fatal(logIngest, "startup failed", "err", err)
 	}
+	defer dlq.Close()
 
-	watcher, err := fsnotify.NewWatcher()
+	indexer, err := newBulkIndexer(es, dlq, config)
 	if err != nil {
-		log.Fatal("Watcher 생성 에러: ", err)
+		fatal(logIngest, "startup failed", "err", err)
+	}
+	limiter := newBulkLimiter(config)
+	indexer.limit = limiter
+	// _id/_routing 템플릿은 원본 문서에만, rollup 등은 자기 규칙을 쓴다
+	if indexer.idTemplate, err = parseRecordTemplate("ES_ID_TEMPLATE", config.ESIDTemplate); err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
+	if indexer.routing, err = parseRecordTemplate("ES_ROUTING_TEMPLATE", config.ESRouting); err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
+	indexer.pipeline = config.ESPipeline
+
+	// dry run: OUTPUT 대신 bulk payload 를 DRY_RUN_OUTPUT 으로 (kafka 등에는 연결하지 않는다)
+	var dry *dryRunWriter
+	var outputs []Output
+	if config.DryRun && cmd.name != "state" {
+		if dry, err = newDryRunWriter(config.DryRunOutput); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		defer dry.Close()
+		indexer.dryRun = dry
+		outputs = []Output{indexer}
+		logIngest.Warn("dry run: writing bulk payloads instead of sending them", "output", config.DryRunOutput)
+	} else if outputs, err = newOutputs(config, indexer); err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
+	// 인증서 설정 오류는 색인 시점이 아니라 시작할 때 드러나게
+	if hasOutput(outputs, indexer) && dry == nil && cmd.name != "state" {
+		if err := checkTLSHandshake(config.ESServer, tlsConfig); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+	}
+	// 색인하는 cluster 들: index template 은 양쪽에 만든다
+	clusters := []*elasticsearch.Client{es}
+	var failover *esFailover
+	if secondary != nil && hasOutput(outputs, indexer) && dry == nil && cmd.name != "state" {
+		if err := checkTLSHandshake(config.ESSecondaryServer, tlsConfig); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		if failover, err = newESFailover(es, secondary, config); err != nil {
+			fatal(logES, "startup failed", "err", err)
+		}
+		indexer.failover = failover
+		clusters = append(clusters, secondary)
+	}
+	if config.SpoolDir != "" && hasOutput(outputs, indexer) && dry == nil && cmd.name != "state" {
+		if indexer.spool, err = openDiskSpool(config.SpoolDir, config.SpoolMaxBytes, metricSpoolBytes, metricSpoolRequests); err != nil {
+			fatal(logES, "startup failed", "err", err)
+		}
+	}
+	var mapping *fieldMapping
+	if config.MappingFile != "" {
+		if mapping, err = loadFieldMapping(config.MappingFile); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+	}
+	fieldsInclude, fieldsExclude := splitList(config.FieldsInclude), splitList(config.FieldsExclude)
+	pipes.selectFields(mapping, fieldsInclude, fieldsExclude)
+	mapping = mapping.withFields(fieldsInclude, fieldsExclude)
+	if delay := delayConversion(config); delay != nil {
+		pipes.addConversion(delay)
+		mapping = mapping.withConversion(delay)
+	}
+	// write alias 의 rollover 는 ILM 이 없으면 직접 한다
+	var rollover *indexRollover
+	if config.ESWriteAlias != "" && hasOutput(outputs, indexer) && dry == nil && cmd.name != "state" {
+		var noILM bool
+		if config.ILMRollover, noILM = rolloverMode(context.Background(), es, config); noILM {
+			config.ILMPolicy = ""
+		}
+		if config.ILMRollover == "self" {
+			rollover = newIndexRollover(clusters, config)
+		}
+		logES.Info("writing through alias", "alias", config.ESWriteAlias, "rollover", config.ILMRollover)
+	}
+	// 매핑/ILM 초기화: 실패해도 색인은 계속 (dynamic mapping 으로 들어감)
+	if config.ESBootstrap && hasOutput(outputs, indexer) && dry == nil && cmd.name != "state" {
+		for _, es := range clusters {
+			if err := bootstrapTemplate(context.Background(), es, schema, mapping, indexer.index, config); err != nil {
+				logES.Error("error bootstrapping index template", "err", err)
+			}
+			// pipeline 별 index 는 그 pipeline 의 스키마/매핑으로 템플릿을 따로 만든다
+			for _, p := range pipes {
+				if p.index == nil {
+					continue
+				}
+				pm := mapping
+				if p.mapping != nil {
+					pm = p.mapping
+				}
+				pc := config
+				pc.ESTemplateName, pc.ESWriteAlias = config.ESTemplateName+"-"+p.Name, ""
+				if err := bootstrapTemplate(context.Background(), es, p.schema, pm, p.index, pc); err != nil {
+					logES.Error("error bootstrapping index template", "pipeline", p.Name, "err", err)
+				}
+			}
+		}
+	}
+	if config.ClickHouseBootstrap && dry == nil && cmd.name != "state" {
+		for _, o := range outputs {
+			if ch, ok := o.(*ClickHouseOutput); ok {
+				if err := ch.bootstrap(context.Background(), schema, mapping, config); err != nil {
+					logOutput.Error("error bootstrapping clickhouse table", "err", err)
+				}
+			}
+		}
+	}
+	if dry == nil && cmd.name != "state" {
+		for _, o := range outputs {
+			if pg, ok := o.(*PostgresOutput); ok {
+				if err := pg.prepare(context.Background(), schema, mapping, config); err != nil {
+					logOutput.Error("error preparing postgres table", "err", err)
+				}
+			}
+			if pq, ok := o.(*ParquetOutput); ok {
+				pq.prepare(schema, mapping, config)
+			}
+		}
+	}
+	indexer.pipelineIndex = pipes.indexes()
+	mainOut := newFanOut(outputs, config)
+	fanOuts := []*fanOut{mainOut}
+	var sink recordSink = mainOut
+	// 자기 index/인증/cluster 가 있는 tenant 는 Elasticsearch output 을 따로 둔다
+	if hasOutput(outputs, indexer) && cmd.name != "state" {
+		routed := false
+		for _, t := range tenants {
+			if !t.routed() {
+				continue
+			}
+			client, limit, tfailover := es, limiter, failover
+			if t.Server != "" || t.ownCredentials() {
+				if client, err = esClient(t.Server, t.User, t.Password, t.APIKey); err != nil {
+					fatal(logES, "error creating Elasticsearch client", "tenant", t.Name, "err", err)
+				}
+				tfailover = nil
+			}
+			if t.Server != "" {
+				limit = newBulkLimiter(config)
+			}
+			tc := config
+			if t.Index != "" {
+				tc.ESIndex, tc.ESWriteAlias = t.Index, ""
+			}
+			if t.indexer, err = newBulkIndexer(client, dlq, tc); err != nil {
+				fatal(logIngest, "startup failed", "tenant", t.Name, "err", err)
+			}
+			t.indexer.name = "elasticsearch/" + t.Name
+			t.indexer.idTemplate, t.indexer.routing, t.indexer.pipeline = indexer.idTemplate, indexer.routing, indexer.pipeline
+			t.indexer.dryRun, t.indexer.limit, t.indexer.failover = dry, limit, tfailover
+			if t.Index == "" {
+				t.indexer.pipelineIndex = indexer.pipelineIndex
+			}
+			if config.SpoolDir != "" && dry == nil {
+				if t.indexer.spool, err = openDiskSpool(filepath.Join(config.SpoolDir, "tenant-"+t.Name), config.SpoolMaxBytes, nil, nil); err != nil {
+					fatal(logES, "startup failed", "tenant", t.Name, "err", err)
+				}
+			}
+			if config.ESBootstrap && dry == nil && (t.Index != "" || t.Server != "") {
+				tc.ESTemplateName = config.ESTemplateName
+				if t.Index != "" {
+					tc.ESTemplateName += "-" + t.Name
+				}
+				if err := bootstrapTemplate(context.Background(), client, schema, mapping, t.indexer.index, tc); err != nil {
+					logES.Error("error bootstrapping index template", "tenant", t.Name, "err", err)
+				}
+			}
+			t.out = newFanOut([]Output{t.indexer}, config)
+			fanOuts = append(fanOuts, t.out)
+			routed = true
+		}
+		if routed {
+			indexer.skip = tenants.routedRecord
+			sink = newTenantRouter(sink, tenants)
+		}
+	}
+	// tenant 로 나누지 않으면 레코드는 mainOut 에만 간다: 쓰고 난 map 은 Convert 가 다시 쓴다.
+	// 아래 단계들은 레코드를 넘긴 뒤에 들고 있지 않는다 (correlate 는 짝을 새 레코드로 합친다)
+	if sink == recordSink(mainOut) && mainOut.recycleRecords() {
+		logIngest.Debug("recycling records", "output", outputs[0].Name())
+	}
+	// 파일의 레코드가 가는 output, 집계/알람 output 은 빼고
+	fileOuts := append([]*fanOut(nil), fanOuts...)
+	// ECS 변환과 이름 변경/제거/단위 변환은 원본 레코드에만, 다른 단계는 스키마 이름을 쓴다
+	if config.DocumentFormat == "ecs" {
+		sink = withStage(sink, toECS)
+	}
+	if m := pipes.mapper(mapping); m != nil {
+		sink = withStage(sink, m)
+	}
+	// 샘플링은 원본 문서에만, rollup 과 그 바깥 단계는 모든 행을 본다
+	if sampler := newSampler(config); sampler.enabled() {
+		if !config.RollupEnabled {
+			logIngest.Warn("SAMPLE_EVERY/SAMPLE_INTERVAL without ROLLUP_ENABLED, dropped rows are not aggregated anywhere")
+		}
+		sink = withStage(sink, sampler.Sample)
+	}
+	// newSideIndexer 는 집계/알람/이상 탐지 문서를 원본과 별도 인덱스로 보내는 output
+	newSideIndexer := func(name, index, duplicates string, docs *Schema, idField string) *fanOut {
+		sideConfig := config
+		sideConfig.ESIndex, sideConfig.ESDataStream, sideConfig.ESWriteAlias = index, false, ""
+		sideConfig.ESDuplicates = duplicates
+		sideIndexer, err := newBulkIndexer(es, dlq, sideConfig)
+		if err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		sideIndexer.dryRun, sideIndexer.limit, sideIndexer.idField = dry, limiter, idField
+		sideIndexer.failover = failover
+		if config.ESBootstrap && dry == nil && cmd.name == "watch" {
+			for _, es := range clusters {
+				if err := putIndexTemplate(context.Background(), es, index, sideIndexer.index.Pattern(), map[string]interface{}{}, docs.esMappings(), false); err != nil {
+					logES.Error("error bootstrapping "+name+" index template", "err", err)
+				}
+			}
+		}
+		out := newFanOut([]Output{sideIndexer}, config)
+		fanOuts = append(fanOuts, out)
+		return out
+	}
+	// 집계 문서는 원본과 별도 인덱스(ROLLUP_INDEX)로 색인
+	if config.RollupEnabled {
+		rollupOut := newSideIndexer("rollup", config.RollupIndex, "allow", rollupSchema(), "")
+		sink = newRollupSink(sink, rollupOut, config.RollupInterval)
+	}
+	if config.OTelTracesExporter == "otlp" && dry == nil && cmd.name != "state" {
+		if tracing, err = newTracer(config); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+	}
+	if config.NotifyFile != "" && dry == nil {
+		notifications, err = newNotifier(config.NotifyFile)
+		if err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		defer notifications.Close()
+	}
+	var alerts *alertSink
+	// ALERTS_FILE 의 임계치 규칙 평가, 알람은 ALERTS_INDEX 로
+	if config.AlertsFile != "" {
+		alertCfg, err := loadAlertConfig(config.AlertsFile)
+		if err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		alertOut := newSideIndexer("alerts", config.AlertsIndex, "allow", alertSchema(), "")
+		alerts, err = newAlertSink(sink, alertOut, alertCfg)
+		if err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		sink = alerts
+	}
+	// 세션별 기준선에서 벗어난 지연/손실, 문서는 ANOMALY_INDEX 로
+	if config.AnomalyDetection {
+		anomalyOut := newSideIndexer("anomaly", config.AnomalyIndex, "allow", anomalySchema(), "")
+		if sink, err = newAnomalySink(sink, anomalyOut, config); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+	}
+	// 장비 알람 ID 의 발생/해제와 측정 열화 구간, 상태 문서는 ALARMS_INDEX 로
+	if config.AlarmCorrelation {
+		alarmOut := newSideIndexer("alarms", config.AlarmsIndex, "overwrite", alarmSchema(), "alarm_key")
+		sink = newAlarmSink(sink, alarmOut, config)
+	}
+	var kpi *kpiEnricher
+	if config.KPIEnrich {
+		kpi = newKPIEnricher(config.KPIUnavailableLossPct)
+		sink = withStage(sink, kpi.Enrich)
+	}
+	if config.OWDTimestampFields != "" {
+		sink = withStage(sink, newOWDEnricher(config).Enrich)
+	}
+	if config.GeoIPCityDB != "" || config.GeoIPASNDB != "" {
+		geoip, err := newGeoIPEnricher(config.GeoIPCityDB, config.GeoIPASNDB)
+		if err != nil {
+			fatal(logGeoIP, "error loading GeoIP database", "err", err)
+		}
+		sink = withStage(sink, geoip.Enrich)
+	}
+	// 장비/사이트 정보는 가장 먼저 붙여서 알람/집계에서도 쓸 수 있게
+	var inventory *inventoryEnricher
+	if config.InventoryFile != "" || config.NetBoxURL != "" {
+		if inventory, err = newInventoryEnricher(config); err != nil {
+			fatal(logInvent, "error loading inventory", "err", err)
+		}
+		sink = withStage(sink, inventory.Enrich)
+	}
+	// 방향별 행은 다른 단계보다 먼저 ul_/dl_ 한 문서로 합친다
+	if config.CorrelateDirections {
+		sink = newCorrelateSink(sink, config)
+	}
+	// WAL 은 파서 바로 뒤: 재시작 후 재전송되는 레코드도 모든 단계를 다시 거친다
+	if config.WALDir != "" && dry == nil && cmd.name != "state" && cmd.name != "replay" {
+		wal, err := openWAL(sink, config.WALDir, config.WALMaxBytes, config.WALFsync, config.WALFsyncInterval)
+		if err != nil {
+			fatal(logWAL, "startup failed", "err", err)
+		}
+		if err := wal.replay(); err != nil {
+			logWAL.Error("error replaying WAL, the records stay for the next start", "err", err)
+		}
+		sink = wal
+	}
+	if len(tenants) > 0 {
+		sink = withStage(sink, tenants.tag)
+	}
+	defer sink.Close()
+	pipes.setSink(sink)
+
+	statePath := config.StateFile
+	if dry != nil {
+		statePath = ""
+	}
+	state, err := loadState(statePath)
+	if err != nil {
+		fatal(logIngest, "startup failed", "err", err)
+	}
+
+	// SIGTERM/SIGINT 를 받으면 ctx 가 취소된다. 두 번째 신호는 바로 종료.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := serviceContext(ctx)
+	defer cancel()
+	if inventory != nil {
+		go inventory.run(ctx, config.InventoryRefresh)
+	}
+	if indexer.spool != nil {
+		go indexer.drainSpool(ctx, config.SpoolRetryInterval)
+	}
+	if rollover != nil {
+		go rollover.run(ctx)
+	}
+	if failover != nil {
+		go failover.run(ctx)
+	}
+	for _, t := range tenants {
+		if t.indexer != nil && t.indexer.spool != nil {
+			go t.indexer.drainSpool(ctx, config.SpoolRetryInterval)
+		}
 	}
 
-	defer watcher.Close()
+	// state/replay 같은 일회성 명령은 HTTP 를 열지 않는다; backfill 은 /status 때문에 연다
+	health := newHealthChecker()
+	admin := newAdminAPI(config)
+	var srv *http.Server
+	if cmd.name == "watch" || cmd.name == "backfill" || cmd.name == "sender" || cmd.name == "reflector" {
+		srv = startHTTPServer(config.HTTPAddr, health, admin)
+		health.setOutputs(config.OutputStallTimeout, fanOuts...)
+		if hasOutput(outputs, indexer) && dry == nil {
+			active := func() (*elasticsearch.Client, string) { return es, clusterPrimary }
+			if failover != nil {
+				active = failover.client
+			}
+			go health.pollElasticsearch(ctx, active, config.HealthCheckInterval)
+		}
+	}
 
+	switch cmd.name {
+	// twamp state [status]: 처리 이력 조회
+	case "state":
+		var status fileStatus
+		if len(args) > 0 {
+			status = fileStatus(args[0])
+		}
+		printLedger(os.Stdout, state.Entries(status))
+		return
+	// twamp replay: 저장된 dead letter 재전송 후 종료
+	case "replay":
+		if dry != nil {
+			fatal(logDeadLtr, "replay can't be used with dry run, the dead letters would be removed")
+		}
+		if err := replayDeadLetters(ctx, config.DeadLetterDir, indexer, config.BulkSize); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		return
+	// twamp reindex: 기존 index 의 문서를 지금의 변환 단계로 ES_INDEX 에 다시 색인
+	case "reindex":
+		if indexer.index.Pattern() == reindexing.Source {
+			fatal(logES, "-source is ES_INDEX, reindex writes to it; point ES_INDEX at the new index")
+		}
+		n, err := reindex(ctx, es, sink, reindexing)
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fatal(logES, "reindex failed", "documents", n, "err", err)
+		}
+		logES.Info("reindex finished", "source", reindexing.Source, "documents", n, "index", config.dataIndex())
+		return
+	// twamp sender: TWAMP_TARGETS 로 직접 측정해서 색인
+	case "sender":
+		targets := parseTwampTargets(config.TwampTargets)
+		if len(targets) == 0 {
+			fatal(logTWAMP, "TWAMP_TARGETS is empty")
+		}
+		go runWatchdog(ctx, health)
+		sdNotify("READY=1")
+		runTwampSender(ctx, targets, config.TwampSender, sink.Add)
+		return
+	// twamp reflector: TWAMP Light reflector 로 동작
+	case "reflector":
+		go runWatchdog(ctx, health)
+		sdNotify("READY=1")
+		if err := runTwampReflector(ctx, config.TwampReflector, sink.Add); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		return
+	}
+
+	if cmd.name != "backfill" {
+		backfill = nil
+	} else if dry != nil {
+		backfill.file = "" // dry run: ledger 처럼 checkpoint 도 남기지 않는다
+	}
+	var failedFiles atomic.Int64
+	// 종료 신호를 받아도 처리 중인 파일은 SHUTDOWN_GRACE 안에서 끝까지 읽는다
+	fileCtx := context.WithoutCancel(ctx)
+	pool := newWorkerPool(config.Workers, config.QueueSize, func(path string) {
+		var fileErr error
+		defer func() { backfill.finish(path, fileErr) }()
+		ok, err := state.Begin(path)
+		if err != nil {
+			logIngest.Error("error reading file", "file", path, "err", err)
+			fileErr = err
+			return
+		}
+		if !ok {
+			logIngest.Info("already processed, skipping", "file", path)
+			return
+		}
+		ckpt := fileCheckpoint{Every: config.CheckpointRows}
+		ckpt.Offset, ckpt.Rows = state.Resume(path)
+		// 배치에는 동시에 읽는 파일들의 레코드가 섞여 있어서 실패한 write 가
+		// 어느 파일 것인지 모른다. 그래서 그동안 처리 중이던 파일은 모두 실패로
+		// 두고 다시 읽는다: _id 가 행에서 나오므로 이미 색인된 문서는 중복되지 않는다
+		failures, _ := outputFailures(fileOuts)
+		writeFailed := func() error {
+			if n, err := outputFailures(fileOuts); n > failures {
+				return fmt.Errorf("output write failed while the file was processed: %w", err)
+			}
+			return nil
+		}
+		ckpt.Commit = func(offset, rows int) error {
+			if err := writeFailed(); err != nil {
+				return err
+			}
+			return state.Checkpoint(path, offset, rows)
+		}
+		fileSink, fileSchema, fileParse := sink, schemaRef.Load(), parse
+		if p := pipes.match(path); p != nil {
+			fileSink, fileSchema, fileParse = p.sink, p.schema, p.parse
+		}
+		fileSink = tenants.fileSink(fileSink, path)
+		trace := tracing.file(path)
+		rows, err := processFile(fileCtx, fileSink, fileSchema, fileParse, path, ckpt, rowPolicy{RejectDir: config.RejectDir, Validate: validation, Trace: trace, Timeout: config.FileTimeout})
+		if err == nil {
+			if err = writeFailed(); err != nil {
+				logIngest.Error("error indexing batch", "file", path, "err", err)
+			}
+		}
+		if err != nil {
+			fileErr = err
+			failedFiles.Add(1)
+			notify(notification{
+				Event:    eventIngestFailed,
+				Severity: "critical",
+				Title:    "Ingestion failed: " + filepath.Base(path),
+				Text:     fmt.Sprintf("%s: %s (%d rows read)", path, err, rows),
+			})
+		}
+		var corrupt corruptFileError
+		if errors.As(err, &corrupt) && dry == nil {
+			if dest, qerr := quarantine(path, config.ErrorsDir); qerr != nil {
+				logIngest.Error("error quarantining file", "file", path, "err", qerr)
+			} else {
+				logIngest.Warn("file quarantined", "file", path, "to", dest)
+			}
+		}
+		if err := state.Finish(path, rows, err); err != nil {
+			logIngest.Error("error saving state", "file", path, "err", err)
+		}
+		trace.set("twamp.rows", rows)
+		trace.finish(err)
+	})
+	health.setWatching(pool.Len)
+	if config.ESWatermarkPause && hasOutput(outputs, indexer) && dry == nil {
+		watermarks := &diskWatermark{pool: pool, check: config.ESWatermarkCheck, active: func() (*elasticsearch.Client, string) { return es, clusterPrimary }}
+		if failover != nil {
+			watermarks.active = failover.client
+		}
+		go watermarks.run(ctx)
+	}
+
+	// twamp backfill <path>: 디렉토리나 파일 하나를 한 번 처리하고 종료.
+	// 날짜별 아카이브는 오래된 파일부터, 끊기면 -resume 으로 이어서
+	if cmd.name == "backfill" {
+		path := backfill.Path
+		info, err := os.Stat(path)
+		if err != nil {
+			fatal(logIngest, "backfill failed", "err", err)
+		}
+		since, err := parseBackfillDate("since", backfill.Since)
+		if err != nil {
+			fatal(logIngest, "backfill failed", "err", err)
+		}
+		until, err := parseBackfillDate("until", backfill.Until)
+		if err != nil {
+			fatal(logIngest, "backfill failed", "err", err)
+		}
+		plan := []backfillFile{{path: path, size: info.Size()}}
+		if info.IsDir() {
+			root, filter := path, newFileFilter(config.IncludePatterns, config.ExcludePatterns)
+			if p := pipes.match(path); p != nil {
+				root, filter = p.root, p.filter
+			}
+			if plan, err = planBackfill(root, path, backfill.Recursive, filter, since, until); err != nil {
+				fatal(logIngest, "backfill failed", "err", err)
+			}
+		}
+		admin.setPool(pool, state, func() []string { return []string{path} })
+		backfill.plan(plan, state)
+		files := backfill.pending()
+		logIngest.Info("backfilling", "path", path, "files", len(plan), "pending", len(files), "concurrency", config.Workers, "since", backfill.Since, "until", backfill.Until)
+		if err := backfill.save(); err != nil {
+			logIngest.Error("error saving backfill checkpoint", "err", err)
+		}
+		progress.setBackfill(backfill)
+		reportCtx, stopReport := context.WithCancel(context.Background())
+		go progress.run(reportCtx, config.ProgressInterval)
+		for _, path := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			pool.SubmitWait(path)
+		}
+		// 끝까지 기다린다; 신호를 받으면 처리 중인 파일은 SHUTDOWN_GRACE 안에서 마무리
+		drained := make(chan struct{})
+		go func() {
+			pool.Close()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+		stop()
+		shutdown(config.ShutdownGrace, srv, func() {
+			pool.Close()
+			if err := sink.Close(); err != nil {
+				logOutput.Error("error flushing outputs", "err", err)
+			}
+			tracing.Close()
+			dlq.Close()
+			if err := state.Save(); err != nil {
+				logIngest.Error("error saving state", "err", err)
+			}
+		})
+		stopReport()
+		progress.status().Backfill.log("backfill finished")
+		if err := backfill.complete(); err != nil {
+			logIngest.Error("error saving backfill checkpoint", "err", err)
+		}
+		if n := failedFiles.Load(); n > 0 {
+			logIngest.Error("backfill finished with failures", "files", len(files), "failed", n)
+			os.Exit(1)
+		}
+		return
+	}
+
+	go progress.run(ctx, config.ProgressInterval)
+	if config.ReportSchedule != "" {
+		reporter, err := newSLAReporter(es, config)
+		if err != nil {
+			fatal(logReport, "invalid report settings", "err", err)
+		}
+		go reporter.run(ctx, config.ReportSchedule, config.ReportAt)
+	}
+	// watchdog 은 READY 이후에만 적용되지만 backlog 처리 중에도 self-check 는 돈다
+	go runWatchdog(ctx, health)
+	sdNotify("STATUS=processing backlog")
+
+	// 데몬이 내려가 있던 동안 들어온 파일 먼저 처리
+	filter := newFileFilter(config.IncludePatterns, config.ExcludePatterns)
+	target := &watchTarget{root: config.FilePath, filter: filter}
+	admin.setPool(pool, state, func() []string {
+		root, _ := target.get()
+		roots := []string{root}
+		for _, p := range pipes {
+			roots = append(roots, p.root)
+		}
+		return roots
+	})
+	watchRoots := func() []watchRoot {
+		var roots []watchRoot
+		if root, filter := target.get(); root != "" {
+			roots = append(roots, watchRoot{root, config.WatchRecursive, filter})
+		}
+		for _, p := range pipes {
+			roots = append(roots, watchRoot{p.root, p.Recursive, p.filter})
+		}
+		return roots
+	}
+	roots := watchRoots()
+	for _, root := range roots {
+		backlog, err := scanBacklog(root.path, root.recursive, root.filter, state)
+		if err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
+		if len(backlog) > 0 {
+			logIngest.Info("found unprocessed files", "files", len(backlog), "path", root.path)
+		}
+		for _, path := range backlog {
+			if ctx.Err() != nil {
+				break
+			}
+			pool.SubmitWait(path)
+		}
+	}
+
+	watcher, err := newDirWatcher()
+	if err != nil {
+		fatal(logIngest, "error creating watcher", "err", err)
+	}
+	// fsnotify 가 알려준 파일은 크기가 멈춘 뒤에 처리 (업로드 중인 파일)
+	// notify 모드에서는 WATCH_RECONCILE 마다 놓친 파일을, 감시 한도를 넘은 디렉토리는 WATCH_POLL_INTERVAL 마다 스캔한다
+	rescan := config.WatchPollInterval
+	if config.WatchMode == "notify" {
+		rescan = config.WatchReconcile
+	}
+	poller := newDirPoller(watchRoots, state, pool, rescan, config.WatchSettleTime)
+	if config.WatchMode == "notify" {
+		poller.reconcile = true
+		poller.watcher, poller.fallback = watcher, config.WatchPollInterval
+	}
+	// poller 를 거쳐야 rescan 이 같은 파일을 다시 넘기지 않는다
+	detected := poller.notify
+	uploads := renamedUploads{}
+
+	watchDone := make(chan struct{})
 	go func() {
+		defer close(watchDone)
+		defer health.setWatcher(watcherStopped)
+		heartbeat := time.NewTicker(watcherHeartbeat)
+		defer heartbeat.Stop()
+		// 지워졌다 다시 생긴 디렉토리의 빠진 감시를 다시 건다
+		var recheck <-chan time.Time
+		if config.WatchMode != "poll" && config.WatchReconcile > 0 {
+			ticker := time.NewTicker(config.WatchReconcile)
+			defer ticker.Stop()
+			recheck = ticker.C
+		}
+		// 오류가 나면 watcher 를 새로 만들어 모든 경로를 다시 건다; 실패하면 1분까지 늘려가며 재시도
+		var restart <-chan time.Time
+		backoff := time.Second
+		failed := func() {
+			if restart == nil {
+				restart = time.After(backoff)
+			}
+		}
+		var dead *fsnotify.Watcher // 채널이 닫힌 watcher
 		for {
+			w := watcher.get()
+			events, errs := w.Events, w.Errors
+			if w == dead {
+				events, errs = nil, nil
+			}
 			select {
-			case event, ok := <-watcher.Events:
+			case <-heartbeat.C:
+				health.beat()
+			case <-recheck:
+				added, err := watcher.rewatch(watchRoots())
+				if added > 0 {
+					metricWatcherRestarts.Add(float64(added), "directory")
+					logIngest.Warn("watched directories had dropped out of fsnotify, watching them again", "directories", added)
+				}
+				if err != nil {
+					logIngest.Error("error watching directory again", "err", err)
+				}
+			case <-restart:
+				restart = nil
+				if err := watcher.restart(watchRoots()); err != nil {
+					logIngest.Error("error rebuilding the watcher, retrying", "retry_in", (2 * backoff).String(), "err", err)
+					backoff = min(2*backoff, time.Minute)
+					failed()
+					continue
+				}
+				backoff = time.Second
+				metricWatcherRestarts.Inc("watcher")
+				logIngest.Warn("watcher rebuilt, all watched directories added again")
+				// 다시 거는 사이에 들어온 파일
+				poller.rescan()
+			case event, ok := <-events:
 				if !ok {
-					return
+					if watcher.stopped() {
+						return
+					}
+					logIngest.Error("watcher closed, rebuilding it")
+					dead = w
+					failed()
+					continue
+				}
+				if event.Op&fsnotify.Rename == fsnotify.Rename {
+					uploads.renamed(event.Name)
+				}
+				if event.Op&fsnotify.Create != fsnotify.Create || isTempFile(event.Name) {
+					continue
+				}
+				root, filter := target.get()
+				recursive := config.WatchRecursive
+				if p := pipes.match(event.Name); p != nil {
+					root, filter, recursive = p.root, p.filter, p.Recursive
+				}
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if !recursive {
+						continue
+					}
+					// 새 하위 디렉토리: 감시 추가 후 이미 들어와 있는 파일 처리
+					if err := watcher.add(event.Name, true); err != nil {
+						logIngest.Error("error watching directory, rebuilding the watcher", "path", event.Name, "err", err)
+						failed()
+					}
+					files, _ := listInputFiles(root, event.Name, true, filter)
+					for _, path := range files {
+						detected(path)
+					}
+					continue
 				}
-				if event.Op&fsnotify.Create == fsnotify.Create && strings.HasSuffix(event.Name, ".gz") {
-					fmt.Println("New .gz file detected:", event.Name)
-					processGzipFile(es, event.Name)
+				rel, err := filepath.Rel(root, event.Name)
+				if err != nil || !filter.Match(rel) {
+					continue
 				}
-			case err, ok := <-watcher.Errors:
+				if uploads.complete(event.Name) {
+					logIngest.Info("new file detected", "file", event.Name, "by", "rename")
+					pool.Submit(event.Name)
+					continue
+				}
+				detected(event.Name)
+			case err, ok := <-errs:
 				if !ok {
-					return
+					if watcher.stopped() {
+						return
+					}
+					logIngest.Error("watcher closed, rebuilding it")
+					dead = w
+					failed()
+					continue
+				}
+				if errors.Is(err, fsnotify.ErrEventOverflow) {
+					// 큐가 넘쳐 이벤트가 버려졌다: 바로 다시 스캔
+					metricWatcherErrors.Inc("overflow")
+					logIngest.Warn("fsnotify dropped events, rescanning watched directories", "err", err)
+					poller.rescan()
+					continue
 				}
-				log.Println("Error:", err)
+				metricWatcherErrors.Inc("error")
+				logIngest.Error("watcher error, rebuilding the watcher", "err", err)
+				failed()
 			}
 		}
 	}()
 
-	// 디렉토리 감시 시작
-	err = watcher.Add(directoryPath)
-	if err != nil {
-		log.Fatal(err)
+	// 디렉토리 감시 시작; 네트워크 공유는 주기적으로 다시 스캔
+	for _, root := range roots {
+		if config.WatchMode == "poll" {
+			break
+		}
+		if err := watcher.add(root.path, root.recursive); err != nil {
+			fatal(logIngest, "startup failed", "err", err)
+		}
 	}
+	pollDone := make(chan struct{})
+	go func() {
+		defer close(pollDone)
+		poller.run(ctx)
+	}()
+	health.setWatcher(watcherRunning)
+	sdNotify("READY=1\nSTATUS=watching")
 
-	// 프로그램이 종료되지 않도록 블록
-	select {}
-}
-
-func processGzipFile(es *elasticsearch.Client, filePath string) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		log.Fatal(err)
+	// S3_BUCKET 의 새 객체를 받아서 같은 pool 로 처리
+	s3Done := make(chan struct{})
+	if config.S3Bucket != "" {
+		client, err := newS3Client(config)
+		if err != nil {
+			fatal(logS3, "invalid S3 settings", "err", err)
+		}
+		poller := &s3Poller{
+			client: client, prefix: config.S3Prefix, spool: config.S3SpoolDir,
+			filter: filter, state: state, submit: pool.SubmitWait, queued: map[string]bool{},
+		}
+		go func() {
+			defer close(s3Done)
+			poller.run(ctx, config.S3PollInterval)
+		}()
+	} else {
+		close(s3Done)
 	}
-	defer f.Close()
 
-	gz, err := gzip.NewReader(f)
-	if err != nil {
-		log.Fatal(err)
+	// 프로브가 gRPC 로 직접 보내는 레코드
+	var grpcSrv *http.Server
+	if config.GRPCAddr != "" {
+		grpcSrv = startGRPCServer(config, sink)
 	}
-	defer gz.Close()
 
-	reader := csv.NewReader(bufio.NewReader(gz))
-	reader.Comma = ','
+	// PULL_FILE 의 SFTP/FTP 서버에서 주기적으로 가져오기
+	pullDone := make(chan struct{})
+	if config.PullFile != "" {
+		pullCfg, err := loadPullConfig(config.PullFile)
+		if err != nil {
+			fatal(logPull, "invalid pull settings", "err", err)
+		}
+		ckpt := config.PullCheckpointFile
+		if dry != nil {
+			ckpt = ""
+		}
+		p, err := newPuller(pullCfg, config.PullSpoolDir, ckpt, pool.SubmitWait)
+		if err != nil {
+			fatal(logPull, "invalid pull settings", "err", err)
+		}
+		go func() {
+			defer close(pullDone)
+			p.run(ctx)
+		}()
+	} else {
+		close(pullDone)
+	}
 
-	headers, err := reader.Read()
-	log.Println("header: ", headers)
-	if err != nil {
-		log.Fatal(err)
+	// 오래된 원본 / dead letter / 격리 파일 정리
+	if config.RetentionMaxAge > 0 && dry == nil {
+		j, err := newJanitor(config, state)
+		if err != nil {
+			fatal(logJanitor, "invalid retention settings", "err", err)
+		}
+		go j.run(ctx, config.RetentionInterval)
 	}
 
-	var dataList []map[string]interface{}
+	// 설정 파일 변경 / SIGHUP 시 안전한 항목만 재적용
+	var queueing sync.WaitGroup // reload 가 큐에 넣는 중
+	reload := &reloader{
+		path: *configPath, config: config, schema: &schemaRef, kpi: kpi, alerts: alerts,
+		fanOuts: fanOuts, watcher: watcher, target: target,
+		queue: func(root string, filter fileFilter) {
+			files, err := scanBacklog(root, config.WatchRecursive, filter, state)
+			if err != nil {
+				logIngest.Error("error scanning directory", "path", root, "err", err)
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			queueing.Add(1)
+			go func() {
+				defer queueing.Done()
+				for _, path := range files {
+					if ctx.Err() != nil {
+						return
+					}
+					pool.SubmitWait(path)
+				}
+			}()
+		},
+	}
+	admin.setConfig(reload.current)
+	if err := reload.watch(*configPath, config.SchemaFile, config.AlertsFile); err != nil {
+		logConfig.Error("error watching config files, hot reload disabled", "err", err)
+	}
 
-	rows, _ := reader.ReadAll()
-	for _, row := range rows {
-		dataMap := make(map[string]interface{})
-		for j, header := range headers {
-			dataMap[header] = row[j]
+	// 종료 신호까지 대기
+	<-ctx.Done()
+	stop()
+	shutdown(config.ShutdownGrace, srv, func() {
+		// 새 파일 받지 않기 -> 대기 중인 파일 처리 -> 버퍼 flush -> ledger 저장
+		health.setStopping()
+		watcher.Close()
+		<-watchDone
+		<-pollDone
+		<-s3Done
+		<-pullDone
+		queueing.Wait()
+		if grpcSrv != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownGrace)
+			grpcSrv.Shutdown(ctx)
+			cancel()
+		}
+		pool.Close()
+		if err := sink.Close(); err != nil {
+			logOutput.Error("error flushing outputs", "err", err)
+		}
+		tracing.Close()
+		dlq.Close()
+		if err := state.Save(); err != nil {
+			logIngest.Error("error saving state", "err", err)
 		}
-		dataList = append(dataList, dataMap)
+	})
+}
+
+// shutdown runs drain and exits non-zero if it doesn't finish within grace.
+func shutdown(grace time.Duration, srv *http.Server, drain func()) {
+	logIngest.Info("shutting down, waiting for in-flight files", "grace", grace.String())
+	sdNotify("STOPPING=1")
+	done := make(chan struct{})
+	go func() {
+		drain()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logIngest.Info("shutdown complete")
+	case <-time.After(grace):
+		logIngest.Error("shutdown grace period exceeded, exiting with files in flight", "grace", grace.String())
+		os.Exit(1)
+	}
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
 	}
-	log.Println("length: ", len(dataList))
-	bulkInsertToElasticsearch(dataList, es)
 }
 
-func bulkInsertToElasticsearch(dataList []map[string]interface{}, es *elasticsearch.Client) error {
-	var buf bytes.Buffer
+// fileCheckpoint makes processFile resumable. The first Offset rows were
+// ingested by an earlier run (Rows of them valid) and are skipped; every
+// Every rows the sink is flushed and Commit saves the offset reached. The
+// zero value reads the whole file without checkpoints.
+type fileCheckpoint struct {
+	Offset, Rows int
+	Every        int
+	Commit       func(offset, rows int) error
+}
 
-	for _, dataMap := range dataList {
-		log.Println("dataMap: ", dataMap)
-		// Elasticsearch 메타데이터
-		meta := []byte(fmt.Sprintf(`{ "create" : { "_index" : "%s" } }%s`, "twamp-data", "\n"))
-		data, err := json.Marshal(dataMap)
-		if err != nil {
-			return fmt.Errorf("error marshalling dataMap: %w", err)
-		}
-		data = append(data, "\n"...)
+// rowPolicy is what processFile does with rows besides converting them.
+type rowPolicy struct {
+	RejectDir string           // REJECT_DIR; "" only logs rejected rows
+	Validate  *validationRules // VALIDATION_FILE
+	Trace     *span            // parent of the parse and flush spans
+	Timeout   time.Duration    // FILE_TIMEOUT; 0 is no limit
+}
+
+// rowCursor tracks a file's position across its CSV documents.
+type rowCursor struct {
+	fileCheckpoint
+	offset    int  // rows read, bad ones included
+	total     int  // rows ingested
+	committed int  // offset of the last checkpoint
+	failed    bool // 색인 오류 이후로는 checkpoint 를 남기지 않는다
+	rejected  int  // malformed rows skipped
+	rejects   *rejectFile
+	validate  *validationRules
+	stages    time.Duration // time spent in sink.Add (stages, output queues)
+	progress  *fileProgress
+}
+
+// reject counts, logs and records a row that was skipped.
+func (c *rowCursor) reject(name string, line int, reason string, err error, row []string) {
+	c.rejected++
+	if reason != "validation" {
+		metricParseErrors.Inc()
+	}
+	metricRowsRejected.Inc(reason)
+	logIngest.Warn("rejected row", "file", name, "line", line, "err", err)
+	if werr := c.rejects.add(name, line, err, row); werr != nil {
+		logIngest.Error("error writing reject file", "file", c.rejects.path, "err", werr)
+	}
+}
+
+// due reports whether another Every rows have been read since the last
+// checkpoint (or the resume point).
+func (c *rowCursor) due() bool {
+	return c.Every > 0 && !c.failed && c.offset-max(c.committed, c.Offset) >= c.Every
+}
 
-		buf.Grow(len(meta) + len(data))
-		buf.Write(meta)
-		buf.Write(data)
+// checkpoint flushes the sink and commits the current offset.
+func (c *rowCursor) checkpoint(sink recordSink, name string) error {
+	if err := sink.Flush(); err != nil {
+		c.failed = true
+		return err
+	}
+	c.committed = c.offset
+	if err := c.Commit(c.offset, c.total); err != nil {
+		logIngest.Error("error saving checkpoint", "file", name, "err", err)
 	}
+	return nil
+}
 
-	req := esapi.BulkRequest{
-		Body:    bytes.NewReader(buf.Bytes()),
-		Refresh: "true",
+// processFile ingests every CSV document contained in filePath and returns
+// the number of rows ingested and the first error. A file that can't be
+// read (corrupt archive, truncated gzip, missing header) gives a
+// corruptFileError; rows read before that are still indexed. Malformed rows
+// are skipped and, with policy.RejectDir, written to a reject file. When
+// ctx is done or policy.Timeout passes, reading stops after the current row
+// and the rows read so far are flushed.
+func processFile(ctx context.Context, sink recordSink, schema *Schema, parse parserFunc, filePath string, ckpt fileCheckpoint, policy rowPolicy) (int, error) {
+	if policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		defer cancel()
+	}
+	c := &rowCursor{
+		fileCheckpoint: ckpt,
+		total:          ckpt.Rows,
+		rejects:        newRejectFile(policy.RejectDir, filePath, ckpt.Offset > 0),
+		validate:       policy.Validate,
+		progress:       progress.begin(filePath, ckpt.Rows),
+	}
+	defer progress.end(c.progress)
+	defer func() {
+		if err := c.rejects.Close(); err != nil {
+			logIngest.Error("error writing reject file", "file", c.rejects.path, "err", err)
+		}
+	}()
+	if ckpt.Offset > 0 {
+		logIngest.Info("resuming file from checkpoint", "file", filePath, "offset", ckpt.Offset)
+	}
+	var indexErr error
+	parseSpan := tracing.start("parse", policy.Trace)
+	err := forEachCSVStream(filePath, c.progress, func(name string, r io.Reader) error {
+		err := parseCSVStream(ctx, sink, schema, parse, name, r, c)
+		var corrupt corruptFileError
+		if errors.As(err, &corrupt) || ctx.Err() != nil {
+			return err
+		}
+		if err != nil && indexErr == nil {
+			indexErr = err
+		}
+		return nil
+	})
+	parseSpan.set("twamp.rows", c.total)
+	parseSpan.set("twamp.rows.rejected", c.rejected)
+	parseSpan.set("twamp.stages.seconds", c.stages.Seconds())
+	parseSpan.finish(err)
+	if err != nil && ctx.Err() != nil {
+		// 시간 초과는 파일 탓이 아니라 quarantine 하지 않는다
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("not finished within FILE_TIMEOUT (%s): %w", policy.Timeout, err)
+		}
+		sink.Flush()
+		logIngest.Error("file not finished", "file", filePath, "rows", c.total, "rejected", c.rejected, "err", err)
+		return c.total, err
+	}
+	if err != nil {
+		var corrupt corruptFileError
+		if !errors.As(err, &corrupt) {
+			err = corruptFileError{err}
+		}
+		// 이미 읽은 행은 flush 해서 색인한다
+		sink.Flush()
+		logIngest.Error("error reading file", "file", filePath, "rows", c.total, "rejected", c.rejected, "err", err)
+		return c.total, err
 	}
+	flushSpan := tracing.start("flush", policy.Trace)
+	err = sink.Flush()
+	flushSpan.finish(err)
+	if err != nil {
+		logIngest.Error("error indexing batch", "file", filePath, "err", err)
+		if indexErr == nil {
+			indexErr = err
+		}
+	}
+	if c.rejected > 0 {
+		logIngest.Warn("file processed with rejected rows", "file", filePath, "rows", c.total, "rejected", c.rejected)
+	} else {
+		logIngest.Info("file processed", "file", filePath, "rows", c.total)
+	}
+	return c.total, indexErr
+}
 
-	res, err := req.Do(context.Background(), es)
+func parseCSVStream(ctx context.Context, sink recordSink, schema *Schema, parse parserFunc, name string, r io.Reader, c *rowCursor) error {
+	reader, err := parse(name, r)
 	if err != nil {
-		return fmt.Errorf("failure indexing batch: %w", err)
+		return corruptFileError{err}
 	}
-	defer res.Body.Close()
+	logIngest.Debug("CSV header", "file", name, "columns", len(reader.Header()))
 
-	if res.IsError() {
-		var resBody map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&resBody); err != nil {
-			return fmt.Errorf("error parsing the response body: %w", err)
+	// 파일 전체를 메모리에 올리지 않고 한 줄씩 읽어서 sink 로 전달
+	var indexErr error
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
 		}
-		return fmt.Errorf("error indexing batch: %s", resBody)
-	} else {
-		log.Printf("Successfully batch of %d messages", len(dataList))
+		if c.due() {
+			if err := c.checkpoint(sink, name); err != nil && indexErr == nil {
+				indexErr = err
+			}
+		}
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		// 잘못된 행은 건너뛰지만, 압축 해제/읽기 오류는 계속 같은 오류를 내므로 중단
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return corruptFileError{fmt.Errorf("error reading %s: %w", name, err)}
+		}
+		c.offset++
+		if c.offset <= c.Offset {
+			continue
+		}
+		if err != nil {
+			reason := "malformed"
+			if errors.Is(err, csv.ErrFieldCount) {
+				reason = "columns"
+			}
+			c.reject(name, reader.Line(), reason, parseErr.Err, row)
+			continue
+		}
+
+		record, err := schema.Convert(reader.Header(), row)
+		if err != nil {
+			c.reject(name, reader.Line(), "value", err, row)
+			continue
+		}
+		if c.validate != nil {
+			if violations := c.validate.Check(record); len(violations) > 0 {
+				if c.validate.Action == "reject" {
+					metricRowsValidated.Inc("rejected")
+					c.reject(name, reader.Line(), "validation", errors.New(strings.Join(violations, "; ")), row)
+					continue
+				}
+				metricRowsValidated.Inc("tagged")
+				record[validationField] = violations
+			} else {
+				metricRowsValidated.Inc("valid")
+			}
+		}
+		metricRowsParsed.Inc()
+		start := time.Now()
+		err = sink.Add(record)
+		c.stages += time.Since(start)
+		if err != nil {
+			logIngest.Error("error indexing batch", "file", name, "err", err)
+			c.failed = true
+			if indexErr == nil {
+				indexErr = err
+			}
+		}
+		c.total++
+		c.progress.row()
 	}
-	return nil
+	return indexErr
+}
+
+func printLedger(w io.Writer, entries []ledgerEntry) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tROWS\tSTARTED\tFINISHED\tCHECKSUM\tPATH\tERROR")
+	for _, e := range entries {
+		finished := ""
+		if !e.FinishedAt.IsZero() {
+			finished = e.FinishedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%.12s\t%s\t%s\n",
+			e.Status, e.Rows, e.StartedAt.Format(time.RFC3339), finished, e.Checksum, e.Path, e.Error)
+	}
+	tw.Flush()
 }
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+)
+
+// fieldMapping is the MAPPING_FILE: how records are reshaped just before
+// they go to the outputs. KPIs, alert rules and rollups still see the
+// schema's field names.
+//
+//	include: ["session_*", "source_*", "destination_*", "rtt_*", "*_loss_pct", "available"]
+//	drop: ["ul_vprio*", "dl_vprio*", "CSVexport Version"]
+//	precision: 3           # decimal places of converted values, unset: all
+//	convert:
+//	  - fields: ["rtt_m*", "ul_dm*", "dl_dm*", "ul_dp*", "dl_dp*"]
+//	    from: us
+//	    to: ms
+//	  - fields: ["*_lostperc"]
+//	    from: permille       # ratio, pct, permille, ppm
+//	    to: pct
+//	    precision: 2
+//	  - fields: [packet_rate]
+//	    scale: 0.001         # any other factor
+//	ratios:
+//	  - field: ul_loss_ratio
+//	    numerator: [ul_lostpkts]
+//	    denominator: [ul_rxpkts, ul_lostpkts]
+//	    unit: ratio
+//	rename:
+//	  rtt_mean: rtt.mean_ms
+//	  Serial: observer.serial_number
+//
+// include, drop, convert and rename all match the schema's names (globs
+// for the first three) and are applied in that order. With include, only
+// the fields it matches are kept, plus @timestamp, session_id, stat_round
+// (the document _id), pipeline and tenant; drop wins over it. ratios adds
+// fields, numerator over denominator (each the sum of its fields, read
+// before the other rules), to records that have them all.
+type fieldMapping struct {
+	Include   []string          `json:"include"`
+	Drop      []string          `json:"drop"`
+	Precision *int              `json:"precision"`
+	Convert   []*unitConversion `json:"convert"`
+	Ratios    []*fieldRatio     `json:"ratios"`
+	Rename    map[string]string `json:"rename"`
+}
+
+type unitConversion struct {
+	Fields    []string `json:"fields"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Scale     float64  `json:"scale"`
+	Precision *int     `json:"precision"`
+
+	// 값 * mul / div; 단위는 ns 기준이라 1952us -> 1.952ms 처럼 오차 없이 나온다
+	mul, div float64
+}
+
+type fieldRatio struct {
+	Field       string   `json:"field"`
+	Numerator   []string `json:"numerator"`
+	Denominator []string `json:"denominator"`
+	Unit        string   `json:"unit"`
+	Precision   *int     `json:"precision"`
+
+	scale float64
+}
+
+// timeUnits in nanoseconds.
+var timeUnits = map[string]float64{
+	"ns": 1, "us": 1e3, "µs": 1e3, "ms": 1e6, "s": 1e9, "m": 60e9, "h": 3600e9,
+}
+
+// ratioUnits in parts per million.
+var ratioUnits = map[string]float64{
+	"ratio": 1e6, "pct": 1e4, "%": 1e4, "permille": 1e3, "‰": 1e3, "ppm": 1,
+}
+
+// unitFactors returns the factors of from and to, which must both be time
+// or both ratio units.
+func unitFactors(from, to string) (float64, float64, bool) {
+	for _, units := range []map[string]float64{timeUnits, ratioUnits} {
+		f, ok1 := units[from]
+		t, ok2 := units[to]
+		if ok1 && ok2 {
+			return f, t, true
+		}
+	}
+	return 0, 0, false
+}
+
+// roundTo rounds v to precision decimal places; nil keeps it as it is.
+func roundTo(v float64, precision *int) float64 {
+	if precision == nil {
+		return v
+	}
+	p := math.Pow10(*precision)
+	return math.Round(v*p) / p
+}
+
+func loadFieldMapping(path string) (*fieldMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mapping file: %w", err)
+	}
+	var m fieldMapping
+	if err := decodeYAML(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing mapping file %s: %w", path, err)
+	}
+	if err := checkFieldPatterns(m.Include, m.Drop); err != nil {
+		return nil, fmt.Errorf("mapping file %s: %w", path, err)
+	}
+	if m.Precision != nil && (*m.Precision < 0 || *m.Precision > 15) {
+		return nil, fmt.Errorf("mapping file %s: precision must be 0 to 15", path)
+	}
+	for i, c := range m.Convert {
+		if len(c.Fields) == 0 {
+			return nil, fmt.Errorf("mapping file %s: convert %d has no fields", path, i+1)
+		}
+		for _, pattern := range c.Fields {
+			if _, err := matchField(pattern, ""); err != nil {
+				return nil, fmt.Errorf("mapping file %s: convert %d: %w", path, i+1, err)
+			}
+		}
+		if c.Precision == nil {
+			c.Precision = m.Precision
+		} else if *c.Precision < 0 || *c.Precision > 15 {
+			return nil, fmt.Errorf("mapping file %s: convert %d: precision must be 0 to 15", path, i+1)
+		}
+		if c.Scale != 0 {
+			c.mul, c.div = c.Scale, 1
+			continue
+		}
+		var ok bool
+		if c.mul, c.div, ok = unitFactors(c.From, c.To); !ok {
+			return nil, fmt.Errorf("mapping file %s: convert %d: unknown unit %q -> %q (ns, us, ms, s, m, h or ratio, pct, permille, ppm; or set scale)", path, i+1, c.From, c.To)
+		}
+	}
+	for i, r := range m.Ratios {
+		if r.Field == "" || len(r.Numerator) == 0 || len(r.Denominator) == 0 {
+			return nil, fmt.Errorf("mapping file %s: ratio %d needs field, numerator and denominator", path, i+1)
+		}
+		if r.Unit == "" {
+			r.Unit = "ratio"
+		}
+		scale, ok := ratioUnits[r.Unit]
+		if !ok {
+			return nil, fmt.Errorf("mapping file %s: ratio %d: unknown unit %q (ratio, pct, permille, ppm)", path, i+1, r.Unit)
+		}
+		r.scale = ratioUnits["ratio"] / scale
+		if r.Precision == nil {
+			r.Precision = m.Precision
+		} else if *r.Precision < 0 || *r.Precision > 15 {
+			return nil, fmt.Errorf("mapping file %s: ratio %d: precision must be 0 to 15", path, i+1)
+		}
+	}
+	return &m, nil
+}
+
+// value returns the ratio for rec, if it has all the fields and a
+// denominator other than 0.
+func (r *fieldRatio) value(rec Record) (float64, bool) {
+	sum := func(fields []string) (float64, bool) {
+		var s float64
+		for _, f := range fields {
+			n, ok := number(rec, f)
+			if !ok {
+				return 0, false
+			}
+			s += n
+		}
+		return s, true
+	}
+	num, ok1 := sum(r.Numerator)
+	den, ok2 := sum(r.Denominator)
+	if !ok1 || !ok2 || den == 0 {
+		return 0, false
+	}
+	return roundTo(num/den*r.scale, r.Precision), true
+}
+
+// keptFields survive any include list.
+var keptFields = map[string]bool{"@timestamp": true, "session_id": true, "stat_round": true, pipelineField: true, tenantField: true}
+
+func checkFieldPatterns(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, pattern := range patterns {
+			if _, err := matchField(pattern, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// withFields returns m with include (if set) in place of its own and
+// exclude added to its drop list; FIELDS_INCLUDE/FIELDS_EXCLUDE and a
+// pipeline's fields. m may be nil, and nil is returned if there's still
+// nothing to map.
+func (m *fieldMapping) withFields(include, exclude []string) *fieldMapping {
+	if len(include) == 0 && len(exclude) == 0 {
+		return m
+	}
+	out := &fieldMapping{}
+	if m != nil {
+		*out = *m
+	}
+	if len(include) > 0 {
+		out.Include = include
+	}
+	out.Drop = append(append([]string(nil), out.Drop...), exclude...)
+	return out
+}
+
+// delayFields are the schema's delay and jitter fields, all in
+// microseconds, for DELAY_UNIT.
+var delayFields = []string{
+	"?l_dm*", "?l_dp*", "?l_dS*", "?l_dv*", "?l_j*", "rtt_m*", "rtt_jitter",
+	"owd_*", "reflector_processing", "clock_offset",
+}
+
+// delayConversion is the DELAY_UNIT conversion, or nil when the delays
+// stay in microseconds. A negative precision keeps every digit.
+func delayConversion(config Config) *unitConversion {
+	if config.DelayUnit == "" || config.DelayUnit == "us" {
+		return nil
+	}
+	c := &unitConversion{Fields: delayFields, From: "us", To: config.DelayUnit, mul: timeUnits["us"], div: timeUnits[config.DelayUnit]}
+	if config.DelayPrecision >= 0 {
+		p := config.DelayPrecision
+		c.Precision = &p
+	}
+	return c
+}
+
+// withConversion returns m with c as its last conversion, behind the
+// file's own, so fields those already convert are left to them.
+func (m *fieldMapping) withConversion(c *unitConversion) *fieldMapping {
+	if c == nil {
+		return m
+	}
+	out := &fieldMapping{}
+	if m != nil {
+		*out = *m
+	}
+	out.Convert = append(append([]*unitConversion(nil), out.Convert...), c)
+	return out
+}
+
+// dropped reports whether the field named k doesn't reach the outputs.
+func (m *fieldMapping) dropped(k string) bool {
+	if matchesField(m.Drop, k) {
+		return true
+	}
+	return len(m.Include) > 0 && !keptFields[k] && !matchesField(m.Include, k)
+}
+
+func matchField(pattern, name string) (bool, error) {
+	ok, err := path.Match(pattern, name)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return ok, nil
+}
+
+func matchesField(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns a mapped copy of rec; rec itself is left alone because
+// the stages before this one may still hold it.
+func (m *fieldMapping) Apply(rec Record) Record {
+	out := make(Record, len(rec))
+	for k, v := range rec {
+		if m.dropped(k) {
+			continue
+		}
+		for _, c := range m.Convert {
+			if n, ok := number(rec, k); ok && matchesField(c.Fields, k) {
+				v = roundTo(n*c.mul/c.div, c.Precision)
+				break
+			}
+		}
+		if name, ok := m.Rename[k]; ok {
+			k = name
+		}
+		out[k] = v
+	}
+	for _, r := range m.Ratios {
+		if v, ok := r.value(rec); ok {
+			out[r.Field] = v
+		}
+	}
+	return out
+}
+
+// Revert undoes Apply on a document mapped with m, for twamp reindex:
+// renamed fields get their schema names back, converted values their
+// original unit (without the rounding) and the ratio fields are removed.
+// Dropped fields stay gone.
+func (m *fieldMapping) Revert(rec Record) Record {
+	names := make(map[string]string, len(m.Rename))
+	for from, to := range m.Rename {
+		names[to] = from
+	}
+	ratios := map[string]bool{}
+	for _, r := range m.Ratios {
+		ratios[r.Field] = true
+	}
+	out := make(Record, len(rec))
+	for k, v := range rec {
+		if name, ok := names[k]; ok {
+			k = name
+		} else if ratios[k] {
+			continue
+		}
+		for _, c := range m.Convert {
+			if n, ok := numericValue(v); ok && matchesField(c.Fields, k) {
+				// 1.952ms -> 1952us: 나눗셈 오차는 정수로 되돌린다
+				f := n * c.div / c.mul
+				v = f
+				if r := math.Round(f); math.Abs(f-r) < 1e-9*math.Max(1, math.Abs(r)) {
+					v = int64(r)
+				}
+				break
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// mapProperties applies the same rules to the index template's field
+// mappings, so renamed and converted fields keep an explicit type.
+func (m *fieldMapping) mapProperties(props map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		if m.dropped(k) {
+			continue
+		}
+		for _, c := range m.Convert {
+			if matchesField(c.Fields, k) {
+				v = map[string]string{"type": "double"}
+				break
+			}
+		}
+		if name, ok := m.Rename[k]; ok {
+			k = name
+		}
+		out[k] = v
+	}
+	for _, r := range m.Ratios {
+		out[r.Field] = map[string]string{"type": "double"}
+	}
+	return out
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Just enough of the Prometheus text exposition format for our own
+// counters, gauges and histograms.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	registry = append(registry, m)
+	registryMu.Unlock()
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	registryMu.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeSeries(w, c.name, c.help, "counter", c.labels, c.values)
+}
+
+type Gauge struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	fn     func() float64
+}
+
+func newGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(g)
+	return g
+}
+
+// newGaugeFunc reports the value returned by fn at scrape time.
+func newGaugeFunc(name, help string, fn func() float64) *Gauge {
+	g := newGauge(name, help)
+	g.fn = fn
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.values[strings.Join(labelValues, "\xff")] = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.values[strings.Join(labelValues, "\xff")] += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	values := g.values
+	if g.fn != nil {
+		values = map[string]float64{"": g.fn()}
+	}
+	writeSeries(w, g.name, g.help, "gauge", g.labels, values)
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		labels := labelPairs(h.labels, key)
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(labels), s.count)
+	}
+}
+
+func writeSeries(w io.Writer, name, help, typ string, labels []string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, braces(labelPairs(labels, key)), formatFloat(values[key]))
+	}
+}
+
+func labelPairs(names []string, key string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, 0, len(names))
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, n+"="+strconv.Quote(v))
+	}
+	return pairs
+}
+
+func withLabel(pairs []string, name, value string) string {
+	return braces(append(append([]string(nil), pairs...), name+"="+strconv.Quote(value)))
+}
+
+func braces(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Pipeline metrics.
+var (
+	metricFilesProcessed = newCounter("twamp_files_processed_total",
+		"Input files finished, by ledger status.", "status")
+	metricRowsParsed = newCounter("twamp_rows_parsed_total",
+		"CSV rows converted to records.")
+	metricParseErrors = newCounter("twamp_parse_errors_total",
+		"CSV rows that could not be read or converted.")
+	metricRowsRejected = newCounter("twamp_rows_rejected_total",
+		"Malformed rows skipped, by reason (columns, malformed, value).", "reason")
+	metricRowsValidated = newCounter("twamp_rows_validated_total",
+		"Rows checked against VALIDATION_FILE, by result (valid, tagged, rejected).", "result")
+	metricValidationViolations = newCounter("twamp_validation_violations_total",
+		"Validation rule violations, by field and rule.", "field", "rule")
+	metricTimestampsFlagged = newCounter("twamp_timestamps_flagged_total",
+		"Rows kept with ingestion time because their timestamp couldn't be parsed.")
+	metricDocumentsIndexed = newCounter("twamp_documents_indexed_total",
+		"Documents accepted by an output.", "output")
+	metricDocumentsDuplicate = newCounter("twamp_documents_duplicate_total",
+		"Documents skipped because one with the same _id was already indexed.", "output")
+	metricWALBytes = newGauge("twamp_wal_bytes",
+		"Bytes of WAL segments on disk.")
+	metricWALUnacked = newGauge("twamp_wal_unacked_records",
+		"Records in the WAL not yet acknowledged by every output.")
+	metricWALBypassed = newCounter("twamp_wal_bypassed_total",
+		"Records passed on without being logged because the WAL was full.")
+	metricDocumentsSpooled = newCounter("twamp_documents_spooled_total",
+		"Documents written to SPOOL_DIR while Elasticsearch was unavailable.", "output")
+	metricSpoolBytes = newGauge("twamp_spool_bytes",
+		"Bytes of bulk requests waiting in SPOOL_DIR.")
+	metricSpoolRequests = newGauge("twamp_spool_requests",
+		"Bulk requests waiting in SPOOL_DIR.")
+	metricESActiveCluster = newGauge("twamp_es_active_cluster",
+		"1 for the Elasticsearch cluster bulk requests go to, primary or secondary (ES_SECONDARY_SERVER).", "cluster")
+	metricESFailovers = newCounter("twamp_es_failovers_total",
+		"Switches of the Elasticsearch output, by the cluster switched to.", "to")
+	metricESDiskPaused = newGauge("twamp_es_disk_watermark_paused",
+		"1 while ingestion is paused because the Elasticsearch cluster is past its flood-stage disk watermark (ES_WATERMARK_PAUSE).")
+	metricESRollovers = newCounter("twamp_es_rollovers_total",
+		"Rollovers of ES_WRITE_ALIAS done through the rollover API (ILM_ROLLOVER=self).", "alias")
+	metricESClusterDocuments = newCounter("twamp_es_cluster_documents_total",
+		"Documents indexed per Elasticsearch cluster with ES_SECONDARY_SERVER: primary, secondary, or reconciled (re-indexed into the primary from the gap).", "cluster")
+	metricReconcileBytes = newGauge("twamp_es_reconcile_bytes",
+		"Bytes of bulk requests in ES_RECONCILE_DIR waiting to be re-indexed into the primary.")
+	metricReconcileRequests = newGauge("twamp_es_reconcile_requests",
+		"Bulk requests in ES_RECONCILE_DIR waiting to be re-indexed into the primary.")
+	metricTenantRecords = newCounter("twamp_tenant_records_total",
+		"Records routed to a tenant's own Elasticsearch output (config file tenants).", "tenant")
+	metricBulkFailures = newCounter("twamp_bulk_failures_total",
+		"Bulk requests that failed, including ones later retried.", "output")
+	metricBulkRetries = newCounter("twamp_bulk_retries_total",
+		"Bulk request retries.", "output")
+	metricBulkItemFailures = newCounter("twamp_bulk_item_failures_total",
+		"Documents failed within a bulk response, by error type, including ones later retried.", "output", "type")
+	metricBulkBatchSize = newGauge("twamp_bulk_batch_size",
+		"Batch size chosen by BULK_ADAPTIVE.", "output")
+	metricBulkThrottled = newCounter("twamp_bulk_throttled_seconds_total",
+		"Time bulk requests waited for ES_RATE_LIMIT_DOCS/ES_RATE_LIMIT_REQUESTS.", "output")
+	metricDeadLettered = newCounter("twamp_documents_dead_lettered_total",
+		"Documents written to the dead-letter queue.")
+	metricMissedFiles = newCounter("twamp_watch_missed_files_total",
+		"Files fsnotify didn't report that the WATCH_RECONCILE scan found.")
+	metricQueueOverflows = newCounter("twamp_queue_overflows_total",
+		"Files dropped from a full queue, settle (left to the next scan) or jobs (left to the next scan or restart).", "queue")
+	metricWatcherErrors = newCounter("twamp_watcher_errors_total",
+		"fsnotify errors, overflow for dropped events.", "type")
+	metricUnwatchedDirs = newGauge("twamp_watch_unwatched_directories",
+		"Directories past the inotify watch limit, polled every WATCH_POLL_INTERVAL instead.")
+	metricWatcherRestarts = newCounter("twamp_watcher_restarts_total",
+		"fsnotify watches re-established: directory for one that had dropped out, watcher for a rebuild after errors.", "scope")
+	metricFilesQuarantined = newCounter("twamp_files_quarantined_total",
+		"Unreadable source files moved to ERRORS_DIR.")
+	metricJanitorFiles = newCounter("twamp_retention_files_total",
+		"Files deleted or compressed by the retention janitor.", "action")
+	metricJanitorReclaimed = newCounter("twamp_retention_reclaimed_bytes_total",
+		"Disk space freed by the retention janitor.", "action")
+	metricS3Downloaded = newCounter("twamp_s3_objects_downloaded_total",
+		"Objects downloaded from S3_BUCKET.")
+	metricPullDownloaded = newCounter("twamp_pull_files_downloaded_total",
+		"Files downloaded from PULL_FILE hosts.", "host")
+	metricPullErrors = newCounter("twamp_pull_errors_total",
+		"Failed listings or downloads from PULL_FILE hosts.", "host")
+	metricGRPCRecords = newCounter("twamp_grpc_records_total",
+		"Records received over the gRPC ingest stream.")
+	metricDirectionsCorrelated = newCounter("twamp_directions_correlated_total",
+		"Per-direction rows by CORRELATE_DIRECTIONS outcome: matched pairs, forward_only or reverse_only rows.", "result")
+	metricAnomalies = newCounter("twamp_anomalies_total",
+		"Stat rounds flagged by ANOMALY_DETECTION, by field and direction (high or low).", "field", "direction")
+	metricAlarms = newCounter("twamp_alarms_total",
+		"Alarm IDs raised and cleared, from ALARM_CORRELATION.", "state")
+	metricAlarmsOpen = newGauge("twamp_alarms_open",
+		"Alarms currently raised on some session.")
+	metricDegradationWindows = newCounter("twamp_degradation_windows_total",
+		"Ended degradation windows, by whether an alarm was up during them.", "alarmed")
+	metricRecordsSampled = newCounter("twamp_records_sampled_total",
+		"Raw records kept or dropped by SAMPLE_EVERY/SAMPLE_INTERVAL.", "result")
+	metricNotificationsDropped = newCounter("twamp_notifications_dropped_total",
+		"Notifications dropped by rate limit or a full queue.", "channel")
+	metricBulkLatency = newHistogram("twamp_bulk_duration_seconds",
+		"Latency of bulk requests.", []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}, "output")
+)
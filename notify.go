@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NOTIFY_FILE 예시:
+//
+//	channels:
+//	  - name: ops
+//	    type: slack                # slack, webhook or smtp
+//	    url: https://hooks.slack.com/services/...
+//	  - name: oncall
+//	    type: smtp
+//	    host: smtp.example.com:587
+//	    username: twamp
+//	    password: secret
+//	    from: twamp@example.com
+//	    to: [oncall@example.com]
+//	routes:
+//	  - events: [ingest_failed, dead_letter, es_failover, es_disk_watermark]
+//	    channels: [ops]
+//	  - events: [alert, anomaly]
+//	    severity: [critical]
+//	    channels: [ops, oncall]
+//	rate_limit:
+//	  max: 10                      # per channel per window, the rest is dropped and counted
+//	  window: 1m
+type notifyConfig struct {
+	Channels  []notifyChannelConfig `json:"channels"`
+	Routes    []notifyRoute         `json:"routes"`
+	RateLimit struct {
+		Max    int    `json:"max"`
+		Window string `json:"window"`
+	} `json:"rate_limit"`
+}
+
+type notifyChannelConfig struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Host     string            `json:"host"`
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	From     string            `json:"from"`
+	To       []string          `json:"to"`
+}
+
+type notifyRoute struct {
+	Events   []string `json:"events"`
+	Severity []string `json:"severity"`
+	Channels []string `json:"channels"`
+}
+
+// notification events
+const (
+	eventIngestFailed = "ingest_failed"
+	eventDeadLetter   = "dead_letter"
+	eventAlert        = "alert"
+	eventAnomaly      = "anomaly"
+	eventFailover     = "es_failover"
+	eventWatermark    = "es_disk_watermark"
+)
+
+type notification struct {
+	Event    string    `json:"event"`
+	Severity string    `json:"severity"`
+	Title    string    `json:"title"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+}
+
+// notifications is set up in main from NOTIFY_FILE; nil means disabled.
+var notifications *notifier
+
+// notify routes n to the matching channels without blocking.
+func notify(n notification) {
+	if notifications == nil {
+		return
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	notifications.dispatch(n)
+}
+
+type notifier struct {
+	routes   []notifyRoute
+	channels map[string]*notifyQueue
+}
+
+func loadNotifyConfig(path string) (notifyConfig, error) {
+	var cfg notifyConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error reading notify file: %w", err)
+	}
+	if err := decodeYAML(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing notify file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func newNotifier(path string) (*notifier, error) {
+	cfg, err := loadNotifyConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	window := time.Minute
+	if cfg.RateLimit.Window != "" {
+		if window, err = time.ParseDuration(cfg.RateLimit.Window); err != nil {
+			return nil, fmt.Errorf("notify file %s: invalid rate_limit.window: %w", path, err)
+		}
+	}
+	n := &notifier{routes: cfg.Routes, channels: make(map[string]*notifyQueue)}
+	for _, c := range cfg.Channels {
+		ch, err := newNotifyChannel(c)
+		if err != nil {
+			return nil, fmt.Errorf("notify file %s: channel %s: %w", path, c.Name, err)
+		}
+		n.channels[c.Name] = newNotifyQueue(c.Name, ch, cfg.RateLimit.Max, window)
+	}
+	for _, r := range cfg.Routes {
+		for _, name := range r.Channels {
+			if _, ok := n.channels[name]; !ok {
+				return nil, fmt.Errorf("notify file %s: route uses unknown channel %q", path, name)
+			}
+		}
+	}
+	return n, nil
+}
+
+func (n *notifier) dispatch(msg notification) {
+	sent := map[string]bool{}
+	for _, r := range n.routes {
+		if !r.matches(msg) {
+			continue
+		}
+		for _, name := range r.Channels {
+			if !sent[name] {
+				sent[name] = true
+				n.channels[name].push(msg)
+			}
+		}
+	}
+}
+
+func (n *notifier) Close() {
+	for _, q := range n.channels {
+		q.close()
+	}
+}
+
+func (r notifyRoute) matches(msg notification) bool {
+	return matchAny(r.Events, msg.Event) && matchAny(r.Severity, msg.Severity)
+}
+
+// matchAny is true for an empty list (no restriction).
+func matchAny(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, s := range list {
+		if s == v || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+type notifyChannel interface {
+	send(msg notification) error
+}
+
+func newNotifyChannel(c notifyChannelConfig) (notifyChannel, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch c.Type {
+	case "slack":
+		if c.URL == "" {
+			return nil, fmt.Errorf("slack channel needs url")
+		}
+		return &slackChannel{url: c.URL, client: client}, nil
+	case "webhook":
+		if c.URL == "" {
+			return nil, fmt.Errorf("webhook channel needs url")
+		}
+		return &webhookChannel{url: c.URL, headers: c.Headers, client: client}, nil
+	case "smtp":
+		if c.Host == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("smtp channel needs host, from and to")
+		}
+		return &smtpChannel{cfg: c}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", c.Type)
+}
+
+type slackChannel struct {
+	url    string
+	client *http.Client
+}
+
+func (s *slackChannel) send(msg notification) error {
+	return postJSON(s.client, s.url, nil, map[string]string{
+		"text": fmt.Sprintf("*%s* (%s)\n%s", msg.Title, msg.Severity, msg.Text),
+	})
+}
+
+type webhookChannel struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (w *webhookChannel) send(msg notification) error {
+	return postJSON(w.client, w.url, w.headers, msg)
+}
+
+type smtpChannel struct {
+	cfg notifyChannelConfig
+}
+
+func (s *smtpChannel) send(msg notification) error {
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [twamp] %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		s.cfg.From, strings.Join(s.cfg.To, ", "), msg.Title, msg.Time.Format(time.RFC1123Z), msg.Text)
+	return s.sendMail([]byte(body))
+}
+
+func (s *smtpChannel) sendMail(msg []byte) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, _ := strings.Cut(s.cfg.Host, ":")
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+	return smtp.SendMail(s.cfg.Host, auth, s.cfg.From, s.cfg.To, msg)
+}
+
+func postJSON(client *http.Client, url string, headers map[string]string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, res.Status)
+	}
+	return nil
+}
+
+// notifyQueue sends to one channel from its own goroutine and applies the
+// rate limit: at most max messages per window, the rest are dropped and
+// mentioned in the next message that goes out.
+type notifyQueue struct {
+	name    string
+	channel notifyChannel
+	max     int
+	window  time.Duration
+	queue   chan notification
+	done    chan struct{}
+
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+	suppressed  int
+}
+
+func newNotifyQueue(name string, ch notifyChannel, max int, window time.Duration) *notifyQueue {
+	q := &notifyQueue{name: name, channel: ch, max: max, window: window,
+		queue: make(chan notification, 100), done: make(chan struct{})}
+	go q.loop()
+	return q
+}
+
+func (q *notifyQueue) push(msg notification) {
+	q.mu.Lock()
+	now := time.Now()
+	if now.Sub(q.windowStart) >= q.window {
+		q.windowStart, q.sent = now, 0
+	}
+	if q.max > 0 && q.sent >= q.max {
+		q.suppressed++
+		q.mu.Unlock()
+		metricNotificationsDropped.Inc(q.name)
+		return
+	}
+	q.sent++
+	if q.suppressed > 0 {
+		msg.Text += fmt.Sprintf("\n(%d notifications suppressed by rate limit)", q.suppressed)
+		q.suppressed = 0
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.queue <- msg:
+	default:
+		metricNotificationsDropped.Inc(q.name)
+		logNotify.Warn("notification queue full, dropping", "channel", q.name, "title", msg.Title)
+	}
+}
+
+func (q *notifyQueue) close() {
+	close(q.queue)
+	<-q.done
+}
+
+func (q *notifyQueue) loop() {
+	defer close(q.done)
+	for msg := range q.queue {
+		if err := q.channel.send(msg); err != nil {
+			logNotify.Error("error sending notification", "channel", q.name, "err", err)
+		}
+	}
+}
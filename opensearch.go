@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// openSearchTransport lets the Elasticsearch client talk to OpenSearch
+// (ES_PRODUCT=opensearch). The client refuses servers that don't answer
+// with X-Elastic-Product: Elasticsearch, and OpenSearch rejects the
+// vnd.elasticsearch compatibility media type; the bulk, index template and
+// cluster health APIs this tool uses are otherwise the same.
+type openSearchTransport struct {
+	next http.RoundTripper
+}
+
+func (t openSearchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cloned := false
+	for _, h := range []string{"Accept", "Content-Type"} {
+		if !strings.Contains(req.Header.Get(h), "vnd.elasticsearch") {
+			continue
+		}
+		// RoundTripper 는 요청을 바꾸면 안 되므로 복사본을 고친다
+		if !cloned {
+			req, cloned = req.Clone(req.Context()), true
+		}
+		req.Header.Set(h, "application/json")
+	}
+	res, err := t.next.RoundTrip(req)
+	if err == nil && res.Header.Get("X-Elastic-Product") == "" {
+		res.Header.Set("X-Elastic-Product", "Elasticsearch")
+	}
+	return res, err
+}
+
+// openSearch reports whether the Elasticsearch output talks to OpenSearch.
+func (c Config) openSearch() bool {
+	return c.ESProduct == "opensearch"
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpExporter posts OTLP/HTTP requests in the JSON encoding to an
+// OpenTelemetry collector (OTEL_EXPORTER_OTLP_ENDPOINT, e.g.
+// http://otel-collector:4318), so no OTel SDK is needed. Endpoint, headers,
+// service name and resource attributes use the standard OTEL_* variables.
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	resource map[string]interface{}
+	client   *http.Client
+}
+
+func newOTLPExporter(config Config) (*otlpExporter, error) {
+	if config.OTLPEndpoint == "" {
+		return nil, errors.New("OTEL_EXPORTER_OTLP_ENDPOINT is required")
+	}
+	if _, err := url.Parse(config.OTLPEndpoint); err != nil {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT: %w", err)
+	}
+	headers, err := parseOTelPairs("OTEL_EXPORTER_OTLP_HEADERS", config.OTLPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := parseOTelPairs("OTEL_RESOURCE_ATTRIBUTES", config.OTelResourceAttributes)
+	if err != nil {
+		return nil, err
+	}
+	attrs["service.name"] = config.OTelServiceName
+	return &otlpExporter{
+		endpoint: strings.TrimSuffix(config.OTLPEndpoint, "/"),
+		headers:  headers,
+		resource: map[string]interface{}{"attributes": otlpAttributes(attrs)},
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// parseOTelPairs parses the key=value,... lists of the OTEL_* variables;
+// values may be percent-encoded.
+func parseOTelPairs(setting, s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, item := range splitList(s) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid %s entry %q", setting, item)
+		}
+		if u, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = u
+		}
+		out[strings.TrimSpace(k)] = v
+	}
+	return out, nil
+}
+
+// post sends one export request for signal (metrics, traces).
+func (e *otlpExporter) post(ctx context.Context, signal string, req interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/v1/"+signal, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		r.Header.Set(k, v)
+	}
+	res, err := e.client.Do(r)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	err = fmt.Errorf("otlp %s export: %s: %s", signal, res.Status, bytes.TrimSpace(msg))
+	if retryableStatus(res.StatusCode) {
+		return retryableError{err}
+	}
+	return err
+}
+
+// otlpAttributes converts to a KeyValue list, sorted by key.
+func otlpAttributes(m map[string]string) []map[string]interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, map[string]interface{}{"key": k, "value": map[string]string{"stringValue": m[k]}})
+	}
+	return out
+}
+
+// otlpTime is a time in the JSON encoding's fixed64 form (a decimal string).
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// OTLPMetricsOutput exports the KPIs as OTLP metrics (OUTPUT=otlp): per
+// batch, one delta histogram point per metric and attribute set (count,
+// sum, min, max of the batch's values, from its oldest to its newest
+// @timestamp), named twamp.rtt, twamp.jitter, twamp.loss and
+// twamp.available. Attributes come from OTEL_METRICS_ATTRIBUTES.
+type OTLPMetricsOutput struct {
+	exporter *otlpExporter
+	attrs    [][2]string // attribute, record field
+	retry    retryPolicy
+}
+
+func newOTLPMetricsOutput(config Config) (*OTLPMetricsOutput, error) {
+	exporter, err := newOTLPExporter(config)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := parseLabelList("OTEL_METRICS_ATTRIBUTES", config.OTelMetricsAttributes, validOTelAttribute)
+	if err != nil {
+		return nil, err
+	}
+	return &OTLPMetricsOutput{exporter: exporter, attrs: attrs, retry: config.retryPolicy()}, nil
+}
+
+func validOTelAttribute(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " =,")
+}
+
+func (o *OTLPMetricsOutput) Name() string { return "otlp" }
+
+func (o *OTLPMetricsOutput) Close() error { return nil }
+
+type otlpPoint struct {
+	attrs         map[string]string
+	count         uint64
+	sum, min, max float64
+	start, end    time.Time
+}
+
+func (o *OTLPMetricsOutput) Write(ctx context.Context, records []Record) error {
+	metrics := o.aggregate(records)
+	if len(metrics) == 0 {
+		return nil
+	}
+	req := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     o.exporter.resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "twamp"}, "metrics": metrics}},
+		}},
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := o.exporter.post(ctx, "metrics", req)
+		metricBulkLatency.Observe(time.Since(start).Seconds(), o.Name())
+		if err == nil {
+			metricDocumentsIndexed.Add(float64(len(records)), o.Name())
+			logOutput.Debug("metrics exported", "output", o.Name(), "metrics", len(metrics))
+			return nil
+		}
+		metricBulkFailures.Inc(o.Name())
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= o.retry.MaxAttempts {
+			return fmt.Errorf("giving up on otlp metrics export after %d attempts: %w", attempt, err)
+		}
+		wait := o.retry.backoff(attempt)
+		metricBulkRetries.Inc(o.Name())
+		logOutput.Warn("otlp metrics export failed, retrying", "attempt", attempt, "max_attempts", o.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// aggregate builds the batch's Metric messages; records without
+// @timestamp are skipped.
+func (o *OTLPMetricsOutput) aggregate(records []Record) []interface{} {
+	points := map[string]map[string]*otlpPoint{} // metric -> attribute key -> point
+	for _, rec := range records {
+		ts, _ := recordField(rec, "@timestamp")
+		t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(ts))
+		if err != nil {
+			continue
+		}
+		base := map[string]string{}
+		for _, a := range o.attrs {
+			if v, ok := recordField(rec, a[1]); ok && v != nil && fmt.Sprint(v) != "" {
+				base[a[0]] = fmt.Sprint(v)
+			}
+		}
+		for _, kpi := range kpiSeries {
+			v, ok := recordField(rec, kpi.field)
+			if !ok {
+				continue
+			}
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			f *= kpi.scale
+			attrs := base
+			if kpi.label != "" {
+				attrs = make(map[string]string, len(base)+1)
+				for k, v := range base {
+					attrs[k] = v
+				}
+				attrs[kpi.label] = kpi.value
+			}
+			key := fmt.Sprint(otlpAttributes(attrs))
+			if points[kpi.name] == nil {
+				points[kpi.name] = map[string]*otlpPoint{}
+			}
+			p, ok := points[kpi.name][key]
+			if !ok {
+				p = &otlpPoint{attrs: attrs, min: math.Inf(1), max: math.Inf(-1), start: t, end: t}
+				points[kpi.name][key] = p
+			}
+			p.count++
+			p.sum += f
+			p.min, p.max = math.Min(p.min, f), math.Max(p.max, f)
+			if t.Before(p.start) {
+				p.start = t
+			}
+			if t.After(p.end) {
+				p.end = t
+			}
+		}
+	}
+
+	var metrics []interface{}
+	for _, kpi := range kpiSeries {
+		byAttrs, ok := points[kpi.name]
+		if !ok {
+			continue
+		}
+		delete(points, kpi.name) // kpiSeries 에 같은 이름이 여러 번 나온다
+		keys := make([]string, 0, len(byAttrs))
+		for k := range byAttrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var dataPoints []interface{}
+		for _, k := range keys {
+			p := byAttrs[k]
+			dataPoints = append(dataPoints, map[string]interface{}{
+				"attributes":        otlpAttributes(p.attrs),
+				"startTimeUnixNano": otlpTime(p.start),
+				"timeUnixNano":      otlpTime(p.end),
+				"count":             strconv.FormatUint(p.count, 10),
+				"sum":               p.sum,
+				"min":               p.min,
+				"max":               p.max,
+				"bucketCounts":      []string{strconv.FormatUint(p.count, 10)},
+				"explicitBounds":    []float64{},
+			})
+		}
+		metrics = append(metrics, map[string]interface{}{
+			"name": "twamp." + kpi.name,
+			"unit": otelUnit(kpi.unit),
+			"histogram": map[string]interface{}{
+				"aggregationTemporality": 1, // DELTA
+				"dataPoints":             dataPoints,
+			},
+		})
+	}
+	return metrics
+}
+
+// otelUnit converts to UCUM, as OpenTelemetry units are.
+func otelUnit(unit string) string {
+	switch unit {
+	case "seconds":
+		return "s"
+	case "percent":
+		return "%"
+	}
+	return "1"
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// owdEnricher works out delays from the raw RFC 5357 timestamps of a test
+// packet when the export has them (OWD_TIMESTAMP_FIELDS, T1 sent by the
+// sender, T2 received and T3 sent back by the reflector, T4 received by
+// the sender). In microseconds, like the d* statistics:
+//
+//	owd_ul                 T2 - T1
+//	owd_dl                 T4 - T3
+//	owd_rtt                (T4 - T1) - (T3 - T2)
+//	reflector_processing   T3 - T2
+//	clock_offset           ((T2 - T1) + (T3 - T4)) / 2, reflector clock ahead of the sender's
+//
+// owd_ul/owd_dl hold the clock offset too unless both ends are
+// synchronised; records whose sync_status isn't one of SYNC_STATUS_SYNCED
+// get clock_unsynced=true. owd_rtt, the reflector time and the offset
+// estimate don't depend on it.
+type owdEnricher struct {
+	fields [4]string
+	unit   string
+	synced map[string]bool
+}
+
+// owdUnits are the OWD_TIMESTAMP_UNIT values in nanoseconds; ntp is
+// decoded separately.
+var owdUnits = map[string]int64{"s": 1e9, "ms": 1e6, "us": 1e3, "ns": 1, "ntp": 0}
+
+func newOWDEnricher(config Config) *owdEnricher {
+	o := &owdEnricher{unit: config.OWDTimestampUnit, synced: map[string]bool{}}
+	copy(o.fields[:], splitList(config.OWDTimestampFields))
+	for _, v := range splitList(config.SyncStatusSynced) {
+		o.synced[v] = true
+	}
+	return o
+}
+
+func (o *owdEnricher) Enrich(rec Record) Record {
+	var t [4]int64 // ns
+	for i, f := range o.fields {
+		v, ok := rec[f]
+		if !ok {
+			return rec
+		}
+		ns, ok := o.nanos(v)
+		if !ok {
+			return rec
+		}
+		t[i] = ns
+	}
+	us := func(ns int64) float64 { return float64(ns) / 1e3 }
+	rec["owd_ul"] = us(t[1] - t[0])
+	rec["owd_dl"] = us(t[3] - t[2])
+	rec["owd_rtt"] = us(t[3] - t[0] - (t[2] - t[1]))
+	rec["reflector_processing"] = us(t[2] - t[1])
+	rec["clock_offset"] = us((t[1] - t[0] + t[2] - t[3]) / 2)
+	if s, ok := rec["sync_status"]; ok && len(o.synced) > 0 {
+		rec["clock_unsynced"] = !o.synced[fmt.Sprint(s)]
+	}
+	return rec
+}
+
+// nanos reads a timestamp as nanoseconds, from a number in unit, a 64-bit
+// NTP timestamp (unit ntp, seconds since 1900 << 32 | fraction; too big
+// for a schema int, so left a string) or an RFC 3339 string.
+func (o *owdEnricher) nanos(v interface{}) (int64, bool) {
+	if s, ok := v.(string); ok {
+		s = strings.TrimSpace(s)
+		if o.unit == "ntp" {
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return ntpNanos(n), true
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			v = n
+		} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+			v = f
+		} else if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return ts.UnixNano(), true
+		} else {
+			return 0, false
+		}
+	}
+	scale, ok := owdUnits[o.unit]
+	if !ok {
+		return 0, false
+	}
+	switch n := v.(type) {
+	case int64:
+		if o.unit == "ntp" {
+			return ntpNanos(uint64(n)), true
+		}
+		return n * scale, true
+	case float64:
+		if o.unit == "ntp" {
+			return 0, false
+		}
+		return int64(n * float64(scale)), true
+	}
+	return 0, false
+}
+
+// ntpNanos converts an NTP timestamp to Unix nanoseconds.
+func ntpNanos(n uint64) int64 {
+	return twampTimestamp{Seconds: uint32(n >> 32), Fraction: uint32(n)}.Time().UnixNano()
+}
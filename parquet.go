@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParquetOutput writes records to Parquet files (OUTPUT=parquet) for a
+// data lake: typed columns (the SQL outputs' columns, @timestamp as
+// timestamp), partitioned Hive-style by PARQUET_PARTITION, e.g.
+// date=2024-05-01/device=ne-01/, under PARQUET_DIR or uploaded to
+// PARQUET_S3_BUCKET/PARQUET_S3_PREFIX, so the history can be queried with
+// Athena/Trino/Spark as an external table.
+//
+// Every batch becomes one file per partition, written before Write
+// returns, so a file only counts as done once its rows are stored; a
+// larger BULK_SIZE/BULK_FLUSH_INTERVAL gives fewer, larger files.
+type ParquetOutput struct {
+	dir       string
+	partition *indexTemplate
+	codec     int32
+	s3        *s3Client
+	prefix    string
+	retry     retryPolicy
+
+	columns  []parquetColumn
+	prepared bool
+}
+
+type parquetColumn struct {
+	name string
+	typ  string // tableColumns 의 Elasticsearch 타입
+}
+
+func newParquetOutput(config Config) (*ParquetOutput, error) {
+	partition, err := parseParquetPartition(config.ParquetPartition)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := parquetCodec(config.ParquetCompression)
+	if err != nil {
+		return nil, err
+	}
+	o := &ParquetOutput{
+		dir: config.ParquetDir, partition: partition, codec: codec,
+		prefix: strings.Trim(config.ParquetS3Prefix, "/"), retry: config.retryPolicy(),
+	}
+	if config.ParquetS3Bucket != "" {
+		sc := config
+		sc.S3Bucket = config.ParquetS3Bucket
+		if o.s3, err = newS3Client(sc); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// parseParquetPartition parses PARQUET_PARTITION, an index name style
+// template of the directories below PARQUET_DIR; empty means none.
+func parseParquetPartition(s string) (*indexTemplate, error) {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, "/") {
+		if part == "" || part == "." || part == ".." {
+			return nil, fmt.Errorf("PARQUET_PARTITION: invalid directory %q in %q", part, s)
+		}
+	}
+	t, err := parseIndexTemplate(s)
+	if err != nil {
+		return nil, fmt.Errorf("PARQUET_PARTITION: %w", err)
+	}
+	return t, nil
+}
+
+func parquetCodec(name string) (int32, error) {
+	switch strings.ToLower(name) {
+	case "gzip", "":
+		return parquetGzip, nil
+	case "none", "uncompressed":
+		return parquetUncompressed, nil
+	}
+	return 0, fmt.Errorf("PARQUET_COMPRESSION must be gzip or none, got %q", name)
+}
+
+func (o *ParquetOutput) Name() string { return "parquet" }
+
+func (o *ParquetOutput) Close() error { return nil }
+
+// prepare sets the columns from the schema (after MAPPING_FILE, plus the
+// KPIs); fields without one are left out, like in the SQL outputs.
+func (o *ParquetOutput) prepare(schema *Schema, mapping *fieldMapping, config Config) {
+	names, types := tableColumns(schema, mapping, config)
+	o.columns = []parquetColumn{{"timestamp", "date"}}
+	for _, name := range names {
+		o.columns = append(o.columns, parquetColumn{name, types[name]})
+	}
+	o.columns = append(o.columns, parquetColumn{pipelineField, "keyword"})
+	o.prepared = true
+}
+
+func (o *ParquetOutput) Write(ctx context.Context, records []Record) error {
+	if !o.prepared {
+		return errors.New("parquet output used before prepare")
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	parts := map[string][]Record{}
+	for _, rec := range records {
+		key := ""
+		if o.partition != nil {
+			key = o.partition.Resolve(rec)
+		}
+		parts[key] = append(parts[key], rec)
+	}
+	keys := make([]string, 0, len(parts))
+	for key := range parts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rows := parts[key]
+		data, err := encodeParquet(o.columns, rows, o.codec)
+		if err != nil {
+			return err
+		}
+		name := parquetFileName()
+		start := time.Now()
+		if o.s3 != nil {
+			err = o.upload(ctx, path.Join(o.prefix, key, name), data)
+		} else {
+			err = writeFileAtomic(filepath.Join(o.dir, filepath.FromSlash(key), name), bytes.NewReader(data))
+		}
+		metricBulkLatency.Observe(time.Since(start).Seconds(), o.Name())
+		if err != nil {
+			metricBulkFailures.Inc(o.Name())
+			return fmt.Errorf("error writing parquet file %s: %w", path.Join(key, name), err)
+		}
+		metricDocumentsIndexed.Add(float64(len(rows)), o.Name())
+		logOutput.Debug("parquet file written", "output", o.Name(), "partition", key, "file", name, "rows", len(rows), "bytes", len(data))
+	}
+	return nil
+}
+
+func (o *ParquetOutput) upload(ctx context.Context, key string, data []byte) error {
+	for attempt := 1; ; attempt++ {
+		err := o.s3.put(ctx, key, data)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= o.retry.MaxAttempts {
+			return fmt.Errorf("giving up on S3 upload after %d attempts: %w", attempt, err)
+		}
+		wait := o.retry.backoff(attempt)
+		metricBulkRetries.Inc(o.Name())
+		logOutput.Warn("parquet upload failed, retrying", "attempt", attempt, "max_attempts", o.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// parquetFileName is unique across instances writing the same partition.
+func parquetFileName() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return "twamp-" + time.Now().UTC().Format("20060102T150405.000Z") + "-" + hex.EncodeToString(b[:]) + ".parquet"
+}
+
+// Parquet 상수 (parquet.thrift)
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetUncompressed = 0
+	parquetGzip         = 2
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// parquetType returns the physical type and the converted type (-1 for
+// none) of a column of the Elasticsearch type typ.
+func parquetType(typ string) (int32, int32) {
+	switch typ {
+	case "long":
+		return parquetInt64, -1
+	case "double":
+		return parquetDouble, -1
+	case "boolean":
+		return parquetBoolean, -1
+	case "date":
+		return parquetInt64, parquetTimestampMillis
+	}
+	return parquetByteArray, parquetUTF8
+}
+
+// encodeParquet encodes rows as a Parquet file with one row group: every
+// column optional, one PLAIN data page per column, definition levels
+// RLE encoded.
+func encodeParquet(columns []parquetColumn, rows []Record, codec int32) ([]byte, error) {
+	out := []byte("PAR1")
+	type chunk struct {
+		offset, size, raw int64
+	}
+	chunks := make([]chunk, len(columns))
+	var total int64
+	for i, col := range columns {
+		typ, _ := parquetType(col.typ)
+		page, err := parquetPage(col, typ, rows)
+		if err != nil {
+			return nil, err
+		}
+		data := page
+		if codec == parquetGzip {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(page)
+			if err := zw.Close(); err != nil {
+				return nil, err
+			}
+			data = buf.Bytes()
+		}
+		h := &thriftWriter{}
+		h.begin()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(len(rows)))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.end()
+		h.end()
+		chunks[i] = chunk{int64(len(out)), int64(len(h.buf) + len(data)), int64(len(h.buf) + len(page))}
+		total += chunks[i].raw
+		out = append(append(out, h.buf...), data...)
+	}
+
+	m := &thriftWriter{}
+	m.begin()
+	m.i32(1, 1)
+	m.list(2, thriftStruct, len(columns)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.end()
+	for _, col := range columns {
+		typ, converted := parquetType(col.typ)
+		m.begin()
+		m.i32(1, typ)
+		m.i32(3, 1) // OPTIONAL
+		m.str(4, col.name)
+		if converted >= 0 {
+			m.i32(6, converted)
+		}
+		m.end()
+	}
+	m.i64(3, int64(len(rows)))
+	m.list(4, thriftStruct, 1)
+	m.begin()
+	m.list(1, thriftStruct, len(columns))
+	for i, col := range columns {
+		typ, _ := parquetType(col.typ)
+		m.begin()
+		m.i64(2, chunks[i].offset)
+		m.beginStruct(3)
+		m.i32(1, typ)
+		m.list(2, thriftI32, 2)
+		m.elemI32(parquetPlain)
+		m.elemI32(parquetRLE)
+		m.list(3, thriftBinary, 1)
+		m.elemStr(col.name)
+		m.i32(4, codec)
+		m.i64(5, int64(len(rows)))
+		m.i64(6, chunks[i].raw)
+		m.i64(7, chunks[i].size)
+		m.i64(9, chunks[i].offset)
+		m.end()
+		m.end()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(len(rows)))
+	m.end()
+	m.str(6, "twamp")
+	m.end()
+
+	out = append(out, m.buf...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(m.buf)))
+	return append(out, "PAR1"...), nil
+}
+
+// parquetPage is the uncompressed body of a v1 data page: the definition
+// levels (1 set, 0 null) and the PLAIN values that are set.
+func parquetPage(col parquetColumn, typ int32, rows []Record) ([]byte, error) {
+	levels := make([]bool, len(rows))
+	var values []byte
+	var bits []bool
+	for i, rec := range rows {
+		v, ok := rec[col.name]
+		if col.name == "timestamp" {
+			v, ok = rec["@timestamp"]
+		}
+		if !ok || v == nil {
+			continue
+		}
+		switch typ {
+		case parquetInt64:
+			var n int64
+			if col.typ == "date" {
+				ms, ok := parquetMillis(v)
+				if !ok {
+					continue
+				}
+				n = ms
+			} else {
+				f, ok := numericValue(v)
+				if !ok {
+					continue
+				}
+				if iv, ok := v.(int64); ok {
+					n = iv
+				} else {
+					n = int64(f)
+				}
+			}
+			values = binary.LittleEndian.AppendUint64(values, uint64(n))
+		case parquetDouble:
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(f))
+		case parquetBoolean:
+			b, ok := v.(bool)
+			if !ok {
+				continue
+			}
+			bits = append(bits, b)
+		default:
+			s := parquetString(v)
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(s)))
+			values = append(values, s...)
+		}
+		levels[i] = true
+	}
+	if typ == parquetBoolean {
+		values = make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				values[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+	// 정의 레벨: RLE run 마다 (길이<<1, 값), 앞에 4바이트 길이
+	var rle []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		rle = binary.AppendUvarint(rle, uint64(j-i)<<1)
+		if levels[i] {
+			rle = append(rle, 1)
+		} else {
+			rle = append(rle, 0)
+		}
+		i = j
+	}
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(rle)))
+	return append(append(page, rle...), values...), nil
+}
+
+// parquetMillis returns a date as milliseconds since the epoch: RFC 3339
+// strings, or numbers taken as epoch milliseconds.
+func parquetMillis(v interface{}) (int64, bool) {
+	if s, ok := v.(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, false
+		}
+		return t.UnixMilli(), true
+	}
+	f, ok := numericValue(v)
+	return int64(f), ok
+}
+
+func parquetString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// thriftWriter writes the Thrift compact protocol, as much of it as the
+// Parquet page headers and file footer need.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // struct 마다 마지막 field id
+}
+
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (w *thriftWriter) field(typ byte, id int16) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+// begin starts a top-level struct or a struct element of a list.
+func (w *thriftWriter) begin() { w.last = append(w.last, 0) }
+
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(thriftStruct, id)
+	w.begin()
+}
+
+func (w *thriftWriter) end() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(thriftI32, id)
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(thriftI64, id)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(thriftBinary, id)
+	w.elemStr(s)
+}
+
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(thriftList, id)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elem)
+	w.buf = binary.AppendUvarint(w.buf, uint64(n))
+}
+
+func (w *thriftWriter) elemI32(v int32) { w.buf = binary.AppendVarint(w.buf, int64(v)) }
+
+func (w *thriftWriter) elemStr(s string) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// rowReader reads the rows of one CSV document in some export format.
+// Next returns io.EOF after the last data row; a *csv.ParseError rejects
+// just that row (with the fields read, if any), any other error aborts the
+// document. Line is the input line the last row started on. The row is
+// only valid until the next call to Next. Formats without a header row
+// (JSON, XML) add a column to Header when a record brings a new field, so
+// a row's columns are those of Header after its Next.
+type rowReader interface {
+	Header() []string
+	Next() ([]string, error)
+	Line() int
+}
+
+// parserFunc opens one document; name is only for messages.
+type parserFunc func(name string, r io.Reader) (rowReader, error)
+
+// parsers is the registry of export formats, selected with PARSER or a
+// pipeline's parser. Vendor formats register themselves from their own
+// file (parser_vendors.go), so adding one doesn't touch the ingest code.
+var parsers = map[string]func(csvOptions) (parserFunc, error){}
+
+// registerParser adds a format with its own rowReader; it takes no
+// csvOptions.
+func registerParser(name string, p parserFunc) {
+	addParser(name, func(opts csvOptions) (parserFunc, error) {
+		if !opts.empty() {
+			return nil, fmt.Errorf("parser %s doesn't take csv options", name)
+		}
+		return p, nil
+	})
+}
+
+// registerFormat adds a csvFormat variant; csvOptions override its settings.
+func registerFormat(name string, f csvFormat) {
+	addParser(name, func(opts csvOptions) (parserFunc, error) {
+		f, err := f.with(opts)
+		if err != nil {
+			return nil, err
+		}
+		return f.parser(), nil
+	})
+}
+
+func addParser(name string, p func(csvOptions) (parserFunc, error)) {
+	if _, dup := parsers[name]; dup {
+		panic("parser registered twice: " + name)
+	}
+	parsers[name] = p
+}
+
+func lookupParser(name string, opts csvOptions) (parserFunc, error) {
+	if p, ok := parsers[name]; ok {
+		return p(opts)
+	}
+	names := make([]string, 0, len(parsers))
+	for n := range parsers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown parser %q (%s)", name, strings.Join(names, ", "))
+}
+
+// csvOptions are the CSV_* settings and a pipeline's csv block; empty ones
+// keep the parser's own.
+//
+//	csv:
+//	  delimiter: ";"    # one character, or "tab"
+//	  quotes: lazy      # strict (RFC 4180) or lazy (bare quotes inside fields)
+//	  comment: "#"      # lines starting with it are skipped
+//	  comments: ["//", "REM "]  # the same for longer prefixes
+//	  skip_lines: 3     # banner lines before the header
+//	  footer: "^(Total|Report generated)"  # ends the data
+//	  header: false     # no header row, the parser's columns are used
+//	  columns: [statTime, Session Id, ...]
+//
+// columns names the fields in order for files without a header row (header
+// then defaults to false); with header: true they replace the header's
+// names and the header must have as many.
+//
+// skip_lines, comments and footer work on the lines before they are
+// split into fields, so banners and summary blocks with another column
+// count don't break the alignment: footer is a regexp, and the first line
+// it matches ends the data. Lines inside a quoted field are left alone.
+type csvOptions struct {
+	Delimiter string   `json:"delimiter"`
+	Quotes    string   `json:"quotes"`
+	Comment   string   `json:"comment"`
+	Comments  []string `json:"comments"`
+	SkipLines int      `json:"skip_lines"`
+	Footer    string   `json:"footer"`
+	Header    *bool    `json:"header"`
+	Columns   []string `json:"columns"`
+}
+
+func (o csvOptions) empty() bool {
+	return o.Delimiter == "" && o.Quotes == "" && o.Comment == "" && len(o.Comments) == 0 && o.SkipLines == 0 &&
+		o.Footer == "" && o.Header == nil && len(o.Columns) == 0
+}
+
+// over fills the options o leaves empty from base.
+func (o csvOptions) over(base csvOptions) csvOptions {
+	if o.Delimiter == "" {
+		o.Delimiter = base.Delimiter
+	}
+	if o.Quotes == "" {
+		o.Quotes = base.Quotes
+	}
+	if o.Comment == "" {
+		o.Comment = base.Comment
+	}
+	if len(o.Comments) == 0 {
+		o.Comments = base.Comments
+	}
+	if o.SkipLines == 0 {
+		o.SkipLines = base.SkipLines
+	}
+	if o.Footer == "" {
+		o.Footer = base.Footer
+	}
+	if o.Header == nil {
+		o.Header = base.Header
+	}
+	if len(o.Columns) == 0 {
+		o.Columns = base.Columns
+	}
+	return o
+}
+
+// with returns f with opts applied.
+func (f csvFormat) with(opts csvOptions) (csvFormat, error) {
+	switch d := opts.Delimiter; {
+	case d == "":
+	case d == "tab" || d == `\t`:
+		f.Comma = '\t'
+	case utf8.RuneCountInString(d) == 1:
+		f.Comma, _ = utf8.DecodeRuneInString(d)
+	default:
+		return f, fmt.Errorf("csv delimiter must be one character or tab, got %q", d)
+	}
+	switch opts.Quotes {
+	case "":
+	case "strict":
+		f.LazyQuotes = false
+	case "lazy":
+		f.LazyQuotes = true
+	default:
+		return f, fmt.Errorf("csv quotes must be strict or lazy, got %q", opts.Quotes)
+	}
+	switch c := opts.Comment; {
+	case c == "":
+	case utf8.RuneCountInString(c) == 1:
+		f.Comment, _ = utf8.DecodeRuneInString(c)
+	default:
+		return f, fmt.Errorf("csv comment must be one character, got %q", c)
+	}
+	for _, c := range opts.Comments {
+		if c == "" {
+			return f, fmt.Errorf("csv comments: empty prefix")
+		}
+	}
+	if len(opts.Comments) > 0 {
+		f.Comments = opts.Comments
+	}
+	if opts.SkipLines < 0 {
+		return f, fmt.Errorf("csv skip_lines can't be negative, got %d", opts.SkipLines)
+	}
+	if opts.SkipLines > 0 {
+		f.SkipLines = opts.SkipLines
+	}
+	if opts.Footer != "" {
+		re, err := regexp.Compile(opts.Footer)
+		if err != nil {
+			return f, fmt.Errorf("csv footer: %w", err)
+		}
+		f.FooterLine = re
+	}
+	if len(opts.Columns) > 0 {
+		f.Columns, f.NoHeader, f.Rename = opts.Columns, true, false
+		if opts.Header != nil && *opts.Header {
+			f.NoHeader, f.Rename = false, true
+		}
+	} else if opts.Header != nil {
+		f.NoHeader = !*opts.Header
+	}
+	if f.NoHeader && len(f.Columns) == 0 {
+		return f, fmt.Errorf("csv header: false needs columns (or a parser with a column list)")
+	}
+	seen := map[string]bool{}
+	for _, c := range opts.Columns {
+		if c == "" || seen[c] {
+			return f, fmt.Errorf("csv columns: empty or duplicate column %q", c)
+		}
+		seen[c] = true
+	}
+	if f.Comma == f.Comment || f.Comma == '"' || f.Comma == '\r' || f.Comma == '\n' {
+		return f, fmt.Errorf("invalid csv delimiter %q", f.Comma)
+	}
+	return f, nil
+}
+
+// csvFormat is the CSV reader the built-in formats are variants of. The
+// zero value plus Comma is a plain CSV with a header in the first line.
+type csvFormat struct {
+	Comma      rune
+	Comment    rune // lines starting with it are skipped
+	LazyQuotes bool
+	// Preamble skips lines before the header, e.g. report titles; the
+	// header is the first line it returns false for.
+	Preamble func(row []string) bool
+	// Footer drops summary rows (totals, averages) wherever they appear.
+	Footer func(row []string) bool
+	// SkipLines drops the first lines of the file, Comments the lines
+	// starting with one of them, and FooterLine ends the data at the first
+	// line it matches; see lineFilter.
+	SkipLines  int
+	Comments   []string
+	FooterLine *regexp.Regexp
+	// Column normalizes header names, e.g. strips units.
+	Column func(string) string
+	// NoHeader files start with data; Columns names their fields in order.
+	// Rename uses Columns in place of the header row's names.
+	NoHeader bool
+	Rename   bool
+	Columns  []string
+}
+
+// parser opens CSV documents with f; XML and JSON documents, told by their
+// first bytes, go to those parsers, so one pipeline takes the CSV, XML
+// and JSON exports of its probes alike.
+func (f csvFormat) parser() parserFunc {
+	return func(name string, r io.Reader) (rowReader, error) {
+		br := bufio.NewReaderSize(r, 4096)
+		switch sniffFormat(br) {
+		case "xml":
+			return openXML(name, br)
+		case "json":
+			return openJSON(name, br)
+		}
+		return f.open(name, br)
+	}
+}
+
+// sniffFormat tells XML ("<?xml", "<!--" or an element) and JSON (an
+// array or object of values) from CSV, by the first bytes after a BOM and
+// white space.
+func sniffFormat(br *bufio.Reader) string {
+	head, _ := br.Peek(512)
+	head = bytes.TrimPrefix(head, []byte("\ufeff"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if len(head) < 2 {
+		return "csv"
+	}
+	next := bytes.TrimLeft(head[1:], " \t\r\n")
+	if len(next) == 0 {
+		return "csv"
+	}
+	switch head[0] {
+	case '<':
+		if c := head[1]; c == '?' || c == '!' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			return "xml"
+		}
+	case '[':
+		if c := next[0]; c == '{' || c == '[' || c == ']' {
+			return "json"
+		}
+	case '{':
+		if c := next[0]; c == '"' || c == '}' {
+			return "json"
+		}
+	}
+	return "csv"
+}
+
+type csvRows struct {
+	format csvFormat
+	reader *csv.Reader
+	header []string
+	rows   int
+	line   int
+}
+
+func (f csvFormat) open(name string, r io.Reader) (rowReader, error) {
+	if f.SkipLines > 0 || len(f.Comments) > 0 || f.FooterLine != nil {
+		r = newLineFilter(r, f)
+	}
+	reader := csv.NewReader(r)
+	reader.Comma = f.Comma
+	reader.Comment = f.Comment
+	reader.LazyQuotes = f.LazyQuotes
+	// 열 개수는 Next 에서 header 와 비교한다 (preamble 은 개수가 다를 수 있다)
+	reader.FieldsPerRecord = -1
+
+	var header []string
+	if f.NoHeader {
+		header = append([]string(nil), f.Columns...)
+	}
+	for header == nil {
+		row, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("error reading header of %s: %w", name, err)
+		}
+		if f.Preamble == nil || !f.Preamble(row) {
+			header = row
+		}
+	}
+	if len(header) > 0 && !f.NoHeader {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	if f.Column != nil {
+		for i, h := range header {
+			header[i] = f.Column(h)
+		}
+	}
+	if f.Rename {
+		if len(header) != len(f.Columns) {
+			return nil, fmt.Errorf("header of %s has %d columns but %d are configured", name, len(header), len(f.Columns))
+		}
+		header = append([]string(nil), f.Columns...)
+	}
+	// header 를 읽은 뒤부터 행 slice 를 재사용한다
+	reader.ReuseRecord = true
+	return &csvRows{format: f, reader: reader, header: header}, nil
+}
+
+func (c *csvRows) Header() []string { return c.header }
+
+func (c *csvRows) Line() int { return c.line }
+
+func (c *csvRows) Next() ([]string, error) {
+	for {
+		row, err := c.reader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			c.line = parseErr.StartLine
+		}
+		if err != nil {
+			return row, err
+		}
+		c.line, _ = c.reader.FieldPos(0)
+		if c.format.Footer != nil && c.format.Footer(row) {
+			continue
+		}
+		if len(row) != len(c.header) {
+			// 헤더 없는 파일의 첫 행부터 맞지 않으면 설정된 열 목록이 틀린 것이다
+			if c.format.NoHeader && c.rows == 0 {
+				return nil, fmt.Errorf("line %d has %d columns but %d are configured", c.line, len(row), len(c.header))
+			}
+			return row, &csv.ParseError{StartLine: c.line, Line: c.line,
+				Err: fmt.Errorf("%w: %d instead of %d", csv.ErrFieldCount, len(row), len(c.header))}
+		}
+		c.rows++
+		return row, nil
+	}
+}
+
+// keyedRows builds the rows of formats whose records name their fields
+// (JSON objects, XML elements): a field's column is added to the header
+// the first time it appears, and a record without it leaves it empty.
+type keyedRows struct {
+	header []string
+	index  map[string]int
+	row    []string
+}
+
+// reset starts a record.
+func (k *keyedRows) reset() {
+	k.row = k.row[:0]
+	for range k.header {
+		k.row = append(k.row, "")
+	}
+}
+
+// set fills the field name; one that repeats in a record is joined with
+// a comma.
+func (k *keyedRows) set(name, value string) {
+	if k.index == nil {
+		k.index = map[string]int{}
+	}
+	i, ok := k.index[name]
+	if !ok {
+		i = len(k.header)
+		k.header = append(k.header, name)
+		k.index[name] = i
+		k.row = append(k.row, "")
+	}
+	if k.row[i] != "" {
+		value = k.row[i] + "," + value
+	}
+	k.row[i] = value
+}
+
+func (k *keyedRows) Header() []string { return k.header }
+
+// csvExportColumns is the column order of TWAMP CSVexport 1.0, for files
+// exported without the header row.
+func csvExportColumns() []string {
+	cols := []string{"CSVexport Version", "Session Type", "Session Name", "Session Id", "Source NE",
+		"Model", "Type", "Serial", "Interface", "Source Ip", "Source Port", "Destination Ip",
+		"Destination Port", "Interval", "Packet Rate", "Packet Size", "statTime", "statRound",
+		"intervalms", "syncStatus"}
+	counters := []string{"statStatus", "firstpktSeq", "lastpktSeq", "rxpkts", "rxbytes", "misorderpkts",
+		"duplicatepkts", "toolatepkts", "lostpkts", "lostperiods", "lostburstmin", "lostburstmax",
+		"lostperc", "mos", "r", "tosmin", "tosmax", "vpriomin", "vpriomax", "cksum", "ttlmin", "ttlmax"}
+	for _, dir := range []string{"ul_", "dl_"} {
+		for _, c := range counters {
+			cols = append(cols, dir+c)
+		}
+		for _, stat := range []string{"d", "j", "dv"} {
+			for _, suffix := range directionStatSuffixes {
+				// delay variation 은 min 과 StdDev 가 없다
+				if stat == "dv" && (suffix == "min" || suffix == "StdDev") {
+					continue
+				}
+				cols = append(cols, dir+stat+suffix)
+			}
+		}
+	}
+	return append(cols, "System Id")
+}
+
+func init() {
+	// 기본: 첫 줄이 header 인 쉼표 구분 CSV (TWAMP CSVexport)
+	registerFormat("twamp", csvFormat{Comma: ',', Columns: csvExportColumns()})
+}
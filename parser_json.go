@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// JSON exports: an array of records or one record per line (NDJSON). A
+// record is an object, its nested objects and lists flattened into
+// dotted names ({"ul": {"lostpkts": 0}} is ul.lostpkts, list items are
+// numbered from 0), or a list of values in the order of a first list of
+// column names:
+//
+//	[{"statTime": "2024-05-01 12:00:00", "Session Id": 7, ...}, ...]
+//	[["statTime", "Session Id", ...], ["2024-05-01 12:00:00", 7, ...], ...]
+//
+// Numbers keep their text. A record that isn't an object or list, or a
+// list with another number of values, is rejected; a syntax error stops
+// the document, as there's no telling where the next record starts.
+type jsonRows struct {
+	keyedRows
+	dec   *json.Decoder
+	lines *lineCounter
+	array bool
+	done  bool
+	line  int
+	// 첫 list 가 열 이름이면 이후 list 는 그 순서다
+	positional bool
+}
+
+func openJSON(name string, r io.Reader) (rowReader, error) {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\ufeff")) {
+		br.Discard(3)
+	}
+	lines := &lineCounter{r: br}
+	j := &jsonRows{dec: json.NewDecoder(lines), lines: lines}
+	j.dec.UseNumber()
+	head, _ := br.Peek(512)
+	if first := bytes.TrimLeft(head, " \t\r\n"); len(first) > 0 && first[0] == '[' {
+		if _, err := j.dec.Token(); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", name, err)
+		}
+		j.array = true
+	}
+	return j, nil
+}
+
+func (j *jsonRows) Line() int { return j.line }
+
+func (j *jsonRows) Next() ([]string, error) {
+	for {
+		if j.done || j.array && !j.dec.More() {
+			j.done = true
+			return nil, io.EOF
+		}
+		var raw json.RawMessage
+		if err := j.dec.Decode(&raw); err != nil {
+			if err == io.EOF && !j.array {
+				j.done = true
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("JSON record after line %d: %w", j.line, err)
+		}
+		j.line = j.lines.at(j.dec.InputOffset() - int64(len(raw)))
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			j.reset()
+			j.object("", v)
+			return j.row, nil
+		case []interface{}:
+			if len(j.header) == 0 && !j.positional {
+				if names, ok := jsonNames(v); ok {
+					for _, n := range names {
+						j.set(n, "")
+					}
+					j.positional = true
+					continue
+				}
+			}
+			if !j.positional {
+				return nil, &csv.ParseError{StartLine: j.line, Line: j.line, Err: fmt.Errorf("a list record needs a list of column names first")}
+			}
+			j.row = j.row[:0]
+			for _, e := range v {
+				j.row = append(j.row, jsonText(e))
+			}
+			if len(j.row) != len(j.header) {
+				return j.row, &csv.ParseError{StartLine: j.line, Line: j.line,
+					Err: fmt.Errorf("%w: %d instead of %d", csv.ErrFieldCount, len(j.row), len(j.header))}
+			}
+			return j.row, nil
+		default:
+			return nil, &csv.ParseError{StartLine: j.line, Line: j.line, Err: fmt.Errorf("record is %.40s, not an object or a list", raw)}
+		}
+	}
+}
+
+// object flattens m into the fields of the record, in name order so the
+// columns come out the same for every file.
+func (j *jsonRows) object(prefix string, m map[string]interface{}) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		j.value(joinField(prefix, k), m[k])
+	}
+}
+
+func (j *jsonRows) value(name string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		j.object(name, v)
+	case []interface{}:
+		for i, e := range v {
+			j.value(joinField(name, strconv.Itoa(i)), e)
+		}
+	default:
+		j.set(name, jsonText(v))
+	}
+}
+
+func joinField(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// jsonText is a JSON value as a CSV field: null is empty, nested values
+// stay JSON.
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// jsonNames returns a list of strings, the column names of list records.
+func jsonNames(v []interface{}) ([]string, bool) {
+	names := make([]string, 0, len(v))
+	for _, e := range v {
+		s, ok := e.(string)
+		if !ok || s == "" {
+			return nil, false
+		}
+		names = append(names, s)
+	}
+	return names, len(names) > 0
+}
+
+// lineCounter notes where the newlines of a stream are, so decoders that
+// only know byte offsets can tell the line of a record. Offsets are asked
+// for in order; newlines before them are forgotten.
+type lineCounter struct {
+	r        io.Reader
+	read     int64
+	newlines []int64
+	passed   int
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i := 0; i < n; {
+		k := bytes.IndexByte(p[i:n], '\n')
+		if k < 0 {
+			break
+		}
+		l.newlines = append(l.newlines, l.read+int64(i+k))
+		i += k + 1
+	}
+	l.read += int64(n)
+	return n, err
+}
+
+// at returns the line of offset.
+func (l *lineCounter) at(offset int64) int {
+	k := 0
+	for k < len(l.newlines) && l.newlines[k] < offset {
+		k++
+	}
+	l.passed += k
+	l.newlines = l.newlines[k:]
+	return l.passed + 1
+}
+
+func init() {
+	registerParser("json", openJSON)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 벤더별 export 형식. 새 벤더는 여기에 registerFormat 한 줄 (또는 자체
+// rowReader 를 registerParser) 을 추가하면 된다; 열 이름은 SCHEMA_FILE / pipeline 의
+// schema_file 로 맞춘다.
+
+// unitSuffix matches a trailing unit in a header, e.g. "Delay Min (us)".
+var unitSuffix = regexp.MustCompile(`\s*[(\[][^)\]]*[)\]]\s*$`)
+
+// summaryRow reports rows whose first field starts a totals line.
+func summaryRow(prefixes ...string) func([]string) bool {
+	return func(row []string) bool {
+		if len(row) == 0 {
+			return false
+		}
+		first := strings.ToLower(strings.TrimSpace(row[0]))
+		for _, p := range prefixes {
+			if strings.HasPrefix(first, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func init() {
+	// Huawei NCE: ';' 구분, 따옴표가 엄격하지 않고 끝에 합계 행이 붙는다
+	registerFormat("huawei", csvFormat{
+		Comma:      ';',
+		LazyQuotes: true,
+		Footer:     summaryRow("total", "summary"),
+	})
+
+	// Nokia NSP: '#' 주석 행, header 에 단위가 붙는다
+	registerFormat("nokia", csvFormat{
+		Comma:   ',',
+		Comment: '#',
+		Column: func(h string) string {
+			return unitSuffix.ReplaceAllString(strings.TrimSpace(h), "")
+		},
+	})
+
+	// Juniper RPM history export: 탭 구분, header 앞뒤 공백
+	registerFormat("juniper", csvFormat{
+		Comma:  '\t',
+		Column: strings.TrimSpace,
+	})
+
+	// Cisco IP SLA report: header 앞에 제목 행들, 공백이 붙은 header, 합계/평균 행
+	registerFormat("cisco-ipsla", csvFormat{
+		Comma:    ',',
+		Comment:  '!',
+		Preamble: func(row []string) bool { return len(row) <= 1 },
+		Footer:   summaryRow("total", "average"),
+		Column:   strings.TrimSpace,
+	})
+}
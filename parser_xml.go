@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XML exports come in two shapes. 3GPP PM files (TS 32.435 measCollecFile,
+// what most EMS write) give one row per measValue: managedElement,
+// measInfoId, measObjLdn, beginTime, endTime and duration of the granularity
+// period, suspect, and a column per measType. In any other document the
+// children of the root element are the records, their attributes and
+// child elements the fields, nested ones with dotted names:
+//
+//	<results>
+//	  <result session="7"><statTime>2024-05-01 12:00:00</statTime><ul><lostpkts>0</lostpkts></ul></result>
+//	</results>
+//
+// is session, statTime and ul.lostpkts. An element repeated in a record is
+// joined with a comma. A syntax error stops the document.
+type xmlRows struct {
+	keyedRows
+	dec  *xml.Decoder
+	line int
+	pm   bool
+	done bool
+
+	// measCollecFile 의 현재 위치
+	managedElement, infoID, beginTime, endTime, duration string
+	types                                                []string
+	typesByP                                             map[string]string
+}
+
+func openXML(name string, r io.Reader) (rowReader, error) {
+	x := &xmlRows{dec: xml.NewDecoder(bufio.NewReader(r)), typesByP: map[string]string{}}
+	x.dec.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+		return xmlCharsetReader(name, label, r)
+	}
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", name, err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			x.pm = start.Name.Local == "measCollecFile"
+			return x, nil
+		}
+	}
+}
+
+// xmlCharsetReader takes the encodings Korean EMS declare; with CHARSET
+// auto or cp949 the document may already be UTF-8, which is kept.
+func xmlCharsetReader(name, label string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "euc-kr", "cp949", "uhc", "ks_c_5601-1987", "windows-949":
+		return &charsetDetector{name: name, r: bufio.NewReader(r)}, nil
+	case "us-ascii", "ascii":
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported XML encoding %q", label)
+}
+
+func (x *xmlRows) Line() int { return x.line }
+
+func (x *xmlRows) Next() ([]string, error) {
+	if x.done {
+		return nil, io.EOF
+	}
+	if x.pm {
+		return x.nextMeasValue()
+	}
+	for {
+		tok, err := x.dec.Token()
+		if err == io.EOF {
+			// 닫히지 않은 root 는 Token 이 오류로 알린다
+			x.done = true
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, x.syntaxError(err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			x.line, _ = x.dec.InputPos()
+			x.reset()
+			if err := x.element("", tok); err != nil {
+				return nil, x.syntaxError(err)
+			}
+			return x.row, nil
+		case xml.EndElement:
+			x.done = true
+			return nil, io.EOF
+		}
+	}
+}
+
+// element reads the fields of start up to its end element; name is its
+// dotted name in the record, empty for the record itself.
+func (x *xmlRows) element(name string, start xml.StartElement) error {
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+			continue
+		}
+		x.set(joinField(name, a.Name.Local), a.Value)
+	}
+	var text strings.Builder
+	children := false
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			children = true
+			if err := x.element(joinField(name, tok.Name.Local), tok); err != nil {
+				return err
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if v := strings.TrimSpace(text.String()); name != "" && (v != "" || !children) {
+				x.set(name, v)
+			}
+			return nil
+		}
+	}
+}
+
+// nextMeasValue reads up to the next measValue of a measCollecFile.
+func (x *xmlRows) nextMeasValue() ([]string, error) {
+	var results []string
+	var resultsByP [][2]string // p, 값
+	suspect := ""
+	for {
+		tok, err := x.dec.Token()
+		if err == io.EOF {
+			x.done = true
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, x.syntaxError(err)
+		}
+		if end, ok := tok.(xml.EndElement); ok {
+			switch end.Name.Local {
+			case "measCollecFile":
+				x.done = true
+				return nil, io.EOF
+			case "measValue":
+				return x.measRow(results, resultsByP, suspect)
+			}
+			continue
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var text string
+		switch start.Name.Local {
+		case "measCollec":
+			if v := xmlAttr(start, "beginTime"); v != "" {
+				x.beginTime = v
+			}
+		case "managedElement":
+			x.managedElement = xmlAttr(start, "localDn")
+			if x.managedElement == "" {
+				x.managedElement = xmlAttr(start, "userLabel")
+			}
+		case "measInfo":
+			x.infoID, x.types, x.typesByP = xmlAttr(start, "measInfoId"), nil, map[string]string{}
+		case "granPeriod":
+			x.duration, x.endTime = xmlAttr(start, "duration"), xmlAttr(start, "endTime")
+		case "measTypes":
+			text, err = x.text()
+			x.types = strings.Fields(text)
+		case "measType":
+			text, err = x.text()
+			x.typesByP[xmlAttr(start, "p")] = strings.TrimSpace(text)
+		case "measValue":
+			x.line, _ = x.dec.InputPos()
+			x.reset()
+			x.set("managedElement", x.managedElement)
+			x.set("measInfoId", x.infoID)
+			x.set("measObjLdn", xmlAttr(start, "measObjLdn"))
+			x.set("beginTime", x.beginTime)
+			x.set("endTime", x.endTime)
+			x.set("duration", x.duration)
+		case "measResults":
+			text, err = x.text()
+			results = strings.Fields(text)
+		case "r":
+			text, err = x.text()
+			resultsByP = append(resultsByP, [2]string{xmlAttr(start, "p"), strings.TrimSpace(text)})
+		case "suspect":
+			text, err = x.text()
+			suspect = strings.TrimSpace(text)
+		}
+		if err != nil {
+			return nil, x.syntaxError(err)
+		}
+	}
+}
+
+// measRow fills the counters of a measValue: measResults in the order of
+// measTypes, or r matched to measType by p.
+func (x *xmlRows) measRow(results []string, resultsByP [][2]string, suspect string) ([]string, error) {
+	if suspect != "" {
+		x.set("suspect", suspect)
+	}
+	for _, r := range resultsByP {
+		name, ok := x.typesByP[r[0]]
+		if !ok {
+			return x.row, &csv.ParseError{StartLine: x.line, Line: x.line, Err: fmt.Errorf("measValue result p=%q has no measType", r[0])}
+		}
+		if r[1] != "NIL" {
+			x.set(name, r[1])
+		}
+	}
+	if len(results) > 0 && len(results) != len(x.types) {
+		return x.row, &csv.ParseError{StartLine: x.line, Line: x.line,
+			Err: fmt.Errorf("%w: %d measResults for %d measTypes", csv.ErrFieldCount, len(results), len(x.types))}
+	}
+	for i, v := range results {
+		// 3GPP 는 측정하지 못한 값을 NIL 로 쓴다
+		if v != "NIL" {
+			x.set(x.types[i], v)
+		}
+	}
+	return x.row, nil
+}
+
+// text reads the character data up to the end of the current element.
+func (x *xmlRows) text() (string, error) {
+	var b strings.Builder
+	depth := 0
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			if depth == 0 {
+				b.Write(tok)
+			}
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return b.String(), nil
+			}
+			depth--
+		}
+	}
+}
+
+func (x *xmlRows) syntaxError(err error) error {
+	line, _ := x.dec.InputPos()
+	return fmt.Errorf("XML line %d: %w", line, err)
+}
+
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func init() {
+	registerParser("xml", openXML)
+}
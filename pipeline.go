@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pipelineConfig is one entry of the config file's pipelines list: a
+// directory watched with its own export format (PARSER), parser profile
+// (SCHEMA_FILE), index (ES_INDEX) and transform rules (MAPPING_FILE).
+// Unset ones fall back to the global settings.
+//
+//	pipelines:
+//	  - name: vendor-a
+//	    path: /data/twamp/vendor-a
+//	    recursive: true
+//	    include: ["*.csv.gz"]
+//	    parser: huawei
+//	    charset: cp949
+//	    csv:
+//	      delimiter: ";"
+//	      footer: "^Total"
+//	    schema_file: ./schema-vendor-a.yaml
+//	    index: twamp-vendor-a-%{+yyyy.MM.dd}
+//	    mapping_file: ./mapping-vendor-a.yaml
+//	    fields:
+//	      exclude: ["ul_vprio*", "dl_vprio*", "*_tos*"]
+//
+// fields.include and fields.exclude replace FIELDS_INCLUDE and
+// FIELDS_EXCLUDE for the pipeline, on top of its mapping.
+type pipelineConfig struct {
+	Name        string     `json:"name"`
+	Path        string     `json:"path"`
+	Recursive   bool       `json:"recursive"`
+	Include     []string   `json:"include"`
+	Exclude     []string   `json:"exclude"`
+	Parser      string     `json:"parser"`
+	Charset     string     `json:"charset"`
+	CSV         csvOptions `json:"csv"`
+	SchemaFile  string     `json:"schema_file"`
+	Index       string     `json:"index"`
+	MappingFile string     `json:"mapping_file"`
+	Fields      fieldLists `json:"fields"`
+}
+
+// fieldLists are the field globs that reach the outputs (include) and
+// that don't (exclude).
+type fieldLists struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// pipelineField is added to every record with the name of the pipeline it
+// came through; the mapping stage and the indexer route on it.
+const pipelineField = "pipeline"
+
+type pipeline struct {
+	pipelineConfig
+	root    string // absolute Path
+	filter  fileFilter
+	parse   parserFunc
+	schema  *Schema
+	mapping *fieldMapping  // nil: MAPPING_FILE
+	index   *indexTemplate // nil: ES_INDEX
+	sink    recordSink     // the shared sink, with the pipeline field added
+}
+
+type pipelines []*pipeline
+
+// newPipelines builds config.Pipelines; schema and ts are the global ones,
+// used where a pipeline has no schema_file, and INCLUDE_PATTERNS applies
+// where it has no include.
+func newPipelines(config Config, schema *Schema, ts *timestampParser) (pipelines, error) {
+	var ps pipelines
+	names := map[string]bool{}
+	for i, c := range config.Pipelines {
+		if c.Name == "" || c.Path == "" {
+			return nil, fmt.Errorf("pipelines[%d]: name and path are required", i)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("pipelines: duplicate name %q", c.Name)
+		}
+		names[c.Name] = true
+		root, err := filepath.Abs(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", c.Name, err)
+		}
+		p := &pipeline{
+			pipelineConfig: c,
+			root:           root,
+			filter:         fileFilter{include: c.Include, exclude: c.Exclude},
+			schema:         schema,
+		}
+		if len(p.filter.include) == 0 {
+			p.filter.include = splitList(config.IncludePatterns)
+		}
+		// 전역 parser 를 쓰면 CSV_* 설정도 이어받는다
+		parser, opts := c.Parser, c.CSV
+		if parser == "" {
+			parser, opts = config.Parser, opts.over(config.csvOptions())
+		}
+		if p.parse, err = lookupParser(parser, opts); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", c.Name, err)
+		}
+		charset := c.Charset
+		if charset == "" {
+			charset = config.Charset
+		}
+		if p.parse, err = withCharset(p.parse, charset); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", c.Name, err)
+		}
+		if c.SchemaFile != "" {
+			if p.schema, err = newSchema(c.SchemaFile, nil, ts); err != nil {
+				return nil, fmt.Errorf("pipeline %s: %w", c.Name, err)
+			}
+		}
+		if c.MappingFile != "" {
+			if p.mapping, err = loadFieldMapping(c.MappingFile); err != nil {
+				return nil, fmt.Errorf("pipeline %s: %w", c.Name, err)
+			}
+		}
+		if err := checkFieldPatterns(c.Fields.Include, c.Fields.Exclude); err != nil {
+			return nil, fmt.Errorf("pipeline %s: fields: %w", c.Name, err)
+		}
+		if c.Index != "" {
+			if p.index, err = parseIndexTemplate(c.Index); err != nil {
+				return nil, fmt.Errorf("pipeline %s: index: %w", c.Name, err)
+			}
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// setSink routes every pipeline's records into sink.
+func (ps pipelines) setSink(sink recordSink) {
+	for _, p := range ps {
+		name := p.Name
+		p.sink = withStage(sink, func(rec Record) Record {
+			rec[pipelineField] = name
+			return rec
+		})
+	}
+}
+
+// match returns the pipeline whose directory contains path, the deepest
+// one if they're nested, or nil.
+func (ps pipelines) match(path string) *pipeline {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	var best *pipeline
+	for _, p := range ps {
+		rel, err := filepath.Rel(p.root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if best == nil || len(p.root) > len(best.root) {
+			best = p
+		}
+	}
+	return best
+}
+
+func (ps pipelines) byName(rec Record) *pipeline {
+	v, ok := recordField(rec, pipelineField)
+	if !ok {
+		return nil
+	}
+	for _, p := range ps {
+		if p.Name == v {
+			return p
+		}
+	}
+	return nil
+}
+
+// selectFields puts FIELDS_INCLUDE/FIELDS_EXCLUDE, or a pipeline's own
+// fields, on the mappings of the pipelines that need one of their own.
+// global is the MAPPING_FILE without FIELDS_*.
+func (ps pipelines) selectFields(global *fieldMapping, include, exclude []string) {
+	for _, p := range ps {
+		own := len(p.Fields.Include) > 0 || len(p.Fields.Exclude) > 0
+		if p.mapping == nil && !own {
+			continue
+		}
+		base, inc, exc := p.mapping, p.Fields.Include, p.Fields.Exclude
+		if base == nil {
+			base = global
+		}
+		if len(inc) == 0 {
+			inc = include
+		}
+		if len(exc) == 0 {
+			exc = exclude
+		}
+		p.mapping = base.withFields(inc, exc)
+	}
+}
+
+// addConversion puts c (DELAY_UNIT) on the pipelines' own mappings.
+func (ps pipelines) addConversion(c *unitConversion) {
+	for _, p := range ps {
+		if p.mapping != nil {
+			p.mapping = p.mapping.withConversion(c)
+		}
+	}
+}
+
+// mapper is the MAPPING_FILE stage, using a pipeline's mapping_file
+// instead for its records. It returns nil if there is nothing to map.
+func (ps pipelines) mapper(global *fieldMapping) func(Record) Record {
+	needed := global != nil
+	for _, p := range ps {
+		needed = needed || p.mapping != nil
+	}
+	if !needed {
+		return nil
+	}
+	return func(rec Record) Record {
+		m := global
+		if p := ps.byName(rec); p != nil && p.mapping != nil {
+			m = p.mapping
+		}
+		if m == nil {
+			return rec
+		}
+		return m.Apply(rec)
+	}
+}
+
+// indexes returns the pipelines' own index templates by name.
+func (ps pipelines) indexes() map[string]*indexTemplate {
+	out := map[string]*indexTemplate{}
+	for _, p := range ps {
+		if p.index != nil {
+			out[p.Name] = p.index
+		}
+	}
+	return out
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirPoller rescans the watched directories every WATCH_POLL_INTERVAL
+// (WATCH_MODE=poll or both), for SMB/CIFS and NFS shares where fsnotify
+// doesn't see files written by other machines. A file is queued once its
+// size and modification time are the same in two scans in a row, so one
+// still being copied waits for the next scan. Files in the ledger, queued
+// or in flight are left alone.
+//
+// fsnotify reports files through notify; they are queued once they've
+// held still for WATCH_SETTLE_TIME rather than on the create event, when
+// an upload has often only just started. With WATCH_MODE=notify the
+// rescans run every WATCH_RECONCILE as a safety net (reconcile): fsnotify
+// drops events when its queue overflows, and what they find was missed.
+// Directories past the inotify watch limit (watcher's unwatched ones) are
+// scanned every fallback instead. Without rescans (interval 0) that is all
+// it does.
+type dirPoller struct {
+	roots     func() []watchRoot
+	state     *stateStore
+	pool      *workerPool
+	interval  time.Duration
+	settle    time.Duration
+	reconcile bool
+	hints     chan string
+	kick      chan struct{}
+
+	watcher  *dirWatcher
+	fallback time.Duration
+
+	// 지난 스캔에서 본 파일
+	seen map[string]fileStamp
+	// 지난 스캔에서 본, 감시하지 못한 디렉토리의 파일
+	unwatchedSeen map[string]fileStamp
+	// fsnotify 가 알려준, 크기가 멈추길 기다리는 파일
+	settling map[string]settlingFile
+	// 이번 실행에서 넘긴 파일; 실패한 파일은 바뀌거나 재시작할 때 다시 시도된다
+	queued map[string]fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func (s fileStamp) same(o fileStamp) bool {
+	return s.size == o.size && s.modTime.Equal(o.modTime)
+}
+
+type settlingFile struct {
+	stamp fileStamp
+	since time.Time // stamp 가 마지막으로 바뀐 때
+}
+
+func statStamp(path string) (fileStamp, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fileStamp{}, false
+	}
+	return fileStamp{info.Size(), info.ModTime()}, true
+}
+
+func newDirPoller(roots func() []watchRoot, state *stateStore, pool *workerPool, interval, settle time.Duration) *dirPoller {
+	return &dirPoller{
+		roots: roots, state: state, pool: pool, interval: interval, settle: settle, hints: make(chan string, 1024), kick: make(chan struct{}, 1),
+		seen: map[string]fileStamp{}, unwatchedSeen: map[string]fileStamp{}, settling: map[string]settlingFile{}, queued: map[string]fileStamp{},
+	}
+}
+
+// notify hands over a file fsnotify reported. When too many are waiting
+// it's left to the next rescan, or queued right away without rescans.
+func (p *dirPoller) notify(path string) {
+	select {
+	case p.hints <- path:
+	default:
+		metricQueueOverflows.Inc("settle")
+		if p.interval > 0 {
+			logIngest.Debug("too many files settling, leaving file to the next scan", "file", path)
+			return
+		}
+		logIngest.Warn("too many files settling, queuing file without waiting", "file", path)
+		p.pool.Submit(path)
+	}
+}
+
+// rescan asks for a scan now and another once WATCH_SETTLE_TIME has
+// passed, so files a dropped event hid are queued without waiting for the
+// next interval.
+func (p *dirPoller) rescan() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (p *dirPoller) run(ctx context.Context) {
+	var rescan <-chan time.Time
+	if p.interval > 0 {
+		if p.reconcile {
+			logIngest.Info("rescanning watched directories for files fsnotify missed", "interval", p.interval.String())
+		} else {
+			logIngest.Info("polling watched directories", "interval", p.interval.String())
+		}
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		rescan = ticker.C
+		p.poll(ctx)
+	}
+	var fallback <-chan time.Time
+	if p.watcher != nil && p.fallback > 0 {
+		ticker := time.NewTicker(p.fallback)
+		defer ticker.Stop()
+		fallback = ticker.C
+	}
+	settle := time.NewTicker(time.Second)
+	defer settle.Stop()
+	var followUp <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rescan:
+			p.poll(ctx)
+		case <-p.kick:
+			p.poll(ctx)
+			followUp = time.After(max(p.settle, time.Second))
+		case <-followUp:
+			followUp = nil
+			p.poll(ctx)
+		case <-fallback:
+			p.pollUnwatched(ctx)
+		case path := <-p.hints:
+			if st, ok := statStamp(path); ok {
+				if p.settle == 0 {
+					p.queue(path, st, "notify")
+					continue
+				}
+				if _, waiting := p.settling[path]; !waiting {
+					p.settling[path] = settlingFile{st, time.Now()}
+				}
+			}
+		case now := <-settle.C:
+			p.settleFiles(now)
+		}
+	}
+}
+
+func (p *dirPoller) poll(ctx context.Context) {
+	seen := map[string]fileStamp{}
+	for _, root := range p.roots() {
+		files, err := listInputFiles(root.path, root.path, root.recursive, root.filter)
+		if err != nil {
+			// 공유가 잠깐 끊겨도 지난 스캔에서 본 파일은 잊지 않는다
+			logIngest.Error("error scanning directory", "path", root.path, "err", err)
+			for path, st := range p.seen {
+				seen[path] = st
+			}
+		}
+		if !p.scan(ctx, files, p.seen, seen) {
+			return
+		}
+	}
+	p.seen = seen
+	for path := range p.queued {
+		if _, ok := seen[path]; !ok {
+			delete(p.queued, path)
+		}
+	}
+}
+
+// scan queues the files whose stamp is the same as in prev, the last
+// scan, and records them in seen. It returns false once ctx is done.
+func (p *dirPoller) scan(ctx context.Context, files []string, prev, seen map[string]fileStamp) bool {
+	for _, path := range files {
+		if ctx.Err() != nil {
+			return false
+		}
+		st, ok := statStamp(path)
+		if !ok {
+			continue
+		}
+		last, ok := prev[path]
+		seen[path] = st
+		if _, waiting := p.settling[path]; waiting || !ok || !last.same(st) {
+			continue
+		}
+		p.queue(path, st, "poll")
+	}
+	return true
+}
+
+// pollUnwatched scans the directories fsnotify can't watch, past the
+// inotify watch limit, the way WATCH_MODE=poll scans everything.
+func (p *dirPoller) pollUnwatched(ctx context.Context) {
+	dirs := p.watcher.unwatchedDirs()
+	if len(dirs) == 0 && len(p.unwatchedSeen) == 0 {
+		return
+	}
+	roots := p.roots()
+	seen := map[string]fileStamp{}
+	for _, dir := range dirs {
+		root, ok := rootOf(roots, dir)
+		if !ok {
+			p.watcher.forget(dir)
+			continue
+		}
+		files, err := listInputFiles(root.path, dir, false, root.filter)
+		if errors.Is(err, fs.ErrNotExist) {
+			p.watcher.forget(dir)
+			continue
+		} else if err != nil {
+			logIngest.Error("error scanning directory", "path", dir, "err", err)
+		}
+		if !p.scan(ctx, files, p.unwatchedSeen, seen) {
+			return
+		}
+	}
+	// rescan 이 없으면 여기서 queued 를 정리한다
+	if p.interval == 0 {
+		for path := range p.queued {
+			if _, ok := seen[path]; !ok {
+				delete(p.queued, path)
+			}
+		}
+	}
+	p.unwatchedSeen = seen
+}
+
+// rootOf returns the deepest of roots dir is in.
+func rootOf(roots []watchRoot, dir string) (watchRoot, bool) {
+	var best watchRoot
+	found := false
+	for _, root := range roots {
+		rel, err := filepath.Rel(root.path, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if !found || len(root.path) > len(best.path) {
+			best, found = root, true
+		}
+	}
+	return best, found
+}
+
+// settleFiles queues the reported files that haven't changed for settle.
+func (p *dirPoller) settleFiles(now time.Time) {
+	for path, f := range p.settling {
+		st, ok := statStamp(path)
+		switch {
+		case !ok:
+			delete(p.settling, path)
+		case !st.same(f.stamp):
+			p.settling[path] = settlingFile{st, now}
+		case now.Sub(f.since) >= p.settle:
+			delete(p.settling, path)
+			p.queue(path, st, "notify")
+		}
+	}
+}
+
+func (p *dirPoller) queue(path string, st fileStamp, by string) {
+	if q, ok := p.queued[path]; ok && q.same(st) || p.pool.Busy(path) || p.state.IsProcessed(path) {
+		return
+	}
+	if by == "poll" && p.reconcile && (p.watcher == nil || !p.watcher.isUnwatched(filepath.Dir(path))) {
+		// 알림 없이 스캔에서만 보인 파일
+		by = "reconcile"
+		metricMissedFiles.Inc()
+		logIngest.Warn("file missed by fsnotify, found by the reconcile scan", "file", path)
+	}
+	logIngest.Info("new file detected", "file", path, "by", by)
+	// rescan 이 없으면 poll 이 queued 를 정리하지 않는다; pollUnwatched 가 찾은 파일만 남긴다
+	p.pool.Submit(path)
+	if p.interval > 0 || by == "poll" {
+		p.queued[path] = st
+	}
+}
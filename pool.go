@@ -0,0 +1,254 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// workerPool processes queued files on a fixed number of goroutines.
+type workerPool struct {
+	jobs  chan string
+	wg    sync.WaitGroup
+	close sync.Once
+
+	wake chan struct{} // overflow has files; closed by Close
+	fed  chan struct{} // closed once the overflow is in jobs
+
+	// Submit/SubmitWait 는 보내는 동안 read lock; Close 후 들어온 파일은 버린다
+	submitMu sync.RWMutex
+	closed   bool
+
+	mu       sync.Mutex
+	queued   []string        // submitted, not started yet
+	overflow []string        // submitted while jobs was full, fed into it by feed
+	busy     map[string]int  // queued or being processed
+	resumed  chan struct{}   // nil unless paused; closed by the last resume
+	paused   map[string]bool // why: admin, disk_watermark
+}
+
+func newWorkerPool(workers, queueSize int, handle func(path string)) *workerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &workerPool{jobs: make(chan string, queueSize), busy: map[string]int{}, wake: make(chan struct{}, 1), fed: make(chan struct{})}
+	go p.feed()
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for path := range p.jobs {
+				p.start(path)
+				func() {
+					defer p.done(path)
+					handle(path)
+				}()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues path without blocking. If the queue is full the file
+// waits in an overflow list and is fed into the queue as room frees up,
+// so a burst of fsnotify events doesn't lose files; while files wait
+// there, later ones queue behind them. After Close the file is dropped.
+func (p *workerPool) Submit(path string) {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.closed {
+		logIngest.Warn("shutting down, not queuing file", "file", path)
+		return
+	}
+	tracing.detect(path)
+	p.enqueue(path)
+	p.mu.Lock()
+	if len(p.overflow) == 0 {
+		select {
+		case p.jobs <- path:
+			p.mu.Unlock()
+			return
+		default:
+		}
+	}
+	p.overflow = append(p.overflow, path)
+	n := len(p.overflow)
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	metricQueueOverflows.Inc("jobs")
+	logIngest.Warn("job queue full, file waits for room", "queue_size", cap(p.jobs), "overflow", n, "file", path)
+}
+
+// feed moves the overflow into jobs, blocking until there's room.
+func (p *workerPool) feed() {
+	defer close(p.fed)
+	for range p.wake {
+		p.feedOverflow()
+	}
+	p.feedOverflow()
+}
+
+func (p *workerPool) feedOverflow() {
+	for {
+		p.mu.Lock()
+		if len(p.overflow) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		path := p.overflow[0]
+		p.overflow = p.overflow[1:]
+		p.mu.Unlock()
+		p.jobs <- path
+	}
+}
+
+// SubmitWait queues path, blocking until there is room in the queue. It
+// doesn't wait for the overflow of Submit, so it may go ahead of those
+// files. After Close the file is dropped.
+func (p *workerPool) SubmitWait(path string) {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.closed {
+		logIngest.Warn("shutting down, not queuing file", "file", path)
+		return
+	}
+	tracing.detect(path)
+	p.enqueue(path)
+	p.jobs <- path
+}
+
+func (p *workerPool) enqueue(path string) {
+	p.mu.Lock()
+	p.queued = append(p.queued, path)
+	p.busy[path]++
+	p.mu.Unlock()
+}
+
+func (p *workerPool) done(path string) {
+	p.mu.Lock()
+	if p.busy[path]--; p.busy[path] <= 0 {
+		delete(p.busy, path)
+	}
+	p.mu.Unlock()
+}
+
+func (p *workerPool) dequeue(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, q := range p.queued {
+		if q == path {
+			p.queued = append(p.queued[:i], p.queued[i+1:]...)
+			return
+		}
+	}
+}
+
+// start waits while the pool is paused; the file stays queued until then.
+func (p *workerPool) start(path string) {
+	p.mu.Lock()
+	resumed := p.resumed
+	p.mu.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+	p.dequeue(path)
+}
+
+// Pause lets the files being processed finish but starts no new ones
+// until Resume.
+func (p *workerPool) Pause() { p.pauseFor("admin") }
+
+func (p *workerPool) Resume() { p.resumeFor("admin") }
+
+// pauseFor pauses the pool for reason; it runs again once every reason
+// it was paused for is resumed, so the admin API and the disk watermark
+// don't undo each other's pause.
+func (p *workerPool) pauseFor(reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == nil {
+		p.paused = map[string]bool{}
+	}
+	p.paused[reason] = true
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+func (p *workerPool) resumeFor(reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.paused, reason)
+	if len(p.paused) == 0 && p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+func (p *workerPool) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed != nil
+}
+
+// PausedFor lists the reasons the pool is paused for, sorted.
+func (p *workerPool) PausedFor() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reasons := make([]string, 0, len(p.paused))
+	for r := range p.paused {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// Queued lists the files waiting for a worker, oldest first.
+func (p *workerPool) Queued() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.queued...)
+}
+
+// Busy reports whether path is queued or being processed.
+func (p *workerPool) Busy(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.busy[path] > 0
+}
+
+// Len reports how many jobs are waiting, the overflow included, and the
+// queue capacity.
+func (p *workerPool) Len() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.jobs) + len(p.overflow), cap(p.jobs)
+}
+
+// Close stops accepting jobs and waits for queued ones to finish; a paused
+// pool is resumed. Files submitted after it are dropped. It may be called
+// more than once; every call waits.
+func (p *workerPool) Close() {
+	p.mu.Lock()
+	p.paused = nil
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+	p.mu.Unlock()
+	// 막혀 있는 SubmitWait 는 worker 가 큐를 비우면서 끝난다
+	p.submitMu.Lock()
+	p.closed = true
+	p.submitMu.Unlock()
+	p.close.Do(func() {
+		close(p.wake)
+		<-p.fed
+		close(p.jobs)
+	})
+	p.wg.Wait()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestWorkerPoolOverflowOrder(t *testing.T) {
+	var mu sync.Mutex
+	var handled []string
+	release := make(chan struct{})
+	p := newWorkerPool(1, 1, func(path string) {
+		<-release
+		mu.Lock()
+		handled = append(handled, path)
+		mu.Unlock()
+	})
+	var want []string
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprint("f", i)
+		want = append(want, path)
+		p.Submit(path)
+	}
+	if n, _ := p.Len(); n < 8 {
+		t.Errorf("Len = %d with 10 files submitted and one worker", n)
+	}
+	close(release)
+	p.Close()
+	if fmt.Sprint(handled) != fmt.Sprint(want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+	for _, path := range want {
+		if p.Busy(path) {
+			t.Errorf("%s still busy", path)
+		}
+	}
+}
+
+func TestWorkerPoolSubmitAfterClose(t *testing.T) {
+	tests := []struct {
+		name   string
+		submit func(*workerPool, string)
+	}{
+		{"Submit", (*workerPool).Submit},
+		{"SubmitWait", (*workerPool).SubmitWait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := 0
+			p := newWorkerPool(1, 1, func(string) { handled++ })
+			p.Close()
+			tt.submit(p, "late.csv")
+			p.Close()
+			if handled != 0 || p.Busy("late.csv") {
+				t.Errorf("file submitted after Close was taken: handled %d", handled)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PostgresOutput writes records into POSTGRES_TABLE (OUTPUT=postgres), one
+// transaction per batch: COPY into a temporary staging table, then INSERT
+// ... ON CONFLICT (session_id, timestamp) DO UPDATE, so redelivered rows
+// overwrite instead of duplicating. With POSTGRES_TIMESCALE the table is a
+// TimescaleDB hypertable on timestamp. Fields without a column are skipped.
+type PostgresOutput struct {
+	url      string
+	table    string
+	retry    retryPolicy
+	columns  []string // besides timestamp; set by prepare
+	prepared bool
+
+	mu   sync.Mutex
+	conn *pgConn
+}
+
+func newPostgresOutput(config Config) (*PostgresOutput, error) {
+	if config.PostgresURL == "" {
+		return nil, errors.New("POSTGRES_URL is required for the postgres output")
+	}
+	return &PostgresOutput{url: config.PostgresURL, table: config.PostgresTable, retry: config.retryPolicy()}, nil
+}
+
+func (o *PostgresOutput) Name() string { return "postgres" }
+
+func (o *PostgresOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+	return nil
+}
+
+// prepare sets the columns from the schema (after MAPPING_FILE, plus the
+// KPIs) and, with POSTGRES_BOOTSTRAP, creates the table if it's missing.
+// An existing table is left alone; it needs at least these columns.
+func (o *PostgresOutput) prepare(ctx context.Context, schema *Schema, mapping *fieldMapping, config Config) error {
+	names, types := tableColumns(schema, mapping, config)
+	o.columns = append(names, pipelineField)
+	o.prepared = true
+	if !config.PostgresBootstrap {
+		return nil
+	}
+	cols := []string{`"timestamp" timestamptz NOT NULL`}
+	for _, name := range names {
+		typ := postgresType(types[name])
+		if name == "session_id" {
+			typ += " NOT NULL"
+		}
+		cols = append(cols, pgIdent(name)+" "+typ)
+	}
+	cols = append(cols, pgIdent(pipelineField)+" text", `PRIMARY KEY (session_id, "timestamp")`)
+	stmts := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)", o.table, strings.Join(cols, ",\n  "))}
+	if config.PostgresTimescale {
+		stmts = append(stmts, fmt.Sprintf("SELECT create_hypertable(%s, 'timestamp', if_not_exists => TRUE, migrate_data => TRUE)", pgLiteral(o.table)))
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, stmt := range stmts {
+		if err := o.execLocked(ctx, stmt); err != nil {
+			return fmt.Errorf("error creating table %s: %w", o.table, err)
+		}
+	}
+	logOutput.Info("postgres table ready", "table", o.table, "columns", len(cols)-1, "timescale", config.PostgresTimescale)
+	return nil
+}
+
+func (o *PostgresOutput) Write(ctx context.Context, records []Record) error {
+	if !o.prepared {
+		return errors.New("postgres output used before prepare")
+	}
+	data, rows := o.copyData(records)
+	if rows == 0 {
+		return nil
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := o.upsert(ctx, data)
+		metricBulkLatency.Observe(time.Since(start).Seconds(), o.Name())
+		if err == nil {
+			metricDocumentsIndexed.Add(float64(rows), o.Name())
+			logOutput.Debug("rows upserted", "output", o.Name(), "rows", rows, "table", o.table)
+			return nil
+		}
+		metricBulkFailures.Inc(o.Name())
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= o.retry.MaxAttempts {
+			return fmt.Errorf("giving up on postgres insert after %d attempts: %w", attempt, err)
+		}
+		wait := o.retry.backoff(attempt)
+		metricBulkRetries.Inc(o.Name())
+		logOutput.Warn("postgres insert failed, retrying", "attempt", attempt, "max_attempts", o.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// upsert runs one batch in a transaction.
+func (o *PostgresOutput) upsert(ctx context.Context, data []byte) error {
+	cols := make([]string, len(o.columns))
+	set := make([]string, len(o.columns))
+	for i, c := range o.columns {
+		cols[i] = pgIdent(c)
+		set[i] = cols[i] + " = EXCLUDED." + cols[i]
+	}
+	all := `"timestamp", ` + strings.Join(cols, ", ")
+	// 같은 batch 안의 중복 키는 ON CONFLICT 가 두 번 건드릴 수 없어서 DISTINCT ON 으로 하나만 남긴다
+	insert := fmt.Sprintf(`INSERT INTO %s (%s) SELECT DISTINCT ON (session_id, "timestamp") %s FROM twamp_stage ORDER BY session_id, "timestamp" ON CONFLICT (session_id, "timestamp") DO UPDATE SET %s`,
+		o.table, all, all, strings.Join(set, ", "))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.execLocked(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	err := o.execLocked(ctx, fmt.Sprintf("CREATE TEMP TABLE IF NOT EXISTS twamp_stage (LIKE %s INCLUDING DEFAULTS) ON COMMIT DELETE ROWS", o.table))
+	if err == nil {
+		err = o.conn.copyIn(fmt.Sprintf("COPY twamp_stage (%s) FROM STDIN", all), data)
+		err = o.classify(err)
+	}
+	if err == nil {
+		err = o.execLocked(ctx, insert)
+	}
+	if err == nil {
+		return o.execLocked(ctx, "COMMIT")
+	}
+	if o.conn != nil {
+		o.conn.exec("ROLLBACK")
+	}
+	return err
+}
+
+// execLocked runs stmt, connecting first if needed.
+func (o *PostgresOutput) execLocked(ctx context.Context, stmt string) error {
+	if o.conn == nil {
+		c, err := pgConnect(ctx, o.url)
+		if err != nil {
+			var pgErr *pgError
+			if errors.As(err, &pgErr) {
+				return err
+			}
+			return retryableError{err}
+		}
+		o.conn = c
+	}
+	deadline := time.Now().Add(2 * time.Minute)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	o.conn.conn.SetDeadline(deadline)
+	return o.classify(o.conn.exec(stmt))
+}
+
+// classify drops the connection after I/O errors and marks what's worth
+// retrying.
+func (o *PostgresOutput) classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgError
+	if errors.As(err, &pgErr) {
+		if pgErr.retryable() {
+			return retryableError{err}
+		}
+		return err
+	}
+	if o.conn != nil {
+		o.conn.conn.Close()
+		o.conn = nil
+	}
+	return retryableError{err}
+}
+
+// copyData encodes records in COPY text format; ones without session_id or
+// @timestamp (the primary key) are skipped.
+func (o *PostgresOutput) copyData(records []Record) ([]byte, int) {
+	var buf bytes.Buffer
+	rows := 0
+	for _, rec := range records {
+		ts, ok1 := rec["@timestamp"]
+		_, ok2 := rec["session_id"]
+		if !ok1 || !ok2 {
+			logOutput.Debug("skipping record without session_id or @timestamp", "output", o.Name())
+			continue
+		}
+		buf.WriteString(pgCopyValue(ts))
+		for _, c := range o.columns {
+			buf.WriteByte('\t')
+			v, ok := rec[c]
+			if !ok {
+				buf.WriteString(`\N`)
+				continue
+			}
+			buf.WriteString(pgCopyValue(v))
+		}
+		buf.WriteByte('\n')
+		rows++
+	}
+	return buf.Bytes(), rows
+}
+
+var pgCopyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func pgCopyValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return `\N`
+	case string:
+		return pgCopyEscaper.Replace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(v)
+	return pgCopyEscaper.Replace(string(data))
+}
+
+func pgIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func pgLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func postgresType(esType string) string {
+	switch esType {
+	case "long":
+		return "bigint"
+	case "double":
+		return "double precision"
+	case "boolean":
+		return "boolean"
+	case "date":
+		return "timestamptz"
+	}
+	return "text"
+}
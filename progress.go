@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress follows the files being ingested, for the progress logs
+// (PROGRESS_INTERVAL) and /status: rows done, rows/sec and an ETA from
+// how much of each file has been read. Compressed files are measured on
+// their compressed bytes, so the ETA assumes an even compression ratio.
+// A backfill adds its totals across files.
+var progress = &progressTracker{files: map[*fileProgress]struct{}{}}
+
+type progressTracker struct {
+	mu       sync.Mutex
+	files    map[*fileProgress]struct{}
+	backfill *backfillRun
+}
+
+// fileProgress is one file in flight; nil (validate) is a no-op.
+type fileProgress struct {
+	path    string
+	size    int64
+	started time.Time
+	read    atomic.Int64 // bytes of the file read
+	rows    atomic.Int64 // rows ingested, resumed ones included
+	resumed int64        // rows ingested by an earlier run
+}
+
+// begin starts following path, which resumes after rows ingested rows.
+func (t *progressTracker) begin(path string, rows int) *fileProgress {
+	p := &fileProgress{path: path, started: time.Now(), resumed: int64(rows)}
+	if info, err := os.Stat(path); err == nil {
+		p.size = info.Size()
+	}
+	p.rows.Store(int64(rows))
+	t.mu.Lock()
+	t.files[p] = struct{}{}
+	t.mu.Unlock()
+	return p
+}
+
+// end stops following p; its bytes and rows then count in the backfill's
+// done files.
+func (t *progressTracker) end(p *fileProgress) {
+	if p == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.files, p)
+	t.mu.Unlock()
+	t.backfillRows(p.rows.Load() - p.resumed)
+}
+
+func (t *progressTracker) backfillRows(n int64) {
+	t.mu.Lock()
+	r := t.backfill
+	t.mu.Unlock()
+	r.addRows(n)
+}
+
+func (t *progressTracker) setBackfill(r *backfillRun) {
+	t.mu.Lock()
+	t.backfill = r
+	t.mu.Unlock()
+}
+
+func (p *fileProgress) row() {
+	if p != nil {
+		p.rows.Add(1)
+	}
+}
+
+// reader counts what's read from f, the file itself.
+func (p *fileProgress) reader(f io.Reader) io.Reader {
+	if p == nil {
+		return f
+	}
+	return &countingReader{r: f, n: &p.read}
+}
+
+// readerAt is reader for zip archives, read through their central
+// directory.
+func (p *fileProgress) readerAt(f io.ReaderAt) io.ReaderAt {
+	if p == nil {
+		return f
+	}
+	return &countingReader{ra: f, n: &p.read}
+}
+
+type countingReader struct {
+	r  io.Reader
+	ra io.ReaderAt
+	n  *atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReader) ReadAt(b []byte, off int64) (int, error) {
+	n, err := c.ra.ReadAt(b, off)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type fileProgressStatus struct {
+	Path       string  `json:"path"`
+	Rows       int64   `json:"rows"`
+	RowsPerSec float64 `json:"rows_per_sec"`
+	Percent    float64 `json:"percent"`
+	Elapsed    string  `json:"elapsed"`
+	ETA        string  `json:"eta,omitempty"`
+
+	elapsed time.Duration
+}
+
+type progressStatus struct {
+	Files    []fileProgressStatus `json:"files"`
+	Backfill *backfillStatus      `json:"backfill,omitempty"`
+}
+
+func (p *fileProgress) status(now time.Time) fileProgressStatus {
+	elapsed := now.Sub(p.started)
+	rows, read := p.rows.Load(), p.read.Load()
+	s := fileProgressStatus{
+		Path:       p.path,
+		Rows:       rows,
+		RowsPerSec: perSecond(rows-p.resumed, elapsed),
+		Percent:    percentOf(read, p.size),
+		Elapsed:    elapsed.Truncate(time.Second).String(),
+		elapsed:    elapsed,
+	}
+	s.ETA = eta(elapsed, read, p.size)
+	return s
+}
+
+// status is what /status returns, the in-flight files oldest first.
+func (t *progressTracker) status() progressStatus {
+	now := time.Now()
+	t.mu.Lock()
+	files := make([]*fileProgress, 0, len(t.files))
+	for p := range t.files {
+		files = append(files, p)
+	}
+	r := t.backfill
+	t.mu.Unlock()
+	sort.Slice(files, func(i, j int) bool { return files[i].started.Before(files[j].started) })
+	s := progressStatus{Files: make([]fileProgressStatus, 0, len(files))}
+	var read, rows int64
+	for _, p := range files {
+		s.Files = append(s.Files, p.status(now))
+		read += p.read.Load()
+		rows += p.rows.Load() - p.resumed
+	}
+	s.Backfill = r.status(now, read, rows)
+	return s
+}
+
+// run logs the progress of the files that take longer than interval, and
+// of the backfill, saving its checkpoint, until ctx is done.
+func (t *progressTracker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s := t.status()
+		for _, f := range s.Files {
+			if f.elapsed < interval {
+				continue
+			}
+			logIngest.Info("file progress", "file", f.Path, "rows", f.Rows, "rows_per_sec", f.RowsPerSec,
+				"percent", f.Percent, "elapsed", f.Elapsed, "eta", f.ETA)
+		}
+		if b := s.Backfill; b != nil {
+			b.log("backfill progress")
+			t.mu.Lock()
+			r := t.backfill
+			t.mu.Unlock()
+			if err := r.save(); err != nil {
+				logIngest.Error("error saving backfill checkpoint", "err", err)
+			}
+		}
+	}
+}
+
+func (t *progressTracker) statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.status())
+}
+
+func perSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return math.Round(float64(n) / d.Seconds())
+}
+
+// percentOf rounds to one decimal.
+func percentOf(n, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Min(100, math.Round(float64(n)*1000/float64(total))/10)
+}
+
+// eta extrapolates the time left from done of total taking elapsed; ""
+// until there's something to go by.
+func eta(elapsed time.Duration, done, total int64) string {
+	if done <= 0 || total <= done || elapsed < time.Second {
+		return ""
+	}
+	left := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+	return left.Truncate(time.Second).String()
+}
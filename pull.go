@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pullConfig is the PULL_FILE: remote EMS servers to fetch exports from.
+//
+//	max_concurrent_hosts: 4
+//	hosts:
+//	  - name: ems-seoul
+//	    protocol: sftp         # or ftp
+//	    host: 10.0.0.5
+//	    username: twamp
+//	    identity_file: /etc/twamp/id_ed25519   # sftp (key auth only)
+//	    password_env: EMS_SEOUL_PASSWORD       # ftp; or password:
+//	    dir: /export/twamp
+//	    include: ["*.gz"]
+//	    interval: 5m
+//	    concurrency: 2
+type pullConfig struct {
+	MaxConcurrentHosts int         `json:"max_concurrent_hosts"`
+	Hosts              []*pullHost `json:"hosts"`
+}
+
+type pullHost struct {
+	Name         string   `json:"name"`
+	Protocol     string   `json:"protocol"`
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	PasswordEnv  string   `json:"password_env"`
+	IdentityFile string   `json:"identity_file"`
+	KnownHosts   string   `json:"known_hosts"`
+	Dir          string   `json:"dir"`
+	Include      []string `json:"include"`
+	Exclude      []string `json:"exclude"`
+	Interval     string   `json:"interval"`
+	Concurrency  int      `json:"concurrency"`
+
+	interval time.Duration
+	filter   fileFilter
+}
+
+func (h *pullHost) port() int {
+	if h.Port != 0 {
+		return h.Port
+	}
+	if h.Protocol == "sftp" {
+		return 22
+	}
+	return 21
+}
+
+func (h *pullHost) password() string {
+	if h.PasswordEnv != "" {
+		return os.Getenv(h.PasswordEnv)
+	}
+	return h.Password
+}
+
+type remoteFile struct {
+	name     string
+	size     int64
+	modified string
+}
+
+// signature identifies a version of a remote file for the checkpoint.
+func (f remoteFile) signature() string {
+	return fmt.Sprintf("%d|%s", f.size, f.modified)
+}
+
+func loadPullConfig(path string) (*pullConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pull file: %w", err)
+	}
+	var cfg pullConfig
+	if err := decodeYAML(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing pull file %s: %w", path, err)
+	}
+	if cfg.MaxConcurrentHosts < 1 {
+		cfg.MaxConcurrentHosts = 4
+	}
+	seen := map[string]bool{}
+	for i, h := range cfg.Hosts {
+		if h.Name == "" {
+			h.Name = h.Host
+		}
+		if h.Host == "" {
+			return nil, fmt.Errorf("pull file %s: host %d has no host", path, i+1)
+		}
+		if seen[h.Name] {
+			return nil, fmt.Errorf("pull file %s: duplicate host name %q", path, h.Name)
+		}
+		seen[h.Name] = true
+		switch h.Protocol {
+		case "":
+			h.Protocol = "sftp"
+		case "sftp", "ftp":
+		default:
+			return nil, fmt.Errorf("pull file %s: host %s: unknown protocol %q (sftp, ftp)", path, h.Name, h.Protocol)
+		}
+		if h.Protocol == "sftp" && h.Password != "" {
+			return nil, fmt.Errorf("pull file %s: host %s: sftp supports key authentication only (identity_file)", path, h.Name)
+		}
+		h.interval = 5 * time.Minute
+		if h.Interval != "" {
+			if h.interval, err = time.ParseDuration(h.Interval); err != nil || h.interval <= 0 {
+				return nil, fmt.Errorf("pull file %s: host %s: invalid interval %q", path, h.Name, h.Interval)
+			}
+		}
+		if h.Concurrency < 1 {
+			h.Concurrency = 1
+		}
+		if h.Dir == "" {
+			h.Dir = "."
+		}
+		h.filter = fileFilter{include: h.Include, exclude: h.Exclude}
+	}
+	return &cfg, nil
+}
+
+// puller polls every host on its interval, downloads files that are new
+// or changed since the checkpoint into spool/<host>/ and submits them.
+type puller struct {
+	cfg    *pullConfig
+	spool  string
+	submit func(path string)
+
+	hosts chan struct{} // max_concurrent_hosts
+
+	mu         sync.Mutex
+	checkpoint map[string]map[string]string // host -> file -> signature
+	ckptPath   string
+}
+
+func newPuller(cfg *pullConfig, spool, checkpointPath string, submit func(string)) (*puller, error) {
+	p := &puller{
+		cfg:        cfg,
+		spool:      spool,
+		submit:     submit,
+		hosts:      make(chan struct{}, cfg.MaxConcurrentHosts),
+		checkpoint: map[string]map[string]string{},
+		ckptPath:   checkpointPath,
+	}
+	data, err := os.ReadFile(checkpointPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading pull checkpoint: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &p.checkpoint); err != nil {
+			return nil, fmt.Errorf("error parsing pull checkpoint %s: %w", checkpointPath, err)
+		}
+	}
+	return p, nil
+}
+
+// run polls all hosts until ctx is done; it returns once every host loop
+// has stopped.
+func (p *puller) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, h := range p.cfg.Hosts {
+		wg.Add(1)
+		go func(h *pullHost) {
+			defer wg.Done()
+			ticker := time.NewTicker(h.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case p.hosts <- struct{}{}:
+					if err := p.pull(ctx, h); err != nil && ctx.Err() == nil {
+						logPull.Error("error pulling files", "host", h.Name, "err", err)
+						metricPullErrors.Inc(h.Name)
+					}
+					<-p.hosts
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(h)
+	}
+	wg.Wait()
+}
+
+func (p *puller) pull(ctx context.Context, h *pullHost) error {
+	var remote pullClient
+	switch h.Protocol {
+	case "ftp":
+		remote = ftpClient{h}
+	default:
+		remote = sftpClient{h}
+	}
+	files, err := remote.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	var todo []remoteFile
+	p.mu.Lock()
+	done := p.checkpoint[h.Name]
+	for _, f := range files {
+		if h.filter.Match(f.name) && done[f.name] != f.signature() {
+			todo = append(todo, f)
+		}
+	}
+	p.mu.Unlock()
+
+	// 호스트별 동시 다운로드 수 제한
+	sem := make(chan struct{}, h.Concurrency)
+	var wg sync.WaitGroup
+	for _, f := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(f remoteFile) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			dest := filepath.Join(p.spool, h.Name, f.name)
+			if err := remote.fetch(ctx, f.name, dest); err != nil {
+				logPull.Error("error downloading file", "host", h.Name, "file", f.name, "err", err)
+				metricPullErrors.Inc(h.Name)
+				return
+			}
+			metricPullDownloaded.Inc(h.Name)
+			logPull.Info("downloaded file", "host", h.Name, "file", f.name, "bytes", f.size)
+			if err := p.mark(h.Name, f); err != nil {
+				logPull.Error("error saving pull checkpoint", "err", err)
+			}
+			p.submit(dest)
+		}(f)
+	}
+	wg.Wait()
+	return nil
+}
+
+// mark records f as fetched and saves the checkpoint.
+func (p *puller) mark(host string, f remoteFile) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.checkpoint[host] == nil {
+		p.checkpoint[host] = map[string]string{}
+	}
+	p.checkpoint[host][f.name] = f.signature()
+	if p.ckptPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.ckptPath, bytes.NewReader(data))
+}
+
+type pullClient interface {
+	list(ctx context.Context) ([]remoteFile, error)
+	fetch(ctx context.Context, name, dest string) error
+}
+
+type ftpClient struct{ h *pullHost }
+
+func (c ftpClient) list(ctx context.Context) ([]remoteFile, error) {
+	conn, err := dialFTP(ctx, c.h)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.list(c.h.Dir)
+}
+
+func (c ftpClient) fetch(ctx context.Context, name, dest string) error {
+	conn, err := dialFTP(ctx, c.h)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.retrieve(path.Join(c.h.Dir, name), dest)
+}
+
+// sftpClient drives the OpenSSH sftp binary in batch mode, the same way
+// zstd input uses the zstd binary; authentication is by key only.
+type sftpClient struct{ h *pullHost }
+
+func (c sftpClient) run(ctx context.Context, batch string) ([]byte, error) {
+	bin, err := exec.LookPath("sftp")
+	if err != nil {
+		return nil, errors.New("sftp pull requires the OpenSSH sftp binary in PATH")
+	}
+	args := []string{"-q", "-b", "-", "-P", strconv.Itoa(c.h.port()),
+		"-o", "BatchMode=yes", "-o", "ConnectTimeout=30"}
+	if c.h.IdentityFile != "" {
+		args = append(args, "-i", c.h.IdentityFile)
+	}
+	if c.h.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+c.h.KnownHosts)
+	}
+	target := c.h.Host
+	if c.h.Username != "" {
+		target = c.h.Username + "@" + target
+	}
+	cmd := exec.CommandContext(ctx, bin, append(args, target)...)
+	cmd.Stdin = strings.NewReader(batch)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sftp %s: %w: %s", c.h.Host, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (c sftpClient) list(ctx context.Context) ([]remoteFile, error) {
+	out, err := c.run(ctx, fmt.Sprintf("ls -l %s\n", sftpQuote(c.h.Dir)))
+	if err != nil {
+		return nil, err
+	}
+	// -rw-r--r--    1 user  group   13489 Jul 31 00:15 /export/twamp/a.gz
+	var files []remoteFile
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 9 || !strings.HasPrefix(f[0], "-") {
+			continue
+		}
+		size, _ := strconv.ParseInt(f[4], 10, 64)
+		files = append(files, remoteFile{
+			name:     path.Base(strings.Join(f[8:], " ")),
+			size:     size,
+			modified: strings.Join(f[5:8], " "),
+		})
+	}
+	return files, scanner.Err()
+}
+
+func (c sftpClient) fetch(ctx context.Context, name, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp := dest + ".part"
+	if _, err := c.run(ctx, fmt.Sprintf("get %s %s\n", sftpQuote(path.Join(c.h.Dir, name)), sftpQuote(tmp))); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// corruptFileError marks a source file that can't be read at all, as
+// opposed to rows that failed to convert or index.
+type corruptFileError struct{ error }
+
+func (e corruptFileError) Unwrap() error { return e.error }
+
+// quarantine moves path into dir so a bad file isn't picked up again; the
+// name gets a timestamp suffix so repeated uploads don't overwrite each
+// other. It returns the new location.
+func quarantine(path, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("%s.%s", filepath.Base(path), time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.Rename(path, dest); err == nil {
+		metricFilesQuarantined.Inc()
+		return dest, nil
+	}
+	// 다른 파일시스템이면 rename 이 안 되므로 복사 후 삭제
+	if err := copyFile(path, dest); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	metricFilesQuarantined.Inc()
+	return dest, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
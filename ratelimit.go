@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate events per second with a burst of one second's
+// worth. A request bigger than the burst is let through once the bucket is
+// full and leaves it in debt, so a single large bulk request isn't stuck.
+type tokenBucket struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// reserve takes n tokens and returns how long to wait before using them.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	need := min(n, b.rate) // burst 보다 큰 요청은 가득 찬 만큼만 기다린다
+	var wait time.Duration
+	if b.tokens < need {
+		wait = time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= n
+	return wait
+}
+
+// bulkLimiter throttles the bulk requests to one Elasticsearch cluster
+// (ES_RATE_LIMIT_DOCS, ES_RATE_LIMIT_REQUESTS). It's shared by every
+// indexer on the client, data, rollups, alerts and replays alike, so a
+// backlog can't take more than its share of a shared cluster.
+type bulkLimiter struct {
+	docs, requests *tokenBucket
+}
+
+func newBulkLimiter(config Config) *bulkLimiter {
+	if config.ESRateLimitDocs <= 0 && config.ESRateLimitRequests <= 0 {
+		return nil
+	}
+	logES.Info("bulk requests rate limited", "docs_per_sec", config.ESRateLimitDocs, "requests_per_sec", config.ESRateLimitRequests)
+	return &bulkLimiter{docs: newTokenBucket(config.ESRateLimitDocs), requests: newTokenBucket(config.ESRateLimitRequests)}
+}
+
+// wait blocks until a bulk request of docs documents may be sent.
+func (l *bulkLimiter) wait(ctx context.Context, name string, docs int) error {
+	if l == nil {
+		return nil
+	}
+	wait := max(l.docs.reserve(float64(docs)), l.requests.reserve(1))
+	if wait <= 0 {
+		return nil
+	}
+	metricBulkThrottled.Add(wait.Seconds(), name)
+	logES.Debug("bulk request throttled", "documents", docs, "wait", wait.String())
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
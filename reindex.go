@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// reindexScroll is how long Elasticsearch keeps the scroll context between
+// two pages; a page waits for the bulk requests of the one before.
+const reindexScroll = 5 * time.Minute
+
+// reindexRun is a `twamp reindex`: the documents of Source (an index,
+// alias or pattern, e.g. twamp-data-*) are read back into records and go
+// through the current transform stages (KPIs, inventory, MAPPING_FILE,
+// DOCUMENT_FORMAT, ...) to ES_INDEX. The source documents are first put
+// back into the schema's names: Format is the DOCUMENT_FORMAT and Mapping
+// the MAPPING_FILE they were indexed with, whose renames and conversions
+// are undone. The KPI fields are computed again when KPI_ENRICH is on;
+// rollups, alerts, anomalies, alarms and sampling don't run, they already
+// saw these rows.
+type reindexRun struct {
+	Source       string
+	Format       string
+	Mapping      string
+	Since, Until string
+	Size         int
+
+	mapping *fieldMapping
+	kpi     bool
+}
+
+// ingestFields are added by the ingest pipeline (provision-ingest-pipeline),
+// which sets them again on the new index.
+var ingestFields = []string{"fingerprint", "ingested_at", "ingest_error"}
+
+// query selects the documents of -since/-until, oldest first so the
+// jitter of every session is computed over its stat rounds in order.
+func (r *reindexRun) query() ([]byte, error) {
+	since, err := parseBackfillDate("since", r.Since)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseBackfillDate("until", r.Until)
+	if err != nil {
+		return nil, err
+	}
+	query := map[string]interface{}{"match_all": map[string]interface{}{}}
+	if !since.IsZero() || !until.IsZero() {
+		bounds := map[string]interface{}{}
+		if !since.IsZero() {
+			bounds["gte"] = since.Format(time.RFC3339)
+		}
+		if !until.IsZero() {
+			bounds["lt"] = until.AddDate(0, 0, 1).Format(time.RFC3339)
+		}
+		query = map[string]interface{}{"range": map[string]interface{}{"@timestamp": bounds}}
+	}
+	return json.Marshal(map[string]interface{}{
+		"query": query,
+		"sort":  []string{"@timestamp", "_doc"},
+	})
+}
+
+// restore turns a source document back into a record as the parser made
+// it.
+func (r *reindexRun) restore(doc Record) Record {
+	if r.Format == "ecs" {
+		doc = fromECS(doc)
+	}
+	if r.mapping != nil {
+		doc = r.mapping.Revert(doc)
+	}
+	for _, f := range ingestFields {
+		delete(doc, f)
+	}
+	if r.kpi {
+		for _, s := range kpiSeries {
+			delete(doc, s.field)
+		}
+	}
+	return doc
+}
+
+// reindex scrolls through the source index and adds every document to
+// sink, flushing after each page. It returns the number of documents
+// read; on ctx's cancellation it stops after the current page.
+func reindex(ctx context.Context, es *elasticsearch.Client, sink recordSink, r *reindexRun) (int, error) {
+	body, err := r.query()
+	if err != nil {
+		return 0, err
+	}
+	res, err := es.Search(es.Search.WithContext(ctx), es.Search.WithIndex(r.Source),
+		es.Search.WithBody(bytes.NewReader(body)), es.Search.WithSize(r.Size),
+		es.Search.WithScroll(reindexScroll))
+	if err != nil {
+		return 0, fmt.Errorf("error querying %s: %w", r.Source, err)
+	}
+	n := 0
+	var scrollID string
+	defer func() {
+		if scrollID == "" {
+			return
+		}
+		// 끝나거나 중단되면 scroll context 를 바로 지운다
+		res, err := es.ClearScroll(es.ClearScroll.WithScrollID(scrollID))
+		if err != nil {
+			logES.Warn("error clearing scroll", "err", err)
+			return
+		}
+		res.Body.Close()
+	}()
+	for {
+		page, err := readScrollPage(res, r.Source)
+		if err != nil {
+			return n, err
+		}
+		scrollID = page.ScrollID
+		if len(page.Hits.Hits) == 0 {
+			return n, nil
+		}
+		for _, hit := range page.Hits.Hits {
+			if err := sink.Add(r.restore(hit.Source)); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if err := sink.Flush(); err != nil {
+			return n, err
+		}
+		logES.Info("reindexing", "source", r.Source, "documents", n, "total", page.Hits.Total.Value)
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+		if res, err = es.Scroll(es.Scroll.WithContext(ctx), es.Scroll.WithScrollID(scrollID), es.Scroll.WithScroll(reindexScroll)); err != nil {
+			return n, fmt.Errorf("error scrolling %s: %w", r.Source, err)
+		}
+	}
+}
+
+type scrollPage struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source Record `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func readScrollPage(res *esapi.Response, index string) (*scrollPage, error) {
+	defer res.Body.Close()
+	if res.IsError() {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("error querying %s: [%d] %s", index, res.StatusCode, msg)
+	}
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	var page scrollPage
+	if err := dec.Decode(&page); err != nil {
+		return nil, fmt.Errorf("error reading search response: %w", err)
+	}
+	for _, hit := range page.Hits.Hits {
+		wholeNumbers(hit.Source)
+	}
+	return &page, nil
+}
+
+// wholeNumbers turns the json.Numbers of a decoded document into int64
+// where they fit and float64 otherwise, like the parser's values, so
+// session_id stays 1234567 and not 1.234567e+06 in the _id.
+func wholeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = wholeNumbers(e)
+		}
+	case Record:
+		for k, e := range v {
+			v[k] = wholeNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = wholeNumbers(e)
+		}
+	}
+	return v
+}
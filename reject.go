@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// rejectFile collects the rows of one input file that were skipped
+// (REJECT_DIR/<file>.rejects.csv): the document, its line, why, and the
+// row's fields as read. It's only created once a row is rejected.
+type rejectFile struct {
+	path   string
+	append bool // resumed file: keep the rejects of the earlier run
+	file   *os.File
+	w      *csv.Writer
+}
+
+func newRejectFile(dir, filePath string, resumed bool) *rejectFile {
+	if dir == "" {
+		return nil
+	}
+	return &rejectFile{path: filepath.Join(dir, filepath.Base(filePath)+".rejects.csv"), append: resumed}
+}
+
+func (r *rejectFile) add(name string, line int, reason error, row []string) error {
+	if r == nil {
+		return nil
+	}
+	if r.w == nil {
+		if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+			return err
+		}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if r.append {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(r.path, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		r.file, r.w = f, csv.NewWriter(f)
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			r.w.Write([]string{"file", "line", "error", "fields..."})
+		}
+	}
+	return r.w.Write(append([]string{name, fmt.Sprint(line), reason.Error()}, row...))
+}
+
+func (r *rejectFile) Close() error {
+	if r == nil || r.w == nil {
+		return nil
+	}
+	r.w.Flush()
+	err := r.w.Error()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloader re-reads the config file (and the schema/alerts files it
+// points to) on change or SIGHUP and applies what can be changed safely:
+//
+//	schema / timestamp settings  new files use the new schema
+//	ALERTS_FILE                  rule set swapped, counts kept per rule name
+//	KPI_UNAVAILABLE_LOSS_PCT
+//	BULK_SIZE, BULK_FLUSH_INTERVAL
+//	FILE_PATH, INCLUDE/EXCLUDE_PATTERNS  watch moves, backlog of the new root is queued
+//
+// Anything else is logged as needing a restart. Files already being
+// processed finish with the settings they started with.
+type reloader struct {
+	path string
+
+	mu     sync.Mutex
+	config Config
+
+	schema  *atomic.Pointer[Schema]
+	kpi     *kpiEnricher
+	alerts  *alertSink
+	fanOuts []*fanOut
+
+	watcher *dirWatcher
+	target  *watchTarget
+	queue   func(root string, filter fileFilter)
+}
+
+var reloadable = map[string]bool{
+	"SchemaFile": true, "TimestampColumns": true, "TimestampLayouts": true,
+	"TimestampTimezone": true, "TimestampOnError": true,
+	"AlertsFile": true, "KPIUnavailableLossPct": true,
+	"BulkSize": true, "BulkFlushInterval": true,
+	"FilePath": true, "IncludePatterns": true, "ExcludePatterns": true,
+}
+
+func (r *reloader) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cf := &configFile{}
+	if r.path != "" {
+		var err error
+		if cf, err = loadConfigFile(r.path); err != nil {
+			logConfig.Error("config reload failed, keeping current settings", "err", err)
+			return
+		}
+	}
+	next := loadConfig()
+	next.Pipelines, next.Tenants = cf.Pipelines, cf.Tenants
+	if err := next.validate(true); err != nil {
+		logConfig.Error("config reload failed, keeping current settings", "err", err)
+		return
+	}
+
+	// 스키마/알람 파일은 내용만 바뀔 수 있으므로 매번 다시 읽는다
+	ts, err := newTimestampParser(next)
+	if err != nil {
+		logConfig.Error("config reload failed", "err", err)
+		return
+	}
+	schema, err := newSchema(next.SchemaFile, cf.SchemaFields, ts)
+	if err != nil {
+		logConfig.Error("config reload failed", "err", err)
+		return
+	}
+	if r.alerts != nil && next.AlertsFile != "" {
+		alertCfg, err := loadAlertConfig(next.AlertsFile)
+		if err != nil {
+			logConfig.Error("config reload failed", "err", err)
+			return
+		}
+		if err := r.alerts.SetRules(alertCfg); err != nil {
+			logConfig.Error("config reload failed", "err", err)
+			return
+		}
+	}
+	r.schema.Store(schema)
+
+	changes := diffConfig(r.config, next)
+	for _, c := range changes {
+		if reloadable[c.field] {
+			logConfig.Info("config changed", "field", c.field, "old", c.old, "new", c.new)
+		} else {
+			logConfig.Warn("config changed, restart required to apply", "field", c.field, "old", c.old, "new", c.new)
+		}
+	}
+	if len(changes) == 0 {
+		logConfig.Info("config reloaded, no setting changed")
+	}
+
+	if r.kpi != nil {
+		r.kpi.SetUnavailableLossPct(next.KPIUnavailableLossPct)
+	}
+	for _, f := range r.fanOuts {
+		f.SetBatch(next.BulkSize, next.BulkFlushInterval)
+	}
+	if r.target != nil {
+		r.retarget(next)
+	}
+
+	// 재시작이 필요한 값은 이전 값을 유지해야 다음 diff 에서도 다시 알려준다
+	applied := r.config
+	nv, av := reflect.ValueOf(next), reflect.ValueOf(&applied).Elem()
+	for i := 0; i < nv.NumField(); i++ {
+		if reloadable[nv.Type().Field(i).Name] {
+			av.Field(i).Set(nv.Field(i))
+		}
+	}
+	r.config = applied
+}
+
+// current is the config in effect: reloaded fields updated, the others as
+// started.
+func (r *reloader) current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+func (r *reloader) retarget(next Config) {
+	oldRoot, _ := r.target.get()
+	filter := newFileFilter(next.IncludePatterns, next.ExcludePatterns)
+	if next.FilePath == oldRoot {
+		r.target.set(oldRoot, filter)
+		return
+	}
+	// poll 모드에서는 dirPoller 가 target 을 따라간다
+	if next.WatchMode != "poll" {
+		if err := r.watcher.add(next.FilePath, next.WatchRecursive); err != nil {
+			logConfig.Error("error watching new FILE_PATH, keeping the old one", "path", next.FilePath, "watching", oldRoot, "err", err)
+			next.FilePath = oldRoot
+			r.target.set(oldRoot, filter)
+			return
+		}
+	}
+	r.watcher.removeTree(oldRoot)
+	r.target.set(next.FilePath, filter)
+	r.queue(next.FilePath, filter)
+}
+
+// watch reloads when any of the files changes (debounced, editors often
+// write several events) or on SIGHUP.
+func (r *reloader) watch(files ...string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// 에디터가 rename 으로 저장하는 경우가 많아서 파일이 아니라 디렉토리를 감시
+	targets := map[string]bool{}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		targets[abs] = true
+		if err := w.Add(filepath.Dir(abs)); err != nil {
+			return err
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if targets[event.Name] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					debounce = time.After(500 * time.Millisecond)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logConfig.Error("config watcher error", "err", err)
+			case <-hup:
+				logConfig.Info("SIGHUP received, reloading config")
+				r.Reload()
+			case <-debounce:
+				debounce = nil
+				logConfig.Info("config file changed, reloading")
+				r.Reload()
+			}
+		}
+	}()
+	return nil
+}
+
+type configChange struct {
+	field    string
+	old, new interface{}
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.field, c.old, c.new)
+}
+
+// secretField reports whether the Config field holds a credential.
+func secretField(name string) bool {
+	return strings.Contains(name, "Password") || strings.Contains(name, "Secret") || strings.HasSuffix(name, "Token") ||
+		strings.HasSuffix(name, "APIKey") || name == "OTLPHeaders" || name == "Tenants"
+}
+
+// maskSecret is the value of a secret field as it may be shown: tenants
+// with their credentials masked, anything else masked whole.
+func maskSecret(v interface{}) interface{} {
+	tenants, ok := v.([]tenantConfig)
+	if !ok {
+		return "***"
+	}
+	masked := make([]tenantConfig, len(tenants))
+	for i, t := range tenants {
+		for _, s := range []*string{&t.User, &t.Password, &t.APIKey} {
+			if *s != "" {
+				*s = "***"
+			}
+		}
+		if u, err := url.Parse(t.Server); err == nil && u.User != nil {
+			t.Server = u.Redacted()
+		}
+		masked[i] = t
+	}
+	return masked
+}
+
+// diffConfig lists the fields that differ, with secrets masked.
+func diffConfig(old, next Config) []configChange {
+	var changes []configChange
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(next)
+	for i := 0; i < ov.NumField(); i++ {
+		if !ov.Type().Field(i).IsExported() {
+			continue
+		}
+		name := ov.Type().Field(i).Name
+		o, n := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		if secretField(name) {
+			o, n = maskSecret(o), maskSecret(n)
+		}
+		changes = append(changes, configChange{field: name, old: o, new: n})
+	}
+	return changes
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RemoteWriteOutput pushes the per-session KPIs to a Prometheus
+// remote_write endpoint (OUTPUT=remote_write): Prometheus itself, Mimir,
+// Thanos Receive, VictoriaMetrics. Labels come from REMOTE_WRITE_LABELS
+// (label=field, or just field), e.g. device=source_ne,session=session_id.
+// Samples keep the record's @timestamp, so backfills older than the
+// receiver's out-of-order window are rejected by it.
+type RemoteWriteOutput struct {
+	endpoint string
+	user     string
+	password string
+	token    string
+	tenant   string
+	labels   [][2]string // label name, record field
+	client   *http.Client
+	retry    retryPolicy
+}
+
+func newRemoteWriteOutput(config Config) (*RemoteWriteOutput, error) {
+	if config.RemoteWriteURL == "" {
+		return nil, errors.New("REMOTE_WRITE_URL is required for the remote_write output")
+	}
+	if _, err := url.Parse(config.RemoteWriteURL); err != nil {
+		return nil, fmt.Errorf("invalid REMOTE_WRITE_URL: %w", err)
+	}
+	labels, err := parseLabelList("REMOTE_WRITE_LABELS", config.RemoteWriteLabels, validLabelName)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteWriteOutput{
+		endpoint: config.RemoteWriteURL,
+		user:     config.RemoteWriteUser,
+		password: config.RemoteWritePassword,
+		token:    config.RemoteWriteToken,
+		tenant:   config.RemoteWriteTenant,
+		labels:   labels,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		retry:    config.retryPolicy(),
+	}, nil
+}
+
+// parseLabelList parses a label=field list (REMOTE_WRITE_LABELS,
+// OTEL_METRICS_ATTRIBUTES); a bare field is also the label name.
+func parseLabelList(setting, s string, valid func(string) bool) ([][2]string, error) {
+	var labels [][2]string
+	seen := map[string]bool{}
+	for _, item := range splitList(s) {
+		name, field, ok := strings.Cut(item, "=")
+		if !ok {
+			field = name
+		}
+		name, field = strings.TrimSpace(name), strings.TrimSpace(field)
+		if !valid(name) || strings.HasPrefix(name, "__") || field == "" {
+			return nil, fmt.Errorf("invalid %s entry %q", setting, item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate label %q in %s", name, setting)
+		}
+		seen[name] = true
+		labels = append(labels, [2]string{name, field})
+	}
+	return labels, nil
+}
+
+func validLabelName(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func (o *RemoteWriteOutput) Name() string { return "remote_write" }
+
+func (o *RemoteWriteOutput) Close() error { return nil }
+
+type remoteSeries struct {
+	labels  [][2]string // sorted by name
+	samples []remoteSample
+}
+
+type remoteSample struct {
+	value float64
+	ms    int64
+}
+
+func (o *RemoteWriteOutput) Write(ctx context.Context, records []Record) error {
+	series, samples := o.series(records)
+	if samples == 0 {
+		return nil
+	}
+	payload := snappyEncode(encodeWriteRequest(series))
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := o.post(ctx, payload)
+		metricBulkLatency.Observe(time.Since(start).Seconds(), o.Name())
+		if err == nil {
+			metricDocumentsIndexed.Add(float64(len(records)), o.Name())
+			logOutput.Debug("samples written", "output", o.Name(), "series", len(series), "samples", samples)
+			return nil
+		}
+		metricBulkFailures.Inc(o.Name())
+		if _, ok := err.(retryableError); !ok {
+			return err
+		}
+		if attempt >= o.retry.MaxAttempts {
+			return fmt.Errorf("giving up on remote_write after %d attempts: %w", attempt, err)
+		}
+		wait := o.retry.backoff(attempt)
+		metricBulkRetries.Inc(o.Name())
+		logOutput.Warn("remote_write failed, retrying", "attempt", attempt, "max_attempts", o.retry.MaxAttempts, "wait", wait.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// series groups the batch's samples by label set, oldest first as the
+// receivers require; records without @timestamp are skipped.
+func (o *RemoteWriteOutput) series(records []Record) ([]*remoteSeries, int) {
+	byKey := map[string]*remoteSeries{}
+	var out []*remoteSeries
+	samples := 0
+	for _, rec := range records {
+		ts, _ := recordField(rec, "@timestamp")
+		t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(ts))
+		if err != nil {
+			continue
+		}
+		var base [][2]string
+		for _, l := range o.labels {
+			if v, ok := recordField(rec, l[1]); ok && v != nil && fmt.Sprint(v) != "" {
+				base = append(base, [2]string{l[0], fmt.Sprint(v)})
+			}
+		}
+		for _, kpi := range kpiSeries {
+			v, ok := recordField(rec, kpi.field)
+			if !ok {
+				continue
+			}
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			name := "twamp_" + kpi.name
+			if kpi.unit != "" {
+				name += "_" + kpi.unit
+			}
+			labels := append([][2]string{{"__name__", name}}, base...)
+			if kpi.label != "" {
+				labels = append(labels, [2]string{kpi.label, kpi.value})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+			var key strings.Builder
+			for _, l := range labels {
+				key.WriteString(l[0] + "\xff" + l[1] + "\xff")
+			}
+			s, ok := byKey[key.String()]
+			if !ok {
+				s = &remoteSeries{labels: labels}
+				byKey[key.String()] = s
+				out = append(out, s)
+			}
+			s.samples = append(s.samples, remoteSample{value: f * kpi.scale, ms: t.UnixMilli()})
+			samples++
+		}
+	}
+	for _, s := range out {
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].ms < s.samples[j].ms })
+	}
+	return out, samples
+}
+
+func (o *RemoteWriteOutput) post(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "twamp")
+	switch {
+	case o.token != "":
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	case o.user != "":
+		req.SetBasicAuth(o.user, o.password)
+	}
+	if o.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", o.tenant)
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	err = fmt.Errorf("remote_write: %s: %s", res.Status, bytes.TrimSpace(msg))
+	// 4xx (out of order, too old, bad labels) 는 재시도해도 같은 결과
+	if retryableStatus(res.StatusCode) || res.StatusCode/100 == 5 {
+		return retryableError{err}
+	}
+	return err
+}
+
+// encodeWriteRequest encodes prometheus.WriteRequest (remote.proto):
+// repeated TimeSeries = 1 { repeated Label = 1 { name = 1, value = 2 },
+// repeated Sample = 2 { double value = 1, int64 timestamp = 2 } }.
+func encodeWriteRequest(series []*remoteSeries) []byte {
+	var out, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = appendBytesField(msg[:0], 1, []byte(l[0]))
+			msg = appendBytesField(msg, 2, []byte(l[1]))
+			ts = appendBytesField(ts, 1, msg)
+		}
+		for _, smp := range s.samples {
+			msg = binary.AppendUvarint(msg[:0], 1<<3|1)
+			msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(smp.value))
+			msg = appendVarintField(msg, 2, uint64(smp.ms))
+			ts = appendBytesField(ts, 2, msg)
+		}
+		out = appendBytesField(out, 1, ts)
+	}
+	return out
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// snappyEncode compresses src in the snappy block format remote_write
+// uses (not the framed stream format): greedy 4 byte matches within each
+// 64KB block, everything else as literals.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 1<<16)
+		dst = snappyBlock(dst, src[:n])
+		src = src[n:]
+	}
+	return dst
+}
+
+func snappyBlock(dst, src []byte) []byte {
+	var table [1 << 14]int32 // 위치+1, 0 은 비어 있음
+	lit := 0
+	for i := 0; i+4 <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> 18
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || binary.LittleEndian.Uint32(src[cand:]) != v {
+			i++
+			continue
+		}
+		dst = snappyLiteral(dst, src[lit:i])
+		n := 4
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		off := i - cand
+		for rem := n; rem > 0; {
+			l := min(rem, 64)
+			dst = append(dst, byte(l-1)<<2|2, byte(off), byte(off>>8))
+			rem -= l
+		}
+		i += n
+		lit = i
+	}
+	return snappyLiteral(dst, src[lit:])
+}
+
+func snappyLiteral(dst, lit []byte) []byte {
+	n := len(lit) - 1
+	switch {
+	case n < 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	default:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	}
+	return append(dst, lit...)
+}
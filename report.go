@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+)
+
+// slaReporter builds SLA reports for a day or a week from what's already
+// indexed: one row per REPORT_GROUP_BY value (a customer, link or session
+// field) with its availability, delay percentiles and loss, checked
+// against the REPORT_SLA_* targets. REPORT_SOURCE=raw aggregates the
+// ingested documents (needs KPI_ENRICH: available, rtt_mean, loss_pct),
+// rollup the ROLLUP_INDEX windows, much cheaper over a week; there the
+// percentiles are over the windows' mean delays and REPORT_GROUP_BY has to
+// be one of the rollup dimensions.
+//
+// Reports are written to REPORT_DIR as HTML or PDF (REPORT_FORMAT) by
+// `twamp report`, or by watch every day or Monday at REPORT_AT
+// (REPORT_SCHEDULE), and mailed through the REPORT_EMAIL_CHANNEL smtp
+// channel of NOTIFY_FILE when set.
+type slaReporter struct {
+	es      *elasticsearch.Client
+	index   string
+	source  string
+	groupBy string
+	targets slaTargets
+	loc     *time.Location
+	format  string
+	dir     string
+	mail    *smtpChannel
+}
+
+// slaTargets are the REPORT_SLA_* objectives; 0 leaves one unchecked.
+type slaTargets struct {
+	Availability float64 // %, at least
+	LatencyP95   float64 // ms, at most
+	LossPct      float64 // %, at most
+}
+
+type slaReport struct {
+	Period    reportPeriod
+	GroupBy   string
+	Source    string
+	Targets   slaTargets
+	Rows      []slaRow
+	Truncated bool // reportMaxGroups 보다 그룹이 많았다
+	Generated time.Time
+}
+
+// slaRow is one group's numbers; NaN where the data had none.
+type slaRow struct {
+	Group        string
+	Samples      int64
+	Availability float64
+	DelayMean    float64 // ms
+	DelayP50     float64
+	DelayP95     float64
+	DelayP99     float64
+	LossPct      float64
+	Breaches     []string
+}
+
+func (r slaRow) Pass() bool { return len(r.Breaches) == 0 }
+
+type reportPeriod struct {
+	Name     string // daily or weekly
+	From, To time.Time
+}
+
+func (p reportPeriod) String() string {
+	if p.Name == "weekly" {
+		return p.From.Format("2006-01-02") + " – " + p.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	return p.From.Format("2006-01-02")
+}
+
+const reportMaxGroups = 10000
+
+func newSLAReporter(es *elasticsearch.Client, config Config) (*slaReporter, error) {
+	loc, err := time.LoadLocation(config.ReportTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REPORT_TIMEZONE %q: %w", config.ReportTimezone, err)
+	}
+	indexName := config.dataIndex()
+	if config.ReportSource == "rollup" {
+		indexName = config.RollupIndex
+	}
+	index, err := parseIndexTemplate(indexName)
+	if err != nil {
+		return nil, err
+	}
+	r := &slaReporter{
+		es: es, index: index.Pattern(), source: config.ReportSource, groupBy: config.ReportGroupBy, loc: loc,
+		format: config.ReportFormat, dir: config.ReportDir,
+		targets: slaTargets{config.ReportSLAAvailability, float64(config.ReportSLALatency) / float64(time.Millisecond), config.ReportSLALossPct},
+	}
+	if config.ReportEmailChannel != "" {
+		if config.NotifyFile == "" {
+			return nil, fmt.Errorf("REPORT_EMAIL_CHANNEL needs NOTIFY_FILE, the smtp channel is defined there")
+		}
+		cfg, err := loadNotifyConfig(config.NotifyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range cfg.Channels {
+			if ch.Name == config.ReportEmailChannel && ch.Type == "smtp" {
+				r.mail = &smtpChannel{cfg: ch}
+			}
+		}
+		if r.mail == nil {
+			return nil, fmt.Errorf("REPORT_EMAIL_CHANNEL: no smtp channel %q in %s", config.ReportEmailChannel, config.NotifyFile)
+		}
+	}
+	return r, nil
+}
+
+// periodOf is the daily or weekly (Monday to Sunday) period day is in.
+func (r *slaReporter) periodOf(name string, day time.Time) reportPeriod {
+	day = day.In(r.loc)
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, r.loc)
+	if name == "weekly" {
+		from = from.AddDate(0, 0, -(int(from.Weekday())+6)%7)
+		return reportPeriod{name, from, from.AddDate(0, 0, 7)}
+	}
+	return reportPeriod{name, from, from.AddDate(0, 0, 1)}
+}
+
+// lastPeriod is the last complete period before now.
+func (r *slaReporter) lastPeriod(name string, now time.Time) reportPeriod {
+	return r.periodOf(name, r.periodOf(name, now).From.AddDate(0, 0, -1))
+}
+
+// run writes (and mails) the last period's report every day, or every
+// Monday for weekly, at REPORT_AT.
+func (r *slaReporter) run(ctx context.Context, schedule, at string) {
+	hour, minute, _ := parseClock(at)
+	logReport.Info("scheduled SLA reports", "schedule", schedule, "at", at, "dir", r.dir)
+	for {
+		now := time.Now().In(r.loc)
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, r.loc)
+		for !next.After(now) || schedule == "weekly" && next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if _, err := r.generate(ctx, r.lastPeriod(schedule, next), "", r.mail != nil); err != nil {
+			logReport.Error("error generating SLA report", "schedule", schedule, "err", err)
+		}
+	}
+}
+
+// parseClock reads REPORT_AT, HH:MM.
+func parseClock(s string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// generate queries and renders the report for p, to out ("-" is stdout)
+// or REPORT_DIR/sla-<period>-<date>.<format>, and mails it when asked.
+func (r *slaReporter) generate(ctx context.Context, p reportPeriod, out string, mail bool) (string, error) {
+	report, err := r.query(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if r.format == "pdf" {
+		err = writeReportPDF(&buf, report)
+	} else {
+		err = writeReportHTML(&buf, report)
+	}
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("sla-%s-%s.%s", p.Name, p.From.Format("2006-01-02"), r.format)
+	switch out {
+	case "-":
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			return "", err
+		}
+	case "":
+		out = filepath.Join(r.dir, name)
+		if err := os.MkdirAll(r.dir, 0o755); err != nil {
+			return "", err
+		}
+		fallthrough
+	default:
+		if err := os.WriteFile(out, buf.Bytes(), 0o644); err != nil {
+			return "", err
+		}
+	}
+	breaches := 0
+	for _, row := range report.Rows {
+		if !row.Pass() {
+			breaches++
+		}
+	}
+	logReport.Info("SLA report generated", "period", p.Name, "from", p.From.Format("2006-01-02"), "groups", len(report.Rows), "breaches", breaches, "file", out)
+	if mail {
+		if r.mail == nil {
+			return out, fmt.Errorf("no REPORT_EMAIL_CHANNEL to mail the report to")
+		}
+		subject := fmt.Sprintf("%s SLA report %s: %d of %d %s values breached", p.Name, p, breaches, len(report.Rows), r.groupBy)
+		if err := r.mail.sendMail(reportMail(r.mail.cfg, subject, name, r.format, buf.Bytes())); err != nil {
+			return out, fmt.Errorf("error mailing SLA report: %w", err)
+		}
+		logReport.Info("SLA report mailed", "to", strings.Join(r.mail.cfg.To, ","))
+	}
+	return out, nil
+}
+
+// reportMail is an HTML report as the message body or a PDF one as an
+// attachment.
+func reportMail(cfg notifyChannelConfig, subject, name, format string, data []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n",
+		cfg.From, strings.Join(cfg.To, ", "), mime.QEncoding.Encode("utf-8", "[twamp] "+subject), time.Now().Format(time.RFC1123Z))
+	if format != "pdf" {
+		fmt.Fprintf(&b, "Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64Lines(&b, data)
+		return b.Bytes()
+	}
+	const boundary = "twamp-sla-report"
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s, see the attached %s.\r\n", boundary, subject, name)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=%q\r\nContent-Transfer-Encoding: base64\r\n\r\n", boundary, name)
+	writeBase64Lines(&b, data)
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+// writeBase64Lines wraps at 76 characters as MIME wants.
+func writeBase64Lines(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		fmt.Fprintf(w, "%s\r\n", enc[:76])
+		enc = enc[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", enc)
+}
+
+// reportBucket is one terms bucket of the report query.
+type reportBucket struct {
+	Key       interface{} `json:"key"`
+	DocCount  int64       `json:"doc_count"`
+	Available reportValue `json:"available"`
+	DelayMean reportValue `json:"delay_mean"`
+	Delay     struct {
+		Values map[string]*float64 `json:"values"`
+	} `json:"delay"`
+	Loss reportValue `json:"loss"`
+	Lost reportValue `json:"lost"`
+	Rx   reportValue `json:"rx"`
+}
+
+type reportValue struct {
+	Value *float64 `json:"value"`
+}
+
+func (v reportValue) get() float64 {
+	if v.Value == nil {
+		return math.NaN()
+	}
+	return *v.Value
+}
+
+func (r *slaReporter) query(ctx context.Context, p reportPeriod) (*slaReport, error) {
+	if r.es == nil {
+		return nil, fmt.Errorf("no elasticsearch client")
+	}
+	// raw 는 KPI 필드, rollup 은 window 문서를 집계한다; 지연은 둘 다 µs
+	delay, aggs := "rtt_mean", map[string]interface{}{
+		"available": map[string]interface{}{"avg": map[string]interface{}{"field": "available"}},
+		"loss":      map[string]interface{}{"avg": map[string]interface{}{"field": "loss_pct"}},
+	}
+	if r.source == "rollup" {
+		delay, aggs = "delay_mean", map[string]interface{}{
+			"available": map[string]interface{}{"weighted_avg": map[string]interface{}{
+				"value": map[string]interface{}{"field": "availability_pct"}, "weight": map[string]interface{}{"field": "rows"}}},
+			"lost": map[string]interface{}{"sum": map[string]interface{}{"field": "lost_pkts"}},
+			"rx":   map[string]interface{}{"sum": map[string]interface{}{"field": "rx_pkts"}},
+		}
+	}
+	aggs["delay_mean"] = map[string]interface{}{"avg": map[string]interface{}{"field": delay}}
+	aggs["delay"] = map[string]interface{}{"percentiles": map[string]interface{}{"field": delay, "percents": []float64{50, 95, 99}}}
+	body, err := json.Marshal(map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{"range": map[string]interface{}{"@timestamp": map[string]interface{}{
+			"gte": p.From.UTC().Format(time.RFC3339), "lt": p.To.UTC().Format(time.RFC3339)}}},
+		"aggs": map[string]interface{}{"groups": map[string]interface{}{
+			"terms": map[string]interface{}{"field": r.groupBy, "size": reportMaxGroups, "order": map[string]string{"_key": "asc"}},
+			"aggs":  aggs,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.es.Search(r.es.Search.WithContext(ctx), r.es.Search.WithIndex(r.index),
+		r.es.Search.WithBody(bytes.NewReader(body)), r.es.Search.WithIgnoreUnavailable(true))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %w", r.index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("error querying %s: [%d] %s", r.index, res.StatusCode, msg)
+	}
+	var resp struct {
+		Aggregations struct {
+			Groups struct {
+				SumOtherDocCount int64          `json:"sum_other_doc_count"`
+				Buckets          []reportBucket `json:"buckets"`
+			} `json:"groups"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error reading search response: %w", err)
+	}
+
+	report := &slaReport{
+		Period: p, GroupBy: r.groupBy, Source: r.source, Targets: r.targets,
+		Truncated: resp.Aggregations.Groups.SumOtherDocCount > 0, Generated: time.Now().In(r.loc),
+	}
+	for _, b := range resp.Aggregations.Groups.Buckets {
+		percentile := func(p string) float64 {
+			if v := b.Delay.Values[p]; v != nil {
+				return *v / 1000
+			}
+			return math.NaN()
+		}
+		row := slaRow{
+			Group: fmt.Sprint(b.Key), Samples: b.DocCount,
+			Availability: b.Available.get(), DelayMean: b.DelayMean.get() / 1000,
+			DelayP50: percentile("50.0"), DelayP95: percentile("95.0"), DelayP99: percentile("99.0"),
+			LossPct: b.Loss.get(),
+		}
+		if r.source == "rollup" {
+			row.LossPct = math.NaN()
+			if lost, rx := b.Lost.get(), b.Rx.get(); lost+rx > 0 {
+				row.LossPct = lost * 100 / (lost + rx)
+			}
+		} else {
+			row.Availability *= 100
+		}
+		row.Breaches = r.targets.check(row)
+		report.Rows = append(report.Rows, row)
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool { return !report.Rows[i].Pass() && report.Rows[j].Pass() })
+	return report, nil
+}
+
+// check lists the targets row misses; no data counts as missing the
+// availability target.
+func (t slaTargets) check(row slaRow) []string {
+	var breaches []string
+	if t.Availability > 0 && !(row.Availability >= t.Availability) {
+		breaches = append(breaches, "availability")
+	}
+	if t.LatencyP95 > 0 && row.DelayP95 > t.LatencyP95 {
+		breaches = append(breaches, "latency")
+	}
+	if t.LossPct > 0 && row.LossPct > t.LossPct {
+		breaches = append(breaches, "loss")
+	}
+	return breaches
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"num": reportNumber,
+	"join": func(s []string) string {
+		return strings.Join(s, ", ")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>TWAMP SLA report {{.Period.Name}} {{.Period}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; }
+th { background: #f3f3f3; text-align: left; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+tr.fail td { background: #fdecea; }
+.pass { color: #1e7e34; font-weight: bold; }
+.fail { color: #c62828; font-weight: bold; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<h1>TWAMP SLA report — {{.Period.Name}} {{.Period}}</h1>
+<p class="meta">{{.Period.From.Format "2006-01-02 15:04 MST"}} to {{.Period.To.Format "2006-01-02 15:04 MST"}},
+from {{.Source}} data grouped by {{.GroupBy}}, generated {{.Generated.Format "2006-01-02 15:04 MST"}}</p>
+<p>Targets:
+{{if .Targets.Availability}}availability ≥ {{num .Targets.Availability 3}} %{{end}}
+{{if .Targets.LatencyP95}}· p95 delay ≤ {{num .Targets.LatencyP95 1}} ms{{end}}
+{{if .Targets.LossPct}}· loss ≤ {{num .Targets.LossPct 2}} %{{end}}</p>
+{{if .Rows}}
+<table>
+<tr><th>{{.GroupBy}}</th><th>Samples</th><th>Availability %</th><th>Mean ms</th><th>p50 ms</th><th>p95 ms</th><th>p99 ms</th><th>Loss %</th><th>SLA</th></tr>
+{{range .Rows}}<tr{{if not .Pass}} class="fail"{{end}}>
+<td>{{.Group}}</td><td class="n">{{.Samples}}</td><td class="n">{{num .Availability 3}}</td><td class="n">{{num .DelayMean 2}}</td>
+<td class="n">{{num .DelayP50 2}}</td><td class="n">{{num .DelayP95 2}}</td><td class="n">{{num .DelayP99 2}}</td><td class="n">{{num .LossPct 3}}</td>
+<td>{{if .Pass}}<span class="pass">pass</span>{{else}}<span class="fail">{{join .Breaches}}</span>{{end}}</td>
+</tr>
+{{end}}</table>
+{{if .Truncated}}<p class="meta">Only the first {{len .Rows}} groups are shown.</p>{{end}}
+{{else}}
+<p>No data in this period.</p>
+{{end}}
+</body>
+</html>
+`))
+
+func writeReportHTML(w io.Writer, report *slaReport) error {
+	return reportTemplate.Execute(w, report)
+}
+
+// reportNumber formats v with prec decimals, "-" for no data.
+func reportNumber(v float64, prec int) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%.*f", prec, v)
+}
+
+// reportLines is the report as fixed-width text, for the PDF.
+func reportLines(r *slaReport) []string {
+	lines := []string{
+		fmt.Sprintf("TWAMP SLA report - %s %s", r.Period.Name, r.Period),
+		fmt.Sprintf("%s to %s, from %s data grouped by %s, generated %s", r.Period.From.Format("2006-01-02 15:04 MST"),
+			r.Period.To.Format("2006-01-02 15:04 MST"), r.Source, r.GroupBy, r.Generated.Format("2006-01-02 15:04 MST")),
+	}
+	var targets []string
+	if r.Targets.Availability > 0 {
+		targets = append(targets, fmt.Sprintf("availability >= %s %%", reportNumber(r.Targets.Availability, 3)))
+	}
+	if r.Targets.LatencyP95 > 0 {
+		targets = append(targets, fmt.Sprintf("p95 delay <= %s ms", reportNumber(r.Targets.LatencyP95, 1)))
+	}
+	if r.Targets.LossPct > 0 {
+		targets = append(targets, fmt.Sprintf("loss <= %s %%", reportNumber(r.Targets.LossPct, 2)))
+	}
+	lines = append(lines, "Targets: "+strings.Join(targets, ", "), "")
+	if len(r.Rows) == 0 {
+		return append(lines, "No data in this period.")
+	}
+	const row = "%-36.36s %9v %9s %9s %9s %9s %9s %8s  %s"
+	lines = append(lines, fmt.Sprintf(row, r.GroupBy, "Samples", "Avail %", "Mean ms", "p50 ms", "p95 ms", "p99 ms", "Loss %", "SLA"),
+		strings.Repeat("-", 120))
+	failed := 0
+	for _, x := range r.Rows {
+		sla := "pass"
+		if !x.Pass() {
+			sla = "FAIL " + strings.Join(x.Breaches, ", ")
+			failed++
+		}
+		lines = append(lines, fmt.Sprintf(row, x.Group, x.Samples, reportNumber(x.Availability, 3), reportNumber(x.DelayMean, 2),
+			reportNumber(x.DelayP50, 2), reportNumber(x.DelayP95, 2), reportNumber(x.DelayP99, 2), reportNumber(x.LossPct, 3), sla))
+	}
+	lines = append(lines, "", fmt.Sprintf("%d of %d %s values breached a target.", failed, len(r.Rows), r.GroupBy))
+	if r.Truncated {
+		lines = append(lines, fmt.Sprintf("Only the first %d groups are shown.", len(r.Rows)))
+	}
+	return lines
+}
+
+// A4 landscape, 8pt Courier
+const (
+	pdfWidth, pdfHeight = 842, 595
+	pdfMargin           = 40
+	pdfLeading          = 10
+	pdfLinesPerPage     = (pdfHeight - 2*pdfMargin) / pdfLeading
+)
+
+// writeReportPDF writes the report's text lines as a plain PDF 1.4 with
+// the standard Courier font, which needs no embedding. Characters outside
+// Latin-1 (e.g. Hangul session names) come out as "?"; use the HTML
+// format for those.
+func writeReportPDF(w io.Writer, report *slaReport) error {
+	lines := reportLines(report)
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	// 1 catalog, 2 pages, 3 font, 그 뒤로 page 마다 page, content
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfWidth, pdfHeight, 5+2*i))
+		var s bytes.Buffer
+		fmt.Fprintf(&s, "BT\n/F1 8 Tf\n%d TL\n%d %d Td\n", pdfLeading, pdfMargin, pdfHeight-pdfMargin)
+		for _, l := range page {
+			fmt.Fprintf(&s, "(%s) '\n", pdfText(l))
+		}
+		fmt.Fprintf(&s, "ET\nBT\n/F1 7 Tf\n%d %d Td\n(page %d of %d) Tj\nET", pdfWidth-pdfMargin-70, pdfMargin/2, i+1, len(pages))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", s.Len(), s.Bytes()))
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfText escapes s for a PDF string in Latin-1.
+func pdfText(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b = append(b, '\\', byte(r))
+		case r == '–' || r == '—':
+			b = append(b, '-')
+		case r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff:
+			b = append(b, byte(r))
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+type retryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// backoff returns the wait before the given retry (1-based), using
+// exponential growth capped at MaxBackoff with ±50% jitter.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d))) + d/2
+}
+
+// retryableStatus reports whether an HTTP status (for the whole request or
+// a single bulk item) is worth sending again.
+func retryableStatus(status int) bool {
+	switch status {
+	case 429, 502, 503, 504:
+		return true
+	}
+	return false
+}
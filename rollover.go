@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// With ES_WRITE_ALIAS the data documents are written to an alias instead
+// of ES_INDEX: its write index is <alias>-000001, <alias>-000002, ... and
+// rolling over creates the next one and moves the write flag to it, the
+// older ones staying behind the alias for searches. bootstrapWriteAlias
+// creates the first index; the rollover conditions (ILM_ROLLOVER_MAX_AGE,
+// _SIZE, _DOCS) are left to ILM's hot phase or, on clusters without ILM
+// (OpenSearch, Elastic serverless, ILM_POLICY empty), checked every
+// ILM_ROLLOVER_CHECK with the rollover API by indexRollover.
+
+// rolloverMode resolves ILM_ROLLOVER=auto: ilm when there is an
+// ILM_POLICY and the cluster has ILM, self otherwise. noILM is true when
+// the cluster turned out to have no ILM, so ILM_POLICY can't be used.
+func rolloverMode(ctx context.Context, es *elasticsearch.Client, config Config) (mode string, noILM bool) {
+	if config.ILMRollover != "auto" {
+		return config.ILMRollover, false
+	}
+	if config.ILMPolicy == "" || config.openSearch() {
+		return "self", false
+	}
+	res, err := es.ILM.GetStatus(es.ILM.GetStatus.WithContext(ctx))
+	if err != nil {
+		logES.Warn("error checking for ILM, rolling the write alias over without it", "alias", config.ESWriteAlias, "err", err)
+		return "self", false
+	}
+	res.Body.Close()
+	if res.IsError() {
+		logES.Info("cluster has no ILM, ILM_POLICY is skipped and the write alias rolled over without it", "alias", config.ESWriteAlias, "status", res.StatusCode)
+		return "self", true
+	}
+	return "ilm", false
+}
+
+// rolloverConditions are the ILM_ROLLOVER_MAX_* conditions, for the ILM
+// hot phase and the rollover API alike. OpenSearch only knows the total
+// size of the primaries.
+func rolloverConditions(config Config) map[string]interface{} {
+	conditions := map[string]interface{}{}
+	if config.ILMRolloverMaxAge != "" {
+		conditions["max_age"] = config.ILMRolloverMaxAge
+	}
+	if config.ILMRolloverMaxSize != "" {
+		if config.openSearch() {
+			conditions["max_size"] = config.ILMRolloverMaxSize
+		} else {
+			conditions["max_primary_shard_size"] = config.ILMRolloverMaxSize
+		}
+	}
+	if config.ILMRolloverMaxDocs > 0 {
+		conditions["max_docs"] = config.ILMRolloverMaxDocs
+	}
+	return conditions
+}
+
+// writeAliasPattern matches the indices behind alias.
+func writeAliasPattern(alias string) string { return alias + "-*" }
+
+// bootstrapWriteAlias creates <alias>-000001 as the write index of alias
+// unless the alias exists. An index named like the alias (created by
+// documents sent before the alias was) is an error: it has to be
+// reindexed or removed first.
+func bootstrapWriteAlias(ctx context.Context, es *elasticsearch.Client, alias string) error {
+	exists := func(do func() (*esapi.Response, error)) (bool, error) {
+		res, err := do()
+		if err != nil {
+			return false, fmt.Errorf("error checking write alias %s: %w", alias, err)
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK, nil
+	}
+	aliasExists := func() (*esapi.Response, error) {
+		return es.Indices.ExistsAlias([]string{alias}, es.Indices.ExistsAlias.WithContext(ctx))
+	}
+	if ok, err := exists(aliasExists); err != nil || ok {
+		return err
+	}
+	if ok, err := exists(func() (*esapi.Response, error) {
+		return es.Indices.Exists([]string{alias}, es.Indices.Exists.WithContext(ctx))
+	}); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("ES_WRITE_ALIAS %s is an index, not an alias; reindex it into %s-000001 or delete it", alias, alias)
+	}
+	first := alias + "-000001"
+	err := putJSON(ctx, es, "write alias "+alias+" on "+first, func(body io.Reader) (*esapi.Response, error) {
+		return es.Indices.Create(first, es.Indices.Create.WithBody(body), es.Indices.Create.WithContext(ctx))
+	}, map[string]interface{}{
+		"aliases": map[string]interface{}{alias: map[string]bool{"is_write_index": true}},
+	})
+	// 다른 인스턴스가 먼저 만들었으면 그대로 쓴다
+	if err != nil {
+		if ok, _ := exists(aliasExists); ok {
+			return nil
+		}
+	}
+	return err
+}
+
+// indexRollover rolls the write alias over with the rollover API when ILM
+// doesn't (ILM_ROLLOVER=self): Elasticsearch checks the conditions and only
+// creates the next index once one of them is met.
+type indexRollover struct {
+	clusters   []*elasticsearch.Client
+	alias      string
+	conditions map[string]interface{}
+	check      time.Duration
+}
+
+func newIndexRollover(clusters []*elasticsearch.Client, config Config) *indexRollover {
+	return &indexRollover{clusters: clusters, alias: config.ESWriteAlias, conditions: rolloverConditions(config), check: config.ILMRolloverCheck}
+}
+
+func (r *indexRollover) run(ctx context.Context) {
+	ticker := time.NewTicker(r.check)
+	defer ticker.Stop()
+	for {
+		for _, es := range r.clusters {
+			if err := r.rollover(ctx, es); err != nil {
+				logES.Error("error rolling over write alias", "alias", r.alias, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *indexRollover) rollover(ctx context.Context, es *elasticsearch.Client) error {
+	body, err := json.Marshal(map[string]interface{}{"conditions": r.conditions})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.check)
+	defer cancel()
+	res, err := es.Indices.Rollover(r.alias, es.Indices.Rollover.WithBody(bytes.NewReader(body)), es.Indices.Rollover.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		OldIndex   string          `json:"old_index"`
+		NewIndex   string          `json:"new_index"`
+		RolledOver bool            `json:"rolled_over"`
+		Conditions map[string]bool `json:"conditions"`
+	}
+	if err := decodeESResponse(res, "rollover of "+r.alias, &result); err != nil {
+		return err
+	}
+	if !result.RolledOver {
+		logES.Debug("write alias rollover conditions not met", "alias", r.alias, "index", result.OldIndex)
+		return nil
+	}
+	var met []string
+	for c, ok := range result.Conditions {
+		if ok {
+			met = append(met, c)
+		}
+	}
+	logES.Info("write alias rolled over", "alias", r.alias, "from", result.OldIndex, "to", result.NewIndex, "conditions", met)
+	metricESRollovers.Inc(r.alias)
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rollupSink passes records through to next and aggregates them per
+// session and interval window. Finished windows are written to out (the
+// rollup index) as one document each.
+//
+// A window is finished when a later window shows up for the same session,
+// when it hasn't been updated for one interval, or on Close.
+type rollupSink struct {
+	next     recordSink
+	out      recordSink
+	interval time.Duration
+
+	mu      sync.Mutex
+	buckets map[rollupKey]*rollupBucket
+	latest  map[string]time.Time // 세션별 가장 최근 window 시작 시각
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type rollupKey struct {
+	session string
+	start   time.Time
+}
+
+type rollupBucket struct {
+	start   time.Time
+	dims    Record
+	updated time.Time
+
+	rows       int
+	delays     []float64
+	min, max   float64
+	hasMinMax  bool
+	lost, rx   float64
+	misorder   float64
+	available  float64
+	availRows  int
+	hasPackets bool
+}
+
+// 창(window)이 바뀌어도 그대로 복사하는 세션 식별 필드
+var rollupDimensions = []string{"session_id", "session_name", "session_type", "source_ne", "source_ip", "destination_ip", "system_id"}
+
+func newRollupSink(next, out recordSink, interval time.Duration) *rollupSink {
+	r := &rollupSink{
+		next:     next,
+		out:      out,
+		interval: interval,
+		buckets:  make(map[rollupKey]*rollupBucket),
+		latest:   make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.idleLoop()
+	return r
+}
+
+func (r *rollupSink) Add(rec Record) error {
+	r.observe(rec)
+	return r.next.Add(rec)
+}
+
+func (r *rollupSink) Flush() error { return r.next.Flush() }
+
+func (r *rollupSink) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		r.mu.Lock()
+		var all []*rollupBucket
+		for k, b := range r.buckets {
+			all = append(all, b)
+			delete(r.buckets, k)
+		}
+		r.mu.Unlock()
+		r.emit(all)
+		if err := r.out.Close(); err != nil {
+			logRollup.Error("error flushing rollups", "err", err)
+		}
+	})
+	return r.next.Close()
+}
+
+func (r *rollupSink) observe(rec Record) {
+	id, ok := rec["session_id"]
+	if !ok {
+		return
+	}
+	session := fmt.Sprint(id)
+	start := recordTime(rec).Truncate(r.interval)
+
+	r.mu.Lock()
+	var finished []*rollupBucket
+	if latest, ok := r.latest[session]; !ok || start.After(latest) {
+		// 같은 세션의 다음 window 가 시작됐으면 이전 window 들은 끝난 것
+		for k, b := range r.buckets {
+			if k.session == session && k.start.Before(start) {
+				finished = append(finished, b)
+				delete(r.buckets, k)
+			}
+		}
+		r.latest[session] = start
+	}
+	key := rollupKey{session: session, start: start}
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &rollupBucket{start: start, dims: Record{}}
+		for _, d := range rollupDimensions {
+			if v, ok := rec[d]; ok {
+				b.dims[d] = v
+			}
+		}
+		r.buckets[key] = b
+	}
+	b.add(rec)
+	r.mu.Unlock()
+
+	r.emit(finished)
+}
+
+func (r *rollupSink) idleLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		var idle []*rollupBucket
+		for k, b := range r.buckets {
+			if time.Since(b.updated) >= r.interval {
+				idle = append(idle, b)
+				delete(r.buckets, k)
+			}
+		}
+		r.mu.Unlock()
+		r.emit(idle)
+	}
+}
+
+func (r *rollupSink) emit(buckets []*rollupBucket) {
+	for _, b := range buckets {
+		if err := r.out.Add(b.document(r.interval)); err != nil {
+			logRollup.Error("error writing rollup", "err", err)
+		}
+	}
+}
+
+func (b *rollupBucket) add(rec Record) {
+	b.rows++
+	b.updated = time.Now()
+
+	delay, ok := number(rec, "rtt_mean")
+	if !ok {
+		ul, ok1 := number(rec, "ul_dmean")
+		dl, ok2 := number(rec, "dl_dmean")
+		delay, ok = ul+dl, ok1 && ok2
+	}
+	if ok {
+		b.delays = append(b.delays, delay)
+	}
+	lo, okLo := number(rec, "rtt_min")
+	hi, okHi := number(rec, "rtt_max")
+	if !okLo || !okHi {
+		lo, hi, okLo = delay, delay, ok
+	}
+	if okLo {
+		if !b.hasMinMax || lo < b.min {
+			b.min = lo
+		}
+		if !b.hasMinMax || hi > b.max {
+			b.max = hi
+		}
+		b.hasMinMax = true
+	}
+
+	lost, okLost := sumDirections(rec, "lostpkts")
+	rx, okRx := sumDirections(rec, "rxpkts")
+	if okLost && okRx {
+		b.lost += lost
+		b.rx += rx
+		b.hasPackets = true
+	}
+	if m, ok := sumDirections(rec, "misorderpkts"); ok {
+		b.misorder += m
+	}
+	if a, ok := number(rec, "available"); ok {
+		b.available += a
+		b.availRows++
+	}
+}
+
+// sumDirections adds ul_<name> and dl_<name>, falling back to <name>
+// (records from `twamp sender` carry both-way counts there).
+func sumDirections(rec Record, name string) (float64, bool) {
+	ul, ok1 := number(rec, "ul_"+name)
+	dl, ok2 := number(rec, "dl_"+name)
+	if ok1 || ok2 {
+		return ul + dl, true
+	}
+	return number(rec, name)
+}
+
+func (b *rollupBucket) document(interval time.Duration) Record {
+	doc := Record{}
+	for k, v := range b.dims {
+		doc[k] = v
+	}
+	doc["@timestamp"] = b.start.UTC().Format(time.RFC3339Nano)
+	doc["window_end"] = b.start.Add(interval).UTC().Format(time.RFC3339Nano)
+	doc["rollup_interval"] = interval.String()
+	doc["rows"] = b.rows
+	if len(b.delays) > 0 {
+		sorted := append([]float64(nil), b.delays...)
+		sort.Float64s(sorted)
+		sum := 0.0
+		for _, d := range sorted {
+			sum += d
+		}
+		doc["delay_mean"] = sum / float64(len(sorted))
+		doc["delay_p50"] = percentile(sorted, 50)
+		doc["delay_p95"] = percentile(sorted, 95)
+		doc["delay_p99"] = percentile(sorted, 99)
+	}
+	if b.hasMinMax {
+		doc["delay_min"] = b.min
+		doc["delay_max"] = b.max
+	}
+	if b.hasPackets {
+		doc["lost_pkts"] = b.lost
+		doc["rx_pkts"] = b.rx
+		if b.lost+b.rx > 0 {
+			doc["loss_pct"] = b.lost * 100 / (b.lost + b.rx)
+		}
+	}
+	doc["misorder_pkts"] = b.misorder
+	if b.availRows > 0 {
+		doc["availability_pct"] = b.available * 100 / float64(b.availRows)
+	}
+	return doc
+}
+
+// rollupSchema describes rollup documents for the index template.
+func rollupSchema() *Schema {
+	s := &Schema{fields: map[string]schemaField{}}
+	add := func(name string, typ fieldType) { s.fields[name] = schemaField{Name: name, Type: typ} }
+	for _, name := range []string{"@timestamp", "window_end"} {
+		add(name, typeDate)
+	}
+	for _, name := range []string{"session_name", "session_type", "source_ne", "source_ip", "destination_ip", "system_id", "rollup_interval"} {
+		add(name, typeString)
+	}
+	add("session_id", typeInt)
+	add("rows", typeInt)
+	for _, name := range []string{"delay_mean", "delay_p50", "delay_p95", "delay_p99", "delay_min", "delay_max", "lost_pkts", "rx_pkts", "loss_pct", "misorder_pkts", "availability_pct"} {
+		add(name, typeFloat)
+	}
+	return s
+}
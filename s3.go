@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body, used for GET requests.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Client is a minimal S3 (and S3-compatible, e.g. MinIO/Ceph) client
+// for listing, downloading and uploading objects, signed with AWS
+// Signature V4.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	pathStyle bool
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	http *http.Client
+}
+
+func newS3Client(config Config) (*s3Client, error) {
+	endpoint := config.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + config.S3Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT: invalid URL %q", endpoint)
+	}
+	return &s3Client{
+		endpoint:     u,
+		region:       config.S3Region,
+		bucket:       config.S3Bucket,
+		pathStyle:    config.S3PathStyle,
+		accessKey:    config.S3AccessKeyID,
+		secretKey:    config.S3SecretAccessKey,
+		sessionToken: config.S3SessionToken,
+		http:         &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// objectURL builds the URL for key (empty for the bucket itself).
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	// Go 가 경로를 다르게 escape 하면 서명이 안 맞으므로 직접 지정
+	u.RawPath = awsEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (c *s3Client) do(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.accessKey != "" {
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+		if c.sessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+		}
+		signV4(req, "s3", c.region, c.accessKey, c.secretKey, emptyPayloadHash, time.Now())
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("S3 GET %s: [%d] %s", req.URL.Path, res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type s3ListResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// list returns every object under prefix (ListObjectsV2, all pages).
+func (c *s3Client) list(ctx context.Context, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		res, err := c.do(ctx, "", q)
+		if err != nil {
+			return nil, err
+		}
+		var page s3ListResult
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing S3 list response: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// download writes key to dest, via a temp file so a partial download is
+// never seen by the pipeline.
+func (c *s3Client) download(ctx context.Context, key, dest string) error {
+	res, err := c.do(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return writeFileAtomic(dest, res.Body)
+}
+
+// put uploads body as key. Connection errors and 5xx/429 are
+// retryableError.
+func (c *s3Client) put(ctx context.Context, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(key, nil).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.accessKey != "" {
+		hash := sha256Hex(body)
+		req.Header.Set("X-Amz-Content-Sha256", hash)
+		if c.sessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+		}
+		signV4(req, "s3", c.region, c.accessKey, c.secretKey, hash, time.Now())
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	err = fmt.Errorf("S3 PUT %s: [%d] %s", req.URL.Path, res.StatusCode, strings.TrimSpace(string(msg)))
+	if res.StatusCode >= 500 || retryableStatus(res.StatusCode) {
+		return retryableError{err}
+	}
+	return err
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header. Every
+// header already set on req is signed, plus host and x-amz-date.
+func signV4(req *http.Request, service, region, accessKey, secretKey, payloadHash string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := sortedKeys(headers)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the RFC 3986 unreserved
+// characters (and '/' unless encodeSlash), as SigV4 requires.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Poller lists S3_BUCKET/S3_PREFIX every interval, downloads objects not
+// yet ingested into spool and hands them to submit. The ledger decides
+// what is new, so restarts don't re-download ingested objects.
+type s3Poller struct {
+	client *s3Client
+	prefix string
+	spool  string
+	filter fileFilter
+	state  *stateStore
+	submit func(path string)
+
+	// 이번 실행에서 이미 넘긴 파일; 실패한 파일은 재시작 시 다시 시도된다
+	queued map[string]bool
+}
+
+func (p *s3Poller) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			logS3.Error("error polling S3", "bucket", p.client.bucket, "prefix", p.prefix, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *s3Poller) poll(ctx context.Context) error {
+	objects, err := p.client.list(ctx, p.prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			return nil
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(obj.Key, p.prefix), "/")
+		if rel == "" || strings.HasSuffix(obj.Key, "/") || !p.filter.Match(rel) {
+			continue
+		}
+		local := filepath.Join(p.spool, p.client.bucket, filepath.FromSlash(path.Clean("/"+obj.Key)))
+		if p.queued[local] || p.state.IsProcessed(local) {
+			continue
+		}
+		if _, err := os.Stat(local); os.IsNotExist(err) {
+			if err := p.client.download(ctx, obj.Key, local); err != nil {
+				logS3.Error("error downloading object", "key", obj.Key, "err", err)
+				continue
+			}
+			metricS3Downloaded.Inc()
+			logS3.Info("downloaded object", "key", obj.Key, "bytes", obj.Size)
+		}
+		p.queued[local] = true
+		p.submit(local)
+	}
+	return nil
+}
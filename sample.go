@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sampler thins out the raw records of busy sessions before they're
+// indexed. Per session (session_id) it keeps one in SAMPLE_EVERY rows
+// and, with SAMPLE_INTERVAL, at most one row per interval of @timestamp;
+// rounds the KPI stage marked unavailable are kept regardless with
+// SAMPLE_KEEP_UNAVAILABLE. It runs after the rollup, alert, anomaly and
+// alarm stages, so those still see every row and ROLLUP_INDEX stays exact.
+type sampler struct {
+	every           int
+	interval        time.Duration
+	keepUnavailable bool
+
+	mu       sync.Mutex
+	sessions map[string]*sampleState
+}
+
+type sampleState struct {
+	seen int       // 마지막으로 남긴 행 이후 버린 행 수
+	last time.Time // 마지막으로 남긴 행의 @timestamp
+}
+
+func newSampler(config Config) *sampler {
+	return &sampler{
+		every: max(config.SampleEvery, 1), interval: config.SampleInterval,
+		keepUnavailable: config.SampleKeepUnavailable,
+		sessions:        map[string]*sampleState{},
+	}
+}
+
+// enabled reports whether the sampler drops anything at all.
+func (s *sampler) enabled() bool { return s.every > 1 || s.interval > 0 }
+
+// Sample is the stage: it returns rec or nil to drop it.
+func (s *sampler) Sample(rec Record) Record {
+	if s.keepUnavailable {
+		if v, ok := number(rec, "available"); ok && v == 0 {
+			metricRecordsSampled.Inc("kept")
+			return rec
+		}
+	}
+	t := recordTime(rec)
+	s.mu.Lock()
+	key := fmt.Sprint(rec["session_id"])
+	st, ok := s.sessions[key]
+	if !ok {
+		st = &sampleState{}
+		s.sessions[key] = st
+	}
+	// 세션의 첫 행은 항상 남긴다
+	keep := !ok || st.seen+1 >= s.every
+	if keep && ok && s.interval > 0 && t.Sub(st.last) < s.interval && !t.Before(st.last) {
+		keep = false
+	}
+	if keep {
+		st.seen, st.last = 0, t
+	} else {
+		st.seen++
+	}
+	s.mu.Unlock()
+	if !keep {
+		metricRecordsSampled.Inc("dropped")
+		return nil
+	}
+	metricRecordsSampled.Inc("kept")
+	return rec
+}
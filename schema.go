@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TwampRecord struct {
+	SessionID       int    `json:"session_id" csv:"Session Id"`
+	SessionName     string `json:"session_name" csv:"Session Name"`
+	SessionType     string `json:"session_type" csv:"Session Type"`
+	SourceNE        string `json:"source_ne" csv:"Source NE"`
+	SourceIP        string `json:"source_ip" csv:"Source Ip"`
+	SourcePort      int    `json:"source_port" csv:"Source Port"`
+	DestinationIP   string `json:"destination_ip" csv:"Destination Ip"`
+	DestinationPort int    `json:"destination_port" csv:"Destination Port"`
+	Interval        int    `json:"interval" csv:"Interval"`
+	PacketRate      int    `json:"packet_rate" csv:"Packet Rate"`
+	PacketSize      int    `json:"packet_size" csv:"Packet Size"`
+	StatRound       int    `json:"stat_round" csv:"statRound"`
+	IntervalMs      int    `json:"interval_ms" csv:"intervalms"`
+	SyncStatus      int    `json:"sync_status" csv:"syncStatus"`
+	Timestamp       string `json:"@timestamp" csv:"statTime,date"`
+	AlarmID         string `json:"alarmid" csv:"alarmid"`
+	SystemID        string `json:"system_id" csv:"System Id"`
+	// other fields as needed
+}
+
+// Record is a single typed document ready to be indexed.
+type Record map[string]interface{}
+
+type fieldType string
+
+const (
+	typeString fieldType = "string"
+	typeInt    fieldType = "int"
+	typeFloat  fieldType = "float"
+	typeBool   fieldType = "bool"
+	typeDate   fieldType = "date"
+)
+
+type schemaField struct {
+	Name string    `json:"name"`
+	Type fieldType `json:"type"`
+}
+
+// ul_ / dl_ 방향별 측정 컬럼 (suffix 기준)
+var directionFields = map[string]fieldType{
+	"statStatus":    typeInt,
+	"firstpktSeq":   typeInt,
+	"lastpktSeq":    typeInt,
+	"rxpkts":        typeInt,
+	"rxbytes":       typeInt,
+	"misorderpkts":  typeInt,
+	"duplicatepkts": typeInt,
+	"toolatepkts":   typeInt,
+	"lostpkts":      typeInt,
+	"lostperiods":   typeInt,
+	"lostburstmin":  typeInt,
+	"lostburstmax":  typeInt,
+	"lostperc":      typeFloat,
+	"mos":           typeFloat,
+	"r":             typeFloat,
+	"tosmin":        typeInt,
+	"tosmax":        typeInt,
+	"vpriomin":      typeInt,
+	"vpriomax":      typeInt,
+	"cksum":         typeInt,
+	"ttlmin":        typeInt,
+	"ttlmax":        typeInt,
+}
+
+// delay (d*), jitter (j*) and delay variation (dv*) statistics
+var directionStatSuffixes = []string{"min", "p25", "p50", "p75", "p95", "pLo", "pMi", "pHi", "max", "mean", "StdDev"}
+
+type Schema struct {
+	fields map[string]schemaField
+	ts     *timestampParser
+}
+
+// newSchema builds the schema from TwampRecord and the direction metric
+// tables, then applies the optional override file and the config file's
+// schema.fields on top.
+func newSchema(overridePath string, inline map[string]schemaField, ts *timestampParser) (*Schema, error) {
+	s := &Schema{fields: make(map[string]schemaField), ts: ts}
+
+	t := reflect.TypeOf(TwampRecord{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("csv")
+		if tag == "" {
+			continue
+		}
+		header, typ, _ := strings.Cut(tag, ",")
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if typ == "" {
+			typ = string(kindFieldType(f.Type.Kind()))
+		}
+		s.fields[header] = schemaField{Name: name, Type: fieldType(typ)}
+	}
+
+	for _, dir := range []string{"ul_", "dl_"} {
+		for suffix, typ := range directionFields {
+			s.fields[dir+suffix] = schemaField{Name: dir + suffix, Type: typ}
+		}
+		for _, prefix := range []string{"d", "j", "dv"} {
+			for _, suffix := range directionStatSuffixes {
+				s.fields[dir+prefix+suffix] = schemaField{Name: dir + prefix + suffix, Type: typeFloat}
+			}
+		}
+	}
+
+	// TIMESTAMP_COLUMNS: 파일에 먼저 나오는 컬럼이 @timestamp 가 된다
+	for _, col := range ts.columns {
+		s.fields[col] = schemaField{Name: "@timestamp", Type: typeDate}
+	}
+
+	if overridePath != "" {
+		if err := s.loadOverrides(overridePath); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.applyOverrides(inline, "config file schema.fields"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadOverrides reads a JSON object keyed by CSV header, e.g.
+//
+//	{"Model": {"type": "string"}, "rtt_avg": {"name": "rtt_avg_ms", "type": "float"}}
+func (s *Schema) loadOverrides(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading schema file: %w", err)
+	}
+	var overrides map[string]schemaField
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("error parsing schema file %s: %w", path, err)
+	}
+	return s.applyOverrides(overrides, "schema file "+path)
+}
+
+func (s *Schema) applyOverrides(overrides map[string]schemaField, source string) error {
+	for header, f := range overrides {
+		if f.Name == "" {
+			f.Name = s.field(header).Name
+		}
+		if f.Type == "" {
+			f.Type = s.field(header).Type
+		}
+		switch f.Type {
+		case typeString, typeInt, typeFloat, typeBool, typeDate:
+		default:
+			return fmt.Errorf("%s: unknown type %q for column %q", source, f.Type, header)
+		}
+		s.fields[header] = f
+	}
+	return nil
+}
+
+func (s *Schema) field(header string) schemaField {
+	if f, ok := s.fields[header]; ok {
+		return f
+	}
+	return schemaField{Name: header, Type: typeString}
+}
+
+// Convert maps a CSV row onto a typed Record. Empty values are omitted so
+// they don't clash with numeric mappings.
+func (s *Schema) Convert(headers, row []string) (Record, error) {
+	rec := getRecord(len(headers))
+	for j, header := range headers {
+		if j >= len(row) {
+			break
+		}
+		raw := strings.TrimSpace(row[j])
+		if raw == "" {
+			continue
+		}
+		f := s.field(header)
+		if f.Type == typeDate {
+			if _, ok := rec[f.Name]; ok {
+				continue
+			}
+			v, err := s.ts.Parse(raw)
+			if err != nil {
+				if !s.ts.flag {
+					return nil, fmt.Errorf("column %q: %w", header, err)
+				}
+				metricTimestampsFlagged.Inc()
+				v = time.Now().UTC().Format(time.RFC3339Nano)
+				rec["timestamp_invalid"] = true
+				rec["timestamp_raw"] = raw
+			}
+			rec[f.Name] = v
+			continue
+		}
+		v, err := convertValue(f.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", header, err)
+		}
+		rec[f.Name] = v
+	}
+	return rec, nil
+}
+
+func convertValue(typ fieldType, raw string) (interface{}, error) {
+	switch typ {
+	case typeInt:
+		return strconv.ParseInt(raw, 10, 64)
+	case typeFloat:
+		f, err := strconv.ParseFloat(raw, 64)
+		// NaN, Inf 는 JSON 으로 보낼 수 없다
+		if err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return nil, fmt.Errorf("%q is not a finite number", raw)
+		}
+		return f, err
+	case typeBool:
+		return strconv.ParseBool(raw)
+	default:
+		return raw, nil
+	}
+}
+
+func kindFieldType(k reflect.Kind) fieldType {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return typeInt
+	case reflect.Float32, reflect.Float64:
+		return typeFloat
+	case reflect.Bool:
+		return typeBool
+	default:
+		return typeString
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+// Windows service support is in service_windows.go; elsewhere these do
+// nothing.
+func startService() {}
+
+func serviceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return ctx, func() {}
+}
+
+func stopService() {}
@@ -0,0 +1,97 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// service is set when the service control manager started twamp, e.g.
+//
+//	sc create twamp binPath= "C:\twamp\twamp.exe --config C:\twamp\config.yaml watch" start= auto
+//	sc failure twamp reset= 86400 actions= restart/60000
+//
+// Stop and shutdown requests cancel the context like SIGTERM does.
+var service *windowsService
+
+type windowsService struct {
+	stop    chan struct{} // 서비스 중지 요청
+	exited  chan struct{} // main 이 끝났다
+	stopped chan struct{} // SCM 에 종료를 알렸다
+}
+
+// startService hands control to the service control manager when running
+// as a Windows service. Services start in System32, so the working
+// directory becomes the executable's: .env and relative paths in the
+// config are found next to twamp.exe.
+func startService() {
+	ok, err := svc.IsWindowsService()
+	if err != nil || !ok {
+		return
+	}
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+	service = &windowsService{stop: make(chan struct{}), exited: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(service.stopped)
+		// own-process 서비스는 이름을 보지 않는다
+		if err := svc.Run("twamp", service); err != nil {
+			logIngest.Error("error running as a Windows service", "err", err)
+		}
+	}()
+}
+
+// serviceContext is ctx, also cancelled when the service is stopped.
+func serviceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if service != nil {
+		go func() {
+			select {
+			case <-service.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// stopService reports the service stopped once main is done draining.
+func stopService() {
+	if service == nil {
+		return
+	}
+	close(service.exited)
+	select {
+	case <-service.stopped:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-s.exited:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				logIngest.Info("stop requested by the service control manager")
+				status <- svc.Status{State: svc.StopPending}
+				close(s.stop)
+				<-s.exited
+				return false, 0
+			}
+		}
+	}
+}
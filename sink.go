@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Output is a destination for parsed records. Write is called with one
+// batch at a time; retries are up to the output.
+type Output interface {
+	Name() string
+	Write(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// recordSink receives parsed records one at a time. Flush blocks until
+// everything added so far has been written and returns the first error.
+type recordSink interface {
+	Add(Record) error
+	Flush() error
+	Close() error
+}
+
+// newOutputs builds the outputs listed in OUTPUT (comma separated).
+func newOutputs(config Config, indexer *BulkIndexer) ([]Output, error) {
+	var outputs []Output
+	for _, name := range splitList(config.Output) {
+		switch strings.ToLower(name) {
+		case "elasticsearch", "es":
+			outputs = append(outputs, indexer)
+		case "kafka":
+			p, err := newKafkaProducer(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, p)
+		case "influxdb", "influx":
+			o, err := newInfluxOutput(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, o)
+		case "clickhouse":
+			o, err := newClickHouseOutput(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, o)
+		case "postgres", "postgresql":
+			o, err := newPostgresOutput(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, o)
+		case "remote_write", "prometheus":
+			o, err := newRemoteWriteOutput(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, o)
+		case "otlp":
+			o, err := newOTLPMetricsOutput(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, o)
+		case "parquet":
+			o, err := newParquetOutput(config)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, o)
+		case "file":
+			outputs = append(outputs, newFileOutput(config))
+		default:
+			return nil, fmt.Errorf("unknown OUTPUT %q", name)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("OUTPUT is empty")
+	}
+	return outputs, nil
+}
+
+// tableColumns lists the columns of the SQL outputs' tables, sorted: the
+// schema's fields after MAPPING_FILE, plus the KPIs, with their
+// Elasticsearch type. @timestamp has a column of its own and isn't listed.
+func tableColumns(schema *Schema, mapping *fieldMapping, config Config) ([]string, map[string]string) {
+	props := schema.esMappings()["properties"].(map[string]interface{})
+	if config.KPIEnrich {
+		for _, f := range []string{"rtt_min", "rtt_mean", "rtt_max", "ul_jitter", "dl_jitter", "rtt_jitter", "ul_loss_pct", "dl_loss_pct", "loss_pct"} {
+			props[f] = map[string]string{"type": "double"}
+		}
+		props["available"] = map[string]string{"type": "long"}
+	}
+	if mapping != nil {
+		props = mapping.mapProperties(props)
+	}
+	delete(props, "@timestamp")
+	names := make([]string, 0, len(props))
+	types := make(map[string]string, len(props))
+	for name, v := range props {
+		names = append(names, name)
+		types[name] = "keyword"
+		if m, ok := v.(map[string]string); ok {
+			types[name] = m["type"]
+		}
+	}
+	sort.Strings(names)
+	return names, types
+}
+
+func hasOutput(outputs []Output, o Output) bool {
+	for _, out := range outputs {
+		if out == o {
+			return true
+		}
+	}
+	return false
+}
+
+type outputJob struct {
+	records []Record
+	flushed chan error
+}
+
+// bufferedOutput batches records for one Output and writes them from its
+// own goroutine. A full queue blocks Add, so a slow output applies
+// backpressure without affecting how the others batch and retry.
+type bufferedOutput struct {
+	out      Output
+	size     int
+	interval time.Duration
+	tuner    *batchTuner // overrides size when the output adapts it
+
+	mu    sync.Mutex
+	batch []Record
+	queue chan outputJob
+
+	errMu sync.Mutex
+	err   error // first error since the last flush, for Flush
+	// failures counts failed writes and lastErr is the latest, so a file
+	// can tell a write failed while it was processed even if another
+	// file's Flush took err
+	failures int64
+	lastErr  error
+
+	writeStart atomic.Int64 // 진행 중인 Write 의 시작 (UnixNano), 0 은 idle
+	recycle    bool         // 쓴 레코드를 putRecord 로 돌려준다
+
+	done    chan struct{}
+	reset   chan time.Duration
+	wg      sync.WaitGroup
+	flushWg sync.WaitGroup
+}
+
+func newBufferedOutput(out Output, config Config) *bufferedOutput {
+	b := &bufferedOutput{
+		out:      out,
+		size:     config.BulkSize,
+		interval: config.BulkFlushInterval,
+		queue:    make(chan outputJob, max(config.OutputQueueSize, 0)),
+		done:     make(chan struct{}),
+		reset:    make(chan time.Duration, 1),
+	}
+	if b.size <= 0 {
+		b.size = 1
+	}
+	if a, ok := out.(adaptiveOutput); ok {
+		b.tuner = a.tuner()
+	}
+	b.wg.Add(1)
+	go b.writeLoop()
+	if b.interval > 0 {
+		b.flushWg.Add(1)
+		go b.flushLoop(b.interval)
+	}
+	return b
+}
+
+func (b *bufferedOutput) writeLoop() {
+	defer b.wg.Done()
+	for job := range b.queue {
+		if len(job.records) > 0 {
+			trace := tracing.start(b.out.Name()+" write", nil)
+			trace.set("output.name", b.out.Name())
+			trace.set("output.records", len(job.records))
+			b.writeStart.Store(time.Now().UnixNano())
+			err := b.out.Write(contextWithSpan(context.Background(), trace), job.records)
+			b.writeStart.Store(0)
+			trace.finish(err)
+			if err != nil {
+				logOutput.Error("error writing records", "output", b.out.Name(), "records", len(job.records), "err", err)
+				b.errMu.Lock()
+				if b.err == nil {
+					b.err = err
+				}
+				b.lastErr = err
+				b.failures++
+				b.errMu.Unlock()
+			}
+			if b.recycle {
+				for _, rec := range job.records {
+					putRecord(rec)
+				}
+			}
+			putBatch(job.records)
+		}
+		if job.flushed != nil {
+			b.errMu.Lock()
+			job.flushed <- b.err
+			b.err = nil
+			b.errMu.Unlock()
+		}
+	}
+}
+
+func (b *bufferedOutput) flushLoop(interval time.Duration) {
+	defer b.flushWg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			b.sendLocked(nil)
+			b.mu.Unlock()
+		case d := <-b.reset:
+			ticker.Reset(d)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// sendLocked hands the current batch to the writer goroutine.
+func (b *bufferedOutput) sendLocked(flushed chan error) {
+	if len(b.batch) == 0 && flushed == nil {
+		return
+	}
+	b.queue <- outputJob{records: b.batch, flushed: flushed}
+	b.batch = nil
+}
+
+// setBatch changes the batch size and flush interval on the fly. A flush
+// interval can only be changed, not turned on, after start.
+func (b *bufferedOutput) setBatch(size int, interval time.Duration) {
+	b.mu.Lock()
+	b.size = max(size, 1)
+	b.mu.Unlock()
+	if b.interval > 0 && interval > 0 && interval != b.interval {
+		b.interval = interval
+		select {
+		case b.reset <- interval:
+		default:
+		}
+	}
+}
+
+func (b *bufferedOutput) Add(rec Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	size := b.size
+	if b.tuner != nil {
+		size = b.tuner.Size()
+	}
+	if b.batch == nil {
+		b.batch = getBatch(size)
+	}
+	b.batch = append(b.batch, rec)
+	if len(b.batch) >= size {
+		b.sendLocked(nil)
+	}
+	return nil
+}
+
+func (b *bufferedOutput) Flush() error {
+	flushed := make(chan error, 1)
+	b.mu.Lock()
+	b.sendLocked(flushed)
+	b.mu.Unlock()
+	return <-flushed
+}
+
+func (b *bufferedOutput) Close() error {
+	close(b.done)
+	b.flushWg.Wait()
+	err := b.Flush()
+	close(b.queue)
+	b.wg.Wait()
+	if cerr := b.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recordRecycler is an Output that is done with the records when Write
+// returns: it encodes them there and keeps none, so their maps can be
+// reused.
+type recordRecycler interface {
+	recyclesRecords()
+}
+
+// fanOut copies every record to each configured output.
+type fanOut struct {
+	outputs   []*bufferedOutput
+	closeOnce sync.Once
+}
+
+func newFanOut(outputs []Output, config Config) *fanOut {
+	f := &fanOut{}
+	for _, out := range outputs {
+		f.outputs = append(f.outputs, newBufferedOutput(out, config))
+	}
+	return f
+}
+
+// recycleRecords gives the records back to getRecord once they're written,
+// if there is one output and it is a recordRecycler. The caller makes sure
+// the records reach no other fanOut and no stage keeps them after
+// passing them on. It must be called before the first Add.
+func (f *fanOut) recycleRecords() bool {
+	if len(f.outputs) != 1 {
+		return false
+	}
+	if _, ok := f.outputs[0].out.(recordRecycler); !ok {
+		return false
+	}
+	f.outputs[0].recycle = true
+	return true
+}
+
+// writing reports, per output, how long its current write has been
+// running; idle outputs report 0.
+func (f *fanOut) writing() map[string]time.Duration {
+	out := make(map[string]time.Duration, len(f.outputs))
+	for _, o := range f.outputs {
+		var d time.Duration
+		if start := o.writeStart.Load(); start != 0 {
+			d = time.Since(time.Unix(0, start))
+		}
+		out[o.out.Name()] = max(out[o.out.Name()], d)
+	}
+	return out
+}
+
+// SetBatch applies new BULK_SIZE / BULK_FLUSH_INTERVAL values to every
+// output; batches already queued are written as they are.
+func (f *fanOut) SetBatch(size int, interval time.Duration) {
+	for _, o := range f.outputs {
+		o.setBatch(size, interval)
+	}
+}
+
+func (f *fanOut) Add(rec Record) error {
+	for _, o := range f.outputs {
+		if err := o.Add(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failures returns how many writes of the outputs failed so far, and the
+// latest error. A batch holds the records of every file being read, so a
+// failed write counts against all of them: a file compares the count
+// before and after it was processed and fails if it grew, even when the
+// failed batch held none of its records.
+func (f *fanOut) failures() (int64, error) {
+	var n int64
+	var last error
+	for _, o := range f.outputs {
+		o.errMu.Lock()
+		if o.failures > 0 {
+			n += o.failures
+			last = fmt.Errorf("%s: %w", o.out.Name(), o.lastErr)
+		}
+		o.errMu.Unlock()
+	}
+	return n, last
+}
+
+// outputFailures sums the failures of fanOuts.
+func outputFailures(fanOuts []*fanOut) (int64, error) {
+	var n int64
+	var last error
+	for _, f := range fanOuts {
+		k, err := f.failures()
+		if n += k; err != nil {
+			last = err
+		}
+	}
+	return n, last
+}
+
+func (f *fanOut) Flush() error {
+	var firstErr error
+	for _, o := range f.outputs {
+		if err := o.Flush(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", o.out.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+func (f *fanOut) Close() error {
+	var firstErr error
+	f.closeOnce.Do(func() {
+		for _, o := range f.outputs {
+			if err := o.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", o.out.Name(), err)
+			}
+		}
+	})
+	return firstErr
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// failingOutput fails the writes of batches holding a record with
+// "fail" set.
+type failingOutput struct {
+	mu      sync.Mutex
+	written int
+}
+
+func (o *failingOutput) Name() string { return "failing" }
+func (o *failingOutput) Close() error { return nil }
+
+func (o *failingOutput) Write(_ context.Context, records []Record) error {
+	for _, rec := range records {
+		if rec["fail"] == true {
+			return errors.New("write failed")
+		}
+	}
+	o.mu.Lock()
+	o.written += len(records)
+	o.mu.Unlock()
+	return nil
+}
+
+// TestOutputFailuresFailAll checks that a failed write fails every file
+// being processed at the time, including one whose records weren't in
+// the failed batch, and none that start afterwards.
+func TestOutputFailuresFailAll(t *testing.T) {
+	tests := []struct {
+		name string
+		// a 와 b 는 같이 처리되는 두 파일, b 의 레코드만 실패한다
+		a, b       []Record
+		wantFailed bool
+	}{
+		{"no failure", []Record{{"file": "a"}}, []Record{{"file": "b"}}, false},
+		{"other file's batch failed", []Record{{"file": "a"}}, []Record{{"file": "b", "fail": true}}, true},
+	}
+	for _, tt := range tests {
+		out := &failingOutput{}
+		f := newFanOut([]Output{out}, Config{BulkSize: 100})
+		fileOuts := []*fanOut{f}
+		start := map[string]int64{}
+		start["a"], _ = outputFailures(fileOuts)
+		start["b"], _ = outputFailures(fileOuts)
+		for _, rec := range tt.a {
+			f.Add(rec)
+		}
+		// b 의 Flush 가 배치를 보낸다: a 의 레코드도 같은 배치에 있다
+		for _, rec := range tt.b {
+			f.Add(rec)
+		}
+		f.Flush()
+		for file, before := range start {
+			n, err := outputFailures(fileOuts)
+			if failed := n > before; failed != tt.wantFailed {
+				t.Errorf("%s: file %s failed = %v (%v), want %v", tt.name, file, failed, err, tt.wantFailed)
+			}
+		}
+		// 실패 뒤에 시작한 파일은 그 실패를 보지 않는다
+		later, _ := outputFailures(fileOuts)
+		f.Add(Record{"file": "c"})
+		f.Flush()
+		if n, err := outputFailures(fileOuts); n > later {
+			t.Errorf("%s: file started after the failure failed: %v", tt.name, err)
+		}
+		f.Close()
+	}
+}
+
+// recyclingOutput is a failingOutput that is a recordRecycler.
+type recyclingOutput struct{ failingOutput }
+
+func (o *recyclingOutput) recyclesRecords() {}
+
+func TestFanOutRecycleRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs []Output
+		want    bool
+	}{
+		{"recycler", []Output{&recyclingOutput{}}, true},
+		{"keeps records", []Output{&failingOutput{}}, false},
+		{"several outputs", []Output{&recyclingOutput{}, &recyclingOutput{}}, false},
+	}
+	for _, tt := range tests {
+		f := newFanOut(tt.outputs, Config{BulkSize: 100})
+		if got := f.recycleRecords(); got != tt.want {
+			t.Errorf("%s: recycleRecords() = %v, want %v", tt.name, got, tt.want)
+		}
+		rec := Record{"session_id": int64(1)}
+		f.Add(rec)
+		f.Flush()
+		f.Close()
+		// 돌려준 레코드는 비워진다
+		if recycled := len(rec) == 0; recycled != tt.want {
+			t.Errorf("%s: record recycled = %v, want %v", tt.name, recycled, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskSpool holds bulk requests Elasticsearch couldn't take while it was
+// unreachable, one segment file per request in the bulk body format. While
+// anything is spooled, new requests are appended too (in order, at disk
+// speed) and drain sends the segments back oldest first. Segments survive
+// a restart.
+type diskSpool struct {
+	dir      string
+	maxBytes int64
+
+	bytes, requests *Gauge
+
+	mu       sync.Mutex
+	segments []string // oldest first
+	size     int64
+	seq      int
+}
+
+func openDiskSpool(dir string, maxBytes int, bytes, requests *Gauge) (*diskSpool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating spool directory: %w", err)
+	}
+	s := &diskSpool{dir: dir, maxBytes: int64(maxBytes), bytes: bytes, requests: requests}
+	// 쓰다 만 segment 는 버린다 (rename 전에 죽은 경우)
+	tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, path := range tmp {
+		os.Remove(path)
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, "spool-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	for _, path := range segments {
+		if info, err := os.Stat(path); err == nil {
+			s.segments = append(s.segments, path)
+			s.size += info.Size()
+		}
+	}
+	if len(s.segments) > 0 {
+		logES.Info("spooled requests from the last run", "dir", dir, "requests", len(s.segments), "bytes", s.size)
+	}
+	s.updateMetrics()
+	return s, nil
+}
+
+// active reports whether anything is waiting, i.e. new requests must queue
+// behind it.
+func (s *diskSpool) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) > 0
+}
+
+// count returns the number of waiting requests.
+func (s *diskSpool) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments)
+}
+
+// write stores items as a new segment. It returns false without writing
+// when the spool would grow past maxBytes.
+func (s *diskSpool) write(items []bulkItem) (bool, error) {
+	body := bulkBody(items)
+	defer putBulkBuffer(body)
+	buf := body.Bytes()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(buf)) > s.maxBytes {
+		return false, nil
+	}
+	name := fmt.Sprintf("spool-%d-%06d.ndjson", time.Now().UTC().UnixNano(), s.seq%1000000)
+	s.seq++
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path+".tmp", buf, 0o644); err != nil {
+		return false, fmt.Errorf("error writing spool: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return false, fmt.Errorf("error writing spool: %w", err)
+	}
+	s.segments = append(s.segments, path)
+	s.size += int64(len(buf))
+	s.updateMetrics()
+	return true, nil
+}
+
+// oldest returns the oldest segment and its items, or "" if the spool is
+// empty. An unreadable segment is returned with an error.
+func (s *diskSpool) oldest() (string, []bulkItem, error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return "", nil, nil
+	}
+	path := s.segments[0]
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return path, nil, err
+	}
+	var items []bulkItem
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for sc.Scan() {
+		meta := append(append([]byte(nil), sc.Bytes()...), '\n')
+		if !sc.Scan() {
+			return path, nil, fmt.Errorf("%s: truncated segment", path)
+		}
+		items = append(items, bulkItem{meta: meta, doc: append(append([]byte(nil), sc.Bytes()...), '\n')})
+	}
+	return path, items, sc.Err()
+}
+
+// remove drops a segment once its items were handed to Elasticsearch.
+func (s *diskSpool) remove(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info, err := os.Stat(path); err == nil {
+		s.size -= info.Size()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logES.Error("error removing spool segment", "file", path, "err", err)
+	}
+	for i, p := range s.segments {
+		if p == path {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	s.updateMetrics()
+}
+
+func (s *diskSpool) updateMetrics() {
+	if s.bytes == nil {
+		return
+	}
+	s.bytes.Set(float64(s.size))
+	s.requests.Set(float64(len(s.segments)))
+}
+
+// drainSpool sends the spooled requests back, oldest first, every
+// interval until ctx is done. A request that fails again because the
+// cluster is still unreachable stays in the spool; anything else (indexed,
+// rejected and dead-lettered) removes it.
+func (b *BulkIndexer) drainSpool(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sent := 0
+		for ctx.Err() == nil {
+			path, items, err := b.spool.oldest()
+			if path == "" {
+				break
+			}
+			if err != nil {
+				logES.Error("error reading spool segment, dropping it", "file", path, "err", err)
+				b.spool.remove(path)
+				continue
+			}
+			if err := b.insert(ctx, items, true); err != nil {
+				if ctx.Err() != nil {
+					// 종료 중: 다음 시작에 다시 보낸다
+					break
+				}
+				if _, ok := err.(retryableError); ok {
+					logES.Warn("Elasticsearch still unavailable, keeping spooled requests", "err", err)
+					break
+				}
+				logES.Error("error indexing spooled request", "file", filepath.Base(path), "err", err)
+			}
+			b.spool.remove(path)
+			sent++
+		}
+		if sent > 0 && !b.spool.active() {
+			logES.Info("spool drained, indexing directly again", "requests", sent)
+		}
+	}
+}
+
+// spoolItems queues items behind the spooled requests. It returns false if
+// the spool is full or can't be written, and they take the normal path.
+func (b *BulkIndexer) spoolItems(items []bulkItem) bool {
+	first := !b.spool.active()
+	ok, err := b.spool.write(items)
+	if err != nil {
+		logES.Error("error writing spool", "err", err)
+		return false
+	}
+	if !ok {
+		logES.Warn("spool full, retrying and dead-lettering as usual", "max_bytes", b.spool.maxBytes)
+		return false
+	}
+	if first {
+		logES.Warn("Elasticsearch unavailable, spooling bulk requests", "dir", b.spool.dir)
+	}
+	metricDocumentsSpooled.Add(float64(len(items)), b.Name())
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func spoolItems(docs ...string) []bulkItem {
+	var items []bulkItem
+	for _, doc := range docs {
+		items = append(items, bulkItem{meta: []byte(`{ "create" : { "_index" : "twamp-data" } }` + "\n"), doc: []byte(doc + "\n")})
+	}
+	return items
+}
+
+func TestDiskSpool(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int
+		writes   [][]bulkItem
+		stored   []bool
+		// 다시 연 뒤 oldest 가 순서대로 돌려줄 요청
+		want [][]bulkItem
+	}{
+		{"oldest first", 1 << 20, [][]bulkItem{spoolItems(`{"a":1}`, `{"a":2}`), spoolItems(`{"a":3}`)}, []bool{true, true},
+			[][]bulkItem{spoolItems(`{"a":1}`, `{"a":2}`), spoolItems(`{"a":3}`)}},
+		{"full", 100, [][]bulkItem{spoolItems(`{"a":1}`), spoolItems(`{"a":2}`)}, []bool{true, false},
+			[][]bulkItem{spoolItems(`{"a":1}`)}},
+		{"empty", 1 << 20, nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := openDiskSpool(dir, tt.maxBytes, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, items := range tt.writes {
+				ok, err := s.write(items)
+				if err != nil || ok != tt.stored[i] {
+					t.Fatalf("write %d = %v, %v; want %v", i, ok, err, tt.stored[i])
+				}
+			}
+			// 쓰다 만 segment 는 다음에 열 때 버린다
+			os.WriteFile(filepath.Join(dir, "spool-1-000001.ndjson.tmp"), []byte("{"), 0o644)
+			s, err = openDiskSpool(dir, tt.maxBytes, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.count() != len(tt.want) {
+				t.Fatalf("%d segments after reopening, want %d", s.count(), len(tt.want))
+			}
+			for i, want := range tt.want {
+				path, items, err := s.oldest()
+				if err != nil || !reflect.DeepEqual(items, want) {
+					t.Fatalf("oldest %d = %q, %v; want %q", i, items, err, want)
+				}
+				s.remove(path)
+			}
+			if path, _, _ := s.oldest(); path != "" || s.active() || s.size != 0 {
+				t.Errorf("spool not empty after removing everything: %s, size %d", path, s.size)
+			}
+			if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+				t.Errorf("temp segments left: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestDiskSpoolTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	// action 줄만 있고 문서 줄이 없다
+	os.WriteFile(filepath.Join(dir, "spool-1-000000.ndjson"), []byte(`{ "create" : {} }`+"\n"), 0o644)
+	s, err := openDiskSpool(dir, 1<<20, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, _, err := s.oldest(); path == "" || err == nil {
+		t.Errorf("oldest = %q, %v; want the segment and an error", path, err)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type fileStatus string
+
+const (
+	statusInProgress fileStatus = "in_progress"
+	statusDone       fileStatus = "done"
+	statusFailed     fileStatus = "failed"
+	statusDuplicate  fileStatus = "duplicate"
+)
+
+// ledgerEntry records one ingestion attempt of a source file.
+type ledgerEntry struct {
+	Path       string     `json:"path"`
+	Checksum   string     `json:"checksum"`
+	Size       int64      `json:"size"`
+	Status     fileStatus `json:"status"`
+	Rows       int        `json:"rows"`
+	Offset     int        `json:"offset,omitempty"` // 마지막 checkpoint 까지 읽은 행 (잘못된 행 포함)
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at,omitempty"`
+}
+
+// stateStore is the persistent ledger of ingested files, kept as a JSON
+// file. Files are deduplicated by content checksum, so a re-delivered file
+// under a new name is not ingested twice.
+type stateStore struct {
+	path string
+
+	mu    sync.Mutex
+	Files map[string]*ledgerEntry `json:"files"`
+	// 이번 실행에서 Begin 하고 아직 Finish 하지 않은 파일; 파일에 남은
+	// in_progress 는 중단된 시도라 중복 판정에 쓰지 않는다
+	active map[string]bool
+}
+
+func loadState(path string) (*stateStore, error) {
+	s := &stateStore{path: path, Files: make(map[string]*ledgerEntry), active: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]*ledgerEntry)
+	}
+	return s, nil
+}
+
+// IsProcessed reports whether path was ingested successfully or skipped as
+// a duplicate.
+func (s *stateStore) IsProcessed(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.Files[stateKey(path)]
+	return ok && (e.Status == statusDone || e.Status == statusDuplicate)
+}
+
+// Begin checksums path and marks it in progress. It returns false if the
+// same content has already been ingested, under this or another name, or
+// is being ingested under another name right now.
+func (s *stateStore) Begin(path string) (bool, error) {
+	sum, size, err := fileChecksum(path)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := stateKey(path)
+	entry := &ledgerEntry{
+		Path:      key,
+		Checksum:  sum,
+		Size:      size,
+		Status:    statusInProgress,
+		StartedAt: time.Now().UTC(),
+	}
+	// 같은 경로, 같은 내용이면 다시 기록하지 않는다 (janitor 가 압축한 파일 등)
+	if e, ok := s.Files[key]; ok && e.Checksum == sum && e.Status == statusDone {
+		return false, nil
+	}
+	// 중단된 파일은 마지막 checkpoint 부터 이어서 읽는다
+	if e, ok := s.Files[key]; ok && e.Checksum == sum && e.Offset > 0 {
+		entry.Offset, entry.Rows = e.Offset, e.Rows
+	}
+	for _, e := range s.Files {
+		ingesting := e.Status == statusInProgress && s.active[e.Path] && e.Path != key
+		if e.Checksum == sum && (e.Status == statusDone || ingesting) {
+			entry.Status = statusDuplicate
+			entry.Error = "same content as " + e.Path
+			if ingesting {
+				entry.Error += ", being ingested"
+			}
+			entry.FinishedAt = entry.StartedAt
+			s.Files[key] = entry
+			metricFilesProcessed.Inc(string(statusDuplicate))
+			return false, s.saveLocked()
+		}
+	}
+	s.Files[key] = entry
+	s.active[key] = true
+	return true, s.saveLocked()
+}
+
+// Finish records the outcome of an ingestion started with Begin.
+func (s *stateStore) Finish(path string, rows int, ingestErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, stateKey(path))
+	e, ok := s.Files[stateKey(path)]
+	if !ok {
+		return fmt.Errorf("no ledger entry for %s", path)
+	}
+	e.Rows = rows
+	e.FinishedAt = time.Now().UTC()
+	e.Status = statusDone
+	e.Error = ""
+	e.Offset = 0
+	if ingestErr != nil {
+		e.Status = statusFailed
+		e.Error = ingestErr.Error()
+	}
+	metricFilesProcessed.Inc(string(e.Status))
+	return s.saveLocked()
+}
+
+// Resume returns the checkpoint Begin carried over for path: rows read
+// (offset) and rows ingested up to it. Both are 0 for a fresh start.
+func (s *stateStore) Resume(path string) (offset, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.Files[stateKey(path)]; ok {
+		return e.Offset, e.Rows
+	}
+	return 0, 0
+}
+
+// Checkpoint records that the first offset rows of path (rows of them
+// ingested) have been written to the outputs.
+func (s *stateStore) Checkpoint(path string, offset, rows int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.Files[stateKey(path)]
+	if !ok {
+		return fmt.Errorf("no ledger entry for %s", path)
+	}
+	e.Offset, e.Rows = offset, rows
+	return s.saveLocked()
+}
+
+// Moved records that the ingested file at path now lives at newPath
+// (e.g. compressed by the janitor), with the content of contentPath, so the
+// new file isn't ingested again. Unknown paths are ignored.
+func (s *stateStore) Moved(path, newPath, contentPath string) error {
+	sum, size, err := fileChecksum(contentPath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.Files[stateKey(path)]
+	if !ok {
+		return nil
+	}
+	moved := *e
+	moved.Path, moved.Checksum, moved.Size = stateKey(newPath), sum, size
+	moved.Status = statusDone
+	s.Files[moved.Path] = &moved
+	return s.saveLocked()
+}
+
+// Forget drops path from the ledger so it's ingested again, from the
+// first row. Content ingested under another name still makes it a
+// duplicate.
+func (s *stateStore) Forget(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Files, stateKey(path))
+	return s.saveLocked()
+}
+
+// Entries returns a snapshot of the ledger sorted by start time, optionally
+// filtered by status.
+func (s *stateStore) Entries(status fileStatus) []ledgerEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []ledgerEntry
+	for _, e := range s.Files {
+		if status == "" || e.Status == status {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].StartedAt.Before(entries[j].StartedAt) })
+	return entries
+}
+
+// Save writes the ledger to disk.
+func (s *stateStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+// saveLocked writes the state to a temp file and renames it into place so
+// a crash never leaves a truncated state file behind.
+func (s *stateStore) saveLocked() error {
+	// dry run: ledger 는 메모리에만 둔다
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func stateKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// scanBacklog lists input files under dir that have not been ingested
+// successfully yet (new, failed or interrupted), oldest first.
+func scanBacklog(dir string, recursive bool, filter fileFilter, state *stateStore) ([]string, error) {
+	paths, err := listInputFiles(dir, dir, recursive, filter)
+	if err != nil {
+		return nil, err
+	}
+	type pending struct {
+		path    string
+		modTime time.Time
+	}
+	var files []pending
+	for _, path := range paths {
+		if state.IsProcessed(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, pending{path: path, modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	backlog := make([]string, len(files))
+	for i, f := range files {
+		backlog[i] = f.path
+	}
+	return backlog, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateDedup(t *testing.T) {
+	type step struct {
+		op, file string
+		want     bool       // begin 의 결과
+		status   fileStatus // 단계 뒤 file 의 상태, 비어 있으면 보지 않는다
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"new file", []step{{"begin", "a", true, statusInProgress}}},
+		{"same file again", []step{{"begin", "a", true, ""}, {"finish", "a", false, statusDone}, {"begin", "a", false, statusDone}}},
+		{"copy of an ingested file", []step{{"begin", "a", true, ""}, {"finish", "a", false, ""}, {"begin", "copy", false, statusDuplicate}}},
+		{"copy of a file being ingested", []step{{"begin", "a", true, ""}, {"begin", "copy", false, statusDuplicate}}},
+		{"interrupted last run isn't a duplicate", []step{{"begin", "a", true, ""}, {"reload", "", false, ""}, {"begin", "copy", true, statusInProgress}}},
+		{"copy after the original failed", []step{{"begin", "a", true, ""}, {"fail", "a", false, statusFailed}, {"begin", "copy", true, statusInProgress}}},
+		{"failed file is retried", []step{{"begin", "a", true, ""}, {"fail", "a", false, ""}, {"begin", "a", true, statusInProgress}}},
+		{"other content", []step{{"begin", "a", true, ""}, {"finish", "a", false, ""}, {"begin", "other", true, statusInProgress}}},
+		{"forgotten file", []step{{"begin", "a", true, ""}, {"finish", "a", false, ""}, {"forget", "a", false, ""}, {"begin", "a", true, statusInProgress}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"a": "x,y\n1,2\n", "copy": "x,y\n1,2\n", "other": "x,y\n3,4\n"}
+			for name, data := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			statePath := filepath.Join(dir, "state.json")
+			s, err := loadState(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, st := range tt.steps {
+				path := filepath.Join(dir, st.file)
+				switch st.op {
+				case "begin":
+					ok, err := s.Begin(path)
+					if err != nil || ok != st.want {
+						t.Fatalf("step %d: Begin(%s) = %v, %v; want %v", i, st.file, ok, err, st.want)
+					}
+				case "finish":
+					err = s.Finish(path, 2, nil)
+				case "fail":
+					err = s.Finish(path, 0, errors.New("output down"))
+				case "forget":
+					err = s.Forget(path)
+				case "reload":
+					s, err = loadState(statePath)
+				}
+				if err != nil {
+					t.Fatalf("step %d: %s %s: %v", i, st.op, st.file, err)
+				}
+				if st.status == "" {
+					continue
+				}
+				if e := s.Files[stateKey(path)]; e == nil || e.Status != st.status {
+					t.Errorf("step %d: %s is %+v, want %s", i, st.file, e, st.status)
+				}
+			}
+		})
+	}
+}
+
+func TestStateResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	os.WriteFile(path, []byte("x\n1\n2\n3\n"), 0o644)
+	statePath := filepath.Join(dir, "state.json")
+	s, _ := loadState(statePath)
+	if ok, err := s.Begin(path); !ok || err != nil {
+		t.Fatalf("Begin = %v, %v", ok, err)
+	}
+	if err := s.Checkpoint(path, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	// 중단 뒤 다시 시작: 같은 내용이면 checkpoint 부터
+	s, _ = loadState(statePath)
+	s.Begin(path)
+	if offset, rows := s.Resume(path); offset != 3 || rows != 2 {
+		t.Errorf("Resume = %d, %d; want 3, 2", offset, rows)
+	}
+	// 내용이 바뀌었으면 처음부터
+	os.WriteFile(path, []byte("x\n9\n"), 0o644)
+	s, _ = loadState(statePath)
+	s.Begin(path)
+	if offset, rows := s.Resume(path); offset != 0 || rows != 0 {
+		t.Errorf("Resume after the content changed = %d, %d; want 0, 0", offset, rows)
+	}
+}
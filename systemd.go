@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state ("READY=1", "STOPPING=1", "STATUS=...") to
+// systemd for Type=notify units. Without NOTIFY_SOCKET it does nothing.
+// watch is ready once the backlog is queued and the directories are
+// watched, so a large backlog may need a longer TimeoutStartSec:
+//
+//	[Service]
+//	Type=notify
+//	ExecStart=/usr/local/bin/twamp --config /etc/twamp/config.yaml watch
+//	WatchdogSec=60
+//	Restart=on-failure
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// '@' 는 abstract namespace 소켓
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		logIngest.Debug("error notifying systemd", "state", state, "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logIngest.Debug("error notifying systemd", "state", state, "err", err)
+	}
+}
+
+// watchdogInterval is WatchdogSec= as systemd passes it (WATCHDOG_USEC),
+// 0 when the watchdog is off or meant for another process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog pings the systemd watchdog at half its interval while the
+// liveness checks pass. When one fails (the watcher loop stopped or hangs,
+// an output write stalls past OUTPUT_STALL_TIMEOUT) the pings stop and
+// systemd restarts the service after WatchdogSec.
+func runWatchdog(ctx context.Context, health *healthChecker) {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	logIngest.Info("systemd watchdog enabled", "interval", interval.String())
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var failed []string
+		for name, c := range health.liveness() {
+			if !c.OK {
+				failed = append(failed, name+": "+c.Detail)
+			}
+		}
+		if len(failed) > 0 {
+			if !failing {
+				logIngest.Error("self-check failed, withholding systemd watchdog pings", "checks", strings.Join(failed, "; "))
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			logIngest.Info("self-check passing again, resuming systemd watchdog pings")
+		}
+		failing = false
+		sdNotify("WATCHDOG=1")
+	}
+}
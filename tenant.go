@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// tenantConfig is one entry of the config file's tenants list: the
+// records of one customer, told apart by a column (field, one of values;
+// * and ? match like in file patterns) or by the directory their files are
+// in (path), and where they go. A tenant with an index, its own
+// credentials or its own cluster (server) gets an Elasticsearch output of
+// its own; unset ones fall back to ES_INDEX, ES_USER/ES_PASSWORD/ES_API_KEY
+// and ES_SERVER.
+//
+//	tenants:
+//	  - name: acme
+//	    field: customer
+//	    values: [ACME, "acme-*"]
+//	    index: twamp-acme-%{+yyyy.MM.dd}
+//	  - name: globex
+//	    path: /data/twamp/globex
+//	    server: https://es.globex.example:9200
+//	    api_key: <base64 id:key>
+type tenantConfig struct {
+	Name     string   `json:"name"`
+	Field    string   `json:"field"`
+	Values   []string `json:"values"`
+	Path     string   `json:"path"`
+	Index    string   `json:"index"`
+	Server   string   `json:"server"`
+	User     string   `json:"user"`
+	Password string   `json:"password"`
+	APIKey   string   `json:"api_key"`
+}
+
+// tenantField is added to every record of a tenant with its name; the
+// router and the default indexer go by it.
+const tenantField = "tenant"
+
+type tenant struct {
+	tenantConfig
+	root string // absolute Path
+
+	// 자기 output 이 있는 tenant 만
+	indexer *BulkIndexer
+	out     *fanOut
+	added   atomic.Uint64 // records handed to out
+	flushed atomic.Uint64 // added as of the last flush
+
+	flushMu  sync.Mutex
+	flushErr error // 마지막 flush 의 결과
+}
+
+type tenants []*tenant
+
+// routed reports whether the tenant's records leave the default
+// Elasticsearch output for one of their own.
+func (t *tenant) routed() bool {
+	return t.Index != "" || t.Server != "" || t.User != "" || t.APIKey != ""
+}
+
+// ownCredentials reports whether the tenant signs in with credentials of
+// its own.
+func (t *tenant) ownCredentials() bool { return t.User != "" || t.APIKey != "" }
+
+// flush flushes the tenant's output if records were added since the last
+// flush. A Flush that comes in while another one is writing its records
+// waits for it and returns its result.
+func (t *tenant) flush() error {
+	n := t.added.Load()
+	if n <= t.flushed.Load() {
+		return nil
+	}
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+	if n <= t.flushed.Load() {
+		return t.flushErr
+	}
+	to := t.added.Load()
+	err := t.out.Flush()
+	t.flushErr = err
+	t.flushed.Store(to)
+	return err
+}
+
+func newTenants(config Config) (tenants, error) {
+	var ts tenants
+	names := map[string]bool{}
+	esOutput := false
+	for _, name := range splitList(config.Output) {
+		esOutput = esOutput || strings.EqualFold(name, "elasticsearch") || strings.EqualFold(name, "es")
+	}
+	for i, c := range config.Tenants {
+		if c.Name == "" || strings.Trim(c.Name, "abcdefghijklmnopqrstuvwxyz0123456789_-") != "" {
+			return nil, fmt.Errorf("tenants[%d]: name %q must be lowercase letters, digits, _ and -", i, c.Name)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("tenants: duplicate name %q", c.Name)
+		}
+		names[c.Name] = true
+		if (c.Field == "") == (c.Path == "") {
+			return nil, fmt.Errorf("tenant %s: one of field and path is required", c.Name)
+		}
+		if c.Field != "" && len(c.Values) == 0 {
+			return nil, fmt.Errorf("tenant %s: field needs values", c.Name)
+		}
+		for _, v := range c.Values {
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, fmt.Errorf("tenant %s: value %q: %w", c.Name, v, err)
+			}
+		}
+		if (c.User != "" || c.Password != "") && c.APIKey != "" {
+			return nil, fmt.Errorf("tenant %s: use only one of user/password and api_key", c.Name)
+		}
+		t := &tenant{tenantConfig: c}
+		if t.routed() && !esOutput && !config.DryRun {
+			return nil, fmt.Errorf("tenant %s: index, server and credentials need the elasticsearch output", c.Name)
+		}
+		if c.Path != "" {
+			root, err := filepath.Abs(c.Path)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", c.Name, err)
+			}
+			t.root = root
+		}
+		if c.Index != "" {
+			if _, err := parseIndexTemplate(c.Index); err != nil {
+				return nil, fmt.Errorf("tenant %s: index: %w", c.Name, err)
+			}
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// tag is the stage that names the tenant of records matched by field.
+// Records already tagged (by path) are left alone.
+func (ts tenants) tag(rec Record) Record {
+	if _, ok := rec[tenantField]; ok {
+		return rec
+	}
+	for _, t := range ts {
+		if t.Field == "" {
+			continue
+		}
+		v, ok := recordField(rec, t.Field)
+		if !ok {
+			continue
+		}
+		s := fmt.Sprint(v)
+		for _, pattern := range t.Values {
+			if ok, _ := path.Match(pattern, s); ok {
+				rec[tenantField] = t.Name
+				return rec
+			}
+		}
+	}
+	return rec
+}
+
+// fileSink returns sink with the records of a file under a tenant's path
+// tagged with it, the deepest one if they're nested.
+func (ts tenants) fileSink(sink recordSink, file string) recordSink {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return sink
+	}
+	var best *tenant
+	for _, t := range ts {
+		if t.root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(t.root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if best == nil || len(t.root) > len(best.root) {
+			best = t
+		}
+	}
+	if best == nil {
+		return sink
+	}
+	name := best.Name
+	return withStage(sink, func(rec Record) Record {
+		rec[tenantField] = name
+		return rec
+	})
+}
+
+func (ts tenants) byName(rec Record) *tenant {
+	v, ok := recordField(rec, tenantField)
+	if !ok {
+		return nil
+	}
+	for _, t := range ts {
+		if t.Name == v {
+			return t
+		}
+	}
+	return nil
+}
+
+// routedRecord reports whether rec goes to its tenant's own output, and
+// so not to the default indexer.
+func (ts tenants) routedRecord(rec Record) bool {
+	t := ts.byName(rec)
+	return t != nil && t.out != nil
+}
+
+// tenantRouter hands the records of tenants with an output of their own
+// to it, next to next (the other outputs, whose Elasticsearch indexer
+// skips them). Every tenant output has its own queue, bulk workers,
+// retries and spool (SPOOL_DIR/tenant-<name>), so a tenant whose cluster
+// is down or rejects its credentials only holds up and fails the files
+// that carry its records (and those flushed together with them). Rollups, alerts and the other derived documents
+// stay in their shared indexes, with the tenant field.
+type tenantRouter struct {
+	next    recordSink
+	tenants tenants
+}
+
+func newTenantRouter(next recordSink, ts tenants) *tenantRouter {
+	return &tenantRouter{next: next, tenants: ts}
+}
+
+func (r *tenantRouter) Add(rec Record) error {
+	if t := r.tenants.byName(rec); t != nil && t.out != nil {
+		metricTenantRecords.Inc(t.Name)
+		if err := t.out.Add(rec); err != nil {
+			return err
+		}
+		t.added.Add(1)
+	}
+	return r.next.Add(rec)
+}
+
+// Flush waits for the tenants that got records since the last Flush only.
+func (r *tenantRouter) Flush() error {
+	firstErr := r.next.Flush()
+	for _, t := range r.tenants {
+		if t.out == nil {
+			continue
+		}
+		if err := t.flush(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+	return firstErr
+}
+
+func (r *tenantRouter) Close() error {
+	var firstErr error
+	for _, t := range r.tenants {
+		if t.out == nil {
+			continue
+		}
+		if err := t.out.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+	if err := r.next.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		tenants []tenantConfig
+		err     string
+	}{
+		{"field and path", "elasticsearch", []tenantConfig{{Name: "acme", Field: "customer", Values: []string{"ACME"}}, {Name: "globex", Path: "/data/globex", Index: "twamp-globex"}}, ""},
+		{"bad name", "elasticsearch", []tenantConfig{{Name: "Acme", Field: "customer", Values: []string{"x"}}}, "must be lowercase"},
+		{"duplicate", "elasticsearch", []tenantConfig{{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}}, "duplicate name"},
+		{"neither field nor path", "elasticsearch", []tenantConfig{{Name: "a"}}, "one of field and path"},
+		{"both field and path", "elasticsearch", []tenantConfig{{Name: "a", Field: "f", Values: []string{"x"}, Path: "/a"}}, "one of field and path"},
+		{"field without values", "elasticsearch", []tenantConfig{{Name: "a", Field: "f"}}, "field needs values"},
+		{"bad pattern", "elasticsearch", []tenantConfig{{Name: "a", Field: "f", Values: []string{"[x"}}}, "value"},
+		{"two credentials", "elasticsearch", []tenantConfig{{Name: "a", Path: "/a", User: "u", Password: "p", APIKey: "k"}}, "only one of"},
+		{"own index without elasticsearch", "kafka", []tenantConfig{{Name: "a", Path: "/a", Index: "twamp-a"}}, "need the elasticsearch output"},
+		{"tag only without elasticsearch", "kafka", []tenantConfig{{Name: "a", Path: "/a"}}, ""},
+	}
+	for _, tt := range tests {
+		_, err := newTenants(Config{Output: tt.output, Tenants: tt.tenants})
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTenantTag(t *testing.T) {
+	ts, err := newTenants(Config{Output: "elasticsearch", Tenants: []tenantConfig{
+		{Name: "acme", Field: "customer", Values: []string{"ACME", "acme-*"}},
+		{Name: "globex", Field: "session_name", Values: []string{"gx?"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rec  Record
+		want interface{}
+	}{
+		{Record{"customer": "ACME"}, "acme"},
+		{Record{"customer": "acme-east"}, "acme"},
+		{Record{"customer": "Acme"}, nil},
+		{Record{"session_name": "gx1"}, "globex"},
+		{Record{"session_name": "gx10"}, nil},
+		// 경로로 이미 정해진 tenant 는 그대로
+		{Record{"customer": "ACME", tenantField: "globex"}, "globex"},
+		{Record{}, nil},
+	}
+	for _, tt := range tests {
+		in := Record{}
+		for k, v := range tt.rec {
+			in[k] = v
+		}
+		if got := ts.tag(tt.rec)[tenantField]; got != tt.want {
+			t.Errorf("tag(%v) tenant = %v, want %v", in, got, tt.want)
+		}
+	}
+}
+
+func TestTenantFileSink(t *testing.T) {
+	dir := t.TempDir()
+	ts, err := newTenants(Config{Output: "elasticsearch", Tenants: []tenantConfig{
+		{Name: "outer", Path: dir},
+		{Name: "inner", Path: filepath.Join(dir, "inner")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file string
+		want interface{}
+	}{
+		{filepath.Join(dir, "a.csv"), "outer"},
+		{filepath.Join(dir, "inner", "a.csv"), "inner"},
+		{filepath.Join(dir, "inner2", "a.csv"), "outer"},
+		{filepath.Join(os.TempDir(), "elsewhere.csv"), nil},
+	}
+	for _, tt := range tests {
+		next := &collectSink{}
+		ts.fileSink(next, tt.file).Add(Record{"a": 1})
+		if got := next.records[0][tenantField]; got != tt.want {
+			t.Errorf("%s: tenant = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestTenantRouter(t *testing.T) {
+	ts, err := newTenants(Config{Output: "elasticsearch", Tenants: []tenantConfig{
+		{Name: "acme", Field: "customer", Values: []string{"ACME"}, Index: "twamp-acme"},
+		{Name: "globex", Field: "customer", Values: []string{"GLOBEX"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acmeOut := &failingOutput{}
+	ts[0].out = newFanOut([]Output{acmeOut}, Config{BulkSize: 100})
+	next := &collectSink{}
+	router := newTenantRouter(next, ts)
+	tests := []struct {
+		rec    Record
+		routed bool
+	}{
+		{ts.tag(Record{"customer": "ACME"}), true},
+		// 자기 output 이 없는 tenant 는 기본 indexer 로
+		{ts.tag(Record{"customer": "GLOBEX"}), false},
+		{ts.tag(Record{"customer": "other"}), false},
+	}
+	for _, tt := range tests {
+		if err := router.Add(tt.rec); err != nil {
+			t.Fatal(err)
+		}
+		if got := ts.routedRecord(tt.rec); got != tt.routed {
+			t.Errorf("routedRecord(%v) = %v, want %v", tt.rec, got, tt.routed)
+		}
+	}
+	if err := router.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// 다음 단계는 모든 레코드를 받고 (indexer 가 routed 를 건너뛴다) tenant output 은 자기 것만
+	if len(next.records) != 3 || acmeOut.written != 1 {
+		t.Errorf("next got %d records, acme's output %d; want 3 and 1", len(next.records), acmeOut.written)
+	}
+	// tenant output 의 실패는 그 이름과 함께 돌아온다
+	router.Add(ts.tag(Record{"customer": "ACME", "fail": true}))
+	if err := router.Flush(); err == nil || !strings.Contains(err.Error(), "tenant acme") {
+		t.Errorf("Flush() = %v, want the acme output's error", err)
+	}
+	// 새 레코드가 없는 tenant 는 다시 flush 하지 않는다
+	if err := router.Flush(); err != nil {
+		t.Errorf("Flush() with nothing added = %v", err)
+	}
+	router.Close()
+}
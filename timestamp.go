@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampParser turns vendor timestamp columns into UTC RFC 3339.
+// Layouts are tried in order; besides Go time layouts it knows epoch_ms
+// (13 digits, 2001 to 2286), epoch_s and rfc3339. Layouts without a zone
+// are read in loc.
+type timestampParser struct {
+	columns []string
+	layouts []string
+	loc     *time.Location
+	flag    bool // 파싱 실패 시 행을 버리지 않고 수집 시각 + 표시 필드로 남긴다
+}
+
+// Go 는 초 뒤의 소수점을 layout 에 없어도 읽는다
+const defaultTimestampLayouts = "20060102150405|epoch_ms|rfc3339|2006-01-02 15:04:05|2006/01/02 15:04:05"
+
+func newTimestampParser(config Config) (*timestampParser, error) {
+	p := &timestampParser{columns: splitList(config.TimestampColumns)}
+	for _, l := range strings.Split(config.TimestampLayouts, "|") {
+		if l = strings.TrimSpace(l); l != "" {
+			p.layouts = append(p.layouts, l)
+		}
+	}
+	if len(p.layouts) == 0 {
+		return nil, fmt.Errorf("TIMESTAMP_LAYOUTS is empty")
+	}
+	loc, err := time.LoadLocation(config.TimestampTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIMESTAMP_TIMEZONE %q: %w", config.TimestampTimezone, err)
+	}
+	p.loc = loc
+	switch config.TimestampOnError {
+	case "reject":
+	case "flag":
+		p.flag = true
+	default:
+		return nil, fmt.Errorf("invalid TIMESTAMP_ON_ERROR %q (reject or flag)", config.TimestampOnError)
+	}
+	return p, nil
+}
+
+func (p *timestampParser) Parse(raw string) (string, error) {
+	for _, layout := range p.layouts {
+		if t, ok := p.parseLayout(layout, raw); ok {
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", raw)
+}
+
+func (p *timestampParser) parseLayout(layout, raw string) (time.Time, bool) {
+	switch layout {
+	case "epoch_ms":
+		// 14자리 20240105103000 같은 값을 2611년으로 읽지 않게
+		if len(raw) != 13 {
+			return time.Time{}, false
+		}
+		ms, err := strconv.ParseInt(raw, 10, 64)
+		return time.UnixMilli(ms), err == nil
+	case "epoch_s":
+		s, err := strconv.ParseFloat(raw, 64)
+		return time.UnixMilli(int64(s * 1000)), err == nil
+	case "rfc3339":
+		layout = time.RFC3339Nano
+	}
+	t, err := time.ParseInLocation(layout, raw, p.loc)
+	return t, err == nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestTimestampParser(t *testing.T) {
+	tests := []struct {
+		layouts, raw, want string
+	}{
+		// 기본 layout 순서
+		{defaultTimestampLayouts, "20240105103000", "2024-01-05T01:30:00Z"},
+		{defaultTimestampLayouts, "1704418200000", "2024-01-05T01:30:00Z"},
+		{defaultTimestampLayouts, "2024-01-05T10:30:00+09:00", "2024-01-05T01:30:00Z"},
+		{defaultTimestampLayouts, "2024-01-05 10:30:00", "2024-01-05T01:30:00Z"},
+		{defaultTimestampLayouts, "2024-01-05 10:30:00.250", "2024-01-05T01:30:00.25Z"},
+		{defaultTimestampLayouts, "2024/01/05 10:30:00", "2024-01-05T01:30:00Z"},
+		{defaultTimestampLayouts, "170441820000", ""},
+		{defaultTimestampLayouts, "not a date", ""},
+		// layout 하나씩
+		{"epoch_ms", "1704418200000", "2024-01-05T01:30:00Z"},
+		{"epoch_ms", "20240105103000", ""},
+		{"epoch_s", "1704418200", "2024-01-05T01:30:00Z"},
+		{"epoch_s", "1704418200.5", "2024-01-05T01:30:00.5Z"},
+		{"rfc3339", "2024-01-05T01:30:00.123Z", "2024-01-05T01:30:00.123Z"},
+		{"rfc3339", "2024-01-05 01:30:00", ""},
+		{"02/01/2006 15:04", "05/01/2024 10:30", "2024-01-05T01:30:00Z"},
+	}
+	for _, tt := range tests {
+		p, err := newTimestampParser(Config{TimestampLayouts: tt.layouts, TimestampTimezone: "Asia/Seoul", TimestampOnError: "reject"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.Parse(tt.raw)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: Parse(%q) = %s, want an error", tt.layouts, tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Parse(%q) = %q, %v; want %q", tt.layouts, tt.raw, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tracing is set up in main when OTEL_TRACES_EXPORTER=otlp; nil means
+// disabled, and every method below is then a no-op.
+//
+// Each file is one trace: a "file" span from detection to the ledger
+// update, with "queue" (waiting for a worker), "parse" (reading, converting
+// and passing rows through the stages) and "flush" (waiting for the
+// outputs) below it. Output batches mix rows from several files, so every
+// output write ("elasticsearch write", ...) is a trace of its own carrying
+// the bulk response counts.
+var tracing *tracer
+
+const (
+	tracesFlushInterval = 5 * time.Second
+	tracesMaxQueued     = 4096
+)
+
+type tracer struct {
+	exporter *otlpExporter
+	detected sync.Map // path -> time.Time
+
+	mu      sync.Mutex
+	pending []*span
+	dropped int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTracer(config Config) (*tracer, error) {
+	exporter, err := newOTLPExporter(config)
+	if err != nil {
+		return nil, err
+	}
+	t := &tracer{exporter: exporter, done: make(chan struct{})}
+	t.wg.Add(1)
+	go t.loop()
+	logIngest.Info("exporting traces", "endpoint", exporter.endpoint)
+	return t, nil
+}
+
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      error
+}
+
+// start begins a span below parent, or a new trace when parent is nil.
+func (t *tracer) start(name string, parent *span) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now(), attrs: map[string]interface{}{}}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// detect notes when a file was found, the start of its trace.
+func (t *tracer) detect(path string) {
+	if t == nil {
+		return
+	}
+	t.detected.LoadOrStore(path, time.Now())
+}
+
+// file starts the trace of a file a worker picked up; the time it waited
+// since detect is its "queue" span.
+func (t *tracer) file(path string) *span {
+	s := t.start("file", nil)
+	if s == nil {
+		return nil
+	}
+	s.set("file.path", path)
+	if v, ok := t.detected.LoadAndDelete(path); ok {
+		s.start = v.(time.Time)
+		q := t.start("queue", s)
+		q.start = s.start
+		q.end = time.Now()
+		t.queue(q)
+	}
+	return s
+}
+
+func (s *span) set(key string, v interface{}) {
+	if s != nil {
+		s.attrs[key] = v
+	}
+}
+
+// add increments an integer attribute.
+func (s *span) add(key string, n int) {
+	if s != nil {
+		v, _ := s.attrs[key].(int)
+		s.attrs[key] = v + n
+	}
+}
+
+// finish ends the span, recording err as its status.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	s.tracer.queue(s)
+}
+
+func (t *tracer) queue(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= tracesMaxQueued {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+type spanKey struct{}
+
+func contextWithSpan(ctx context.Context, s *span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func (t *tracer) loop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(tracesFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.export()
+		case <-t.done:
+			t.export()
+			return
+		}
+	}
+}
+
+// export sends the finished spans; a failed export drops them, tracing
+// never holds up ingestion.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		logIngest.Warn("trace queue full, spans dropped", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	out := make([]interface{}, 0, len(spans))
+	for _, s := range spans {
+		m := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1, // INTERNAL
+			"startTimeUnixNano": otlpTime(s.start),
+			"endTimeUnixNano":   otlpTime(s.end),
+			"attributes":        spanAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			m["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			m["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()} // ERROR
+		}
+		out = append(out, m)
+	}
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   t.exporter.resource,
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "twamp"}, "spans": out}},
+		}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := t.exporter.post(ctx, "traces", req); err != nil {
+		logIngest.Warn("error exporting traces", "spans", len(spans), "err", err)
+	}
+}
+
+// spanAttributes is otlpAttributes keeping numbers as numbers.
+func spanAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	strs := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		strs[k] = fmt.Sprint(v)
+	}
+	out := otlpAttributes(strs)
+	for _, kv := range out {
+		switch v := attrs[kv["key"].(string)].(type) {
+		case int:
+			kv["value"] = map[string]string{"intValue": strconv.Itoa(v)}
+		case float64:
+			kv["value"] = map[string]float64{"doubleValue": v}
+		case bool:
+			kv["value"] = map[string]bool{"boolValue": v}
+		}
+	}
+	return out
+}
+
+// Close exports what's left.
+func (t *tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	t.wg.Wait()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TWAMP-Control / TWAMP-Test wire format (RFC 5357, RFC 4656).
+const (
+	twampControlPort = 862
+	twampTestPort    = 862 // RFC 8545
+
+	twampModeUnauthenticated = 1
+
+	twampCmdStartSessions  = 2
+	twampCmdStopSessions   = 3
+	twampCmdRequestSession = 5
+
+	twampGreetingSize       = 64
+	twampSetupResponseSize  = 164
+	twampServerStartSize    = 48
+	twampRequestSessionSize = 112
+	twampAcceptSessionSize  = 48
+	twampStartSessionsSize  = 32
+	twampStartAckSize       = 32
+	twampStopSessionsSize   = 32
+
+	// unauthenticated test packets
+	twampSenderHeaderSize    = 14
+	twampReflectorHeaderSize = 41
+)
+
+// seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = uint64(2208988800)
+
+// twampTimestamp is the 64-bit NTP-style timestamp from RFC 4656 §4.1.2.
+type twampTimestamp struct {
+	Seconds  uint32
+	Fraction uint32
+}
+
+func newTimestamp(t time.Time) twampTimestamp {
+	ns := uint64(t.UnixNano())
+	return twampTimestamp{
+		Seconds:  uint32(ns/1e9 + ntpEpochOffset),
+		Fraction: uint32((ns % 1e9) << 32 / 1e9),
+	}
+}
+
+func readTimestamp(b []byte) twampTimestamp {
+	return twampTimestamp{
+		Seconds:  binary.BigEndian.Uint32(b[0:4]),
+		Fraction: binary.BigEndian.Uint32(b[4:8]),
+	}
+}
+
+func (ts twampTimestamp) put(b []byte) {
+	binary.BigEndian.PutUint32(b[0:4], ts.Seconds)
+	binary.BigEndian.PutUint32(b[4:8], ts.Fraction)
+}
+
+// durationTimestamp encodes a relative duration (e.g. the session Timeout).
+func durationTimestamp(d time.Duration) twampTimestamp {
+	ns := uint64(d)
+	return twampTimestamp{
+		Seconds:  uint32(ns / 1e9),
+		Fraction: uint32((ns % 1e9) << 32 / 1e9),
+	}
+}
+
+func (ts twampTimestamp) Time() time.Time {
+	secs := int64(uint64(ts.Seconds) - ntpEpochOffset)
+	nanos := int64(uint64(ts.Fraction) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
+
+// Error Estimate field: S bit (synchronized), Z bit, 6-bit scale, 8-bit multiplier.
+const twampErrorEstimateSynced = 0x8000
+
+func errorEstimate(synced bool) uint16 {
+	// scale 0 / multiplier 1: "unknown but small" as most implementations send
+	e := uint16(0x0001)
+	if synced {
+		e |= twampErrorEstimateSynced
+	}
+	return e
+}
+
+// twampSenderPacket is an unauthenticated Session-Sender test packet.
+type twampSenderPacket struct {
+	Sequence      uint32
+	Timestamp     twampTimestamp
+	ErrorEstimate uint16
+}
+
+func (p twampSenderPacket) marshal(padding int) []byte {
+	b := make([]byte, twampSenderHeaderSize+padding)
+	binary.BigEndian.PutUint32(b[0:4], p.Sequence)
+	p.Timestamp.put(b[4:12])
+	binary.BigEndian.PutUint16(b[12:14], p.ErrorEstimate)
+	return b
+}
+
+func parseSenderPacket(b []byte) (twampSenderPacket, error) {
+	if len(b) < twampSenderHeaderSize {
+		return twampSenderPacket{}, fmt.Errorf("short TWAMP-Test packet: %d bytes", len(b))
+	}
+	return twampSenderPacket{
+		Sequence:      binary.BigEndian.Uint32(b[0:4]),
+		Timestamp:     readTimestamp(b[4:12]),
+		ErrorEstimate: binary.BigEndian.Uint16(b[12:14]),
+	}, nil
+}
+
+// twampReflectorPacket is an unauthenticated Session-Reflector test packet.
+type twampReflectorPacket struct {
+	Sequence            uint32
+	Timestamp           twampTimestamp
+	ErrorEstimate       uint16
+	ReceiveTimestamp    twampTimestamp
+	SenderSequence      uint32
+	SenderTimestamp     twampTimestamp
+	SenderErrorEstimate uint16
+	SenderTTL           uint8
+}
+
+func (p twampReflectorPacket) marshal(padding int) []byte {
+	b := make([]byte, twampReflectorHeaderSize+padding)
+	binary.BigEndian.PutUint32(b[0:4], p.Sequence)
+	p.Timestamp.put(b[4:12])
+	binary.BigEndian.PutUint16(b[12:14], p.ErrorEstimate)
+	p.ReceiveTimestamp.put(b[16:24])
+	binary.BigEndian.PutUint32(b[24:28], p.SenderSequence)
+	p.SenderTimestamp.put(b[28:36])
+	binary.BigEndian.PutUint16(b[36:38], p.SenderErrorEstimate)
+	b[40] = p.SenderTTL
+	return b
+}
+
+func parseReflectorPacket(b []byte) (twampReflectorPacket, error) {
+	if len(b) < twampReflectorHeaderSize {
+		return twampReflectorPacket{}, fmt.Errorf("short TWAMP-Test reply: %d bytes", len(b))
+	}
+	return twampReflectorPacket{
+		Sequence:            binary.BigEndian.Uint32(b[0:4]),
+		Timestamp:           readTimestamp(b[4:12]),
+		ErrorEstimate:       binary.BigEndian.Uint16(b[12:14]),
+		ReceiveTimestamp:    readTimestamp(b[16:24]),
+		SenderSequence:      binary.BigEndian.Uint32(b[24:28]),
+		SenderTimestamp:     readTimestamp(b[28:36]),
+		SenderErrorEstimate: binary.BigEndian.Uint16(b[36:38]),
+		SenderTTL:           b[40],
+	}, nil
+}
+
+// putAddress writes ip into a 16-byte address field (IPv4 uses the first 4
+// bytes, the rest is MBZ) and returns the IPVN value.
+func putAddress(b []byte, ip net.IP) uint8 {
+	if v4 := ip.To4(); v4 != nil {
+		copy(b[0:4], v4)
+		return 4
+	}
+	copy(b[0:16], ip.To16())
+	return 6
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTwampTimestamp(t *testing.T) {
+	tests := []struct {
+		time     time.Time
+		wire     []byte // 8 바이트, big endian
+		fraction uint32
+	}{
+		{time.Unix(0, 0), []byte{0x83, 0xaa, 0x7e, 0x80, 0, 0, 0, 0}, 0},
+		{time.Unix(1704418200, 500_000_000), []byte{0xe9, 0x41, 0xda, 0x18, 0x80, 0, 0, 0}, 1 << 31},
+		{time.Unix(1704418200, 250_000_000), []byte{0xe9, 0x41, 0xda, 0x18, 0x40, 0, 0, 0}, 1 << 30},
+	}
+	for _, tt := range tests {
+		ts := newTimestamp(tt.time)
+		if ts.Fraction != tt.fraction {
+			t.Errorf("%s: fraction = %#x, want %#x", tt.time, ts.Fraction, tt.fraction)
+		}
+		b := make([]byte, 8)
+		ts.put(b)
+		if !bytes.Equal(b, tt.wire) {
+			t.Errorf("%s: wire = % x, want % x", tt.time, b, tt.wire)
+		}
+		if got := readTimestamp(b).Time(); !got.Equal(tt.time) {
+			t.Errorf("%s: round trip = %s", tt.time, got)
+		}
+	}
+	// fraction 은 1/2^32 초 단위라 ns 는 1 안쪽으로만 맞는다
+	now := time.Unix(1704418200, 123456789)
+	if d := newTimestamp(now).Time().Sub(now); d > 0 || d < -time.Nanosecond {
+		t.Errorf("round trip of %s is off by %s", now, d)
+	}
+	if ts := durationTimestamp(2500 * time.Millisecond); ts.Seconds != 2 || ts.Fraction != 1<<31 {
+		t.Errorf("durationTimestamp(2.5s) = %+v", ts)
+	}
+}
+
+func TestSenderPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		packet  twampSenderPacket
+		padding int
+	}{
+		{twampSenderPacket{Sequence: 0, Timestamp: newTimestamp(time.Unix(1704418200, 0)), ErrorEstimate: errorEstimate(false)}, 0},
+		{twampSenderPacket{Sequence: 0xfffffffe, Timestamp: newTimestamp(time.Unix(1704418200, 5e8)), ErrorEstimate: errorEstimate(true)}, 27},
+	}
+	for _, tt := range tests {
+		b := tt.packet.marshal(tt.padding)
+		if len(b) != twampSenderHeaderSize+tt.padding {
+			t.Errorf("marshal(%d) is %d bytes", tt.padding, len(b))
+		}
+		got, err := parseSenderPacket(b)
+		if err != nil || got != tt.packet {
+			t.Errorf("parseSenderPacket(marshal) = %+v, %v; want %+v", got, err, tt.packet)
+		}
+	}
+	if _, err := parseSenderPacket(make([]byte, twampSenderHeaderSize-1)); err == nil {
+		t.Error("short packet parsed")
+	}
+	if e := errorEstimate(true); e != 0x8001 {
+		t.Errorf("errorEstimate(true) = %#x, want 0x8001 (S bit, multiplier 1)", e)
+	}
+}
+
+func TestPutAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		ipvn uint8
+		want []byte
+	}{
+		{"192.0.2.1", 4, append([]byte{192, 0, 2, 1}, make([]byte, 12)...)},
+		{"2001:db8::1", 6, net.ParseIP("2001:db8::1").To16()},
+	}
+	for _, tt := range tests {
+		b := make([]byte, 16)
+		if ipvn := putAddress(b, net.ParseIP(tt.ip)); ipvn != tt.ipvn || !bytes.Equal(b, tt.want) {
+			t.Errorf("putAddress(%s) = %d, % x; want %d, % x", tt.ip, ipvn, b, tt.ipvn, tt.want)
+		}
+	}
+}
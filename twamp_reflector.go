@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type twampReflectorConfig struct {
+	Addr     string
+	Interval time.Duration
+}
+
+// reflectorSession accumulates what the reflector saw from one sender
+// during the current reporting interval.
+type reflectorSession struct {
+	received   int
+	duplicates int
+	misordered int
+	firstSeq   uint32
+	lastSeq    uint32
+	maxSeq     uint32
+	seen       map[uint32]struct{}
+	forward    []float64
+	synced     bool
+	port       int
+	size       int
+}
+
+// runTwampReflector answers TWAMP-Test packets on cfg.Addr as a stateless
+// TWAMP Light Session-Reflector (RFC 5357 Appendix I). Per-sender statistics
+// for the forward direction are emitted every cfg.Interval.
+func runTwampReflector(ctx context.Context, cfg twampReflectorConfig, emit func(Record) error) error {
+	addr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	logTWAMP.Info("TWAMP Light reflector listening", "addr", conn.LocalAddr().String())
+	if err := receiveTTL(conn); err != nil {
+		logTWAMP.Warn("can't read the TTL of received packets, replies carry Sender TTL 0", "err", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		sessions = make(map[string]*reflectorSession)
+		started  = time.Now()
+		round    = 0
+	)
+
+	report := func() {
+		mu.Lock()
+		current := sessions
+		sessions = make(map[string]*reflectorSession)
+		from := started
+		started = time.Now()
+		round++
+		mu.Unlock()
+
+		for sender, s := range current {
+			rec := s.record(sender, conn.LocalAddr().(*net.UDPAddr), cfg.Interval)
+			rec["@timestamp"] = from.UTC().Format(time.RFC3339Nano)
+			rec["stat_round"] = round
+			if err := emit(rec); err != nil {
+				logTWAMP.Error("error indexing reflector stats", "sender", sender, "err", err)
+			}
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+
+	buf, oob := make([]byte, 65535), make([]byte, 128)
+	for {
+		n, oobn, _, from, err := conn.ReadMsgUDP(buf, oob)
+		t2 := time.Now()
+		if err != nil {
+			if ctx.Err() != nil {
+				report()
+				return nil
+			}
+			return err
+		}
+		req, err := parseSenderPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		// 응답 크기는 요청과 같게 (symmetric size)
+		padding := n - twampReflectorHeaderSize
+		if padding < 0 {
+			padding = 0
+		}
+		reply := twampReflectorPacket{
+			// stateless reflector 는 sender sequence 를 그대로 사용
+			Sequence:            req.Sequence,
+			ErrorEstimate:       errorEstimate(false),
+			ReceiveTimestamp:    newTimestamp(t2),
+			SenderSequence:      req.Sequence,
+			SenderTimestamp:     req.Timestamp,
+			SenderErrorEstimate: req.ErrorEstimate,
+		}
+		// RFC 5357 4.2.1: 받은 패킷의 TTL 을 돌려준다
+		reply.SenderTTL, _ = packetTTL(oob[:oobn])
+		reply.Timestamp = newTimestamp(time.Now())
+		if _, err := conn.WriteToUDP(reply.marshal(padding), from); err != nil {
+			logTWAMP.Warn("error reflecting packet", "to", from.String(), "err", err)
+		}
+
+		mu.Lock()
+		s, ok := sessions[from.String()]
+		if !ok {
+			s = &reflectorSession{seen: make(map[uint32]struct{}), firstSeq: req.Sequence, synced: true, port: from.Port}
+			sessions[from.String()] = s
+		}
+		s.add(req, t2, n)
+		mu.Unlock()
+	}
+}
+
+func (s *reflectorSession) add(req twampSenderPacket, t2 time.Time, size int) {
+	if _, dup := s.seen[req.Sequence]; dup {
+		s.duplicates++
+		return
+	}
+	s.seen[req.Sequence] = struct{}{}
+	if s.received > 0 && req.Sequence < s.lastSeq {
+		s.misordered++
+	}
+	if req.Sequence < s.firstSeq {
+		s.firstSeq = req.Sequence
+	}
+	if req.Sequence > s.maxSeq {
+		s.maxSeq = req.Sequence
+	}
+	s.lastSeq = req.Sequence
+	s.received++
+	s.size = size
+	s.synced = s.synced && req.ErrorEstimate&twampErrorEstimateSynced != 0
+	s.forward = append(s.forward, micros(t2.Sub(req.Timestamp.Time())))
+}
+
+func (s *reflectorSession) record(sender string, local *net.UDPAddr, interval time.Duration) Record {
+	host, _, _ := net.SplitHostPort(sender)
+	expected := int(s.maxSeq-s.firstSeq) + 1
+	lost := expected - s.received
+	rec := Record{
+		"session_type":     "TWAMP-Light-Reflector",
+		"session_name":     sender,
+		"source_ip":        host,
+		"source_port":      s.port,
+		"destination_ip":   local.IP.String(),
+		"destination_port": local.Port,
+		"interval":         int(interval / time.Second),
+		"interval_ms":      int(interval / time.Millisecond),
+		"packet_size":      s.size,
+		"ul_rxpkts":        s.received,
+		"ul_lostpkts":      lost,
+		"ul_lostperc":      float64(lost) * 100 / float64(expected),
+		"ul_misorderpkts":  s.misordered,
+		"ul_duplicatepkts": s.duplicates,
+		"ul_firstpktSeq":   s.firstSeq,
+		"ul_lastpktSeq":    s.maxSeq,
+	}
+	// source_* 는 sender 쪽이다; reflector 는 destination 쪽
+	if host, err := os.Hostname(); err == nil {
+		rec["destination_hostname"] = host
+	}
+	if s.synced {
+		rec["sync_status"] = 1
+		// forward delay 는 sender 시계가 동기화된 경우에만 의미가 있음
+		putDelayStats(rec, "ul_d", s.forward)
+		rec["ul_jmean"] = rfc3550Jitter(s.forward)
+	} else {
+		rec["sync_status"] = 0
+	}
+	return rec
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReflectorRecordSides(t *testing.T) {
+	s := &reflectorSession{received: 9, firstSeq: 0, maxSeq: 9, port: 40000, size: 41}
+	local := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 862}
+	rec := s.record("10.0.0.1:40000", local, 10*time.Second)
+	want := map[string]interface{}{
+		"source_ip":        "10.0.0.1",
+		"source_port":      40000,
+		"destination_ip":   "10.0.0.2",
+		"destination_port": 862,
+		"ul_lostpkts":      1,
+		"ul_lostperc":      10.0,
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s = %v, want %v", k, rec[k], v)
+		}
+	}
+	if _, ok := rec["source_ne"]; ok {
+		t.Errorf("source_ne = %v, the reflector isn't the source", rec["source_ne"])
+	}
+	if _, ok := rec["destination_hostname"]; !ok {
+		t.Error("no destination_hostname")
+	}
+}
+
+func TestReflectorPacketRoundTrip(t *testing.T) {
+	now := time.Unix(1704418200, 250_000_000)
+	p := twampReflectorPacket{
+		Sequence:            7,
+		Timestamp:           newTimestamp(now),
+		ErrorEstimate:       errorEstimate(true),
+		ReceiveTimestamp:    newTimestamp(now.Add(-time.Millisecond)),
+		SenderSequence:      5,
+		SenderTimestamp:     newTimestamp(now.Add(-2 * time.Millisecond)),
+		SenderErrorEstimate: errorEstimate(false),
+		SenderTTL:           63,
+	}
+	got, err := parseReflectorPacket(p.marshal(27))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != p {
+		t.Errorf("parseReflectorPacket(marshal) = %+v, want %+v", got, p)
+	}
+	if _, err := parseReflectorPacket(make([]byte, twampReflectorHeaderSize-1)); err == nil {
+		t.Error("short packet accepted")
+	}
+}
+
+func TestReceiveTTL(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TTL is only read on Linux")
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := receiveTTL(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteToUDP([]byte("x"), conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf, oob := make([]byte, 16), make([]byte, 128)
+	_, oobn, _, _, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := packetTTL(oob[:oobn]); !ok || ttl == 0 {
+		t.Errorf("packetTTL = %d, %v", ttl, ok)
+	}
+}
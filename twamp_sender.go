@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// twampControlClient is a TWAMP-Control client in unauthenticated mode.
+type twampControlClient struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func dialTwampControl(addr string, timeout time.Duration) (*twampControlClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &twampControlClient{conn: conn, timeout: timeout}
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *twampControlClient) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if _, err := io.ReadFull(c.conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (c *twampControlClient) write(b []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func (c *twampControlClient) handshake() error {
+	greeting, err := c.read(twampGreetingSize)
+	if err != nil {
+		return fmt.Errorf("error reading server greeting: %w", err)
+	}
+	modes := binary.BigEndian.Uint32(greeting[12:16])
+	if modes&twampModeUnauthenticated == 0 {
+		return fmt.Errorf("server does not offer unauthenticated mode (modes %#x)", modes)
+	}
+
+	setup := make([]byte, twampSetupResponseSize)
+	binary.BigEndian.PutUint32(setup[0:4], twampModeUnauthenticated)
+	if err := c.write(setup); err != nil {
+		return fmt.Errorf("error sending set-up-response: %w", err)
+	}
+
+	start, err := c.read(twampServerStartSize)
+	if err != nil {
+		return fmt.Errorf("error reading server-start: %w", err)
+	}
+	if start[15] != 0 {
+		return fmt.Errorf("server rejected control connection (accept=%d)", start[15])
+	}
+	return nil
+}
+
+type twampSessionRequest struct {
+	SenderAddr   net.IP
+	SenderPort   uint16
+	ReceiverAddr net.IP
+	ReceiverPort uint16
+	Padding      uint32
+	StartTime    time.Time
+	Timeout      time.Duration
+	DSCP         uint8
+}
+
+// requestSession sends Request-TW-Session and returns the reflector port
+// chosen by the server.
+func (c *twampControlClient) requestSession(r twampSessionRequest) (uint16, error) {
+	b := make([]byte, twampRequestSessionSize)
+	b[0] = twampCmdRequestSession
+	putAddress(b[16:32], r.SenderAddr)
+	b[1] = putAddress(b[32:48], r.ReceiverAddr) & 0x0f
+	binary.BigEndian.PutUint16(b[12:14], r.SenderPort)
+	binary.BigEndian.PutUint16(b[14:16], r.ReceiverPort)
+	binary.BigEndian.PutUint32(b[64:68], r.Padding)
+	newTimestamp(r.StartTime).put(b[68:76])
+	durationTimestamp(r.Timeout).put(b[76:84])
+	binary.BigEndian.PutUint32(b[84:88], uint32(r.DSCP&0x3f)<<24)
+	if err := c.write(b); err != nil {
+		return 0, fmt.Errorf("error sending request-tw-session: %w", err)
+	}
+
+	resp, err := c.read(twampAcceptSessionSize)
+	if err != nil {
+		return 0, fmt.Errorf("error reading accept-session: %w", err)
+	}
+	if resp[0] != 0 {
+		return 0, fmt.Errorf("server rejected test session (accept=%d)", resp[0])
+	}
+	port := binary.BigEndian.Uint16(resp[2:4])
+	if port == 0 {
+		port = r.ReceiverPort
+	}
+	return port, nil
+}
+
+func (c *twampControlClient) startSessions() error {
+	b := make([]byte, twampStartSessionsSize)
+	b[0] = twampCmdStartSessions
+	if err := c.write(b); err != nil {
+		return fmt.Errorf("error sending start-sessions: %w", err)
+	}
+	ack, err := c.read(twampStartAckSize)
+	if err != nil {
+		return fmt.Errorf("error reading start-ack: %w", err)
+	}
+	if ack[0] != 0 {
+		return fmt.Errorf("server refused to start sessions (accept=%d)", ack[0])
+	}
+	return nil
+}
+
+func (c *twampControlClient) stopSessions(n uint32) error {
+	b := make([]byte, twampStopSessionsSize)
+	b[0] = twampCmdStopSessions
+	binary.BigEndian.PutUint32(b[4:8], n)
+	return c.write(b)
+}
+
+func (c *twampControlClient) Close() error {
+	return c.conn.Close()
+}
+
+type twampSenderConfig struct {
+	Packets        int
+	PacketInterval time.Duration
+	Padding        int
+	Wait           time.Duration
+	ReceiverPort   int
+	DSCP           int
+	Light          bool
+	Interval       time.Duration
+	Timeout        time.Duration
+}
+
+type twampTarget struct {
+	Name string
+	Addr string
+	ID   int
+}
+
+// parseTwampTargets reads "host[:port]" entries separated by commas. The
+// port is the TWAMP-Control port, or the reflector UDP port in light mode.
+func parseTwampTargets(list string) []twampTarget {
+	var targets []twampTarget
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr := entry
+		if _, _, err := net.SplitHostPort(entry); err != nil {
+			addr = net.JoinHostPort(entry, strconv.Itoa(twampControlPort))
+		}
+		targets = append(targets, twampTarget{Name: entry, Addr: addr, ID: len(targets) + 1})
+	}
+	return targets
+}
+
+type twampSample struct {
+	seq    uint32
+	t1, t4 time.Time
+	t2, t3 time.Time
+	synced bool
+}
+
+// runTwampSender measures every target each cfg.Interval until ctx is
+// cancelled, handing one Record per target and round to emit.
+func runTwampSender(ctx context.Context, targets []twampTarget, cfg twampSenderConfig, emit func(Record) error) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target twampTarget) {
+			defer wg.Done()
+			ticker := time.NewTicker(cfg.Interval)
+			defer ticker.Stop()
+			for round := 1; ; round++ {
+				rec, err := measureTarget(target, cfg, round)
+				if err != nil {
+					logTWAMP.Warn("session failed", "target", target.Name, "err", err)
+				} else if err := emit(rec); err != nil {
+					logTWAMP.Error("error indexing result", "target", target.Name, "err", err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+func measureTarget(target twampTarget, cfg twampSenderConfig, round int) (Record, error) {
+	host, portStr, err := net.SplitHostPort(target.Addr)
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	localIP, err := outboundIP(raddr.IP)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	reflectorPort, _ := strconv.Atoi(portStr)
+	if !cfg.Light {
+		ctrl, err := dialTwampControl(target.Addr, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		defer ctrl.Close()
+
+		port, err := ctrl.requestSession(twampSessionRequest{
+			SenderAddr:   local.IP,
+			SenderPort:   uint16(local.Port),
+			ReceiverAddr: raddr.IP,
+			ReceiverPort: uint16(cfg.ReceiverPort),
+			Padding:      uint32(cfg.Padding),
+			StartTime:    time.Now(),
+			Timeout:      cfg.Wait,
+			DSCP:         uint8(cfg.DSCP),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := ctrl.startSessions(); err != nil {
+			return nil, err
+		}
+		defer ctrl.stopSessions(1)
+		reflectorPort = int(port)
+	}
+
+	dst := &net.UDPAddr{IP: raddr.IP, Port: reflectorPort, Zone: raddr.Zone}
+	started := time.Now()
+	samples, err := runTestSession(conn, dst, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := twampSessionRecord(samples, cfg)
+	rec["@timestamp"] = started.UTC().Format(time.RFC3339Nano)
+	rec["session_type"] = "TWAMP"
+	if cfg.Light {
+		rec["session_type"] = "TWAMP-Light"
+	}
+	rec["session_name"] = target.Name
+	rec["session_id"] = target.ID
+	rec["source_ip"] = local.IP.String()
+	rec["source_port"] = local.Port
+	rec["destination_ip"] = raddr.IP.String()
+	rec["destination_port"] = reflectorPort
+	rec["interval"] = int(cfg.Interval / time.Second)
+	rec["interval_ms"] = int(cfg.Interval / time.Millisecond)
+	rec["packet_size"] = twampSenderHeaderSize + cfg.Padding
+	if cfg.PacketInterval > 0 {
+		rec["packet_rate"] = int(time.Second / cfg.PacketInterval)
+	}
+	rec["stat_round"] = round
+	if host, err := os.Hostname(); err == nil {
+		rec["source_ne"] = host
+	}
+	return rec, nil
+}
+
+// outboundIP returns the local address the kernel would use to reach dst.
+// Connecting a UDP socket sends nothing on the wire.
+func outboundIP(dst net.IP) (net.IP, error) {
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: twampTestPort})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// runTestSession sends cfg.Packets test packets to dst and collects the
+// reflected replies until cfg.Wait after the last packet.
+func runTestSession(conn *net.UDPConn, dst *net.UDPAddr, cfg twampSenderConfig) ([]twampSample, error) {
+	var (
+		mu      sync.Mutex
+		sent    = make(map[uint32]time.Time, cfg.Packets)
+		samples []twampSample
+		sendErr error
+		done    = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+		for seq := 0; seq < cfg.Packets; seq++ {
+			now := time.Now()
+			pkt := twampSenderPacket{
+				Sequence:      uint32(seq),
+				Timestamp:     newTimestamp(now),
+				ErrorEstimate: errorEstimate(false),
+			}
+			mu.Lock()
+			sent[uint32(seq)] = now
+			mu.Unlock()
+			if _, err := conn.WriteToUDP(pkt.marshal(cfg.Padding), dst); err != nil {
+				mu.Lock()
+				sendErr = err
+				mu.Unlock()
+				return
+			}
+			time.Sleep(cfg.PacketInterval)
+		}
+	}()
+
+	buf := make([]byte, 65535)
+	deadline := time.Now().Add(time.Duration(cfg.Packets)*cfg.PacketInterval + cfg.Wait)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, from, err := conn.ReadFromUDP(buf)
+		t4 := time.Now()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		if !from.IP.Equal(dst.IP) {
+			continue
+		}
+		reply, err := parseReflectorPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		mu.Lock()
+		t1, ok := sent[reply.SenderSequence]
+		delete(sent, reply.SenderSequence)
+		mu.Unlock()
+		if !ok {
+			continue
+		}
+		samples = append(samples, twampSample{
+			seq:    reply.SenderSequence,
+			t1:     t1,
+			t2:     reply.ReceiveTimestamp.Time(),
+			t3:     reply.Timestamp.Time(),
+			t4:     t4,
+			synced: reply.ErrorEstimate&twampErrorEstimateSynced != 0,
+		})
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sendErr != nil {
+		return nil, sendErr
+	}
+	return samples, nil
+}
+
+// twampSessionRecord summarises one test session using the same field names
+// as the vendor CSV exports (delays in microseconds).
+func twampSessionRecord(samples []twampSample, cfg twampSenderConfig) Record {
+	rec := Record{}
+	received := len(samples)
+	lost := cfg.Packets - received
+
+	rec["ul_rxpkts"] = received
+	rec["dl_rxpkts"] = received
+	rec["rxpkts"] = received
+	rec["lostpkts"] = lost
+	if cfg.Packets > 0 {
+		rec["lostperc"] = float64(lost) * 100 / float64(cfg.Packets)
+	}
+	if received == 0 {
+		return rec
+	}
+
+	// samples are in arrival order here
+	misordered := 0
+	for i := 1; i < received; i++ {
+		if samples[i].seq < samples[i-1].seq {
+			misordered++
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].seq < samples[j].seq })
+
+	rtt := make([]float64, 0, received)
+	ul := make([]float64, 0, received)
+	dl := make([]float64, 0, received)
+	synced := true
+	for _, s := range samples {
+		// reflector 처리 시간은 RTT 에서 제외
+		rtt = append(rtt, micros(s.t4.Sub(s.t1)-s.t3.Sub(s.t2)))
+		ul = append(ul, micros(s.t2.Sub(s.t1)))
+		dl = append(dl, micros(s.t4.Sub(s.t3)))
+		synced = synced && s.synced
+	}
+	if synced {
+		rec["sync_status"] = 1
+	} else {
+		rec["sync_status"] = 0
+	}
+	rec["misorderpkts"] = misordered
+
+	putDelayStats(rec, "rtt_", rtt)
+	rec["rtt_jitter"] = rfc3550Jitter(rtt)
+	// one-way delays are only meaningful with synchronized clocks
+	if synced {
+		putDelayStats(rec, "ul_d", ul)
+		putDelayStats(rec, "dl_d", dl)
+		rec["ul_jmean"] = rfc3550Jitter(ul)
+		rec["dl_jmean"] = rfc3550Jitter(dl)
+	}
+	return rec
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+func putDelayStats(rec Record, prefix string, values []float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	variance := 0.0
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	rec[prefix+"min"] = sorted[0]
+	rec[prefix+"max"] = sorted[len(sorted)-1]
+	rec[prefix+"mean"] = mean
+	rec[prefix+"p50"] = percentile(sorted, 50)
+	rec[prefix+"p95"] = percentile(sorted, 95)
+	rec[prefix+"StdDev"] = math.Sqrt(variance / float64(len(sorted)))
+}
+
+// percentile uses nearest-rank on an already sorted slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// rfc3550Jitter is the interarrival jitter estimator from RFC 3550 §6.4.1.
+func rfc3550Jitter(transit []float64) float64 {
+	j := 0.0
+	for i := 1; i < len(transit); i++ {
+		d := math.Abs(transit[i] - transit[i-1])
+		j += (d - j) / 16
+	}
+	return j
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// receiveTTL asks the kernel to pass the TTL (IPv6: hop limit) of every
+// packet received on conn, for the Sender TTL of the reflected packets.
+// A dual-stack socket takes both; the one that doesn't apply fails.
+func receiveTTL(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err4, err6 error
+	err = raw.Control(func(fd uintptr) {
+		err4 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVTTL, 1)
+		err6 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// packetTTL returns the TTL in the control messages of a packet.
+func packetTTL(oob []byte) (uint8, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		ttl := m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_TTL ||
+			m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_HOPLIMIT
+		if ttl && len(m.Data) >= 4 {
+			return uint8(binary.NativeEndian.Uint32(m.Data)), true
+		}
+	}
+	return 0, false
+}
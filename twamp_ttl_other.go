@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// The TTL of received packets is only read on Linux (twamp_ttl_linux.go);
+// elsewhere the reflector sends Sender TTL 0.
+func receiveTTL(conn *net.UDPConn) error { return nil }
+
+func packetTTL(oob []byte) (uint8, bool) { return 0, false }
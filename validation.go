@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// validationField lists a tagged row's violations.
+const validationField = "validation_errors"
+
+// validationRules is the VALIDATION_FILE: constraints checked on every
+// converted row, before KPIs and the outputs. Fields are the schema's names
+// and may be globs.
+//
+//	action: tag            # tag (validation_errors field) or reject
+//	rules:
+//	  - fields: [session_id, source_ip, "@timestamp"]
+//	    required: true
+//	  - fields: ["ul_lostperc", "dl_lostperc"]
+//	    min: 0
+//	    max: 100
+//	  - fields: [sync_status]
+//	    enum: [0, 1]
+//	  - fields: ["@timestamp"]
+//	    not_future: 5m     # clock skew allowed
+type validationRules struct {
+	Action string            `json:"action"`
+	Rules  []*validationRule `json:"rules"`
+}
+
+type validationRule struct {
+	Fields    []string      `json:"fields"`
+	Required  bool          `json:"required"`
+	Min       *float64      `json:"min"`
+	Max       *float64      `json:"max"`
+	Enum      []interface{} `json:"enum"`
+	NotFuture string        `json:"not_future"`
+
+	enum   map[string]bool
+	future bool
+	skew   time.Duration
+}
+
+func loadValidationRules(path string) (*validationRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading validation file: %w", err)
+	}
+	var v validationRules
+	if err := decodeYAML(data, &v); err != nil {
+		return nil, fmt.Errorf("error parsing validation file %s: %w", path, err)
+	}
+	if v.Action == "" {
+		v.Action = "tag"
+	}
+	if v.Action != "tag" && v.Action != "reject" {
+		return nil, fmt.Errorf("validation file %s: action must be tag or reject", path)
+	}
+	for i, r := range v.Rules {
+		if len(r.Fields) == 0 {
+			return nil, fmt.Errorf("validation file %s: rule %d has no fields", path, i+1)
+		}
+		for _, pattern := range r.Fields {
+			if _, err := matchField(pattern, ""); err != nil {
+				return nil, fmt.Errorf("validation file %s: rule %d: %w", path, i+1, err)
+			}
+		}
+		if len(r.Enum) > 0 {
+			r.enum = map[string]bool{}
+			for _, e := range r.Enum {
+				r.enum[fmt.Sprint(e)] = true
+			}
+		}
+		if r.NotFuture != "" {
+			r.future = true
+			if r.NotFuture != "0" {
+				if r.skew, err = time.ParseDuration(r.NotFuture); err != nil {
+					return nil, fmt.Errorf("validation file %s: rule %d: not_future: %w", path, i+1, err)
+				}
+			}
+		}
+	}
+	return &v, nil
+}
+
+// Check returns rec's violations, e.g. `ul_lostperc: 120 above max 100`.
+func (v *validationRules) Check(rec Record) []string {
+	var out []string
+	violation := func(field, rule, format string, args ...interface{}) {
+		metricValidationViolations.Inc(field, rule)
+		out = append(out, field+": "+fmt.Sprintf(format, args...))
+	}
+	now := time.Now()
+	for _, r := range v.Rules {
+		if r.Required {
+			for _, pattern := range r.Fields {
+				if !hasField(rec, pattern) {
+					violation(pattern, "required", "missing")
+				}
+			}
+		}
+		for k, val := range rec {
+			if !matchesField(r.Fields, k) {
+				continue
+			}
+			if r.Min != nil || r.Max != nil {
+				n, ok := number(rec, k)
+				switch {
+				case !ok:
+					violation(k, "range", "%v is not a number", val)
+				case r.Min != nil && n < *r.Min:
+					violation(k, "min", "%v below min %v", val, *r.Min)
+				case r.Max != nil && n > *r.Max:
+					violation(k, "max", "%v above max %v", val, *r.Max)
+				}
+			}
+			if r.enum != nil && !r.enum[fmt.Sprint(val)] {
+				violation(k, "enum", "%v not one of %v", val, r.Enum)
+			}
+			if r.future {
+				s, _ := val.(string)
+				t, err := time.Parse(time.RFC3339Nano, s)
+				switch {
+				case err != nil:
+					violation(k, "not_future", "%v is not a timestamp", val)
+				case t.After(now.Add(r.skew)):
+					violation(k, "not_future", "%s is in the future", s)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// hasField reports whether a field matching pattern is set.
+func hasField(rec Record, pattern string) bool {
+	if _, ok := rec[pattern]; ok {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return false
+	}
+	for k := range rec {
+		if ok, _ := matchField(pattern, k); ok {
+			return true
+		}
+	}
+	return false
+}
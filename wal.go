@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walSegmentBytes is the size at which the WAL starts a new segment.
+const walSegmentBytes = 64 << 20
+
+// walSink is the write-ahead log (WAL_DIR) between the parsers and the
+// rest of the pipeline. Each record is appended to the log before it's
+// handed on, and acknowledged by the next Flush that every output completed
+// without error (what an output gave up on is in its dead-letter queue by
+// then). Records still unacknowledged when the process stopped are sent
+// again on the next start; fully acknowledged segments are removed.
+type walSink struct {
+	next     recordSink
+	dir      string
+	maxBytes int64
+	fsync    string
+
+	// Add 는 RLock 으로 병렬로, Flush 는 Lock 으로 그때까지 추가된 레코드의 seq 를 확정한다
+	gate sync.RWMutex
+
+	mu       sync.Mutex
+	file     *os.File
+	segments []*walSegment // oldest first; the last one is being written
+	size     int64
+	seq      uint64 // last record written
+	acked    uint64
+	dirty    bool // written since the last fsync
+	bypass   bool // over maxBytes, records skip the log
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+type walSegment struct {
+	path        string
+	first, last uint64 // seq range; last < first while empty
+	size        int64
+}
+
+// openWAL opens dir, creating it if needed. Unacknowledged records from
+// a previous run stay in place until replay sends them.
+func openWAL(next recordSink, dir string, maxBytes int, fsync string, fsyncInterval time.Duration) (*walSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating WAL directory: %w", err)
+	}
+	w := &walSink{next: next, dir: dir, maxBytes: int64(maxBytes), fsync: fsync, done: make(chan struct{})}
+	if data, err := os.ReadFile(filepath.Join(dir, "ack")); err == nil {
+		w.acked, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		seg, err := scanWALSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		if seg.size == 0 {
+			os.Remove(path)
+			continue
+		}
+		w.segments = append(w.segments, seg)
+		w.size += seg.size
+		w.seq = max(w.seq, seg.last)
+	}
+	w.seq = max(w.seq, w.acked)
+	// 이전 segment 에는 이어 쓰지 않는다 (마지막 줄이 잘렸을 수 있다)
+	if err := w.rotateLocked(); err != nil {
+		return nil, err
+	}
+	if w.fsync == "interval" {
+		w.wg.Add(1)
+		go w.syncLoop(fsyncInterval)
+	}
+	w.updateMetrics()
+	return w, nil
+}
+
+// scanWALSegment counts the records of a segment; its first seq is in the
+// file name.
+func scanWALSegment(path string) (*walSegment, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "wal-"), ".log")
+	first, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WAL segment name %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading WAL segment: %w", err)
+	}
+	n := uint64(bytes.Count(data, []byte("\n")))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	return &walSegment{path: path, first: first, last: first + n - 1, size: int64(len(data))}, nil
+}
+
+// replay sends the records that weren't acknowledged before the last
+// shutdown through the pipeline and acknowledges them once flushed.
+func (w *walSink) replay() error {
+	w.mu.Lock()
+	segments := append([]*walSegment(nil), w.segments[:len(w.segments)-1]...)
+	acked, last := w.acked, w.seq
+	w.mu.Unlock()
+	if last <= acked {
+		return nil
+	}
+	logWAL.Info("replaying unacknowledged records", "records", last-acked, "dir", w.dir)
+	for _, seg := range segments {
+		if seg.last <= acked {
+			continue
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return fmt.Errorf("error reading WAL segment: %w", err)
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 64*1024), walSegmentBytes)
+		for seq := seg.first; sc.Scan(); seq++ {
+			if seq <= acked {
+				continue
+			}
+			rec, err := decodeWALRecord(sc.Bytes())
+			if err != nil {
+				logWAL.Warn("skipping unreadable WAL record", "file", seg.path, "seq", seq, "err", err)
+				continue
+			}
+			if err := w.next.Add(rec); err != nil {
+				logWAL.Error("error replaying record", "err", err)
+			}
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error reading WAL segment %s: %w", seg.path, err)
+		}
+	}
+	if err := w.next.Flush(); err != nil {
+		return fmt.Errorf("error flushing replayed records: %w", err)
+	}
+	w.ack(last)
+	logWAL.Info("WAL replay done", "records", last-acked)
+	return nil
+}
+
+// decodeWALRecord undoes json.Marshal; integers come back as int64 like
+// the schema produces them, not float64.
+func decodeWALRecord(line []byte) (Record, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var rec Record
+	if err := dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	for k, v := range rec {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				rec[k] = i
+			} else {
+				rec[k], _ = n.Float64()
+			}
+		}
+	}
+	return rec, nil
+}
+
+func (w *walSink) Add(rec Record) error {
+	w.gate.RLock()
+	defer w.gate.RUnlock()
+	if err := w.append(rec); err != nil {
+		// 로그에 못 남겨도 레코드는 그대로 전달한다
+		logWAL.Error("error writing WAL", "err", err)
+	}
+	return w.next.Add(rec)
+}
+
+func (w *walSink) append(rec Record) error {
+	line, err := appendRecordJSON(nil, rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size+int64(len(line)) > w.maxBytes {
+		if !w.bypass {
+			logWAL.Warn("WAL full, records skip it until acknowledged ones are removed", "max_bytes", w.maxBytes)
+			w.bypass = true
+		}
+		metricWALBypassed.Inc()
+		return nil
+	}
+	seg := w.segments[len(w.segments)-1]
+	if seg.size+int64(len(line)) > walSegmentBytes && seg.size > 0 {
+		if err := w.rotateLocked(); err != nil {
+			return err
+		}
+		seg = w.segments[len(w.segments)-1]
+	}
+	if _, err := w.file.Write(line); err != nil {
+		return err
+	}
+	if w.fsync == "always" {
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+	}
+	w.seq++
+	seg.last = w.seq
+	seg.size += int64(len(line))
+	w.size += int64(len(line))
+	w.dirty = true
+	w.updateMetrics()
+	return nil
+}
+
+// Flush flushes the pipeline and, if every output succeeded, acknowledges
+// everything added before it.
+func (w *walSink) Flush() error {
+	w.gate.Lock()
+	w.mu.Lock()
+	seq := w.seq
+	w.mu.Unlock()
+	w.gate.Unlock()
+	if err := w.next.Flush(); err != nil {
+		return err
+	}
+	w.ack(seq)
+	return nil
+}
+
+func (w *walSink) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.wg.Wait()
+		w.gate.Lock()
+		w.mu.Lock()
+		seq := w.seq
+		w.mu.Unlock()
+		w.gate.Unlock()
+		if err = w.next.Close(); err == nil {
+			w.ack(seq)
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.file != nil {
+			w.file.Sync()
+			w.file.Close()
+		}
+	})
+	return err
+}
+
+// ack records seq as written by every output and removes the segments it
+// covers. The segment being written is rotated first if it's fully acked.
+func (w *walSink) ack(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq <= w.acked {
+		return
+	}
+	w.acked = seq
+	tmp := filepath.Join(w.dir, "ack.tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)+"\n"), 0o644); err != nil {
+		logWAL.Error("error saving WAL acknowledgement", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(w.dir, "ack")); err != nil {
+		logWAL.Error("error saving WAL acknowledgement", "err", err)
+		return
+	}
+
+	if cur := w.segments[len(w.segments)-1]; cur.size > 0 && cur.last <= w.acked {
+		if err := w.rotateLocked(); err != nil {
+			logWAL.Error("error rotating WAL segment", "err", err)
+		}
+	}
+	keep := w.segments[:0]
+	for i, seg := range w.segments {
+		if i < len(w.segments)-1 && seg.last <= w.acked {
+			if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+				logWAL.Error("error removing WAL segment", "file", seg.path, "err", err)
+			}
+			w.size -= seg.size
+			continue
+		}
+		keep = append(keep, seg)
+	}
+	w.segments = keep
+	if w.bypass && w.size < w.maxBytes {
+		logWAL.Info("WAL has room again, logging records")
+		w.bypass = false
+	}
+	w.updateMetrics()
+}
+
+// rotateLocked starts a new segment at the next seq.
+func (w *walSink) rotateLocked() error {
+	if w.file != nil {
+		w.file.Sync()
+		w.file.Close()
+		w.file = nil
+	}
+	first := w.seq + 1
+	path := filepath.Join(w.dir, fmt.Sprintf("wal-%020d.log", first))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating WAL segment: %w", err)
+	}
+	w.file = f
+	w.segments = append(w.segments, &walSegment{path: path, first: first, last: first - 1})
+	return nil
+}
+
+// syncLoop fsyncs the segment being written every interval
+// (WAL_FSYNC=interval).
+func (w *walSink) syncLoop(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.file != nil {
+				if err := w.file.Sync(); err != nil {
+					logWAL.Error("error syncing WAL", "err", err)
+				}
+				w.dirty = false
+			}
+			w.updateMetrics()
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *walSink) updateMetrics() {
+	metricWALBytes.Set(float64(w.size))
+	metricWALUnacked.Set(float64(w.seq - w.acked))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// collectSink keeps the records it gets; Flush returns flushErr.
+type collectSink struct {
+	mu       sync.Mutex
+	records  []Record
+	flushErr error
+}
+
+func (c *collectSink) Add(rec Record) error {
+	c.mu.Lock()
+	c.records = append(c.records, rec)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *collectSink) Flush() error { return c.flushErr }
+func (c *collectSink) Close() error { return c.flushErr }
+
+func TestWALReplay(t *testing.T) {
+	recs := func(ids ...int64) []Record {
+		var out []Record
+		for _, id := range ids {
+			out = append(out, Record{"session_id": id, "rtt_mean": 1.5, "source_ne": "ne-1"})
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		// flushed 는 Flush 가 성공한 뒤 추가, unflushed 는 Flush 없이 (또는 실패) 중단
+		flushed, unflushed []Record
+		flushFails         bool
+		torn               bool // 마지막 줄이 반만 써진 채 중단
+		want               []Record
+	}{
+		{"all acknowledged", recs(1, 2, 3), nil, false, false, nil},
+		{"nothing acknowledged", nil, recs(1, 2), false, false, recs(1, 2)},
+		{"tail after the last flush", recs(1, 2, 3), recs(4, 5), false, false, recs(4, 5)},
+		{"failed flush isn't acknowledged", nil, recs(1, 2), true, false, recs(1, 2)},
+		{"torn last record is skipped", recs(1), recs(2), false, true, recs(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			first := &collectSink{}
+			w, err := openWAL(first, dir, 1<<20, "always", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range tt.flushed {
+				w.Add(rec)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range tt.unflushed {
+				w.Add(rec)
+			}
+			if tt.flushFails {
+				first.flushErr = errors.New("output down")
+				if err := w.Flush(); err == nil {
+					t.Fatal("Flush succeeded")
+				}
+			}
+			// Close 없이 중단된 것처럼 다시 연다
+			w.mu.Lock()
+			if tt.torn {
+				w.file.WriteString(`{"session_id":`)
+			}
+			w.file.Close()
+			w.mu.Unlock()
+
+			second := &collectSink{}
+			w, err = openWAL(second, dir, 1<<20, "always", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.replay(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(second.records, tt.want) {
+				t.Errorf("replayed %v, want %v", second.records, tt.want)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// 재전송이 끝나면 다음 시작에는 보낼 것이 없다
+			third := &collectSink{}
+			w, err = openWAL(third, dir, 1<<20, "always", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.replay()
+			w.Close()
+			if len(third.records) > 0 {
+				t.Errorf("replayed %d records again", len(third.records))
+			}
+			if segs, _ := filepath.Glob(filepath.Join(dir, "wal-*.log")); len(segs) > 1 {
+				t.Errorf("%d segments left, want the one being written", len(segs))
+			}
+		})
+	}
+}
+
+func TestWALBypassWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	next := &collectSink{}
+	w, err := openWAL(next, dir, 100, "always", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		w.Add(Record{"session_id": int64(i), "pad": "0123456789012345678901234567890123456789"})
+	}
+	if len(next.records) != 5 {
+		t.Fatalf("%d records passed on, want 5", len(next.records))
+	}
+	if !w.bypass {
+		t.Error("WAL over WAL_MAX_BYTES isn't bypassed")
+	}
+	w.Flush()
+	if w.bypass || w.size != 0 {
+		t.Errorf("after the ack bypass = %v, size = %d; want logging again", w.bypass, w.size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ack")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileFilter selects input files by glob. Patterns without a slash match
+// the base name; patterns with one match the path relative to the watch
+// root, where "**" matches any number of directories.
+type fileFilter struct {
+	include []string
+	exclude []string
+}
+
+func newFileFilter(include, exclude string) fileFilter {
+	return fileFilter{include: splitList(include), exclude: splitList(exclude)}
+}
+
+// watchRoot is a watched directory, FILE_PATH or a pipeline's.
+type watchRoot struct {
+	path      string
+	recursive bool
+	filter    fileFilter
+}
+
+// watchTarget is the watched root and filter, swappable on config reload.
+type watchTarget struct {
+	mu     sync.RWMutex
+	root   string
+	filter fileFilter
+}
+
+func (w *watchTarget) get() (string, fileFilter) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.root, w.filter
+}
+
+func (w *watchTarget) set(root string, filter fileFilter) {
+	w.mu.Lock()
+	w.root, w.filter = root, filter
+	w.mu.Unlock()
+}
+
+func (f fileFilter) Match(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, p := range f.exclude {
+		if matchGlob(p, rel) {
+			return false
+		}
+	}
+	for _, p := range f.include {
+		if matchGlob(p, rel) {
+			return true
+		}
+	}
+	return len(f.include) == 0
+}
+
+func matchGlob(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// addWatchTree adds dir, and every directory below it when recursive is
+// set, to the watcher. Directories past the inotify watch limit are
+// returned, limited, rather than failing the walk.
+func addWatchTree(watcher *fsnotify.Watcher, dir string, recursive bool) (limited []string, err error) {
+	add := func(p string) error {
+		err := watcher.Add(p)
+		if watchLimitErr(err) {
+			limited = append(limited, filepath.Clean(p))
+			return nil
+		}
+		return err
+	}
+	if !recursive {
+		return limited, add(dir)
+	}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return add(p)
+		}
+		return nil
+	})
+	return limited, err
+}
+
+// watchLimitErr reports whether err is inotify running out of watches
+// (fs.inotify.max_user_watches, shared by every process of the user).
+func watchLimitErr(err error) bool { return errors.Is(err, syscall.ENOSPC) }
+
+// dirWatcher holds the fsnotify watcher of the watched directories. After
+// errors it is rebuilt (restart): a new watcher with every root added
+// again replaces the old one, which is closed. The watch loop and the
+// reloader go through get so they always use the current one.
+//
+// Directories past the inotify watch limit are unwatched: instead of
+// missing their files, dirPoller scans them every WATCH_POLL_INTERVAL
+// until a later rewatch or restart gets a watch for them.
+type dirWatcher struct {
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	closed  bool
+
+	unwatched   map[string]bool
+	limitLogged bool
+}
+
+func newDirWatcher() (*dirWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		if errors.Is(err, syscall.EMFILE) {
+			err = fmt.Errorf("%w (raise fs.inotify.max_user_instances)", err)
+		}
+		return nil, err
+	}
+	return &dirWatcher{watcher: watcher, unwatched: map[string]bool{}}, nil
+}
+
+func (w *dirWatcher) get() *fsnotify.Watcher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.watcher
+}
+
+// add is addWatchTree on the current watcher.
+func (w *dirWatcher) add(dir string, recursive bool) error {
+	limited, err := addWatchTree(w.get(), dir, recursive)
+	w.mu.Lock()
+	w.limited(limited)
+	w.mu.Unlock()
+	return err
+}
+
+// limited marks dirs unwatched; the first time the limit is hit it says
+// how to raise it. w.mu is held.
+func (w *dirWatcher) limited(dirs []string) {
+	if len(dirs) == 0 {
+		return
+	}
+	if !w.limitLogged {
+		w.limitLogged = true
+		max, _ := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+		logIngest.Error("inotify watch limit reached, polling the directories past it every WATCH_POLL_INTERVAL instead",
+			"max_user_watches", strings.TrimSpace(string(max)), "watching", len(w.watcher.WatchList()),
+			"fix", "raise it (sysctl -w fs.inotify.max_user_watches=524288, and in /etc/sysctl.d to keep it), watch fewer directories or set WATCH_MODE=poll")
+	}
+	for _, dir := range dirs {
+		if !w.unwatched[dir] {
+			w.unwatched[dir] = true
+			logIngest.Warn("directory past the inotify watch limit, polling it", "path", dir)
+		}
+	}
+	metricUnwatchedDirs.Set(float64(len(w.unwatched)))
+}
+
+// unwatchedDirs returns the directories past the watch limit.
+func (w *dirWatcher) unwatchedDirs() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dirs := make([]string, 0, len(w.unwatched))
+	for dir := range w.unwatched {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+func (w *dirWatcher) isUnwatched(dir string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.unwatched[filepath.Clean(dir)]
+}
+
+// forget drops an unwatched directory that's gone.
+func (w *dirWatcher) forget(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.unwatched, dir)
+	metricUnwatchedDirs.Set(float64(len(w.unwatched)))
+}
+
+// removeTree stops watching root and everything below it.
+func (w *dirWatcher) removeTree(root string) {
+	watcher := w.get()
+	under := func(dir string) bool {
+		rel, err := filepath.Rel(root, dir)
+		return err == nil && !strings.HasPrefix(rel, "..")
+	}
+	for _, dir := range watcher.WatchList() {
+		if under(dir) {
+			watcher.Remove(dir)
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir := range w.unwatched {
+		if under(dir) {
+			delete(w.unwatched, dir)
+		}
+	}
+	metricUnwatchedDirs.Set(float64(len(w.unwatched)))
+}
+
+// restart replaces the watcher with a new one watching roots. On error the
+// old one is kept.
+func (w *dirWatcher) restart(roots []watchRoot) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	var limited []string
+	for _, root := range roots {
+		dirs, err := addWatchTree(watcher, root.path, root.recursive)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		limited = append(limited, dirs...)
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return watcher.Close()
+	}
+	old := w.watcher
+	w.watcher, w.unwatched = watcher, map[string]bool{}
+	w.limited(limited)
+	metricUnwatchedDirs.Set(float64(len(w.unwatched)))
+	w.mu.Unlock()
+	return old.Close()
+}
+
+// stopped reports whether Close was called, so a closed watcher channel
+// is the end of the watch loop rather than a failure.
+func (w *dirWatcher) stopped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+func (w *dirWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return w.watcher.Close()
+}
+
+// rewatch adds the directories of roots that dropped out of the watcher
+// back to it: inotify forgets a directory that was removed or moved away,
+// and one recreated in its place isn't watched. It returns how many it
+// added. Unwatched ones are tried again too, in case the limit was raised
+// or watches were freed.
+func (w *dirWatcher) rewatch(roots []watchRoot) (int, error) {
+	watcher := w.get()
+	w.mu.Lock()
+	wasUnwatched := maps.Clone(w.unwatched)
+	w.mu.Unlock()
+	watched := map[string]bool{}
+	for _, dir := range watcher.WatchList() {
+		watched[filepath.Clean(dir)] = true
+	}
+	added := 0
+	var limited []string
+	var firstErr error
+	for _, root := range roots {
+		err := filepath.WalkDir(root.path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			if p != root.path && !root.recursive {
+				return filepath.SkipDir
+			}
+			p = filepath.Clean(p)
+			if watched[p] {
+				return nil
+			}
+			if err := watcher.Add(p); watchLimitErr(err) {
+				limited = append(limited, p)
+				return nil
+			} else if err != nil {
+				return err
+			}
+			watched[p] = true
+			if !wasUnwatched[p] {
+				added++
+			}
+			return nil
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.mu.Lock()
+	for dir := range w.unwatched {
+		if watched[dir] {
+			delete(w.unwatched, dir)
+			logIngest.Info("directory watched again, no longer polling it", "path", dir)
+		}
+	}
+	if len(w.unwatched) == 0 {
+		w.limitLogged = false
+	}
+	w.limited(limited)
+	metricUnwatchedDirs.Set(float64(len(w.unwatched)))
+	w.mu.Unlock()
+	return added, firstErr
+}
+
+// listInputFiles returns files under dir accepted by filter. Patterns are
+// matched against paths relative to root, the top of the watched tree.
+func listInputFiles(root, dir string, recursive bool, filter fileFilter) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if filter.Match(rel) && !isTempFile(p) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// tempSuffixes mark uploads still being written (WATCH_TEMP_SUFFIXES);
+// such files are never ingested.
+var tempSuffixes []string
+
+func isTempFile(path string) bool {
+	_, ok := trimTempSuffix(path)
+	return ok
+}
+
+func trimTempSuffix(path string) (string, bool) {
+	for _, s := range tempSuffixes {
+		if len(path) > len(s) && strings.EqualFold(path[len(path)-len(s):], s) {
+			return path[:len(path)-len(s)], true
+		}
+	}
+	return path, false
+}
+
+// renamedUploads remembers the temp files renamed in the watched
+// directories: the file one becomes (x.csv.gz.tmp or x.tmp renamed to
+// x.csv.gz) is complete and needn't settle. fsnotify reports the old name
+// (Rename) before the new one (Create). Only the watcher loop uses it.
+type renamedUploads map[string]time.Time
+
+func (r renamedUploads) renamed(path string) {
+	if stem, ok := trimTempSuffix(path); ok {
+		r[stem] = time.Now()
+	}
+}
+
+// complete reports whether path was renamed from a temp file just now.
+func (r renamedUploads) complete(path string) bool {
+	for stem, at := range r {
+		if time.Since(at) > time.Minute {
+			delete(r, stem)
+		}
+	}
+	// x.csv.gz 는 x.csv.gz.tmp, x.csv.tmp, x.tmp 에서 올 수 있다
+	for stem := path; ; {
+		if _, ok := r[stem]; ok {
+			delete(r, stem)
+			return true
+		}
+		ext := filepath.Ext(stem)
+		if ext == "" || ext == stem {
+			return false
+		}
+		stem = strings.TrimSuffix(stem, ext)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// pauseDiskWatermark is the reason the worker pool is paused for while the
+// cluster is out of disk.
+const pauseDiskWatermark = "disk_watermark"
+
+// diskWatermark pauses the worker pool while a node of the cluster indexed
+// into (the secondary when failed over) is past the flood-stage disk
+// watermark (ES_WATERMARK_PAUSE). From there Elasticsearch makes every
+// index with a shard on the node read-only, and indexing on would only
+// fill the spool and the dead-letter queue. Ingestion resumes once every
+// node is below the high watermark again, so it doesn't flap around the
+// flood stage. Files in flight are finished; the others stay queued or in
+// the watch directory. The disks and the watermark settings are read
+// every check.
+type diskWatermark struct {
+	active func() (*elasticsearch.Client, string)
+	pool   *workerPool
+	check  time.Duration
+
+	paused bool
+}
+
+func (w *diskWatermark) run(ctx context.Context) {
+	ticker := time.NewTicker(w.check)
+	defer ticker.Stop()
+	for {
+		w.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *diskWatermark) poll(ctx context.Context) {
+	es, cluster := w.active()
+	ctx, cancel := context.WithTimeout(ctx, w.check)
+	defer cancel()
+	disks, err := readDiskUsage(ctx, es)
+	if err != nil {
+		// 확인하지 못하면 상태를 바꾸지 않는다
+		logES.Warn("error checking Elasticsearch disk watermarks", "cluster", cluster, "err", err)
+		return
+	}
+	if !w.paused {
+		node := disks.past(func(n nodeDisk) watermark { return n.flood })
+		if node == nil {
+			return
+		}
+		w.paused = true
+		w.pool.pauseFor(pauseDiskWatermark)
+		metricESDiskPaused.Set(1)
+		logES.Warn("Elasticsearch disk past the flood-stage watermark, pausing ingestion", "cluster", cluster, "node", node.Name, "used_pct", node.usedPct(), "available", node.Available, "flood_stage", node.flood.String())
+		notify(notification{
+			Event:    eventWatermark,
+			Severity: "critical",
+			Title:    "Ingestion paused: Elasticsearch disk flood stage",
+			Text:     fmt.Sprintf("node %s of the %s cluster is %.1f%% full (%d bytes available), past the flood-stage watermark %s; no new files are ingested until every node is below the high watermark", node.Name, cluster, node.usedPct(), node.Available, node.flood),
+		})
+		return
+	}
+	if node := disks.past(func(n nodeDisk) watermark { return n.high }); node != nil {
+		logES.Debug("Elasticsearch disk still past the high watermark, ingestion stays paused", "cluster", cluster, "node", node.Name, "used_pct", node.usedPct())
+		return
+	}
+	w.paused = false
+	w.pool.resumeFor(pauseDiskWatermark)
+	metricESDiskPaused.Set(0)
+	logES.Info("Elasticsearch disks below the high watermark, resuming ingestion", "cluster", cluster)
+	notify(notification{
+		Event:    eventWatermark,
+		Severity: "info",
+		Title:    "Ingestion resumed: Elasticsearch disks below the high watermark",
+		Text:     fmt.Sprintf("every node of the %s cluster is below the high disk watermark again; files are ingested again", cluster),
+	})
+}
+
+// watermark is a disk watermark of Elasticsearch: a share of the disk
+// used ("90%", "0.9") or, as bytes ("20gb"), the space that must stay
+// available. headroom (max_headroom, ES 8.5+) caps the space a percentage
+// asks for on large disks; -1 is none.
+type watermark struct {
+	pct      float64
+	bytes    int64
+	headroom int64
+	raw      string
+}
+
+func (w watermark) String() string { return w.raw }
+
+// exceeded reports whether a disk of total bytes with available of them
+// free is past w.
+func (w watermark) exceeded(total, available int64) bool {
+	if w.raw == "" || total <= 0 {
+		return false
+	}
+	if w.pct == 0 {
+		return available < w.bytes
+	}
+	need := float64(total) * (100 - w.pct) / 100
+	if w.headroom >= 0 {
+		need = math.Min(need, float64(w.headroom))
+	}
+	return float64(available) < need
+}
+
+// parseWatermark reads a watermark setting and its max_headroom (may be
+// empty).
+func parseWatermark(value, headroom string) (watermark, error) {
+	w := watermark{raw: value, headroom: -1}
+	switch {
+	case strings.HasSuffix(value, "%"):
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return w, fmt.Errorf("invalid disk watermark %q", value)
+		}
+		w.pct = pct
+	default:
+		// 0.9 같은 비율이 아니면 남아 있어야 할 크기
+		if ratio, err := strconv.ParseFloat(value, 64); err == nil {
+			if ratio <= 0 || ratio > 1 {
+				return w, fmt.Errorf("invalid disk watermark %q", value)
+			}
+			w.pct = ratio * 100
+			break
+		}
+		n, err := parseByteSize(value)
+		if err != nil {
+			return w, fmt.Errorf("invalid disk watermark %q", value)
+		}
+		w.bytes = n
+	}
+	if headroom != "" && headroom != "-1" {
+		n, err := parseByteSize(headroom)
+		if err != nil {
+			return w, fmt.Errorf("invalid disk watermark max_headroom %q", headroom)
+		}
+		w.headroom = n
+	}
+	return w, nil
+}
+
+// parseByteSize reads an Elasticsearch byte size: 512, 512b, 20kb .. 2pb,
+// case insensitive, 1024 based.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	for i, unit := range []string{"pb", "tb", "gb", "mb", "kb"} {
+		if strings.HasSuffix(s, unit) {
+			s, mult = strings.TrimSuffix(s, unit), int64(1)<<(10*(5-i))
+			break
+		}
+	}
+	if mult == 1 {
+		s = strings.TrimSuffix(s, "b")
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// nodeDisk is the disk of one data node with the cluster's watermarks.
+type nodeDisk struct {
+	Name             string
+	Total, Available int64
+
+	high, flood watermark
+}
+
+func (n nodeDisk) usedPct() float64 {
+	if n.Total <= 0 {
+		return 0
+	}
+	return math.Round(float64(n.Total-n.Available)/float64(n.Total)*1000) / 10
+}
+
+type diskUsage []nodeDisk
+
+// past returns the first node past the watermark level picks, or nil.
+func (d diskUsage) past(level func(nodeDisk) watermark) *nodeDisk {
+	for i, n := range d {
+		if level(n).exceeded(n.Total, n.Available) {
+			return &d[i]
+		}
+	}
+	return nil
+}
+
+const watermarkSetting = "cluster.routing.allocation.disk."
+
+// readDiskUsage reads the disks of the data nodes and the cluster's high
+// and flood-stage watermarks. With the disk threshold decider off
+// (cluster.routing.allocation.disk.threshold_enabled: false) Elasticsearch
+// doesn't block on full disks and no node is reported.
+func readDiskUsage(ctx context.Context, es *elasticsearch.Client) (diskUsage, error) {
+	if es == nil {
+		return nil, fmt.Errorf("no elasticsearch client")
+	}
+	// filter_path 는 점이 든 flat 이름에 맞지 않아서 전부 받는다
+	res, err := es.Cluster.GetSettings(es.Cluster.GetSettings.WithContext(ctx),
+		es.Cluster.GetSettings.WithIncludeDefaults(true), es.Cluster.GetSettings.WithFlatSettings(true))
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]map[string]interface{}
+	if err := decodeESResponse(res, "cluster settings", &settings); err != nil {
+		return nil, err
+	}
+	// transient 이 persistent 보다, persistent 가 기본값보다 우선
+	setting := func(name string) string {
+		for _, scope := range []string{"transient", "persistent", "defaults"} {
+			if v, ok := settings[scope][watermarkSetting+name].(string); ok {
+				return v
+			}
+		}
+		return ""
+	}
+	if setting("threshold_enabled") == "false" {
+		return nil, nil
+	}
+	high, err := parseWatermark(setting("watermark.high"), setting("watermark.high.max_headroom"))
+	if err != nil {
+		return nil, err
+	}
+	flood, err := parseWatermark(setting("watermark.flood_stage"), setting("watermark.flood_stage.max_headroom"))
+	if err != nil {
+		return nil, err
+	}
+	if high.raw == "" || flood.raw == "" {
+		return nil, fmt.Errorf("cluster settings have no disk watermarks")
+	}
+
+	res, err = es.Nodes.Stats(es.Nodes.Stats.WithContext(ctx), es.Nodes.Stats.WithMetric("fs"),
+		es.Nodes.Stats.WithFilterPath("nodes.*.name", "nodes.*.roles", "nodes.*.fs.total"))
+	if err != nil {
+		return nil, err
+	}
+	var stats struct {
+		Nodes map[string]struct {
+			Name  string   `json:"name"`
+			Roles []string `json:"roles"`
+			FS    struct {
+				Total struct {
+					Total     int64 `json:"total_in_bytes"`
+					Available int64 `json:"available_in_bytes"`
+				} `json:"total"`
+			} `json:"fs"`
+		} `json:"nodes"`
+	}
+	if err := decodeESResponse(res, "node stats", &stats); err != nil {
+		return nil, err
+	}
+	var disks diskUsage
+	for _, n := range stats.Nodes {
+		if !dataNode(n.Roles) {
+			continue
+		}
+		disks = append(disks, nodeDisk{Name: n.Name, Total: n.FS.Total.Total, Available: n.FS.Total.Available, high: high, flood: flood})
+	}
+	return disks, nil
+}
+
+// dataNode reports whether a node with roles holds shards; without roles
+// (old versions) every node counts.
+func dataNode(roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, r := range roles {
+		if strings.HasPrefix(r, "data") {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeESResponse(res *esapi.Response, what string, v interface{}) error {
+	defer res.Body.Close()
+	if res.IsError() {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("error reading %s: [%d] %s", what, res.StatusCode, msg)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("error parsing %s: %w", what, err)
+	}
+	return nil
+}
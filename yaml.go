@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 외부 의존성 없이 설정 파일을 읽기 위한 YAML 부분 집합 파서.
+//
+// Supported: block mappings and sequences (indented with spaces), plain,
+// single- and double-quoted scalars, flow sequences ([a, b]), literal (|)
+// and folded (>) block scalars, and # comments. Anchors, tags, multiple
+// documents and flow mappings are not. Plain scalars follow the YAML 1.2
+// core schema: only true/false are booleans (yes, no, on and off stay
+// strings) and nan/inf are strings, not numbers.
+
+type yamlLine struct {
+	indent int
+	text   string // 주석 제거, 앞 공백 제거
+	raw    string // block scalar 용 원문
+	num    int
+}
+
+// decodeYAML parses data and stores it in v using its json struct tags.
+func decodeYAML(data []byte, v interface{}) error {
+	node, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+	return remarshal(node, v)
+}
+
+// remarshal converts a parsed YAML node into v through JSON.
+func remarshal(node interface{}, v interface{}) error {
+	js, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(raw, "---") || strings.HasPrefix(raw, "...") {
+			continue
+		}
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs can't be used for indentation", i+1)
+		}
+		text := strings.TrimSpace(stripYAMLComment(trimmed))
+		lines = append(lines, yamlLine{indent: len(raw) - len(trimmed), text: text, raw: raw, num: i + 1})
+	}
+	p := &yamlParser{lines: lines}
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return map[string]interface{}{}, nil
+	}
+	node, err := p.parseNode(p.lines[p.i].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("yaml line %d: unexpected indentation", p.lines[p.i].num)
+	}
+	return node, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.i < len(p.lines) && p.lines[p.i].text == "" {
+		p.i++
+	}
+}
+
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isYAMLDash(p.lines[p.i].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func isYAMLDash(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	seq := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.i >= len(p.lines) || p.lines[p.i].indent != indent || !isYAMLDash(p.lines[p.i].text) {
+			return seq, nil
+		}
+		line := p.lines[p.i]
+		content := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if content == "" {
+			p.i++
+			p.skipBlank()
+			if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+				seq = append(seq, nil)
+				continue
+			}
+			v, err := p.parseNode(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		// "- key: value" 는 dash 뒤 위치를 들여쓰기로 하는 mapping 의 첫 줄
+		if _, _, ok := splitYAMLKey(content); ok || isYAMLDash(content) {
+			offset := strings.Index(line.raw, content)
+			p.lines[p.i] = yamlLine{indent: offset, text: content, raw: line.raw, num: line.num}
+			v, err := p.parseNode(offset)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		v, err := parseYAMLScalar(content, line.num)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+		p.i++
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		p.skipBlank()
+		if p.i >= len(p.lines) || p.lines[p.i].indent < indent {
+			return m, nil
+		}
+		line := p.lines[p.i]
+		if line.indent > indent {
+			return nil, fmt.Errorf("yaml line %d: unexpected indentation", line.num)
+		}
+		if isYAMLDash(line.text) {
+			return m, nil
+		}
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml line %d: expected \"key: value\"", line.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("yaml line %d: duplicate key %q", line.num, key)
+		}
+		p.i++
+		switch {
+		case value == "":
+			p.skipBlank()
+			if p.i < len(p.lines) && (p.lines[p.i].indent > indent ||
+				p.lines[p.i].indent == indent && isYAMLDash(p.lines[p.i].text)) {
+				v, err := p.parseNode(p.lines[p.i].indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			} else {
+				m[key] = nil
+			}
+		case value[0] == '|' || value[0] == '>':
+			m[key] = p.blockScalar(indent, value)
+		default:
+			v, err := parseYAMLScalar(value, line.num)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+	}
+}
+
+// blockScalar collects the lines indented deeper than the key.
+func (p *yamlParser) blockScalar(indent int, header string) string {
+	var lines []string
+	blockIndent := -1
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.lines[p.i]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if l.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = l.indent
+		}
+		if len(l.raw) >= blockIndent {
+			lines = append(lines, l.raw[blockIndent:])
+		} else {
+			lines = append(lines, strings.TrimSpace(l.raw))
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	sep := "\n"
+	if header[0] == '>' {
+		sep = " "
+	}
+	s := strings.Join(lines, sep)
+	if !strings.HasSuffix(header, "-") {
+		s += "\n"
+	}
+	return s
+}
+
+// splitYAMLKey splits "key: value" outside quotes.
+func splitYAMLKey(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			key := strings.TrimSpace(text[:i])
+			if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+				key = key[1 : len(key)-1]
+			}
+			if key == "" {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		case c == '[' || c == '{':
+			if i == 0 {
+				return "", "", false
+			}
+		}
+	}
+	return "", "", false
+}
+
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || s[i-1] == ' ' || s[i-1] == '[' || s[i-1] == ',' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func parseYAMLScalar(s string, num int) (interface{}, error) {
+	switch {
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d: bad quoted string %s", num, s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("yaml line %d: bad quoted string %s", num, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return nil, fmt.Errorf("yaml line %d: unterminated flow sequence", num)
+		}
+		seq := []interface{}{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return seq, nil
+		}
+		for _, item := range splitYAMLFlow(inner) {
+			v, err := parseYAMLScalar(strings.TrimSpace(item), num)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case s[0] == '{':
+		return nil, fmt.Errorf("yaml line %d: flow mappings are not supported", num)
+	}
+	// YAML 1.2 core schema: yes/no/on/off 는 bool 이 아니라 문자열
+	switch s {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	// nan, inf 같은 이름은 숫자로 보지 않는다 (설정에 쓰일 일도 없다)
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f, nil
+	}
+	return s, nil
+}
+
+func splitYAMLFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
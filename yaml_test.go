@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAMLScalar(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"true", true},
+		{"False", false},
+		{"yes", "yes"},
+		{"no", "no"},
+		{"on", "on"},
+		{"off", "off"},
+		{"~", nil},
+		{"null", nil},
+		{"42", int64(42)},
+		{"-7", int64(-7)},
+		{"12.5", 12.5},
+		{"1e3", 1000.0},
+		{"nan", "nan"},
+		{"NaN", "NaN"},
+		{"inf", "inf"},
+		{"-Infinity", "-Infinity"},
+		{"1s", "1s"},
+		{`"yes"`, "yes"},
+		{`"a\tb"`, "a\tb"},
+		{"'it''s'", "it's"},
+		{"[a, 'b, c', 3, no]", []interface{}{"a", "b, c", int64(3), "no"}},
+		{"[]", []interface{}{}},
+	}
+	for _, tt := range tests {
+		got, err := parseYAMLScalar(tt.in, 1)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseYAMLScalar(%s) = %#v, %v; want %#v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     interface{}
+		err      bool
+	}{
+		{"mapping", "a: 1\nb:\n  c: x # comment\n", map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": "x"}}, false},
+		{"sequence", "list:\n  - a\n  - b: 1\n", map[string]interface{}{"list": []interface{}{"a", map[string]interface{}{"b": int64(1)}}}, false},
+		{"literal", "text: |\n  one\n  two\n", map[string]interface{}{"text": "one\ntwo\n"}, false},
+		{"empty", "# nothing\n", map[string]interface{}{}, false},
+		{"tabs", "a:\n\tb: 1\n", nil, true},
+		{"flow mapping", "a: {b: 1}\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.in))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parseYAML = %#v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseYAML = %#v, %v; want %#v", tt.name, got, err, tt.want)
+		}
+	}
+}